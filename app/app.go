@@ -7,12 +7,14 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -30,7 +32,7 @@ func Start() {
 	wait := &sync.WaitGroup{}
 
 	// Wait for all tasks
-	wait.Add(11)
+	wait.Add(12)
 
 	// Load application logger
 	loadAppLogger()
@@ -41,13 +43,16 @@ func Start() {
 	// Run logger renew service
 	go util.RenewLogger()
 
+	// Run config reload service
+	go configReloader()
+
 	loadLUAConfig()
 	connectDatabase()
 
 	// Execute our tasks
 	go func(wait *sync.WaitGroup) {
 
-		if util.Config.Configuration.LoadMap {
+		if util.Config.Get().LoadMap {
 			loadMap()
 			go mapWatcher()
 		} else {
@@ -56,12 +61,19 @@ func Start() {
 
 		go loadHouses(wait)
 		go loadVocations(wait)
+		go loadAchievements(wait)
 		go loadServerMonsters(wait)
 	}(wait)
 
 	// Create application cache
 	createCache()
 
+	// Load geolocation database
+	loadGeoIPDatabase()
+
+	// Register lua template functions
+	executeTemplateFuncsFile()
+
 	go loadLanguageFiles(wait)
 	go loadWidgetList(wait)
 	go appTemplates(wait)
@@ -84,7 +96,7 @@ func Start() {
 
 func loadServerMonsters(wg *sync.WaitGroup) {
 	// Load server monsters
-	if err := util.LoadServerMonsters(util.Config.Configuration.Datapack); err != nil {
+	if err := util.LoadServerMonsters(util.Config.Get().Datapack); err != nil {
 		util.Logger.Logger.Fatalf("Cannot load server monsters: %v", err)
 	}
 
@@ -127,12 +139,12 @@ func loadExtensionStaticResources(wg *sync.WaitGroup) {
 
 func mapWatcher() {
 	// Check if watcher is enabled
-	if !util.Config.Configuration.MapWatch.Enabled {
+	if !util.Config.Get().MapWatch.Enabled {
 		return
 	}
 
 	// Create watcher ticker
-	ticker := time.NewTicker(util.Config.Configuration.MapWatch.Check.Duration)
+	ticker := time.NewTicker(util.Config.Get().MapWatch.Check.Duration)
 	defer ticker.Stop()
 
 	// Start watcher loop
@@ -145,11 +157,56 @@ func mapWatcher() {
 	}
 }
 
+// Shutdown stops the background events waiting for the running ones to finish
+func Shutdown() {
+	// Get shutdown timeout
+	timeout := util.Config.Get().Shutdown.Timeout.Duration
+
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	// Wait for background events
+	if !lua.ShutdownEvents(timeout) {
+		util.Logger.Logger.Warnf("Background events still running after %v", timeout)
+	}
+}
+
+func configReloader() {
+	// Listen for reload signals
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for range reload {
+
+		// Reload configuration file
+		ignored, err := util.ReloadConfig("config.toml")
+
+		if err != nil {
+			util.Logger.Logger.Errorf("Cannot reload configuration file: %v", err)
+			continue
+		}
+
+		// Reload config overwrites
+		if err := lua.OverwriteConfigFile(); err != nil {
+			util.Logger.Logger.Errorf("Cannot reload external config files: %v", err)
+			continue
+		}
+
+		// Report fields that need a restart
+		if len(ignored) > 0 {
+			util.Logger.Logger.Infof("Configuration fields ignored until restart: %v", strings.Join(ignored, ", "))
+		}
+
+		util.Logger.Logger.Info("Configuration file reloaded")
+	}
+}
+
 func loadConfigMap() {
 	mapTowns := []otmap.Town{}
 
 	// Convert config towns to map towns
-	for _, t := range util.Config.Configuration.Towns {
+	for _, t := range util.Config.Get().Towns {
 		mapTowns = append(mapTowns, otmap.Town{
 			Name: t.Name,
 			ID:   t.ID,
@@ -158,7 +215,7 @@ func loadConfigMap() {
 
 	// Set map global
 	util.OTBMap.Load(&util.CastroMap{
-		HouseFile: util.Config.Configuration.MapHouseFile,
+		HouseFile: util.Config.Get().MapHouseFile,
 		Towns:     mapTowns,
 	})
 }
@@ -168,7 +225,7 @@ func loadMap() {
 	m := models.Map{}
 
 	// Get map mod time
-	fileInformation, err := os.Stat(filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"))
+	fileInformation, err := os.Stat(filepath.Join(util.Config.Get().Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"))
 	if err != nil {
 		util.Logger.Logger.Fatalf("Cannot get map file information: %v", err)
 	}
@@ -189,7 +246,7 @@ func loadMap() {
 
 		// Encode map
 		mapData, err := util.EncodeMap(
-			filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
+			filepath.Join(util.Config.Get().Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
 		)
 
 		if err != nil {
@@ -224,7 +281,7 @@ func loadMap() {
 
 		// Encode map
 		mapData, err := util.EncodeMap(
-			filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
+			filepath.Join(util.Config.Get().Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
 		)
 
 		if err != nil {
@@ -286,7 +343,7 @@ func executeMigrations() {
 			glua.P{
 				Fn:      state.GetGlobal("migration"),
 				NRet:    0,
-				Protect: !util.Config.Configuration.IsDev(),
+				Protect: !util.Config.Get().IsDev(),
 			},
 		); err != nil {
 			return err
@@ -318,6 +375,29 @@ func executeInitFile() {
 	}
 }
 
+func executeTemplateFuncsFile() {
+	path := filepath.Join("engine", "templatefuncs.lua")
+
+	// Template functions file is optional
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+
+	// Get lua state
+	luaState := glua.NewState()
+
+	// Close state
+	defer luaState.Close()
+
+	// Get application ready state
+	lua.GetApplicationState(luaState)
+
+	// Execute template functions file
+	if err := lua.ExecuteFile(luaState, path); err != nil {
+		util.Logger.Logger.Fatalf("Cannot execute template functions lua file: %v", err)
+	}
+}
+
 func loadWidgets(wg *sync.WaitGroup) {
 	// Load subtopic list
 	if err := lua.WidgetList.Load("widgets"); err != nil {
@@ -369,7 +449,7 @@ func loadAppLogger() {
 func loadVocations(wg *sync.WaitGroup) {
 	// Load server vocations
 	if err := util.LoadVocations(
-		filepath.Join(util.Config.Configuration.Datapack, "data", "XML", "vocations.xml"),
+		filepath.Join(util.Config.Get().Datapack, "data", "XML", "vocations.xml"),
 		util.ServerVocationList,
 	); err != nil {
 		util.Logger.Logger.Fatalf("Cannot load map house list: %v", err)
@@ -379,10 +459,32 @@ func loadVocations(wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+func loadAchievements(wg *sync.WaitGroup) {
+	// Load achievement definitions
+	if err := util.ServerAchievementList.LoadAchievements("achievements.xml"); err != nil {
+		util.Logger.Logger.Fatalf("Cannot load achievement list: %v", err)
+	}
+
+	// Tell the wait group we are done
+	wg.Done()
+}
+
+func loadGeoIPDatabase() {
+	// Geolocation is optional
+	if util.Config.Get().GeoIP.Database == "" {
+		return
+	}
+
+	// Load MaxMind database
+	if err := util.GeoIP.Load(util.Config.Get().GeoIP.Database); err != nil {
+		util.Logger.Logger.Errorf("Cannot load GeoIP database: %v", err)
+	}
+}
+
 func loadHouses(wg *sync.WaitGroup) {
 	// Load server houses
 	if err := util.ServerHouseList.LoadHouses(
-		filepath.Join(util.Config.Configuration.Datapack, "data", "world", util.OTBMap.Map.HouseFile),
+		filepath.Join(util.Config.Get().Datapack, "data", "world", util.OTBMap.Map.HouseFile),
 	); err != nil {
 		util.Logger.Logger.Fatalf("Cannot load map house list: %v", err)
 	}
@@ -402,7 +504,7 @@ func loadAppConfig() {
 
 func loadLUAConfig() {
 	// Load the LUA configuration file
-	if err := lua.LoadConfig(filepath.Join(util.Config.Configuration.Datapack, "config.lua")); err != nil {
+	if err := lua.LoadConfig(filepath.Join(util.Config.Get().Datapack, "config.lua")); err != nil {
 		util.Logger.Logger.Fatalf("Cannot read lua configuration file: %v", err)
 	}
 }
@@ -412,9 +514,12 @@ func createCache() {
 	// first parameter is the default item duration on the cache
 	// second parameter is the tick time to purge all dead cache items
 	util.Cache = cache.New(
-		util.Config.Configuration.Cache.Default.Duration,
-		util.Config.Configuration.Cache.Purge.Duration,
+		util.Config.Get().Cache.Default.Duration,
+		util.Config.Get().Cache.Purge.Duration,
 	)
+
+	// Create the lua cache backend
+	util.LuaCache = util.NewCacheBackend(util.Config.Get().Cache, util.Cache)
 }
 
 func loadWidgetList(wg *sync.WaitGroup) {
@@ -441,7 +546,7 @@ func appTemplates(wg *sync.WaitGroup) {
 	util.FuncMap = templateFuncs()
 
 	// Load templates
-	if err := util.Template.LoadTemplates(util.Config.Configuration.Template); err != nil {
+	if err := util.Template.LoadTemplates(util.Config.Get().Template); err != nil {
 		util.Logger.Logger.Fatalf("Cannot load templates: %v", err)
 	}
 
@@ -486,6 +591,15 @@ func widgetTemplates(wg *sync.WaitGroup) {
 func connectDatabase() {
 	var err error
 
+	// Set connection retry options
+	retries := util.Config.Get().Database.Retries
+
+	if retries == 0 {
+		retries = 5
+	}
+
+	database.SetReconnect(retries, util.Config.Get().Database.Backoff.Duration)
+
 	// Connect to the MySQL database
 	if database.DB, err = database.Open(lua.Config.GetGlobal("mysqlUser").String(), 
 		lua.Config.GetGlobal("mysqlPass").String(), 
@@ -495,13 +609,47 @@ func connectDatabase() {
 		""); err != nil {
 		util.Logger.Logger.Fatalf("Cannot connect to MySQL database: %v", err)
 	}
+
+	// Get ping interval
+	interval := util.Config.Get().Database.Ping.Duration
+
+	if interval <= 0 {
+		interval = time.Second * 30
+	}
+
+	// Ping database on the background
+	go database.KeepAlive(database.DB, interval, func(err error) {
+		if err != nil {
+			util.Logger.Logger.Errorf("Lost connection to MySQL database: %v", err)
+			return
+		}
+
+		util.Logger.Logger.Info("Connection to MySQL database restored")
+	})
+}
+
+// templateNumber converts a numeric template argument to float64. Lua numbers
+// reach the templates as int64 or float64 depending on their value
+func templateNumber(v interface{}) float64 {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+
+	return 0
 }
 
 func templateFuncs() template.FuncMap {
-	return template.FuncMap{
-		"vocation": func(voc float64) string {
+	funcs := template.FuncMap{
+		"vocation": func(voc interface{}) string {
 			for _, v := range util.ServerVocationList.List.Vocations {
-				if v.ID == int(voc) {
+				if v.ID == int(templateNumber(voc)) {
 					return v.Name
 				}
 			}
@@ -511,20 +659,20 @@ func templateFuncs() template.FuncMap {
 			return reflect.TypeOf(i).Kind() == reflect.Map
 		},
 		"isDev": func() bool {
-			return util.Config.Configuration.IsDev()
+			return util.Config.Get().IsDev()
 		},
 		"safeURL": func(s string) template.URL {
 			return template.URL(s)
 		},
 		"url": func(args ...interface{}) template.URL {
-			u := fmt.Sprintf("%v", util.Config.Configuration.URL)
+			u := fmt.Sprintf("%v", util.Config.Get().URL)
 			for _, arg := range args {
 				u = u + fmt.Sprintf("/%v", arg)
 			}
-			if util.Config.Configuration.SSL.Proxy {
+			if util.Config.Get().SSL.Proxy {
 				return template.URL("https://" + u)
 			}
-			if util.Config.Configuration.SSL.Enabled {
+			if util.Config.Get().SSL.Enabled {
 				return template.URL("https://" + u)
 			}
 			return template.URL("http://" + u)
@@ -537,8 +685,8 @@ func templateFuncs() template.FuncMap {
 			}
 			return r
 		},
-		"unixToDate": func(m float64) template.HTML {
-			date := time.Unix(int64(m), 0)
+		"unixToDate": func(m interface{}) template.HTML {
+			date := time.Unix(int64(templateNumber(m)), 0)
 			return template.HTML(
 				date.Format("2006 - Mon Jan 2 15:04:05"),
 			)
@@ -578,22 +726,25 @@ func templateFuncs() template.FuncMap {
 			return util.Widgets.List
 		},
 		"captchaKey": func() string {
-			return util.Config.Configuration.Captcha.Public
+			return util.Config.Get().Captcha.Public
 		},
-		"captchaEnabled": func() bool {
-			return util.Config.Configuration.Captcha.Enabled
+		"captchaEnabled": func(form ...string) bool {
+			if len(form) > 0 {
+				return util.Config.Get().Captcha.IsEnabled(form[0])
+			}
+			return util.Config.Get().Captcha.Enabled
 		},
 		"eq": func(a, b interface{}) bool {
 			return a == b
 		},
-		"eqNumber": func(a, b float64) bool {
-			return a == b
+		"eqNumber": func(a, b interface{}) bool {
+			return templateNumber(a) == templateNumber(b)
 		},
-		"gtNumber": func(a, b float64) bool {
-			return a > b
+		"gtNumber": func(a, b interface{}) bool {
+			return templateNumber(a) > templateNumber(b)
 		},
-		"lsNumber": func(a, b float64) bool {
-			return a < b
+		"lsNumber": func(a, b interface{}) bool {
+			return templateNumber(a) < templateNumber(b)
 		},
 		"menuPages": func() interface{} {
 			return util.Config.GetCustomValue("MenuPages")
@@ -636,8 +787,8 @@ func templateFuncs() template.FuncMap {
 			}
 			return v
 		},
-		"formatFloat": func(i float64) string {
-			return strconv.FormatFloat(i, 'f', -1, 64)
+		"formatFloat": func(i interface{}) string {
+			return strconv.FormatFloat(templateNumber(i), 'f', -1, 64)
 		},
 		"itoa": func(i int) string {
 			return strconv.Itoa(i)
@@ -652,4 +803,15 @@ func templateFuncs() template.FuncMap {
 			return strings.ToTitle(s)
 		},
 	}
+
+	// Add functions registered from lua
+	for name, f := range lua.TemplateFuncMap() {
+		if _, ok := funcs[name]; ok {
+			util.Logger.Logger.Warnf("Template function %v cannot replace a built-in function", name)
+			continue
+		}
+		funcs[name] = f
+	}
+
+	return funcs
 }
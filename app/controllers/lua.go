@@ -3,6 +3,7 @@ package controllers
 import (
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/raggaer/castro/app/lua"
@@ -12,27 +13,42 @@ import (
 // LuaPage executes the given lua page
 func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Create application paypal REST client
-	lua.CreatePaypalClient(util.Config.Configuration.PayPal.SandBox)
+	lua.CreatePaypalClient(util.Config.Get().PayPal.SandBox)
+
+	// Get request body limited by the middleware
+	body, ok := r.Body.(*util.LimitedBody)
+
+	if !ok {
+
+		// Limit request body size
+		body = util.NewLimitedBody(w, r.Body, util.Config.Get().HTTP.BodyLimit())
+		r.Body = body
+	}
 
 	// Check if request is POST
 	if r.Method == http.MethodPost {
 
 		// Parse POST form
 		if err := r.ParseForm(); err != nil {
+			if body.Exceeded() {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+
 			w.WriteHeader(500)
 			return
 		}
 	}
 
 	// If development mode reload pages, widgets and config file
-	if util.Config.Configuration.IsDev() {
+	if util.Config.Get().IsDev() {
 
 		// Reload config file
 		if err := util.LoadConfig("config.toml"); err != nil {
 
 			// Set error header
 			w.WriteHeader(500)
-			util.Logger.Logger.Errorf("Cannot reload config file: %v", err)
+			util.Logger.ForRequest(r).Errorf("Cannot reload config file: %v", err)
 
 			return
 		}
@@ -42,7 +58,7 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 			// Set error header
 			w.WriteHeader(500)
-			util.Logger.Logger.Errorf("Cannot reload external config files: %v", err)
+			util.Logger.ForRequest(r).Errorf("Cannot reload external config files: %v", err)
 
 			return
 		}
@@ -52,7 +68,7 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 			// Set error header
 			w.WriteHeader(500)
-			util.Logger.Logger.Errorf("Cannot reload subtopic %v: %v", ps.ByName("page"), err)
+			util.Logger.ForRequest(r).Errorf("Cannot reload subtopic %v: %v", ps.ByName("page"), err)
 
 			return
 		}
@@ -61,15 +77,15 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		if err := lua.CompiledPageList.CompileExtensions("pages"); err != nil {
 
 			// Log error
-			util.Logger.Logger.Errorf("Cannot reload extension subtopic %v: %v", ps.ByName("page"), err)
+			util.Logger.ForRequest(r).Errorf("Cannot reload extension subtopic %v: %v", ps.ByName("page"), err)
 		}
 
 		// Reload extension static list
 		if err := util.ExtensionStatic.Load("extensions"); err != nil {
 
 			// If AAC is running on development mode log error
-			if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
-				util.Logger.Logger.Errorf("Cannot load extension subtopic %v: %v", ps.ByName("page"), err)
+			if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+				util.Logger.ForRequest(r).Errorf("Cannot load extension subtopic %v: %v", ps.ByName("page"), err)
 			}
 		}
 
@@ -78,24 +94,24 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 			// Set error header
 			w.WriteHeader(500)
-			util.Logger.Logger.Errorf("Cannot reload widgets when executing %v subtopic: %v", ps.ByName("page"), err)
+			util.Logger.ForRequest(r).Errorf("Cannot reload widgets when executing %v subtopic: %v", ps.ByName("page"), err)
 
 			return
 		}
 
 		// Reload extension widgets
 		if err := lua.WidgetList.LoadExtensions(); err != nil {
-			util.Logger.Logger.Errorf("Cannot load extension widgets when executing %v subtopic: %v", ps.ByName("page"), err)
+			util.Logger.ForRequest(r).Errorf("Cannot load extension widgets when executing %v subtopic: %v", ps.ByName("page"), err)
 		}
 
 		// Reload widget list
 		if err := util.Widgets.Load("widgets/"); err != nil {
-			util.Logger.Logger.Fatalf("Cannot load widget list: %v", err)
+			util.Logger.ForRequest(r).Fatalf("Cannot load widget list: %v", err)
 		}
 
 		// Reload extension widget list
 		if err := util.Widgets.LoadExtensions(); err != nil {
-			util.Logger.Logger.Errorf("Cannot load extension widget list: %v", err)
+			util.Logger.ForRequest(r).Errorf("Cannot load extension widget list: %v", err)
 		}
 	}
 
@@ -105,7 +121,7 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if !ok {
 		// Set error header
 		w.WriteHeader(500)
-		util.Logger.Logger.Error("Cannot get session as map")
+		util.Logger.ForRequest(r).Error("Cannot get session as map")
 
 		return
 	}
@@ -115,7 +131,7 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if !ok {
 		// Set error header
 		w.WriteHeader(500)
-		util.Logger.Logger.Error("Cannot get language as string slice")
+		util.Logger.ForRequest(r).Error("Cannot get language as string slice")
 
 		return
 	}
@@ -128,6 +144,23 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		pageName = "index"
 	}
 
+	// Preflight requests load the handler of the requested method and run its options function
+	// so http.cors can answer them. Pages without an options function are never executed
+	method := r.Method
+	pageWriter := lua.NewErrorPageWriter(w)
+
+	if r.Method == http.MethodOptions {
+		method = strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+
+		if r.Header.Get("Origin") == "" || (method != http.MethodGet && method != http.MethodPost) {
+			w.Header().Set("Allow", "GET, POST, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		pageWriter = lua.NewCORSPreflightWriter(w)
+	}
+
 	// Get state from the pool
 	s := lua.NewState()
 
@@ -135,7 +168,7 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	lua.SetHTTPMetaTable(s)
 
 	// Set the state user data
-	lua.SetHTTPUserData(s, w, r)
+	lua.SetHTTPUserData(s, pageWriter, r)
 
 	// Set session user data
 	lua.SetSessionMetaTableUserData(s, session)
@@ -144,14 +177,14 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	lua.SetI18nUserData(s, language)
 
 	// Retrieve compiled proto
-	protoPath := filepath.Join("pages", pageName, r.Method+".lua")
+	protoPath := filepath.Join("pages", pageName, method+".lua")
 	if !lua.CompiledPageList.Exists(protoPath) {
-		protoPath = filepath.Join("pages", "404", r.Method+".lua")
+		protoPath = filepath.Join("pages", "404", method+".lua")
 	}
 	proto, err := lua.CompiledPageList.Get(protoPath)
 	if err != nil {
 		w.WriteHeader(404)
-		util.Logger.Logger.Errorf("Cannot find lua proto, subtopic source (%s) %v", pageName, err)
+		util.Logger.ForRequest(r).Errorf("Cannot find lua proto, subtopic source (%s) %v", pageName, err)
 		return
 	}
 
@@ -161,12 +194,49 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		proto,
 	); err != nil {
 		w.WriteHeader(404)
-		util.Logger.Logger.Errorf("Cannot get %v subtopic source (%s) %v", pageName, err)
+		util.Logger.ForRequest(r).Errorf("Cannot get %v subtopic source (%s)", pageName, err)
 		return
 	}
 
-	if err := lua.ExecuteControllerPage(s, r.Method); err != nil {
-		w.WriteHeader(500)
-		util.Logger.Logger.Errorf("Cannot execute subtopic %v: %v", pageName, err)
+	// Run functions deferred by the page once the handler returns
+	defer lua.RunDeferredEvents(s)
+
+	// Preflight requests not answered by http.cors get no CORS headers
+	if r.Method == http.MethodOptions {
+		if lua.HasControllerFunction(s, http.MethodOptions) {
+			if err := lua.ExecuteControllerPage(s, http.MethodOptions); err != nil {
+				util.Logger.ForRequest(r).Errorf("Cannot execute %v subtopic preflight: %v", pageName, err)
+			}
+		}
+
+		if !lua.CORSPreflightHandled(pageWriter) {
+			w.Header().Set("Allow", "GET, POST, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+		return
 	}
+
+	err = lua.ExecuteControllerPage(s, method)
+
+	if err != nil {
+		// Requests that exceeded the body limit are not page errors
+		if body.Exceeded() {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		util.Metrics.Increment("castro_lua_errors_total", 1)
+		util.Logger.ForRequest(r).Errorf("Cannot execute subtopic %v: %v", pageName, err)
+
+		// Render the error page. The error is never sent to the client
+		if !lua.ExecuteErrorHandler(s, pageWriter, r, err) {
+			pageWriter.WriteHeader(500)
+		}
+
+		return
+	}
+
+	// Save the page response if the page is cached
+	lua.StorePageCache(s)
 }
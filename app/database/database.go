@@ -1,20 +1,150 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	// Let sqlx know about MySQL
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
-// DB holds the main database handle
-var DB *sqlx.DB
+const (
+	// reconnectDriverName name of the MySQL driver that retries failed connections
+	reconnectDriverName = "castro-mysql"
+
+	// maxReconnectDelay maximum delay between two connection attempts
+	maxReconnectDelay = 30 * time.Second
+)
+
+var (
+	// DB holds the main database handle
+	DB *sqlx.DB
+
+	// connections holds the named secondary connections
+	connections = &namedConnections{
+		handles: map[string]*namedConnection{},
+	}
+
+	// reconnect holds the connection retry options
+	reconnect = &reconnectOptions{
+		backoff: 500 * time.Millisecond,
+	}
+)
+
+// reconnectOptions mutex guarded connection retry options
+type reconnectOptions struct {
+	rw      sync.RWMutex
+	retries int
+	backoff time.Duration
+}
+
+// namedConnections mutex guarded list of named connections
+type namedConnections struct {
+	rw      sync.RWMutex
+	handles map[string]*namedConnection
+}
+
+// namedConnection secondary connection and the DSN used to open it
+type namedConnection struct {
+	dsn string
+	db  *sqlx.DB
+}
+
+// reconnectDriver MySQL driver that retries failed connection attempts using exponential backoff.
+// Queries that find a dead connection are retried by database/sql using a new connection
+type reconnectDriver struct {
+	mysql.MySQLDriver
+}
+
+func init() {
+	sql.Register(reconnectDriverName, &reconnectDriver{})
+}
+
+// SetReconnect sets the number of times a failed connection attempt is retried and the delay
+// before the first retry. The delay doubles after every attempt
+func SetReconnect(retries int, backoff time.Duration) {
+	reconnect.rw.Lock()
+	defer reconnect.rw.Unlock()
+
+	reconnect.retries = retries
+
+	if backoff > 0 {
+		reconnect.backoff = backoff
+	}
+}
+
+// Open opens a new connection to the MySQL database server
+func (d *reconnectDriver) Open(dsn string) (driver.Conn, error) {
+	return d.open(context.Background(), dsn)
+}
+
+// OpenConnector returns a connector so database/sql passes the connection context
+func (d *reconnectDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return &reconnectConnector{
+		driver: d,
+		dsn:    dsn,
+	}, nil
+}
+
+// open opens a new connection retrying failed attempts until the retries are exhausted or
+// the given context is done
+func (d *reconnectDriver) open(ctx context.Context, dsn string) (driver.Conn, error) {
+	reconnect.rw.RLock()
+	retries, delay := reconnect.retries, reconnect.backoff
+	reconnect.rw.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		conn, err := d.MySQLDriver.Open(dsn)
+
+		if err == nil {
+			return conn, nil
+		}
+
+		// Errors returned by the server (wrong credentials, unknown database) are not retried
+		if _, ok := err.(*mysql.MySQLError); ok || attempt >= retries {
+			return nil, err
+		}
+
+		// Wait for the next attempt unless the caller gives up
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// reconnectConnector connector of the reconnect driver
+type reconnectConnector struct {
+	driver *reconnectDriver
+	dsn    string
+}
+
+// Connect opens a new connection using the given context
+func (c *reconnectConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.open(ctx, c.dsn)
+}
+
+// Driver returns the reconnect driver
+func (c *reconnectConnector) Driver() driver.Driver {
+	return c.driver
+}
 
 // Open creates a new connection to a MySQL database with the given credentials
 func Open(username, password, host, port, db, params string) (*sqlx.DB, error) {
 	// Connect to the given database
-	databaseHandle, err := sqlx.Connect("mysql", fmt.Sprintf(
+	return openDSN(fmt.Sprintf(
 		"%v:%v@(%v:%v)/%v?charset=utf8&parseTime=True&loc=Local"+params,
 		username,
 		password,
@@ -22,7 +152,84 @@ func Open(username, password, host, port, db, params string) (*sqlx.DB, error) {
 		port,
 		db,
 	))
+}
+
+// Connect opens a named connection using the given DSN. Connecting again with the same DSN reuses
+// the open connection
+func Connect(name, dsn string) (*sqlx.DB, error) {
+	connections.rw.Lock()
+	defer connections.rw.Unlock()
+
+	// Reuse open connection
+	if conn, ok := connections.handles[name]; ok {
+		if conn.dsn != dsn {
+			return nil, errors.New("Database connection " + name + " is already registered with a different DSN")
+		}
+
+		return conn.db, nil
+	}
+
+	db, err := openDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	connections.handles[name] = &namedConnection{
+		dsn: dsn,
+		db:  db,
+	}
+
+	return db, nil
+}
+
+// Named returns the named connection or nil if there is no connection with the given name
+func Named(name string) *sqlx.DB {
+	connections.rw.RLock()
+	defer connections.rw.RUnlock()
+
+	if conn, ok := connections.handles[name]; ok {
+		return conn.db
+	}
+
+	return nil
+}
+
+// openDSN connects to the database using the given DSN
+func openDSN(dsn string) (*sqlx.DB, error) {
+	sqlHandle, err := sql.Open(reconnectDriverName, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the MySQL bind type
+	databaseHandle := sqlx.NewDb(sqlHandle, "mysql")
+
+	if err := databaseHandle.Ping(); err != nil {
+		databaseHandle.Close()
+		return nil, err
+	}
 
 	// Return database handler
-	return databaseHandle, err
+	return databaseHandle, nil
+}
+
+// KeepAlive pings the database every interval so dead connections are removed from the pool. The
+// notify function is called with the ping error when the database goes down and with nil once it
+// is reachable again
+func KeepAlive(db *sqlx.DB, interval time.Duration, notify func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	down := false
+
+	for range ticker.C {
+		err := db.Ping()
+
+		if (err != nil) != down {
+			down = err != nil
+			notify(err)
+		}
+	}
 }
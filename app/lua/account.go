@@ -0,0 +1,413 @@
+package lua
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetAccountMetaTable sets the account metatable of the given state
+func SetAccountMetaTable(luaState *lua.LState) {
+	// Create and set the account metatable
+	accountMetaTable := luaState.NewTypeMetatable(AccountMetaTableName)
+	luaState.SetGlobal(AccountMetaTableName, accountMetaTable)
+
+	// Set all account metatable functions
+	luaState.SetFuncs(accountMetaTable, accountMethods)
+}
+
+// CreateAccount creates a new account from the given table
+func CreateAccount(L *lua.LState) int {
+	// Get information table
+	tbl := L.Get(2)
+
+	// Check for valid type
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid account type. Expected table")
+		return 0
+	}
+
+	// Convert table to map
+	info := TableToMap(tbl.(*lua.LTable))
+
+	// Get account name
+	name, ok := info["name"].(string)
+
+	if !ok || name == "" {
+		L.ArgError(1, "Missing 'name' table field")
+		return 0
+	}
+
+	// Get account password
+	password, ok := info["password"].(string)
+
+	if !ok || password == "" {
+		L.ArgError(1, "Missing 'password' table field")
+		return 0
+	}
+
+	// Get account email
+	email, ok := info["email"].(string)
+
+	if !ok {
+		L.ArgError(1, "Missing 'email' table field")
+		return 0
+	}
+
+	// Get optional premium days
+	premdays, _ := info["premdays"].(int64)
+
+	// Create account
+	id, err := models.CreateAccount(name, password, email, int(premdays))
+
+	if err == models.ErrAccountNameTaken || err == models.ErrAccountEmailTaken {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	if err != nil {
+		L.RaiseError("Cannot create account: %v", err)
+		return 0
+	}
+
+	// Push account identifier
+	L.Push(lua.LNumber(id))
+
+	return 1
+}
+
+// ThrottlePasswordReset checks if another password reset can be requested for the given
+// account. By default one reset is allowed every 15 minutes (Security.PasswordReset config)
+func ThrottlePasswordReset(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Get limit options
+	limit := util.Config.Get().Security.PasswordReset.Limit
+
+	if limit <= 0 {
+		limit = 1
+	}
+
+	window := util.Config.Get().Security.PasswordReset.Window.Duration
+
+	if window <= 0 {
+		window = 15 * time.Minute
+	}
+
+	// Check action
+	allowed, remaining, err := allowRateLimitAction(fmt.Sprintf("pwreset:%v", int64(id.(lua.LNumber))), limit, window)
+
+	if err != nil {
+		L.RaiseError("Cannot check password reset limit: %v", err)
+		return 0
+	}
+
+	// Push results
+	L.Push(lua.LBool(allowed))
+	L.Push(lua.LNumber(remaining))
+
+	return 2
+}
+
+// GetAccountCreationDate returns the creation timestamp of the given account or nil if the account
+// does not exist. Returns zero if the schema does not store the creation date
+func GetAccountCreationDate(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Get account creation date
+	creation, err := models.GetAccountCreationDate(int64(id.(lua.LNumber)))
+
+	if err == sql.ErrNoRows {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	if err != nil {
+		L.RaiseError("Cannot get account creation date: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LNumber(creation))
+
+	return 1
+}
+
+// EnableAccountTwoFactor enables two-factor authentication for the given account. The token must be
+// a valid code generated from the secret so users prove their authenticator is set up. Returns nil
+// and an error message if the token is not valid
+func EnableAccountTwoFactor(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Get secret key
+	secret := L.Get(3)
+
+	// Check for valid secret type
+	if secret.Type() != lua.LTString || secret.String() == "" {
+		L.ArgError(2, "Invalid secret type. Expected string")
+		return 0
+	}
+
+	// Get current token
+	token := L.Get(4)
+
+	// Check for valid token type
+	if token.Type() != lua.LTString {
+		L.ArgError(3, "Invalid token type. Expected string")
+		return 0
+	}
+
+	// Validate token against the new secret
+	valid, err := verifyTOTP(token.String(), secret.String())
+
+	if err != nil {
+		L.RaiseError("Cannot authenticate token: %v", err)
+		return 0
+	}
+
+	if !valid {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("Invalid two-factor token"))
+		return 2
+	}
+
+	// Save secret
+	if err := models.EnableAccountTwoFactor(int64(id.(lua.LNumber)), secret.String()); err != nil {
+		if err == sql.ErrNoRows {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("Account not found"))
+			return 2
+		}
+
+		L.RaiseError("Cannot enable two-factor authentication: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// DisableAccountTwoFactor disables two-factor authentication for the given account. Returns false
+// if it was not enabled
+func DisableAccountTwoFactor(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Remove secret
+	disabled, err := models.DisableAccountTwoFactor(int64(id.(lua.LNumber)))
+
+	if err != nil {
+		L.RaiseError("Cannot disable two-factor authentication: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(disabled))
+
+	return 1
+}
+
+// AccountHasTwoFactor checks if the given account has two-factor authentication enabled
+func AccountHasTwoFactor(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Check account secret
+	enabled, err := models.AccountHasTwoFactor(int64(id.(lua.LNumber)))
+
+	if err != nil {
+		L.RaiseError("Cannot check two-factor authentication: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(enabled))
+
+	return 1
+}
+
+// GetAccountBanInfo returns the active ban of the given account or nil if it is not banned
+func GetAccountBanInfo(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Get account ban
+	ban, err := models.GetAccountBan(int64(id.(lua.LNumber)))
+
+	if err != nil {
+		L.RaiseError("Cannot get account ban: %v", err)
+		return 0
+	}
+
+	if ban == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(accountBanToTable(L, ban))
+
+	return 1
+}
+
+// BanAccount bans the given account. The options table accepts the reason, bannedBy (player id),
+// days or duration (number of seconds or duration string) and ip (players.lastip value) fields.
+// Bans without days or duration are permanent
+func BanAccount(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Get options
+	opts, ok := L.Get(3).(*lua.LTable)
+
+	if !ok {
+		L.ArgError(2, "Invalid ban options type. Expected table")
+		return 0
+	}
+
+	// Get reason
+	reason := opts.RawGetString("reason")
+
+	if reason.Type() != lua.LTString || reason.String() == "" {
+		L.ArgError(2, "Missing 'reason' table field")
+		return 0
+	}
+
+	// Get banning player
+	bannedBy, ok := opts.RawGetString("bannedBy").(lua.LNumber)
+
+	if !ok {
+		L.ArgError(2, "Missing 'bannedBy' table field")
+		return 0
+	}
+
+	// Get expiration date
+	expiresAt := int64(0)
+
+	if days, ok := opts.RawGetString("days").(lua.LNumber); ok && days > 0 {
+		expiresAt = time.Now().Add(time.Duration(float64(days) * float64(24*time.Hour))).Unix()
+	} else if v := opts.RawGetString("duration"); v != lua.LNil {
+		d, ok := durationFromValue(v)
+
+		if !ok {
+			L.ArgError(2, "Invalid duration. Expected number of seconds or duration string")
+			return 0
+		}
+
+		expiresAt = time.Now().Add(d).Unix()
+	}
+
+	// Get IP address
+	ip := uint32(0)
+
+	if v, ok := opts.RawGetString("ip").(lua.LNumber); ok {
+		ip = uint32(v)
+	}
+
+	// Ban account
+	if err := models.BanAccount(int64(id.(lua.LNumber)), reason.String(), expiresAt, int64(bannedBy), ip); err != nil {
+		L.RaiseError("Cannot ban account: %v", err)
+		return 0
+	}
+
+	// Clear cached ban lists
+	util.Cache.Delete("SELECT * FROM account_bans")
+	util.Cache.Delete("SELECT * FROM ip_bans")
+
+	return 0
+}
+
+// UnbanAccount lifts the ban of the given account. Returns false if the account was not banned
+func UnbanAccount(L *lua.LState) int {
+	// Get account id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid account id type. Expected number")
+		return 0
+	}
+
+	// Unban account
+	unbanned, err := models.UnbanAccount(int64(id.(lua.LNumber)))
+
+	if err != nil {
+		L.RaiseError("Cannot unban account: %v", err)
+		return 0
+	}
+
+	// Clear cached ban lists
+	util.Cache.Delete("SELECT * FROM account_bans")
+	util.Cache.Delete("SELECT * FROM account_ban_history")
+
+	L.Push(lua.LBool(unbanned))
+
+	return 1
+}
+
+// accountBanToTable converts the given ban to a lua table. Permanent bans have no expiresAt field
+func accountBanToTable(L *lua.LState, ban *models.AccountBan) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("accountId", lua.LNumber(ban.Account_id))
+	tbl.RawSetString("reason", lua.LString(ban.Reason))
+	tbl.RawSetString("bannedAt", lua.LNumber(ban.Banned_at))
+	tbl.RawSetString("bannedBy", lua.LNumber(ban.Banned_by))
+	tbl.RawSetString("permanent", lua.LBool(ban.Permanent()))
+
+	if ban.Banned_by_name.Valid {
+		tbl.RawSetString("bannedByName", lua.LString(ban.Banned_by_name.String))
+	}
+
+	if !ban.Permanent() {
+		tbl.RawSetString("expiresAt", lua.LNumber(ban.Expires_at))
+	}
+
+	return tbl
+}
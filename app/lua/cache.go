@@ -29,7 +29,12 @@ func GetCacheValue(L *lua.LState) int {
 	}
 
 	// Get value from cache
-	v, found := util.Cache.Get(key.String())
+	v, found, err := util.LuaCache.Get(key.String())
+
+	if err != nil {
+		L.RaiseError("Cannot get cache value: %v", err)
+		return 0
+	}
 
 	// If there is no value return nil
 	if !found {
@@ -73,11 +78,27 @@ func SetCacheValue(L *lua.LState) int {
 		return 0
 	}
 
-	// Get optional time value
+	// Get optional time value or options table
 	t := L.Get(4)
 
+	// Tags holder
+	tags := []string{}
+
+	if opts, ok := t.(*lua.LTable); ok {
+
+		// Get tag list
+		if list, ok := opts.RawGetString("tags").(*lua.LTable); ok {
+			list.ForEach(func(_, tag lua.LValue) {
+				tags = append(tags, tag.String())
+			})
+		}
+
+		// Get time from the options
+		t = opts.RawGetString("time")
+	}
+
 	// Duration time placeholder. Cache default time
-	dur := util.Config.Configuration.Cache.Default.Duration
+	dur := util.Config.Get().Cache.Default.Duration
 
 	if t.Type() == lua.LTString {
 
@@ -93,12 +114,15 @@ func SetCacheValue(L *lua.LState) int {
 		dur = d
 	}
 
+	// Value holder
+	var v interface{}
+
 	// Switch cache value type
 	switch val.Type() {
 
 	case lua.LTString:
 
-		util.Cache.Set(key.String(), val.String(), dur)
+		v = val.String()
 	case lua.LTNumber:
 
 		// Convert number to float64
@@ -109,8 +133,7 @@ func SetCacheValue(L *lua.LState) int {
 			return 0
 		}
 
-		// Set cache value
-		util.Cache.Set(key.String(), f, dur)
+		v = f
 
 	case lua.LTBool:
 
@@ -122,16 +145,25 @@ func SetCacheValue(L *lua.LState) int {
 			return 0
 		}
 
-		// Set cache value
-		util.Cache.Set(key.String(), b, dur)
+		v = b
 
 	case lua.LTTable:
 
 		// Convert table to map
-		m := TableToMap(val.(*lua.LTable))
+		v = TableToMap(val.(*lua.LTable))
+	}
 
-		// Set cache value
-		util.Cache.Set(key.String(), m, dur)
+	// Set cache value
+	if err := util.LuaCache.Set(key.String(), v, dur); err != nil {
+		L.RaiseError("Cannot set cache value: %v", err)
+		return 0
+	}
+
+	// Tag cache value
+	if len(tags) > 0 {
+		if err := util.LuaCache.Tag(key.String(), tags); err != nil {
+			L.RaiseError("Cannot tag cache value: %v", err)
+		}
 	}
 
 	return 0
@@ -148,7 +180,27 @@ func DeleteCacheValue(L *lua.LState) int {
 	}
 
 	// Delete element from the cache
-	util.Cache.Delete(key.String())
+	if err := util.LuaCache.Delete(key.String()); err != nil {
+		L.RaiseError("Cannot delete cache value: %v", err)
+	}
+
+	return 0
+}
+
+// InvalidateCacheTag removes every cache value with the given tag
+func InvalidateCacheTag(L *lua.LState) int {
+	// Get tag
+	tag := L.Get(2)
+
+	if tag.Type() != lua.LTString {
+		L.ArgError(1, "Invalid cache tag type. Expected string")
+		return 0
+	}
+
+	// Delete tagged elements
+	if err := util.LuaCache.InvalidateTag(tag.String()); err != nil {
+		L.RaiseError("Cannot invalidate cache tag: %v", err)
+	}
 
 	return 0
 }
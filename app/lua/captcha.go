@@ -15,11 +15,29 @@ func SetCaptchaMetaTable(luaState *lua.LState) {
 	luaState.SetFuncs(captchaMetaTable, captchaMethods)
 }
 
-// IsEnabled checks if the captcha service is enabled
+// IsEnabled checks if the captcha service is enabled. If a form name is given the
+// captcha forms configuration is used
 func IsEnabled(L *lua.LState) int {
+	// Get form name
+	form := L.Get(2)
+
+	// Check for valid form type
+	if form.Type() != lua.LTNil && form.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid form name type. Expected string")
+		return 0
+	}
+
 	// Push captcha status
+	if form.Type() == lua.LTNil {
+		L.Push(
+			lua.LBool(util.Config.Get().Captcha.Enabled),
+		)
+		return 1
+	}
+
 	L.Push(
-		lua.LBool(util.Config.Configuration.Captcha.Enabled),
+		lua.LBool(util.Config.Get().Captcha.IsEnabled(form.String())),
 	)
 
 	return 1
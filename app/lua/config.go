@@ -1,8 +1,6 @@
 package lua
 
 import (
-	"os"
-
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
@@ -18,6 +16,9 @@ func SetConfigMetaTable(luaState *lua.LState) {
 
 	// Set all Config metatable functions
 	luaState.SetFuncs(configMetaTable, configMethods)
+
+	// Set reload function outside the method map since it depends on the application state
+	luaState.SetField(configMetaTable, "reload", luaState.NewFunction(ReloadConfigFile))
 }
 
 // LoadConfig loads the lua configuration file using lua vm to get the global variables
@@ -37,6 +38,86 @@ func GetConfigLuaValue(L *lua.LState) int {
 	return 1
 }
 
+// ReloadConfigFile reloads the configuration file and refreshes the app global
+func ReloadConfigFile(L *lua.LState) int {
+	// Reload configuration file
+	ignored, err := util.ReloadConfig("config.toml")
+
+	if err != nil {
+		L.RaiseError("Cannot reload configuration file: %v", err)
+		return 0
+	}
+
+	// Reload config overwrites
+	if err := OverwriteConfigFile(); err != nil {
+		L.RaiseError("Cannot reload external config files: %v", err)
+		return 0
+	}
+
+	// Refresh app global
+	SetConfigGlobal(L)
+
+	// Push ignored fields
+	L.Push(StringSliceToTable(ignored))
+
+	return 1
+}
+
+// SetConfigValue sets a config value and saves the configuration file
+func SetConfigValue(L *lua.LState) int {
+	// Get config key
+	key := L.Get(2)
+
+	// Check valid key
+	if key.Type() != lua.LTString {
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	// Value holder
+	var v interface{}
+
+	switch lv := L.Get(3).(type) {
+	case *lua.LTable:
+
+		// Convert table to go map
+		v = TableToMap(lv)
+
+	case lua.LBool:
+
+		v = bool(lv)
+
+	case lua.LNumber:
+
+		v = float64(lv)
+
+	case lua.LString:
+
+		v = string(lv)
+
+	default:
+		L.ArgError(2, "Invalid value type. Expected table, bool, number or string")
+		return 0
+	}
+
+	// Set configuration value
+	if err := util.Config.SetValue(key.String(), v); err != nil {
+		L.RaiseError("Cannot set config value: %v", err)
+		return 0
+	}
+
+	// Save configuration file
+	if err := util.SaveConfig("config.toml"); err != nil {
+		L.RaiseError("Cannot save configuration file: %v", err)
+		return 0
+	}
+
+	// Refresh app global
+	SetConfigGlobal(L)
+
+	return 0
+}
+
 // SetConfigCustomValue sets the a config custom value
 func SetConfigCustomValue(L *lua.LState) int {
 	// Get config key
@@ -70,19 +151,9 @@ func SetConfigCustomValue(L *lua.LState) int {
 		util.Config.SetCustomValue(key, string(lv))
 	}
 
-	// Open configuration file
-	configFile, err := os.OpenFile("config.toml", os.O_RDWR|os.O_TRUNC, 0660)
-
-	if err != nil {
-		L.RaiseError("Cannot open configuration file: %v", err)
-		return 0
-	}
-
-	// Close configuration file
-	defer configFile.Close()
-
-	if err := util.EncodeConfig(configFile, util.Config.Configuration); err != nil {
-		L.RaiseError("Cannot encode configuration file: %v", err)
+	// Save configuration file
+	if err := util.SaveConfig("config.toml"); err != nil {
+		L.RaiseError("Cannot save configuration file: %v", err)
 	}
 
 	return 0
@@ -1,6 +1,63 @@
 package lua
 
 const (
+	// HealthMetaTableName the name of the health metatable
+	HealthMetaTableName = "health"
+
+	// DiscordMetaTableName the name of the discord metatable
+	DiscordMetaTableName = "discord"
+
+	// FormatMetaTableName the name of the format metatable
+	FormatMetaTableName = "format"
+
+	// JWTMetaTableName the name of the jwt metatable
+	JWTMetaTableName = "jwt"
+
+	// WebSocketMetaTableName the name of the websocket metatable
+	WebSocketMetaTableName = "websocket"
+
+	// SitemapMetaTableName the name of the sitemap metatable
+	SitemapMetaTableName = "sitemap"
+
+	// GeoIPMetaTableName the name of the geoip metatable
+	GeoIPMetaTableName = "geoip"
+
+	// TemplateMetaTableName the name of the template metatable
+	TemplateMetaTableName = "template"
+
+	// TokenMetaTableName the name of the token metatable
+	TokenMetaTableName = "token"
+
+	// MetricsMetaTableName the name of the metrics metatable
+	MetricsMetaTableName = "metrics"
+
+	// SharedMetaTableName the name of the shared metatable
+	SharedMetaTableName = "shared"
+
+	// CSVMetaTableName the name of the csv metatable
+	CSVMetaTableName = "csv"
+
+	// HTMLMetaTableName the name of the html metatable
+	HTMLMetaTableName = "html"
+
+	// MarkdownMetaTableName the name of the markdown metatable
+	MarkdownMetaTableName = "markdown"
+
+	// GuildMetaTableName the name of the guild metatable
+	GuildMetaTableName = "guild"
+
+	// PayGolMetaTableName the name of the paygol metatable
+	PayGolMetaTableName = "paygol"
+
+	// ServerMetaTableName the name of the server metatable
+	ServerMetaTableName = "server"
+
+	// AccountMetaTableName the name of the account metatable
+	AccountMetaTableName = "account"
+
+	// RateLimitMetaTableName the name of the ratelimit metatable
+	RateLimitMetaTableName = "ratelimit"
+
 	// I18nMetaTableName the name of the i18n metatable
 	I18nMetaTableName = "i18n"
 
@@ -0,0 +1,163 @@
+package lua
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// corsPreflightWriter response writer used while a page handler runs for a preflight request.
+// The handler output is discarded so only http.cors can answer the request
+type corsPreflightWriter struct {
+	http.ResponseWriter
+	header  http.Header
+	handled bool
+}
+
+// NewCORSPreflightWriter wraps the response writer of a preflight request
+func NewCORSPreflightWriter(w http.ResponseWriter) http.ResponseWriter {
+	return &corsPreflightWriter{
+		ResponseWriter: w,
+		header:         http.Header{},
+	}
+}
+
+// Header returns a header map that is never sent
+func (p *corsPreflightWriter) Header() http.Header {
+	return p.header
+}
+
+// Write discards the handler output
+func (p *corsPreflightWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader discards the handler status code
+func (p *corsPreflightWriter) WriteHeader(int) {}
+
+// Hijack takes over the connection
+func (p *corsPreflightWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijackResponseWriter(p.ResponseWriter)
+}
+
+// Flush does nothing since the handler output is discarded
+func (p *corsPreflightWriter) Flush() {}
+
+// CORSPreflightHandled checks if http.cors answered the preflight request of the given writer
+func CORSPreflightHandled(w http.ResponseWriter) bool {
+	p, ok := w.(*corsPreflightWriter)
+	return ok && p.handled
+}
+
+// SetCORSHeaders sets the Access-Control-Allow-* headers when the request origin is on the
+// origins list of the options table. The options table accepts origins, methods (default GET
+// and POST), headers (default Content-Type), credentials and maxAge (seconds, default 600).
+// Preflight requests only run the options function of the page handler. They are answered with
+// a 204 response and true is returned so the function can return right away
+func SetCORSHeaders(L *glua.LState) int {
+	// Get options table
+	opts, ok := L.Get(2).(*glua.LTable)
+
+	if !ok {
+		L.ArgError(1, "Invalid cors options type. Expected table")
+		return 0
+	}
+
+	// Get allowed origins
+	origins, ok := opts.RawGetString("origins").(*glua.LTable)
+
+	if !ok {
+		L.ArgError(1, "Missing 'origins' table field")
+		return 0
+	}
+
+	// Get credentials flag
+	credentials := glua.LVAsBool(opts.RawGetString("credentials"))
+
+	// Get allowed methods and headers
+	methods := corsList(opts.RawGetString("methods"), "GET, POST")
+	headers := corsList(opts.RawGetString("headers"), "Content-Type")
+
+	// Get preflight cache time
+	maxAge := 600
+
+	if v, ok := opts.RawGetString("maxAge").(glua.LNumber); ok {
+		maxAge = int(v)
+	}
+
+	// Get HTTP request and HTTP response writer
+	req, w := getRequestAndResponseWriter(L)
+
+	// Preflight requests are answered using the real response writer
+	preflight, isPreflight := w.(*corsPreflightWriter)
+
+	if isPreflight {
+		w = preflight.ResponseWriter
+	}
+
+	// Responses depend on the request origin
+	w.Header().Add("Vary", "Origin")
+
+	// Check if origin is allowed
+	origin := req.Header.Get("Origin")
+	allowed := ""
+
+	origins.ForEach(func(_, v glua.LValue) {
+		switch v.String() {
+		case origin:
+			allowed = origin
+		case "*":
+			if allowed == "" && !credentials {
+				allowed = "*"
+			}
+		}
+	})
+
+	if origin != "" && allowed != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if isPreflight {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
+		}
+	}
+
+	if !isPreflight {
+		L.Push(glua.LFalse)
+		return 1
+	}
+
+	// Answer preflight request
+	w.WriteHeader(http.StatusNoContent)
+	preflight.handled = true
+
+	L.Push(glua.LTrue)
+
+	return 1
+}
+
+// corsList joins the values of the given table or returns the default value
+func corsList(v glua.LValue, def string) string {
+	tbl, ok := v.(*glua.LTable)
+
+	if !ok {
+		return def
+	}
+
+	list := []string{}
+
+	tbl.ForEach(func(_, v glua.LValue) {
+		list = append(list, v.String())
+	})
+
+	return strings.Join(list, ", ")
+}
@@ -3,13 +3,18 @@ package lua
 import (
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/dchest/uniuri"
+	"github.com/raggaer/castro/app/util"
 	"github.com/skip2/go-qrcode"
 	"github.com/yuin/gopher-lua"
 )
@@ -147,6 +152,60 @@ func RandomString(L *lua.LState) int {
 	return 1
 }
 
+// VerifyPassword checks the given password against a stored bcrypt or sha1 hash. Returns if the
+// password is valid and if the stored hash should be upgraded to bcrypt
+func VerifyPassword(L *lua.LState) int {
+	// Get stored hash
+	stored := L.Get(2)
+
+	// Check for valid hash type
+	if stored.Type() != lua.LTString {
+		L.ArgError(1, "Invalid hash type. Expected string")
+		return 0
+	}
+
+	// Get password
+	password := L.Get(3)
+
+	// Check for valid password type
+	if password.Type() != lua.LTString {
+		L.ArgError(2, "Invalid password type. Expected string")
+		return 0
+	}
+
+	// Verify password
+	valid, upgrade := util.VerifyPassword(stored.String(), password.String())
+
+	L.Push(lua.LBool(valid))
+	L.Push(lua.LBool(valid && upgrade))
+
+	return 2
+}
+
+// HashPassword hashes the given password using bcrypt
+func HashPassword(L *lua.LState) int {
+	// Get password
+	password := L.Get(2)
+
+	// Check for valid password type
+	if password.Type() != lua.LTString {
+		L.ArgError(1, "Invalid password type. Expected string")
+		return 0
+	}
+
+	// Hash password
+	hash, err := util.HashPasswordBcrypt(password.String())
+
+	if err != nil {
+		L.RaiseError("Cannot hash password: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(hash))
+
+	return 1
+}
+
 // GenerateAuthSecretKey generates a valid authentication secret key
 func GenerateAuthSecretKey(L *lua.LState) int {
 	// Push random key
@@ -155,24 +214,268 @@ func GenerateAuthSecretKey(L *lua.LState) int {
 	return 1
 }
 
+// VerifyTOTP checks if the given time-based token is valid for the given secret key
+func VerifyTOTP(L *lua.LState) int {
+	// Get token
+	token := L.Get(2)
+
+	// Check for valid token type
+	if token.Type() != lua.LTString {
+		L.ArgError(1, "Invalid token type. Expected string")
+		return 0
+	}
+
+	// Get secret key
+	secret := L.Get(3)
+
+	// Check for valid secret type
+	if secret.Type() != lua.LTString {
+		L.ArgError(2, "Invalid secret type. Expected string")
+		return 0
+	}
+
+	// Validate token
+	valid, err := verifyTOTP(token.String(), secret.String())
+
+	if err != nil {
+		L.RaiseError("Cannot authenticate token: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(valid))
+
+	return 1
+}
+
 // GenerateQRCode generates a QR code for the given string and returns a base64 encoded image
 func GenerateQRCode(L *lua.LState) int {
+	// Create QR code
+	code := encodeQRCode(L)
+
+	if code == nil {
+		return 0
+	}
+
+	// Base64 encode the byte array
+	encoded := base64.StdEncoding.EncodeToString(code)
+
+	// Push as string
+	L.Push(lua.LString(encoded))
+
+	return 1
+}
+
+// GenerateQRCodeDataURI generates a QR code for the given string and returns a png data URI
+func GenerateQRCodeDataURI(L *lua.LState) int {
+	// Create QR code
+	code := encodeQRCode(L)
+
+	if code == nil {
+		return 0
+	}
+
+	// Push data URI
+	L.Push(lua.LString("data:image/png;base64," + base64.StdEncoding.EncodeToString(code)))
+
+	return 1
+}
+
+// encodeQRCode creates a png QR code using the optional size and level options
+func encodeQRCode(L *lua.LState) []byte {
 	// Get string to encode
 	msg := L.ToString(2)
 
+	// Default QR code options
+	size := 256
+	level := qrcode.Medium
+
+	// Get options table
+	if opts, ok := L.Get(3).(*lua.LTable); ok {
+
+		// Get image size
+		if v, ok := L.GetField(opts, "size").(lua.LNumber); ok {
+			size = int(v)
+		}
+
+		// Get error correction level
+		switch v := L.GetField(opts, "level"); v.String() {
+		case "low":
+			level = qrcode.Low
+		case "medium":
+			level = qrcode.Medium
+		case "high":
+			level = qrcode.High
+		case "highest":
+			level = qrcode.Highest
+		default:
+			if v == lua.LNil {
+				break
+			}
+
+			L.ArgError(2, "Invalid QR code level. Expected low, medium, high or highest")
+			return nil
+		}
+	}
+
 	// Create QR code
-	code, err := qrcode.Encode(msg, qrcode.Medium, 256)
+	code, err := qrcode.Encode(msg, level, size)
 
 	if err != nil {
 		L.RaiseError("Cannot create QR code: %v", err)
+		return nil
+	}
+
+	return code
+}
+
+// CryptoBase64Encode encodes the given string to base64 using the standard or url variant
+func CryptoBase64Encode(L *lua.LState) int {
+	// Get string to be encoded
+	str := L.Get(2)
+
+	// Check for valid string type
+	if str.Type() != lua.LTString {
+		L.ArgError(1, "Invalid string format. Expected string")
 		return 0
 	}
 
-	// Base64 encode the byte array
-	encoded := base64.StdEncoding.EncodeToString(code)
+	// Get encoding variant
+	encoding, ok := getBase64Encoding(L.Get(3))
 
-	// Push as string
-	L.Push(lua.LString(encoded))
+	if !ok {
+		L.ArgError(2, "Invalid base64 variant. Expected std or url")
+		return 0
+	}
+
+	// Push encoded string
+	L.Push(lua.LString(encoding.EncodeToString([]byte(str.String()))))
+
+	return 1
+}
+
+// CryptoBase64Decode decodes the given base64 string using the standard or url variant
+func CryptoBase64Decode(L *lua.LState) int {
+	// Get string to be decoded
+	str := L.Get(2)
+
+	// Check for valid string type
+	if str.Type() != lua.LTString {
+		L.ArgError(1, "Invalid string format. Expected string")
+		return 0
+	}
+
+	// Get encoding variant
+	encoding, ok := getBase64Encoding(L.Get(3))
+
+	if !ok {
+		L.ArgError(2, "Invalid base64 variant. Expected std or url")
+		return 0
+	}
+
+	// Decode string
+	decoded, err := encoding.DecodeString(str.String())
+
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	// Push decoded bytes
+	L.Push(lua.LString(decoded))
+
+	return 1
+}
+
+// getBase64Encoding returns the base64 encoding for the given variant name
+func getBase64Encoding(variant lua.LValue) (*base64.Encoding, bool) {
+	// Use standard encoding by default
+	if variant == lua.LNil {
+		return base64.StdEncoding, true
+	}
+
+	switch variant.String() {
+	case "std":
+		return base64.StdEncoding, true
+	case "url":
+		return base64.URLEncoding, true
+	}
+
+	return nil, false
+}
+
+// GenerateUUID returns a random version 4 UUID. Passing 7 returns a time sortable version 7 UUID
+func GenerateUUID(L *lua.LState) int {
+	// Get UUID version
+	version := L.Get(2)
+
+	// UUID holder
+	var uuid [16]byte
+
+	// Fill UUID with random data
+	if _, err := rand.Read(uuid[:]); err != nil {
+		L.RaiseError("Cannot generate UUID: %v", err)
+		return 0
+	}
+
+	switch {
+	case version == lua.LNil || version == lua.LNumber(4):
+
+		// Set version 4 bits
+		uuid[6] = (uuid[6] & 0x0f) | 0x40
+
+	case version == lua.LNumber(7):
+
+		// Set unix millisecond timestamp on the first 48 bits
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+		copy(uuid[:6], ts[2:])
+
+		// Set version 7 bits
+		uuid[6] = (uuid[6] & 0x0f) | 0x70
+
+	default:
+		L.ArgError(1, "Invalid UUID version. Expected 4 or 7")
+		return 0
+	}
+
+	// Set RFC 4122 variant bits
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	// Push UUID string
+	L.Push(
+		lua.LString(
+			fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]),
+		),
+	)
+
+	return 1
+}
+
+// SecureCompare compares two strings in constant time. Both strings are hashed first so
+// strings of different length do not return early
+func SecureCompare(L *lua.LState) int {
+	// Get strings
+	a := L.Get(2)
+	b := L.Get(3)
+
+	// Check for valid types
+	if a.Type() != lua.LTString {
+		L.ArgError(1, "Invalid string type. Expected string")
+		return 0
+	}
+
+	if b.Type() != lua.LTString {
+		L.ArgError(2, "Invalid string type. Expected string")
+		return 0
+	}
+
+	// Hash both strings
+	ha := sha256.Sum256([]byte(a.String()))
+	hb := sha256.Sum256([]byte(b.String()))
+
+	// Push comparison result
+	L.Push(lua.LBool(subtle.ConstantTimeCompare(ha[:], hb[:]) == 1))
 
 	return 1
 }
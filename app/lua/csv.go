@@ -0,0 +1,166 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// SetCSVMetaTable sets the csv metatable of the given state
+func SetCSVMetaTable(luaState *lua.LState) {
+	// Create and set the csv metatable
+	csvMetaTable := luaState.NewTypeMetatable(CSVMetaTableName)
+	luaState.SetGlobal(CSVMetaTableName, csvMetaTable)
+
+	// Set all csv metatable functions
+	luaState.SetFuncs(csvMetaTable, csvMethods)
+}
+
+// EncodeCSV encodes the given list of rows as a CSV string. When a headers list is given
+// a header line is written and every row is read as a table keyed by header name,
+// otherwise rows are read as lists of values
+func EncodeCSV(L *lua.LState) int {
+	// Get rows table
+	rows := L.Get(2)
+
+	// Check for valid rows type
+	if rows.Type() != lua.LTTable {
+
+		L.ArgError(1, "Invalid rows type. Expected table")
+		return 0
+	}
+
+	// Get optional headers
+	headers := []string{}
+
+	switch h := L.Get(3); h.Type() {
+	case lua.LTTable:
+		h.(*lua.LTable).ForEach(func(_, v lua.LValue) {
+			headers = append(headers, v.String())
+		})
+	case lua.LTNil:
+	default:
+		L.ArgError(2, "Invalid headers type. Expected table")
+		return 0
+	}
+
+	buff := &bytes.Buffer{}
+	w := csv.NewWriter(buff)
+
+	// Write header line
+	if len(headers) > 0 {
+		if err := w.Write(headers); err != nil {
+			L.RaiseError("Cannot encode csv headers: %v", err)
+			return 0
+		}
+	}
+
+	// Write every row
+	tbl := rows.(*lua.LTable)
+
+	for i := 1; i <= tbl.Len(); i++ {
+		row, ok := tbl.RawGetInt(i).(*lua.LTable)
+
+		if !ok {
+			L.ArgError(1, "Invalid row type. Expected table")
+			return 0
+		}
+
+		record := []string{}
+
+		if len(headers) > 0 {
+			for _, header := range headers {
+				record = append(record, csvFieldValue(row.RawGetString(header)))
+			}
+		} else {
+			for j := 1; j <= row.Len(); j++ {
+				record = append(record, csvFieldValue(row.RawGetInt(j)))
+			}
+		}
+
+		if err := w.Write(record); err != nil {
+			L.RaiseError("Cannot encode csv row: %v", err)
+			return 0
+		}
+	}
+
+	// Flush writer
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		L.RaiseError("Cannot encode csv: %v", err)
+		return 0
+	}
+
+	// Push result as string
+	L.Push(lua.LString(buff.String()))
+
+	return 1
+}
+
+// DecodeCSV decodes the given CSV string to a list of rows. If the second argument is true
+// the first line is used as headers and every row is returned as a table keyed by header
+func DecodeCSV(L *lua.LState) int {
+	// Get input string
+	src := L.Get(2)
+
+	// Check for valid string type
+	if src.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid csv source. Expected string")
+		return 0
+	}
+
+	// Check if first line holds the headers
+	useHeaders := lua.LVAsBool(L.Get(3))
+
+	// Create reader allowing rows of different length
+	r := csv.NewReader(strings.NewReader(src.String()))
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+
+	if err != nil {
+		L.RaiseError("Cannot decode csv: %v", err)
+		return 0
+	}
+
+	result := L.NewTable()
+	headers := []string{}
+
+	for i, record := range records {
+		// Use first line as headers
+		if useHeaders && i == 0 {
+			headers = record
+			continue
+		}
+
+		row := L.NewTable()
+
+		for j, field := range record {
+			if useHeaders && j < len(headers) {
+				row.RawSetString(headers[j], lua.LString(field))
+			} else {
+				row.RawSetInt(j+1, lua.LString(field))
+			}
+		}
+
+		result.Append(row)
+	}
+
+	// Push rows table
+	L.Push(result)
+
+	return 1
+}
+
+// csvFieldValue converts a lua value to a csv field
+func csvFieldValue(v lua.LValue) string {
+	if v == lua.LNil {
+		return ""
+	}
+
+	return v.String()
+}
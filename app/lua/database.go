@@ -2,8 +2,12 @@ package lua
 
 import (
 	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
@@ -22,6 +26,102 @@ func SetDatabaseMetaTable(luaState *lua.LState) {
 	luaState.SetField(mysqlMetaTable, DatabaseTransactionStatusFieldName, lua.LBool(false))
 }
 
+// ConnectDatabase registers a named database connection using the given DSN. Connections are
+// shared by every request so connecting again with the same DSN reuses the open connection
+func ConnectDatabase(L *lua.LState) int {
+	// Get connection name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString || name.String() == "" {
+		L.ArgError(1, "Invalid connection name type. Expected string")
+		return 0
+	}
+
+	// Get DSN
+	dsn := L.Get(3)
+
+	// Check for valid DSN type
+	if dsn.Type() != lua.LTString {
+		L.ArgError(2, "Invalid DSN type. Expected string")
+		return 0
+	}
+
+	// Open connection
+	if _, err := database.Connect(name.String(), dsn.String()); err != nil {
+		return pushError(L, "Cannot connect to database", err)
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// UseDatabase returns a database object that runs queries using the given named connection
+func UseDatabase(L *lua.LState) int {
+	// Get connection name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid connection name type. Expected string")
+		return 0
+	}
+
+	// Get connection
+	db := database.Named(name.String())
+
+	if db == nil {
+		L.RaiseError("Unknown database connection %v", name.String())
+		return 0
+	}
+
+	// Create database table
+	tbl := L.NewTable()
+
+	// Create connection user data
+	dbUserData := L.NewUserData()
+	dbUserData.Value = db
+
+	// Set the user data and name fields
+	L.SetField(tbl, "__database", dbUserData)
+	L.SetField(tbl, "name", name)
+
+	// Set the database methods
+	L.SetFuncs(tbl, namedDatabaseMethods)
+
+	// Push database table
+	L.Push(tbl)
+
+	return 1
+}
+
+// databaseHandle returns the connection of the database object the function was called on and
+// its name. Calls on the db metatable use the main connection
+func databaseHandle(L *lua.LState) (*sqlx.DB, string) {
+	// Get database object
+	tbl, ok := L.Get(1).(*lua.LTable)
+
+	if !ok {
+		return database.DB, ""
+	}
+
+	// Get user data
+	data, ok := tbl.RawGetString("__database").(*lua.LUserData)
+
+	if !ok {
+		return database.DB, ""
+	}
+
+	db, ok := data.Value.(*sqlx.DB)
+
+	if !ok {
+		L.RaiseError("Cannot retrieve database connection from user data")
+	}
+
+	return db, tbl.RawGetString("name").String()
+}
+
 // Wrapper around database.DB.Exec
 func executeQueryHelper(L *lua.LState, query string, args ...interface{}) (sql.Result, error) {
 	return database.DB.Exec(query, args)
@@ -53,16 +153,18 @@ func Execute(L *lua.LState) int {
 	}
 
 	// Log query on development mode
-	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
 		util.Logger.Logger.Infof("execute: "+strings.Replace(query.String(), "?", "%v", -1), args...)
 	}
 
+	// Get database handle
+	db, _ := databaseHandle(L)
+
 	// Execute query using database or transaction
-	result, err := database.DB.Exec(query.String(), args...)
+	result, err := db.Exec(query.String(), args...)
 
 	if err != nil {
-		L.RaiseError("Cannot execute query: %v", err)
-		return 0
+		return raiseError(L, "Cannot execute query", err)
 	}
 
 	// Check if query is INSERT
@@ -74,8 +176,7 @@ func Execute(L *lua.LState) int {
 	id, err := result.LastInsertId()
 
 	if err != nil {
-		L.RaiseError("Cannot get last inserted id: %v", err)
-		return 0
+		return raiseError(L, "Cannot get last inserted id", err)
 	}
 
 	// Push id
@@ -112,10 +213,18 @@ func SingleQuery(L *lua.LState) int {
 	// Check if user wants to use cache
 	cache := L.ToBool(3 + n)
 
+	// Get database handle
+	db, name := databaseHandle(L)
+
 	// Save cache variable
 	saveToCache := false
 	cacheKey := query.String()
 
+	// Named connections use their own cache keys
+	if name != "" {
+		cacheKey = name + ":" + cacheKey
+	}
+
 	if cache {
 
 		// Build cache key as the full query with arguments inside
@@ -158,43 +267,30 @@ func SingleQuery(L *lua.LState) int {
 	}
 
 	// Log query on development mode
-	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
 		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
 	}
 
 	// Run query
-	rows, err := database.DB.Queryx(query.String(), args...)
+	rows, err := db.Queryx(query.String(), args...)
 
 	if err != nil {
-		L.RaiseError("Cannot execute query: %v", err)
-		return 0
+		return raiseError(L, "Cannot execute query", err)
 	}
 
 	// Close rows
 	defer rows.Close()
 
-	// Result holder
-	results := L.NewTable()
+	// Scan rows to lua table
+	results, err := scanQueryRows(L, rows)
 
-	// Loop rows
-	for rows.Next() {
-
-		// Hold current row
-		result := make(map[string]interface{})
-
-		// Scan row to map
-		if err := rows.MapScan(result); err != nil {
-			L.RaiseError("Cannot map row to map: %v", err)
-			return 0
-		}
-
-		// Append to lua table
-		results.Append(MapToTable(result))
+	if err != nil {
+		return raiseError(L, "Cannot map row to map", err)
 	}
 
 	// If user wants to use cache save table
 	if saveToCache {
-		util.Cache.Add(cacheKey, results, util.Config.Configuration.Cache.Default.Duration)
+		util.Cache.Add(cacheKey, results, util.Config.Get().Cache.Default.Duration)
 	}
 
 	// If there are no results return nil
@@ -244,10 +340,18 @@ func Query(L *lua.LState) int {
 	// Check if user wants to use cache
 	cache := L.ToBool(3 + n)
 
+	// Get database handle
+	db, name := databaseHandle(L)
+
 	// Save cache variable
 	saveToCache := false
 	cacheKey := query.String()
 
+	// Named connections use their own cache keys
+	if name != "" {
+		cacheKey = name + ":" + cacheKey
+	}
+
 	if cache {
 
 		// Build cache key as the full query with arguments inside
@@ -287,43 +391,30 @@ func Query(L *lua.LState) int {
 	}
 
 	// Log query on development mode
-	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
 		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
 	}
 
 	// Run query
-	rows, err := database.DB.Queryx(query.String(), args...)
+	rows, err := db.Queryx(query.String(), args...)
 
 	if err != nil {
-		L.RaiseError("Cannot execute query: %v", err)
-		return 0
+		return raiseError(L, "Cannot execute query", err)
 	}
 
 	// Close rows
 	defer rows.Close()
 
-	// Result holder
-	results := L.NewTable()
-
-	// Loop rows
-	for rows.Next() {
-
-		// Hold current row
-		result := make(map[string]interface{})
-
-		// Scan row to map
-		if err := rows.MapScan(result); err != nil {
-			L.RaiseError("Cannot map row to map: %v", err)
-			return 0
-		}
+	// Scan rows to lua table
+	results, err := scanQueryRows(L, rows)
 
-		// Append to lua table
-		results.Append(MapToTable(result))
+	if err != nil {
+		return raiseError(L, "Cannot map row to map", err)
 	}
 
 	// If user wants to use cache save table
 	if saveToCache {
-		util.Cache.Add(cacheKey, results, util.Config.Configuration.Cache.Default.Duration)
+		util.Cache.Add(cacheKey, results, util.Config.Get().Cache.Default.Duration)
 	}
 
 	// If there are no results return nil
@@ -344,3 +435,652 @@ func Query(L *lua.LState) int {
 
 	return 2
 }
+
+// QueryWithColumns executes the given query returning a {columns, rows} table. Columns holds the
+// column names in order and every row is an array with the values in the same order, NULL values
+// are nil so rows should be iterated using the number of columns
+func QueryWithColumns(L *lua.LState) int {
+	// Get query
+	query := L.Get(2)
+
+	// Check if query is valid
+	if query.Type() != lua.LTString {
+
+		// Raise error
+		L.ArgError(1, "Invalid query type. Expected string")
+		return 0
+	}
+
+	// Get query params
+	args := []interface{}{}
+
+	switch params := L.Get(3).(type) {
+	case *lua.LTable:
+		for i := 1; i <= params.Len(); i++ {
+			args = append(args, params.RawGetInt(i).String())
+		}
+	case *lua.LNilType:
+	default:
+		L.ArgError(2, "Invalid params type. Expected table")
+		return 0
+	}
+
+	// Log query on development mode
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
+	}
+
+	// Get database handle
+	db, _ := databaseHandle(L)
+
+	// Run query
+	rows, err := db.Queryx(query.String(), args...)
+
+	if err != nil {
+		return raiseError(L, "Cannot execute query", err)
+	}
+
+	// Close rows
+	defer rows.Close()
+
+	// Get column types
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		return raiseError(L, "Cannot get query columns", err)
+	}
+
+	// Check if values should be kept as returned by the driver
+	raw := lua.LVAsBool(L.GetField(L.GetTypeMetatable(DatabaseMetaTableName), "stringResults"))
+
+	// Create column list
+	columnList := L.NewTable()
+
+	for _, column := range columns {
+		columnList.Append(lua.LString(column.Name()))
+	}
+
+	// Scan rows as positional arrays
+	rowList := L.NewTable()
+
+	for rows.Next() {
+		values, err := rows.SliceScan()
+
+		if err != nil {
+			return raiseError(L, "Cannot scan row", err)
+		}
+
+		row := L.NewTable()
+
+		for i, v := range values {
+			if v != nil && !raw {
+				v = convertColumnValue(columns[i], v)
+			}
+
+			row.RawSetInt(i+1, ValueToLua(v))
+		}
+
+		rowList.Append(row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return raiseError(L, "Cannot read rows", err)
+	}
+
+	// Create result table
+	result := L.NewTable()
+	result.RawSetString("columns", columnList)
+	result.RawSetString("rows", rowList)
+
+	L.Push(result)
+
+	return 1
+}
+
+// QueryEach executes the given query calling the function with every row. Rows are read one
+// at a time so big results are never fully loaded. Returning false from the function stops
+// the iteration. Returns the number of rows processed
+func QueryEach(L *lua.LState) int {
+	// Get query
+	query := L.Get(2)
+
+	// Check if query is valid
+	if query.Type() != lua.LTString {
+
+		// Raise error
+		L.ArgError(1, "Invalid query type. Expected string")
+		return 0
+	}
+
+	// Get query params
+	args := []interface{}{}
+
+	switch params := L.Get(3).(type) {
+	case *lua.LTable:
+		for i := 1; i <= params.Len(); i++ {
+			args = append(args, params.RawGetInt(i).String())
+		}
+	case *lua.LNilType:
+	default:
+		L.ArgError(2, "Invalid params type. Expected table")
+		return 0
+	}
+
+	// Get row function
+	fn := L.Get(4)
+
+	if fn.Type() != lua.LTFunction {
+		L.ArgError(3, "Invalid row function type. Expected function")
+		return 0
+	}
+
+	// Log query on development mode
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
+	}
+
+	// Get database handle
+	db, _ := databaseHandle(L)
+
+	// Run query
+	rows, err := db.Queryx(query.String(), args...)
+
+	if err != nil {
+		return raiseError(L, "Cannot execute query", err)
+	}
+
+	// Close rows. Also runs when the row function raises an error
+	defer rows.Close()
+
+	// Get column types
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		return raiseError(L, "Cannot get query columns", err)
+	}
+
+	// Check if values should be kept as returned by the driver
+	raw := lua.LVAsBool(L.GetField(L.GetTypeMetatable(DatabaseMetaTableName), "stringResults"))
+
+	// Queries of the row function raise unless they use a try variant
+	try := isTryCall(L)
+	setTryCall(L, false)
+	defer setTryCall(L, try)
+
+	// Loop rows
+	n := 0
+
+	for rows.Next() {
+
+		// Scan row
+		result, err := scanQueryRow(rows, columns, raw)
+
+		if err != nil {
+			return raiseError(L, "Cannot map row to map", err)
+		}
+
+		n++
+
+		// Call row function
+		L.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    1,
+			Protect: false,
+		}, MapToTable(result))
+
+		// Get returned value
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		// Stop on false
+		if ret == lua.LFalse {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return raiseError(L, "Cannot read query rows", err)
+	}
+
+	// Push number of processed rows
+	L.Push(lua.LNumber(n))
+
+	return 1
+}
+
+// Paginate executes the given query returning a single page of results along with the total
+// number of rows. The query can contain a {{limit}} placeholder, otherwise LIMIT is appended
+func Paginate(L *lua.LState) int {
+	// Get query
+	query := L.Get(2)
+
+	// Check if query is valid
+	if query.Type() != lua.LTString {
+		L.ArgError(1, "Invalid query type. Expected string")
+		return 0
+	}
+
+	// Get query params
+	params := L.Get(3)
+
+	args := []interface{}{}
+
+	switch p := params.(type) {
+	case *lua.LTable:
+
+		// Get all params in order
+		for i := 1; i <= p.Len(); i++ {
+			args = append(args, p.RawGetInt(i).String())
+		}
+
+	case *lua.LNilType:
+	default:
+		L.ArgError(2, "Invalid query params. Expected table")
+		return 0
+	}
+
+	// Check params count
+	if strings.Count(query.String(), "?") != len(args) {
+		L.ArgError(2, "Invalid query params. Params count does not match the query")
+		return 0
+	}
+
+	// Get page
+	page := 1
+
+	if v := L.Get(4); v != lua.LNil {
+		page = L.CheckInt(4)
+	}
+
+	if page < 1 {
+		L.ArgError(3, "Invalid page. Expected number greater than zero")
+		return 0
+	}
+
+	// Get items per page
+	perPage := 10
+
+	if v := L.Get(5); v != lua.LNil {
+		perPage = L.CheckInt(5)
+	}
+
+	if perPage < 1 || perPage > 1000 {
+		L.ArgError(4, "Invalid items per page. Expected number between 1 and 1000")
+		return 0
+	}
+
+	// Remove trailing semicolon
+	base := strings.TrimRight(strings.TrimSpace(query.String()), ";")
+
+	// Limit clause
+	limit := fmt.Sprintf("LIMIT %d OFFSET %d", perPage, (page-1)*perPage)
+
+	// Query holders
+	pageQuery := ""
+	countQuery := ""
+
+	if strings.Contains(base, "{{limit}}") {
+
+		// Replace placeholder
+		pageQuery = strings.Replace(base, "{{limit}}", limit, 1)
+		countQuery = strings.Replace(base, "{{limit}}", "", 1)
+
+	} else {
+
+		// Appending LIMIT is only safe when the query has no LIMIT clause
+		if strings.Contains(strings.ToUpper(base), "LIMIT") {
+			L.ArgError(1, "Invalid query. Use the {{limit}} placeholder for queries with a LIMIT clause")
+			return 0
+		}
+
+		pageQuery = base + " " + limit
+		countQuery = base
+	}
+
+	// Wrap count query
+	countQuery = "SELECT COUNT(*) FROM (" + countQuery + ") AS castro_paginate"
+
+	// Get database handle
+	db, _ := databaseHandle(L)
+
+	// Run pagination queries
+	tbl, err := paginateQuery(L, db, pageQuery, countQuery, args, page, perPage)
+
+	if err != nil {
+		return raiseError(L, "Cannot paginate query", err)
+	}
+
+	// Push pagination table
+	L.Push(tbl)
+
+	return 1
+}
+
+// paginateQuery runs the page and count queries returning the pagination table
+func paginateQuery(L *lua.LState, db *sqlx.DB, pageQuery, countQuery string, args []interface{}, page, perPage int) (*lua.LTable, error) {
+	// Log query on development mode
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+		util.Logger.Logger.Infof("paginate: "+strings.Replace(pageQuery, "?", "%v", -1), args...)
+	}
+
+	// Get total number of rows
+	total := 0
+
+	if err := db.Get(&total, countQuery, args...); err != nil {
+		return nil, fmt.Errorf("Cannot execute count query: %w", err)
+	}
+
+	// Run page query
+	rows, err := db.Queryx(pageQuery, args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot execute query: %w", err)
+	}
+
+	// Close rows
+	defer rows.Close()
+
+	// Scan rows to lua table
+	results, err := scanQueryRows(L, rows)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot map row to map: %w", err)
+	}
+
+	// Create pagination table
+	tbl := L.NewTable()
+
+	tbl.RawSetString("rows", results)
+	tbl.RawSetString("total", lua.LNumber(total))
+	tbl.RawSetString("page", lua.LNumber(page))
+	tbl.RawSetString("pages", lua.LNumber((total+perPage-1)/perPage))
+
+	return tbl, nil
+}
+
+// escapeLikePattern escapes the LIKE wildcard characters of the given string
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// sqlIdentifierRegexp valid unquoted table and column names
+var sqlIdentifierRegexp = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// EscapeLike escapes the LIKE wildcard characters of the given string so user input matches
+// literally. The result should be passed as a query parameter with your own wildcards:
+//
+//	db:query("SELECT name FROM players WHERE name LIKE ?", db:escapeLike(term) .. "%")
+func EscapeLike(L *lua.LState) int {
+	// Get input
+	input := L.Get(2)
+
+	// Check for valid input type
+	if input.Type() != lua.LTString && input.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid input type. Expected string")
+		return 0
+	}
+
+	L.Push(lua.LString(escapeLikePattern(input.String())))
+
+	return 1
+}
+
+// LikeSearch returns the rows of the given table whose column contains the given term. The
+// term is escaped and bound as a parameter. The options table accepts match (contains, prefix
+// or suffix) and limit (default 50). Returns nil if there are no matches
+func LikeSearch(L *lua.LState) int {
+	// Get table name
+	table := L.Get(2)
+
+	// Check for valid table name
+	if table.Type() != lua.LTString || !sqlIdentifierRegexp.MatchString(table.String()) {
+		L.ArgError(1, "Invalid table name. Expected letters, numbers and underscores")
+		return 0
+	}
+
+	// Get column name
+	column := L.Get(3)
+
+	// Check for valid column name
+	if column.Type() != lua.LTString || !sqlIdentifierRegexp.MatchString(column.String()) {
+		L.ArgError(2, "Invalid column name. Expected letters, numbers and underscores")
+		return 0
+	}
+
+	// Get search term
+	term := L.Get(4)
+
+	// Check for valid term type
+	if term.Type() != lua.LTString && term.Type() != lua.LTNumber {
+		L.ArgError(3, "Invalid search term type. Expected string")
+		return 0
+	}
+
+	// Default options
+	match := "contains"
+	limit := 50
+
+	if opts, ok := L.Get(5).(*lua.LTable); ok {
+
+		// Get match mode
+		if v := opts.RawGetString("match"); v != lua.LNil {
+			match = v.String()
+		}
+
+		// Get result limit
+		if v := opts.RawGetString("limit"); v != lua.LNil {
+			n, ok := v.(lua.LNumber)
+
+			if !ok || n < 1 {
+				L.ArgError(4, "Invalid limit. Expected positive number")
+				return 0
+			}
+
+			limit = int(n)
+		}
+	}
+
+	// Build pattern
+	pattern := escapeLikePattern(term.String())
+
+	switch match {
+	case "contains":
+		pattern = "%" + pattern + "%"
+	case "prefix":
+		pattern = pattern + "%"
+	case "suffix":
+		pattern = "%" + pattern
+	default:
+		L.ArgError(4, "Invalid match mode. Expected contains, prefix or suffix")
+		return 0
+	}
+
+	// Get database handle
+	db, _ := databaseHandle(L)
+
+	query := fmt.Sprintf("SELECT * FROM `%v` WHERE `%v` LIKE ? LIMIT %d", table.String(), column.String(), limit)
+
+	// Log query on development mode
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+		util.Logger.Logger.Infof("query: "+strings.Replace(query, "?", "%v", -1), pattern)
+	}
+
+	// Run query
+	rows, err := db.Queryx(query, pattern)
+
+	if err != nil {
+		return raiseError(L, "Cannot execute query", err)
+	}
+
+	// Close rows
+	defer rows.Close()
+
+	// Scan rows to lua table
+	results, err := scanQueryRows(L, rows)
+
+	if err != nil {
+		return raiseError(L, "Cannot map row to map", err)
+	}
+
+	// If there are no results return nil
+	if results.Len() == 0 {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(results)
+
+	return 1
+}
+
+// DatabaseStats returns the database connection pool statistics
+func DatabaseStats(L *lua.LState) int {
+	// Get database handle
+	db, _ := databaseHandle(L)
+
+	// Get connection pool stats
+	stats := db.Stats()
+
+	// Create stats table
+	tbl := L.NewTable()
+
+	tbl.RawSetString("maxOpenConnections", lua.LNumber(stats.MaxOpenConnections))
+	tbl.RawSetString("openConnections", lua.LNumber(stats.OpenConnections))
+	tbl.RawSetString("inUse", lua.LNumber(stats.InUse))
+	tbl.RawSetString("idle", lua.LNumber(stats.Idle))
+	tbl.RawSetString("waitCount", lua.LNumber(stats.WaitCount))
+	tbl.RawSetString("waitDuration", lua.LNumber(stats.WaitDuration.Seconds()))
+	tbl.RawSetString("maxIdleClosed", lua.LNumber(stats.MaxIdleClosed))
+	tbl.RawSetString("maxLifetimeClosed", lua.LNumber(stats.MaxLifetimeClosed))
+
+	// Push stats table
+	L.Push(tbl)
+
+	return 1
+}
+
+// scanQueryRows scans all the rows into a lua table. NULL columns are left out of the row table and
+// column values are converted to lua types using the column database type unless the db.stringResults
+// field is set
+func scanQueryRows(L *lua.LState, rows *sqlx.Rows) (*lua.LTable, error) {
+	// Get column types
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if values should be kept as returned by the driver
+	raw := lua.LVAsBool(L.GetField(L.GetTypeMetatable(DatabaseMetaTableName), "stringResults"))
+
+	// Result holder
+	results := L.NewTable()
+
+	// Loop rows
+	for rows.Next() {
+
+		// Scan row
+		result, err := scanQueryRow(rows, columns, raw)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Append to lua table
+		results.Append(MapToTable(result))
+	}
+
+	return results, rows.Err()
+}
+
+// scanQueryRow scans the current row into a map leaving NULL columns out
+func scanQueryRow(rows *sqlx.Rows, columns []*sql.ColumnType, raw bool) (map[string]interface{}, error) {
+	// Hold current row
+	result := make(map[string]interface{})
+
+	// Scan row to map
+	if err := rows.MapScan(result); err != nil {
+		return nil, err
+	}
+
+	for _, column := range columns {
+
+		// Get column value
+		v := result[column.Name()]
+
+		// Remove NULL columns so they are nil on lua instead of an empty value
+		if v == nil {
+			delete(result, column.Name())
+			continue
+		}
+
+		// Convert column value
+		if !raw {
+			result[column.Name()] = convertColumnValue(column, v)
+		}
+	}
+
+	return result, nil
+}
+
+// convertColumnValue converts a text column value to a number or boolean depending on the column type
+func convertColumnValue(column *sql.ColumnType, v interface{}) interface{} {
+	// Only text values need conversion
+	b, ok := v.([]byte)
+
+	if !ok {
+		return v
+	}
+
+	switch strings.TrimPrefix(column.DatabaseTypeName(), "UNSIGNED ") {
+	case "TINYINT":
+
+		// TINYINT(1) columns hold booleans
+		if length, ok := column.Length(); ok && length == 1 {
+			return string(b) != "0"
+		}
+
+		return parseIntegerColumn(b)
+
+	case "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return parseIntegerColumn(b)
+
+	case "DECIMAL", "FLOAT", "DOUBLE":
+
+		// Convert value to number
+		n, err := strconv.ParseFloat(string(b), 64)
+
+		if err != nil {
+			return v
+		}
+
+		return n
+
+	case "BIT":
+
+		// Convert value to boolean
+		for _, c := range b {
+			if c != 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return v
+}
+
+// parseIntegerColumn converts the given integer column value to int64. Unsigned values
+// outside of the int64 range are kept as uint64
+func parseIntegerColumn(b []byte) interface{} {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		return n
+	}
+
+	if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+		return n
+	}
+
+	return b
+}
@@ -0,0 +1,172 @@
+package lua
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yuin/gopher-lua"
+)
+
+// testDriverName name of the driver returning the rows of testRows
+const testDriverName = "castro-test"
+
+// testColumns columns and database types returned by the test driver
+var testColumns = []struct {
+	name string
+	kind string
+}{
+	{"id", "INT"},
+	{"name", "VARCHAR"},
+	{"guild_id", "INT"},
+	{"nickname", "VARCHAR"},
+}
+
+// testRows rows returned by the test driver. Text columns are sent as bytes like the MySQL driver does
+var testRows = [][]driver.Value{
+	{[]byte("1"), []byte(""), nil, nil},
+	{[]byte("2"), []byte("Bob"), []byte("7"), []byte("")},
+}
+
+func init() {
+	sql.Register(testDriverName, testDriver{})
+}
+
+// testDriver database driver that answers every query with testRows
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) {
+	return testConn{}, nil
+}
+
+type testConn struct{}
+
+func (testConn) Prepare(string) (driver.Stmt, error) {
+	return testStmt{}, nil
+}
+
+func (testConn) Close() error {
+	return nil
+}
+
+func (testConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("Transactions are not supported")
+}
+
+type testStmt struct{}
+
+func (testStmt) Close() error {
+	return nil
+}
+
+func (testStmt) NumInput() int {
+	return -1
+}
+
+func (testStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("Exec is not supported")
+}
+
+func (testStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &testResultRows{}, nil
+}
+
+type testResultRows struct {
+	n int
+}
+
+func (r *testResultRows) Columns() []string {
+	columns := []string{}
+
+	for _, column := range testColumns {
+		columns = append(columns, column.name)
+	}
+
+	return columns
+}
+
+func (r *testResultRows) ColumnTypeDatabaseTypeName(index int) string {
+	return testColumns[index].kind
+}
+
+func (r *testResultRows) Close() error {
+	return nil
+}
+
+func (r *testResultRows) Next(dest []driver.Value) error {
+	if r.n >= len(testRows) {
+		return io.EOF
+	}
+
+	copy(dest, testRows[r.n])
+	r.n++
+
+	return nil
+}
+
+func TestScanQueryRowsNull(t *testing.T) {
+	// Open test database
+	conn, err := sql.Open(testDriverName, "")
+
+	if err != nil {
+		t.Fatalf("cannot open test database: %v", err)
+	}
+
+	db := sqlx.NewDb(conn, "mysql")
+	defer db.Close()
+
+	// Create state with the database metatable
+	L := lua.NewState()
+	defer L.Close()
+
+	SetDatabaseMetaTable(L)
+
+	// Run query
+	rows, err := db.Queryx("SELECT id, name, guild_id, nickname FROM players")
+
+	if err != nil {
+		t.Fatalf("cannot run query: %v", err)
+	}
+
+	defer rows.Close()
+
+	results, err := scanQueryRows(L, rows)
+
+	if err != nil {
+		t.Fatalf("cannot scan rows: %v", err)
+	}
+
+	if results.Len() != len(testRows) {
+		t.Fatalf("got %d rows. Expected %d", results.Len(), len(testRows))
+	}
+
+	tests := []struct {
+		row    int
+		column string
+		want   lua.LValue
+	}{
+		{1, "id", lua.LNumber(1)},
+		{1, "name", lua.LString("")},
+		{1, "guild_id", lua.LNil},
+		{1, "nickname", lua.LNil},
+		{2, "id", lua.LNumber(2)},
+		{2, "name", lua.LString("Bob")},
+		{2, "guild_id", lua.LNumber(7)},
+		{2, "nickname", lua.LString("")},
+	}
+
+	for _, test := range tests {
+		row, ok := results.RawGetInt(test.row).(*lua.LTable)
+
+		if !ok {
+			t.Fatalf("row %d is not a table", test.row)
+		}
+
+		if v := row.RawGetString(test.column); v != test.want {
+			t.Errorf("row %d column %v is %v (%v). Expected %v (%v)", test.row, test.column, v, v.Type(), test.want, test.want.Type())
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package lua
 
 import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
@@ -38,3 +42,71 @@ func DebugValue(L *lua.LState) int {
 
 	return 0
 }
+
+// DebugTraceback returns the current lua stack trace
+func DebugTraceback(L *lua.LState) int {
+	// Create trace buffer
+	buff := &bytes.Buffer{}
+	buff.WriteString("stack traceback:")
+
+	// Loop call stack skipping the traceback call itself
+	for level := 1; ; level++ {
+
+		// Get stack frame
+		frame, ok := L.GetStack(level)
+
+		if !ok {
+			break
+		}
+
+		// Get frame information
+		if _, err := L.GetInfo("Sln", frame, lua.LNil); err != nil {
+			continue
+		}
+
+		// Get function name
+		name := frame.Name
+
+		if name == "" {
+			name = fmt.Sprintf("<%v:%v>", frame.Source, frame.LineDefined)
+		}
+
+		// Go functions have no line information
+		if frame.CurrentLine < 0 {
+			buff.WriteString(fmt.Sprintf("\n\t%v: in function %v", frame.Source, name))
+			continue
+		}
+
+		buff.WriteString(fmt.Sprintf("\n\t%v:%v: in function %v", frame.Source, frame.CurrentLine, name))
+	}
+
+	// Append Go stack trace
+	if L.Options.IncludeGoStackTrace {
+		buff.WriteString("\n")
+		buff.Write(debug.Stack())
+	}
+
+	// Push stack trace
+	L.Push(lua.LString(buff.String()))
+
+	return 1
+}
+
+// DebugStateInfo returns whether the current state was freshly created or reused
+// from the state pool and how many times it has been reused
+func DebugStateInfo(L *lua.LState) int {
+	// Get pool information
+	pooled, reuses := Pool.Info(L)
+
+	// States created outside the pool are always fresh
+	tbl := L.NewTable()
+	tbl.RawSetString("pooled", lua.LBool(pooled))
+	tbl.RawSetString("fresh", lua.LBool(reuses == 0))
+	tbl.RawSetString("reused", lua.LBool(reuses > 0))
+	tbl.RawSetString("reuses", lua.LNumber(reuses))
+
+	// Push info table
+	L.Push(tbl)
+
+	return 1
+}
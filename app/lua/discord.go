@@ -0,0 +1,192 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	// discordMaxRateLimitRetries number of times a rate limited message is sent again
+	discordMaxRateLimitRetries = 3
+
+	// discordMaxRetryAfter longest rate limit wait before giving up
+	discordMaxRetryAfter = 10 * time.Second
+)
+
+// discordPayloadFields webhook payload fields that can be set from lua
+var discordPayloadFields = []string{
+	"content",
+	"username",
+	"avatar_url",
+	"tts",
+	"embeds",
+	"allowed_mentions",
+}
+
+// SetDiscordMetaTable sets the discord metatable of the given state
+func SetDiscordMetaTable(luaState *lua.LState) {
+	// Create and set the discord metatable
+	discordMetaTable := luaState.NewTypeMetatable(DiscordMetaTableName)
+	luaState.SetGlobal(DiscordMetaTableName, discordMetaTable)
+
+	// Set all discord metatable functions
+	luaState.SetFuncs(discordMetaTable, discordMethods)
+}
+
+// SendDiscordWebhook posts a message to the given discord webhook. The message table accepts the
+// content, username, avatar_url, tts, embeds and allowed_mentions fields. Rate limited messages are
+// sent again after the Retry-After delay. Returns the response status code
+func SendDiscordWebhook(L *lua.LState) int {
+	// Get webhook url
+	webhook := L.Get(2)
+
+	// Check for valid url type
+	if webhook.Type() != lua.LTString {
+		L.ArgError(1, "Invalid webhook url type. Expected string")
+		return 0
+	}
+
+	if !isDiscordWebhookURL(webhook.String()) {
+		L.ArgError(1, "Invalid webhook url. Expected a discord webhook url")
+		return 0
+	}
+
+	// Get message table
+	msg, ok := L.Get(3).(*lua.LTable)
+
+	if !ok {
+		L.ArgError(2, "Invalid message type. Expected table")
+		return 0
+	}
+
+	// Build payload
+	payload, err := discordPayload(msg)
+
+	if err != nil {
+		L.ArgError(2, err.Error())
+		return 0
+	}
+
+	// Get retry options
+	opts, err := httpRetryOptionsFromTable(L.Get(4))
+
+	if err != nil {
+		L.ArgError(3, err.Error())
+		return 0
+	}
+
+	if opts.timeout == 0 {
+		opts.timeout = 10 * time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		// Post message
+		resp, err := doHTTPRequestWithRetry(opts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, webhook.String(), bytes.NewReader(payload))
+
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("Content-Type", "application/json")
+
+			return req, nil
+		})
+
+		if err != nil {
+			return pushError(L, "Cannot send discord message", err)
+		}
+
+		resp.Body.Close()
+
+		// Wait for the rate limit to reset
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < discordMaxRateLimitRetries {
+			if wait, ok := discordRetryAfter(resp); ok {
+				time.Sleep(wait)
+				continue
+			}
+		}
+
+		L.Push(lua.LNumber(resp.StatusCode))
+
+		return 1
+	}
+}
+
+// isDiscordWebhookURL checks if the given url points to a discord webhook
+func isDiscordWebhookURL(v string) bool {
+	u, err := url.Parse(v)
+
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	if host != "discord.com" && host != "discordapp.com" && !strings.HasSuffix(host, ".discord.com") && !strings.HasSuffix(host, ".discordapp.com") {
+		return false
+	}
+
+	return strings.HasPrefix(u.Path, "/api/webhooks/")
+}
+
+// discordPayload encodes the given message table as a webhook JSON payload
+func discordPayload(msg *lua.LTable) ([]byte, error) {
+	payload := map[string]interface{}{}
+
+	for _, field := range discordPayloadFields {
+		v := msg.RawGetString(field)
+
+		if v == lua.LNil {
+			continue
+		}
+
+		value, err := sessionValueToGo(v, map[*lua.LTable]bool{}, "webhook message")
+
+		if err != nil {
+			return nil, err
+		}
+
+		payload[field] = value
+	}
+
+	// Discord rejects messages without content or embeds
+	content, _ := payload["content"].(string)
+	embeds, _ := payload["embeds"].([]interface{})
+
+	if content == "" && len(embeds) == 0 {
+		return nil, errors.New("Missing 'content' or 'embeds' table field")
+	}
+
+	if len([]rune(content)) > 2000 {
+		return nil, errors.New("Invalid content. Messages cannot be longer than 2000 characters")
+	}
+
+	if len(embeds) > 10 {
+		return nil, errors.New("Invalid embeds. Messages cannot have more than 10 embeds")
+	}
+
+	return json.Marshal(payload)
+}
+
+// discordRetryAfter returns how long to wait before sending a rate limited message again
+func discordRetryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	wait := time.Duration(seconds * float64(time.Second))
+
+	return wait, wait <= discordMaxRetryAfter
+}
@@ -3,6 +3,7 @@ package lua
 import (
 	"github.com/yuin/gopher-lua"
 	"os"
+	"strings"
 )
 
 // SetEnvMetaTable sets the env metatable on the given lua state
@@ -69,3 +70,46 @@ func GetEnvVariable(L *lua.LState) int {
 
 	return 1
 }
+
+// DeleteEnvVariable removes the given environment variable
+func DeleteEnvVariable(L *lua.LState) int {
+	// Get variable key
+	key := L.Get(2)
+
+	// Check valid key
+	if key.Type() != lua.LTString {
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	// Remove variable
+	if err := os.Unsetenv(key.String()); err != nil {
+		L.RaiseError("Cannot delete env variable: %v", err)
+	}
+
+	return 0
+}
+
+// ListEnvVariables returns a table with all the environment variables
+func ListEnvVariables(L *lua.LState) int {
+	// Create variables table
+	tbl := L.NewTable()
+
+	for _, v := range os.Environ() {
+
+		// Split variable into key and value
+		parts := strings.SplitN(v, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		// Set variable field
+		tbl.RawSetString(parts[0], lua.LString(parts[1]))
+	}
+
+	// Push variables table
+	L.Push(tbl)
+
+	return 1
+}
@@ -0,0 +1,168 @@
+package lua
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// errorHandler function registered with http.onError
+var errorHandler = &errorHandlerProto{}
+
+// errorHandlerProto compiled error page handler
+type errorHandlerProto struct {
+	rw    sync.RWMutex
+	proto *glua.FunctionProto
+}
+
+// errorPageWriter response writer used by page handlers. Keeps track of written responses so
+// the error page is only rendered when the failed handler did not answer the request
+type errorPageWriter struct {
+	http.ResponseWriter
+	written bool
+	status  int
+}
+
+// NewErrorPageWriter wraps the response writer of a page request
+func NewErrorPageWriter(w http.ResponseWriter) http.ResponseWriter {
+	return &errorPageWriter{
+		ResponseWriter: w,
+	}
+}
+
+// WriteHeader sends the status code. While the error page is rendered the status is overwritten
+func (e *errorPageWriter) WriteHeader(status int) {
+	if e.written {
+		return
+	}
+
+	if e.status != 0 {
+		status = e.status
+	}
+
+	e.written = true
+	e.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the response body
+func (e *errorPageWriter) Write(b []byte) (int, error) {
+	if !e.written {
+		e.WriteHeader(http.StatusOK)
+	}
+
+	return e.ResponseWriter.Write(b)
+}
+
+// Hijack takes over the connection. Hijacked responses count as written
+func (e *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijackResponseWriter(e.ResponseWriter)
+
+	if err == nil {
+		e.written = true
+	}
+
+	return conn, rw, err
+}
+
+// Flush sends the buffered data to the client
+func (e *errorPageWriter) Flush() {
+	if !e.written {
+		e.WriteHeader(http.StatusOK)
+	}
+
+	flushResponseWriter(e.ResponseWriter)
+}
+
+// SetErrorHandler registers the function used to render the error page of failed page handlers.
+// The function receives an error table and runs on the state of the failed request so it can use
+// the http methods. Handlers cannot use upvalues and should be registered on engine/init.lua
+func SetErrorHandler(L *glua.LState) int {
+	// Get handler function
+	f := L.Get(2)
+
+	// Remove the handler when called with nil
+	if f.Type() == glua.LTNil {
+		errorHandler.rw.Lock()
+		errorHandler.proto = nil
+		errorHandler.rw.Unlock()
+
+		return 0
+	}
+
+	if f.Type() != glua.LTFunction {
+		L.ArgError(1, "Invalid error handler type. Expected function")
+		return 0
+	}
+
+	// Get lua function
+	fn := f.(*glua.LFunction)
+
+	if fn.IsG || fn.Proto.NumUpvalues > 0 {
+		L.ArgError(1, "Error handlers cannot be Go functions or use upvalues")
+		return 0
+	}
+
+	// Save function proto
+	errorHandler.rw.Lock()
+	errorHandler.proto = fn.Proto
+	errorHandler.rw.Unlock()
+
+	return 0
+}
+
+// ExecuteErrorHandler renders the error page of a failed page handler using the http.onError
+// function. The stack trace is only given to the handler on development mode. Returns false
+// when there is no handler or the handler failed so the caller can answer the request
+func ExecuteErrorHandler(luaState *glua.LState, w http.ResponseWriter, r *http.Request, pageErr error) bool {
+	// Responses already sent cannot be replaced
+	ew, ok := w.(*errorPageWriter)
+
+	if ok && ew.written {
+		return true
+	}
+
+	// Get handler proto
+	errorHandler.rw.RLock()
+	proto := errorHandler.proto
+	errorHandler.rw.RUnlock()
+
+	if proto == nil || !ok {
+		return false
+	}
+
+	// Create error table
+	errTable := luaState.NewTable()
+	errTable.RawSetString("status", glua.LNumber(http.StatusInternalServerError))
+	errTable.RawSetString("requestId", glua.LString(util.RequestID(r)))
+	errTable.RawSetString("method", glua.LString(r.Method))
+	errTable.RawSetString("path", glua.LString(r.URL.Path))
+
+	if apiErr, ok := pageErr.(*glua.ApiError); ok {
+		errTable.RawSetString("message", glua.LString(apiErr.Object.String()))
+
+		if util.Config.Get().IsDev() {
+			errTable.RawSetString("stackTrace", glua.LString(apiErr.StackTrace))
+		}
+	} else {
+		errTable.RawSetString("message", glua.LString(pageErr.Error()))
+	}
+
+	// Every status written by the handler is sent as an internal server error
+	ew.status = http.StatusInternalServerError
+
+	// Call error handler
+	if err := luaState.CallByParam(glua.P{
+		Fn:      luaState.NewFunctionFromProto(proto),
+		NRet:    0,
+		Protect: true,
+	}, errTable); err != nil {
+		util.Logger.ForRequest(r).Errorf("Cannot execute error handler: %v", err)
+		return ew.written
+	}
+
+	return ew.written
+}
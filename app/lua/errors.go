@@ -0,0 +1,158 @@
+package lua
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/textproto"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	// ErrorCodeTimeout the operation did not finish in time
+	ErrorCodeTimeout = "timeout"
+
+	// ErrorCodeConnectionRefused the remote server refused the connection
+	ErrorCodeConnectionRefused = "connection_refused"
+
+	// ErrorCodeConnection the connection failed or was lost
+	ErrorCodeConnection = "connection"
+
+	// ErrorCodeSyntax the SQL query is not valid
+	ErrorCodeSyntax = "syntax"
+
+	// ErrorCodeDuplicate a unique key already holds the given value
+	ErrorCodeDuplicate = "duplicate"
+
+	// ErrorCodeDeadlock the transaction was aborted by a deadlock or lock wait timeout
+	ErrorCodeDeadlock = "deadlock"
+
+	// ErrorCodeDatabase any other database server error
+	ErrorCodeDatabase = "database"
+
+	// ErrorCodeMail the mail server rejected the message
+	ErrorCodeMail = "mail"
+
+	// ErrorCodeInternal any other error
+	ErrorCodeInternal = "internal"
+)
+
+// classifyError returns the structured error code of the given error and if the
+// operation can be retried
+func classifyError(err error) (string, bool) {
+	// Timeouts
+	var netErr net.Error
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errRequestTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorCodeTimeout, true
+	}
+
+	// Connection errors
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorCodeConnectionRefused, true
+	}
+
+	var opErr *net.OpError
+
+	if errors.As(err, &opErr) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return ErrorCodeConnection, true
+	}
+
+	// MySQL server errors
+	var mysqlErr *mysql.MySQLError
+
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1064, 1054, 1146:
+			return ErrorCodeSyntax, false
+		case 1062:
+			return ErrorCodeDuplicate, false
+		case 1205, 1213:
+			return ErrorCodeDeadlock, true
+		}
+
+		return ErrorCodeDatabase, false
+	}
+
+	// SMTP errors. 4xx replies are temporary failures
+	var smtpErr *textproto.Error
+
+	if errors.As(err, &smtpErr) {
+		return ErrorCodeMail, smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+
+	return ErrorCodeInternal, false
+}
+
+// errorToTable converts the given error to a table with the code, message and retryable fields.
+// The table can be converted to a string to get the message
+func errorToTable(L *lua.LState, message string, err error) *lua.LTable {
+	code, retryable := classifyError(err)
+
+	tbl := L.NewTable()
+	tbl.RawSetString("code", lua.LString(code))
+	tbl.RawSetString("message", lua.LString(message+": "+err.Error()))
+	tbl.RawSetString("retryable", lua.LBool(retryable))
+
+	// Set string conversion
+	meta := L.NewTable()
+	meta.RawSetString("__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(L.GetField(L.Get(1), "message"))
+		return 1
+	}))
+
+	L.SetMetatable(tbl, meta)
+
+	return tbl
+}
+
+// pushError pushes nil followed by the structured error of the given error. Returns the
+// number of pushed values
+func pushError(L *lua.LState, message string, err error) int {
+	L.Push(lua.LNil)
+	L.Push(errorToTable(L, message, err))
+
+	return 2
+}
+
+// tryCallRegistryKey registry field set while a try variant is running. Functions called
+// while it is set return the structured error instead of raising it
+const tryCallRegistryKey = "castro_try_call"
+
+// tryFunction returns the try variant of the given function. The variant returns nil
+// followed by the structured error instead of raising
+func tryFunction(fn lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		try := isTryCall(L)
+		setTryCall(L, true)
+		defer setTryCall(L, try)
+
+		return fn(L)
+	}
+}
+
+// isTryCall checks if the given state is running a try variant
+func isTryCall(L *lua.LState) bool {
+	return lua.LVAsBool(L.G.Registry.RawGetString(tryCallRegistryKey))
+}
+
+// setTryCall sets if the given state is running a try variant
+func setTryCall(L *lua.LState, try bool) {
+	L.G.Registry.RawSetString(tryCallRegistryKey, lua.LBool(try))
+}
+
+// raiseError raises the given error. Try variants push the structured error instead.
+// Returns the number of pushed values
+func raiseError(L *lua.LState, message string, err error) int {
+	if isTryCall(L) {
+		return pushError(L, message, err)
+	}
+
+	L.RaiseError("%s: %v", message, err)
+
+	return 0
+}
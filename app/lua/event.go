@@ -1,9 +1,100 @@
 package lua
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+var (
+	// runningEvents tracks the background events being executed
+	runningEvents sync.WaitGroup
+
+	// eventsStopped is set once the application starts shutting down
+	eventsStopped int32
+
+	// shutdownHandlers functions registered with events.onShutdown
+	shutdownHandlers = &shutdownHandlerList{}
+
+	// scheduledEvents pending events registered with events.addAt
+	scheduledEvents = &scheduledEventList{
+		timers: map[*time.Timer]struct{}{},
+	}
+
+	// scheduledEventRunner executes the functions registered with events.addAt. Set on init
+	// since the state pool depends on the events metatable
+	scheduledEventRunner func(*deferredEvent)
+)
+
+func init() {
+	scheduledEventRunner = runScheduledEvent
+}
+
+// deferredEvent function registered with events.defer and its copied arguments
+type deferredEvent struct {
+	proto *lua.FunctionProto
+	args  []interface{}
+}
+
+// shutdownHandlerList list of compiled shutdown functions
+type shutdownHandlerList struct {
+	rw       sync.Mutex
+	handlers []*lua.FunctionProto
+}
+
+// scheduledEventList list of pending scheduled event timers
+type scheduledEventList struct {
+	rw     sync.Mutex
+	timers map[*time.Timer]struct{}
+}
+
+// stop stops the given pending timer. Returns false if the event already fired
+func (s *scheduledEventList) stop(timer *time.Timer) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if _, ok := s.timers[timer]; !ok {
+		return false
+	}
+
+	delete(s.timers, timer)
+	timer.Stop()
+
+	runningEvents.Done()
+
+	return true
+}
+
+// fire removes the given timer from the pending list. Returns false if the event was stopped
+func (s *scheduledEventList) fire(timer *time.Timer) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if _, ok := s.timers[timer]; !ok {
+		return false
+	}
+
+	delete(s.timers, timer)
+
+	return true
+}
+
+// stopAll stops every pending scheduled event
+func (s *scheduledEventList) stopAll() {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for timer := range s.timers {
+		delete(s.timers, timer)
+		timer.Stop()
+
+		runningEvents.Done()
+	}
+}
+
 // SetEventsMetaTable sets the event metatable of the given state
 func SetEventsMetaTable(luaState *lua.LState) {
 	// Create and set the events metatable
@@ -19,40 +110,347 @@ func BackgroundEvent(L *lua.LState) int {
 	// Get function
 	f := L.ToFunction(2)
 
+	// Ignore new events while shutting down
+	if atomic.LoadInt32(&eventsStopped) == 1 {
+		util.Logger.Logger.Warn("Background event ignored. Castro is shutting down")
+		return 0
+	}
+
 	// Create new thread
 	thread, _ := L.NewThread()
 
-	// Infinite loop
+	// Track event execution
+	runningEvents.Add(1)
+
+	// Run event on the background
 	go func() {
+		defer runningEvents.Done()
+
+		runEventThread(L, thread, f)
+	}()
 
-		for {
+	return 0
+}
 
-			// Resume function using  a new state thread
-			status, err, _ := L.Resume(thread, f)
+// ScheduleEvent executes a function once at the given unix timestamp. Timestamps in the
+// past run the function immediately. The function runs on a pooled state so it cannot use
+// upvalues. Returns a handle with a stop function
+func ScheduleEvent(L *lua.LState) int {
+	// Get timestamp
+	ts := L.Get(2)
 
-			if status == lua.ResumeError {
-				break
-			}
+	if ts.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid timestamp type. Expected number")
+		return 0
+	}
 
-			// Check if event finished execution
-			if status == lua.ResumeOK {
-				break
-			}
+	// Get function
+	f := L.Get(3)
 
-			if err != nil {
+	if f.Type() != lua.LTFunction {
+		L.ArgError(2, "Invalid event type. Expected function")
+		return 0
+	}
 
-				// Weird case
-				if err.Error() == "nil" {
-					break
-				}
+	// Get lua function
+	fn := f.(*lua.LFunction)
 
-				L.RaiseError("Running event returned an error: %v", err)
-				break
-			}
+	if fn.IsG || fn.Proto.NumUpvalues > 0 {
+		L.ArgError(2, "Scheduled events cannot be Go functions or use upvalues")
+		return 0
+	}
+
+	event := &deferredEvent{
+		proto: fn.Proto,
+	}
+
+	// Ignore new events while shutting down
+	if atomic.LoadInt32(&eventsStopped) == 1 {
+		util.Logger.Logger.Warn("Scheduled event ignored. Castro is shutting down")
+		return 0
+	}
+
+	// Track event execution until it runs or is stopped
+	runningEvents.Add(1)
+
+	// Schedule event. The list lock is held so the timer is registered before it fires
+	scheduledEvents.rw.Lock()
+
+	var timer *time.Timer
+
+	timer = time.AfterFunc(time.Until(time.Unix(int64(ts.(lua.LNumber)), 0)), func() {
+		if !scheduledEvents.fire(timer) {
+			return
 		}
 
-		thread.Close()
-	}()
+		defer runningEvents.Done()
+
+		// Skip events that fire while shutting down
+		if atomic.LoadInt32(&eventsStopped) == 1 {
+			return
+		}
+
+		scheduledEventRunner(event)
+	})
+
+	scheduledEvents.timers[timer] = struct{}{}
+	scheduledEvents.rw.Unlock()
+
+	// Create handle table
+	handle := L.NewTable()
+
+	// Set stop function. Returns true if the event was stopped before running
+	handle.RawSetString("stop", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(scheduledEvents.stop(timer)))
+		return 1
+	}))
+
+	// Push handle
+	L.Push(handle)
+
+	return 1
+}
+
+// OnShutdown registers a function that runs when castro shuts down. Shutdown functions run on
+// their own state so they cannot use upvalues
+func OnShutdown(L *lua.LState) int {
+	// Get function
+	f := L.Get(2)
+
+	if f.Type() != lua.LTFunction {
+		L.ArgError(1, "Invalid shutdown function type. Expected function")
+		return 0
+	}
+
+	// Get lua function
+	fn := f.(*lua.LFunction)
+
+	if fn.IsG || fn.Proto.NumUpvalues > 0 {
+		L.ArgError(1, "Shutdown functions cannot be Go functions or use upvalues")
+		return 0
+	}
+
+	// Save function proto
+	shutdownHandlers.rw.Lock()
+	shutdownHandlers.handlers = append(shutdownHandlers.handlers, fn.Proto)
+	shutdownHandlers.rw.Unlock()
+
+	return 0
+}
+
+// DeferEvent registers a function that runs once on a pooled state after the current response
+// is sent. Deferred functions cannot use upvalues so any extra argument is copied and passed to it
+func DeferEvent(L *lua.LState) int {
+	// Get function
+	f := L.Get(2)
+
+	if f.Type() != lua.LTFunction {
+		L.ArgError(1, "Invalid deferred function type. Expected function")
+		return 0
+	}
+
+	// Get lua function
+	fn := f.(*lua.LFunction)
+
+	if fn.IsG || fn.Proto.NumUpvalues > 0 {
+		L.ArgError(1, "Deferred functions cannot be Go functions or use upvalues")
+		return 0
+	}
+
+	event := &deferredEvent{
+		proto: fn.Proto,
+	}
+
+	// Copy arguments
+	for i := 3; i <= L.GetTop(); i++ {
+		if L.Get(i).Type() == lua.LTNil {
+			event.args = append(event.args, nil)
+			continue
+		}
+
+		arg, err := sessionValueToGo(L.Get(i), map[*lua.LTable]bool{}, "deferred event")
+
+		if err != nil {
+			L.ArgError(i-1, "Invalid deferred function argument: "+err.Error())
+			return 0
+		}
+
+		event.args = append(event.args, arg)
+	}
+
+	// Get events metatable
+	meta := L.GetTypeMetatable(EventsMetaTableName)
+
+	// Get deferred list
+	data, ok := L.GetField(meta, "__deferred").(*lua.LUserData)
+
+	if !ok {
+		data = L.NewUserData()
+		data.Value = []*deferredEvent{}
+
+		L.SetField(meta, "__deferred", data)
+	}
+
+	data.Value = append(data.Value.([]*deferredEvent), event)
 
 	return 0
 }
+
+// RunDeferredEvents executes the functions registered with events.defer on the given state. Each
+// function runs on the background using a pooled state. Errors are logged
+func RunDeferredEvents(L *lua.LState) {
+	// Get deferred list
+	data, ok := L.GetField(L.GetTypeMetatable(EventsMetaTableName), "__deferred").(*lua.LUserData)
+
+	if !ok {
+		return
+	}
+
+	events, _ := data.Value.([]*deferredEvent)
+	data.Value = []*deferredEvent{}
+
+	for _, event := range events {
+
+		// Ignore new events while shutting down
+		if atomic.LoadInt32(&eventsStopped) == 1 {
+			util.Logger.Logger.Warn("Deferred event ignored. Castro is shutting down")
+			return
+		}
+
+		// Track event execution
+		runningEvents.Add(1)
+
+		go func(event *deferredEvent) {
+			defer runningEvents.Done()
+
+			runDeferredEvent(event)
+		}(event)
+	}
+}
+
+// runDeferredEvent executes a deferred function on a pooled state
+func runDeferredEvent(event *deferredEvent) {
+	// Get a lua state from the pool
+	state := Pool.Get()
+
+	// Return state
+	defer Pool.Put(state)
+
+	// Convert arguments
+	args := make([]lua.LValue, 0, len(event.args))
+
+	for _, arg := range event.args {
+		args = append(args, sessionValueToLua(arg))
+	}
+
+	// Call deferred function
+	if err := state.CallByParam(lua.P{
+		Fn:      state.NewFunctionFromProto(event.proto),
+		NRet:    0,
+		Protect: true,
+	}, args...); err != nil {
+		util.Logger.Logger.Errorf("Cannot execute deferred event: %v", err)
+	}
+}
+
+// runScheduledEvent executes a scheduled function on a pooled state
+func runScheduledEvent(event *deferredEvent) {
+	// Get a lua state from the pool
+	state := Pool.Get()
+
+	// Return state
+	defer Pool.Put(state)
+
+	// Call scheduled function
+	if err := state.CallByParam(lua.P{
+		Fn:      state.NewFunctionFromProto(event.proto),
+		NRet:    0,
+		Protect: true,
+	}); err != nil {
+		util.Logger.Logger.Errorf("Cannot execute scheduled event: %v", err)
+	}
+}
+
+// ShutdownEvents stops accepting new background events, runs the shutdown functions and
+// waits for the running events to finish. Returns false if the timeout was reached
+func ShutdownEvents(timeout time.Duration) bool {
+	// Stop accepting new events
+	atomic.StoreInt32(&eventsStopped, 1)
+
+	// Stop pending scheduled events
+	scheduledEvents.stopAll()
+
+	// Wait for running events
+	done := make(chan struct{})
+
+	go func() {
+		// Run shutdown functions
+		shutdownHandlers.rw.Lock()
+		handlers := shutdownHandlers.handlers
+		shutdownHandlers.rw.Unlock()
+
+		for _, proto := range handlers {
+			runShutdownHandler(proto)
+		}
+
+		runningEvents.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// runShutdownHandler executes a shutdown function on a new state
+func runShutdownHandler(proto *lua.FunctionProto) {
+	// Create state
+	state := NewState()
+
+	// Close state
+	defer state.Close()
+
+	// Call shutdown function
+	if err := state.CallByParam(lua.P{
+		Fn:      state.NewFunctionFromProto(proto),
+		NRet:    0,
+		Protect: true,
+	}); err != nil {
+		util.Logger.Logger.Errorf("Cannot execute shutdown function: %v", err)
+	}
+}
+
+// runEventThread resumes the event function until it finishes
+func runEventThread(L *lua.LState, thread *lua.LState, f *lua.LFunction) {
+	// Infinite loop
+	for {
+
+		// Resume function using  a new state thread
+		status, err, _ := L.Resume(thread, f)
+
+		if status == lua.ResumeError {
+			break
+		}
+
+		// Check if event finished execution
+		if status == lua.ResumeOK {
+			break
+		}
+
+		if err != nil {
+
+			// Weird case
+			if err.Error() == "nil" {
+				break
+			}
+
+			L.RaiseError("Running event returned an error: %v", err)
+			break
+		}
+	}
+
+	thread.Close()
+}
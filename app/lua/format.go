@@ -0,0 +1,58 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetFormatMetaTable sets the format metatable of the given state
+func SetFormatMetaTable(luaState *lua.LState) {
+	// Create and set the format metatable
+	formatMetaTable := luaState.NewTypeMetatable(FormatMetaTableName)
+	luaState.SetGlobal(FormatMetaTableName, formatMetaTable)
+
+	// Set all format metatable functions
+	luaState.SetFuncs(formatMetaTable, formatMethods)
+}
+
+// FormatMoney formats the given amount using the given currency code. If no currency
+// is given the configured shop currency is used
+func FormatMoney(L *lua.LState) int {
+	// Get amount
+	amount := L.Get(2)
+
+	// Check for valid amount type
+	if amount.Type() != lua.LTNumber {
+
+		L.ArgError(1, "Invalid amount type. Expected number")
+		return 0
+	}
+
+	// Get currency code
+	code := L.Get(3)
+
+	// Check for valid currency type
+	if code.Type() != lua.LTNil && code.Type() != lua.LTString {
+
+		L.ArgError(2, "Invalid currency type. Expected string")
+		return 0
+	}
+
+	currency := util.DefaultCurrency()
+
+	if code.Type() == lua.LTString {
+		currency = code.String()
+	}
+
+	// Format amount
+	result, err := util.FormatMoney(float64(amount.(lua.LNumber)), currency)
+
+	if err != nil {
+		L.RaiseError("Cannot format money: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(result))
+
+	return 1
+}
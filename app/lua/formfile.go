@@ -1,8 +1,6 @@
 package lua
 
 import (
-	"bytes"
-	"image"
 	"image/png"
 	"io/ioutil"
 	"mime/multipart"
@@ -15,6 +13,7 @@ import (
 
 	"github.com/kardianos/osext"
 	"github.com/nfnt/resize"
+	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
@@ -171,7 +170,7 @@ func SaveFormFileAsJPEG(L *lua.LState) int {
 	defer file.Close()
 
 	// Create png image from byte array
-	pngImage, _, err := image.Decode(bytes.NewBuffer(formFile.File))
+	pngImage, _, err := util.DecodeImage(formFile.File)
 
 	if err != nil {
 		L.RaiseError("Cannot decode image from byte array: %v", err)
@@ -238,7 +237,7 @@ func SaveFormFileAsPNG(L *lua.LState) int {
 	defer file.Close()
 
 	// Create png image from byte array
-	pngImage, _, err := image.Decode(bytes.NewBuffer(formFile.File))
+	pngImage, _, err := util.DecodeImage(formFile.File)
 
 	if err != nil {
 		L.RaiseError("Cannot decode image from byte array: %v", err)
@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetGeoIPMetaTable sets the geoip metatable of the given state
+func SetGeoIPMetaTable(luaState *lua.LState) {
+	// Create and set the geoip metatable
+	geoipMetaTable := luaState.NewTypeMetatable(GeoIPMetaTableName)
+	luaState.SetGlobal(GeoIPMetaTableName, geoipMetaTable)
+
+	// Set all geoip metatable functions
+	luaState.SetFuncs(geoipMetaTable, geoipMethods)
+}
+
+// GeoIPCountry returns the ISO country code of the given address. Returns nil
+// for private or unknown addresses
+func GeoIPCountry(L *lua.LState) int {
+	// Lookup address
+	location, ok := geoipLookup(L)
+
+	if !ok {
+		return 2
+	}
+
+	if location == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Push country code
+	L.Push(lua.LString(location.Country))
+
+	return 1
+}
+
+// GeoIPLookup returns the country, city and coordinates of the given address. Returns nil
+// for private or unknown addresses
+func GeoIPLookup(L *lua.LState) int {
+	// Lookup address
+	location, ok := geoipLookup(L)
+
+	if !ok {
+		return 2
+	}
+
+	if location == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Create location table
+	tbl := L.NewTable()
+	tbl.RawSetString("country", lua.LString(location.Country))
+
+	if location.City != "" {
+		tbl.RawSetString("city", lua.LString(location.City))
+	}
+
+	if location.HasCoords {
+		tbl.RawSetString("latitude", lua.LNumber(location.Latitude))
+		tbl.RawSetString("longitude", lua.LNumber(location.Longitude))
+	}
+
+	// Push location table
+	L.Push(tbl)
+
+	return 1
+}
+
+// geoipLookup resolves the address argument. Returns false after pushing nil
+// and the error message when the lookup fails
+func geoipLookup(L *lua.LState) (*util.GeoIPLocation, bool) {
+	// Get address
+	addr := L.Get(2)
+
+	// Check for valid address type
+	if addr.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid address type. Expected string")
+		return nil, false
+	}
+
+	// Lookup address
+	location, err := util.GeoIP.Lookup(addr.String())
+
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return nil, false
+	}
+
+	return location, true
+}
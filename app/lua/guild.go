@@ -8,6 +8,16 @@ import (
 	"github.com/yuin/gopher-lua"
 )
 
+// SetGuildMetaTable sets the guild metatable of the given state
+func SetGuildMetaTable(luaState *lua.LState) {
+	// Create and set the guild metatable
+	guildMetaTable := luaState.NewTypeMetatable(GuildMetaTableName)
+	luaState.SetGlobal(GuildMetaTableName, guildMetaTable)
+
+	// Set all guild metatable functions
+	luaState.SetFuncs(guildMetaTable, guildModuleMethods)
+}
+
 // GuildConstructor returns a new guild metatable for the given ID or name
 func GuildConstructor(L *lua.LState) int {
 	// Retrieve guild
@@ -135,3 +145,152 @@ func GetGuildLeader(L *lua.LState) int {
 	L.Push(createPlayerMetaTable(leader, L))
 	return 1
 }
+
+// GetGuildByName returns a guild object by the guild name
+func GetGuildByName(L *lua.LState) int {
+	// Get guild name
+	name := L.Get(2)
+
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid guild name type. Expected string")
+		return 0
+	}
+
+	// Retrieve guild
+	guild, err := models.GetGuildByName(name.String())
+
+	if err != nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(createGuildMetaTable(guild, L))
+	return 1
+}
+
+// GetGuildByID returns a guild object by the guild identifier
+func GetGuildByID(L *lua.LState) int {
+	// Get guild identifier
+	id := L.Get(2)
+
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid guild identifier type. Expected number")
+		return 0
+	}
+
+	// Retrieve guild
+	guild, err := models.GetGuildByID(int64(id.(lua.LNumber)))
+
+	if err != nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(createGuildMetaTable(guild, L))
+	return 1
+}
+
+// CreateGuild creates a guild from the given table and returns the guild object
+func CreateGuild(L *lua.LState) int {
+	// Get information table
+	tbl := L.Get(2)
+
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid guild type. Expected table")
+		return 0
+	}
+
+	// Get guild name
+	name, ok := L.GetField(tbl, "name").(lua.LString)
+
+	if !ok {
+		L.ArgError(1, "Missing 'name' table field")
+		return 0
+	}
+
+	// Get guild owner
+	owner, ok := L.GetField(tbl, "ownerPlayerId").(lua.LNumber)
+
+	if !ok {
+		L.ArgError(1, "Missing 'ownerPlayerId' table field")
+		return 0
+	}
+
+	// Create guild
+	guild, err := models.CreateGuild(string(name), int64(owner))
+
+	if err == models.ErrGuildNameTaken || err == models.ErrPlayerInGuild {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	if err != nil {
+		L.RaiseError("Unable to create guild: %v", err)
+		return 0
+	}
+
+	L.Push(createGuildMetaTable(guild, L))
+	return 1
+}
+
+// GetGuildMembersWithRanks retrieves guild members with their rank
+func GetGuildMembersWithRanks(L *lua.LState) int {
+	// Retrieve guild object
+	guild := getGuildObject(L)
+
+	// Retrieve guild members
+	members, err := models.GetGuildMembersWithRanks(guild.ID)
+
+	if err != nil {
+		L.RaiseError("Unable to retrieve guild member list: %v", err)
+		return 0
+	}
+
+	g := L.NewTable()
+
+	for _, member := range members {
+		m := L.NewTable()
+		m.RawSetString("id", lua.LNumber(member.ID))
+		m.RawSetString("name", lua.LString(member.Name))
+		m.RawSetString("level", lua.LNumber(member.Level))
+		m.RawSetString("vocation", lua.LNumber(member.Vocation))
+		m.RawSetString("nick", lua.LString(member.Nick))
+		m.RawSetString("rank", lua.LString(member.Rank))
+		m.RawSetString("rankLevel", lua.LNumber(member.RankLevel))
+		g.Append(m)
+	}
+
+	L.Push(g)
+	return 1
+}
+
+// InviteGuildPlayer invites a player to the guild
+func InviteGuildPlayer(L *lua.LState) int {
+	// Retrieve guild object
+	guild := getGuildObject(L)
+
+	// Get player identifier
+	id := L.Get(2)
+
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid player identifier type. Expected number")
+		return 0
+	}
+
+	// Invite player
+	if err := guild.InvitePlayer(int64(id.(lua.LNumber))); err != nil {
+
+		if err == models.ErrPlayerInGuild {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+
+		L.RaiseError("Unable to invite player: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LTrue)
+	return 1
+}
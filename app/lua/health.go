@@ -0,0 +1,141 @@
+package lua
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// healthCheckTimeout default time each dependency has to answer a health check
+const healthCheckTimeout = 2 * time.Second
+
+// SetHealthMetaTable sets the health metatable of the given state
+func SetHealthMetaTable(luaState *lua.LState) {
+	// Create and set the health metatable
+	healthMetaTable := luaState.NewTypeMetatable(HealthMetaTableName)
+	luaState.SetGlobal(HealthMetaTableName, healthMetaTable)
+
+	// Set all health metatable functions
+	luaState.SetFuncs(healthMetaTable, healthMethods)
+}
+
+// HealthCheck checks the database connection, the game server port and the cache at the same
+// time. The optional options table accepts a timeout (number of seconds or duration string).
+// Returns a {db, gameServer, cache, overall} table
+func HealthCheck(L *lua.LState) int {
+	// Get timeout
+	timeout := healthCheckTimeout
+
+	if opts, ok := L.Get(2).(*lua.LTable); ok {
+		if v := opts.RawGetString("timeout"); v != lua.LNil {
+			d, ok := durationFromValue(v)
+
+			if !ok {
+				L.ArgError(1, "Invalid timeout. Expected number of seconds or duration string")
+				return 0
+			}
+
+			timeout = d
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Run checks
+	checks := map[string]func(context.Context) bool{
+		"db":         healthCheckDatabase,
+		"gameServer": healthCheckGameServer,
+		"cache":      healthCheckCache,
+	}
+
+	// Checks that do not finish in time are reported as failed
+	results := map[string]bool{}
+
+	for name := range checks {
+		results[name] = false
+	}
+
+	type checkResult struct {
+		name string
+		ok   bool
+	}
+
+	done := make(chan checkResult, len(checks))
+
+	for name, check := range checks {
+		go func(name string, check func(context.Context) bool) {
+			done <- checkResult{
+				name: name,
+				ok:   check(ctx),
+			}
+		}(name, check)
+	}
+
+	// Wait for the checks or the timeout
+wait:
+	for range checks {
+		select {
+		case r := <-done:
+			results[r.name] = r.ok
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	// Create result table
+	tbl := L.NewTable()
+	overall := true
+
+	for name, ok := range results {
+		tbl.RawSetString(name, lua.LBool(ok))
+		overall = overall && ok
+	}
+
+	tbl.RawSetString("overall", lua.LBool(overall))
+
+	L.Push(tbl)
+
+	return 1
+}
+
+// healthCheckDatabase pings the database connection
+func healthCheckDatabase(ctx context.Context) bool {
+	if database.DB == nil {
+		return false
+	}
+
+	return database.DB.PingContext(ctx) == nil
+}
+
+// healthCheckGameServer checks if the game server status port accepts connections
+func healthCheckGameServer(ctx context.Context) bool {
+	dialer := net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "tcp", serverStatusAddress())
+
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
+// healthCheckCache checks if values can be stored and retrieved from the cache
+func healthCheckCache(ctx context.Context) bool {
+	if util.Cache == nil {
+		return false
+	}
+
+	util.Cache.Set("health_check", true, time.Minute)
+
+	_, found := util.Cache.Get("health_check")
+
+	return found
+}
@@ -0,0 +1,55 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetHTMLMetaTable sets the html metatable for the given state
+func SetHTMLMetaTable(luaState *lua.LState) {
+	// Create and set the html metatable
+	htmlMetaTable := luaState.NewTypeMetatable(HTMLMetaTableName)
+	luaState.SetGlobal(HTMLMetaTableName, htmlMetaTable)
+
+	// Set all html metatable functions
+	luaState.SetFuncs(htmlMetaTable, htmlMethods)
+}
+
+// SanitizeHTML removes unsafe markup from the given string using a policy
+// ("strict" for text only or "basic" for safe formatting tags)
+func SanitizeHTML(L *lua.LState) int {
+	// Get input string
+	str := L.Get(2)
+
+	// Check for valid string type
+	if str.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid input format. Expected string")
+		return 0
+	}
+
+	// Get policy, strict by default
+	policy := "strict"
+
+	switch p := L.Get(3); p.Type() {
+	case lua.LTNil:
+	case lua.LTString:
+		policy = p.String()
+	default:
+		L.ArgError(2, "Invalid policy format. Expected string")
+		return 0
+	}
+
+	// Sanitize input
+	result, err := util.SanitizeHTML(str.String(), policy)
+
+	if err != nil {
+		L.ArgError(2, err.Error())
+		return 0
+	}
+
+	// Push cleaned string to stack
+	L.Push(lua.LString(result))
+
+	return 1
+}
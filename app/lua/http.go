@@ -1,11 +1,18 @@
 package lua
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/raggaer/castro/app/util"
@@ -60,12 +67,15 @@ func SetHTTPUserData(luaState *glua.LState, w http.ResponseWriter, r *http.Reque
 	// Request body placeholder
 	body := ""
 
-	// Read request body
-	buf, err := ioutil.ReadAll(r.Body)
-	if err == nil {
+	// Read request body. Multipart bodies are left for parseMultiPartForm so uploads
+	// are not buffered before the page sets its limits
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		buf, err := ioutil.ReadAll(r.Body)
+		if err == nil {
 
-		// Update request body
-		body = string(buf[:])
+			// Update request body
+			body = string(buf[:])
+		}
 	}
 
 	// Set request body
@@ -85,6 +95,25 @@ func SetHTTPUserData(luaState *glua.LState, w http.ResponseWriter, r *http.Reque
 	luaState.SetField(httpMetaTable, HTTPCurrentSubtopic, glua.LString(r.RequestURI))
 }
 
+// hijackResponseWriter takes over the connection of the given response writer. Used by the
+// response writer wrappers so websocket upgrades keep working
+func hijackResponseWriter(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.(http.Hijacker)
+
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+
+	return h.Hijack()
+}
+
+// flushResponseWriter sends the buffered data of the given response writer to the client
+func flushResponseWriter(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func getRequestAndResponseWriter(L *glua.LState) (*http.Request, http.ResponseWriter) {
 	// Get HTTP metatable
 	metatable := L.GetTypeMetatable(HTTPMetaTableName)
@@ -98,6 +127,102 @@ func getRequestAndResponseWriter(L *glua.LState) (*http.Request, http.ResponseWr
 	return req, w
 }
 
+// requestFromState returns the HTTP request of the given state or nil for states
+// that are not serving a request (events, shutdown handlers)
+func requestFromState(L *glua.LState) *http.Request {
+	// Get HTTP metatable
+	metatable, ok := L.GetTypeMetatable(HTTPMetaTableName).(*glua.LTable)
+
+	if !ok {
+		return nil
+	}
+
+	// Get HTTP request field
+	data, ok := L.GetField(metatable, HTTPRequestName).(*glua.LUserData)
+
+	if !ok {
+		return nil
+	}
+
+	req, _ := data.Value.(*http.Request)
+	return req
+}
+
+// GetRequestID returns the identifier of the current request
+func GetRequestID(L *glua.LState) int {
+	// Get HTTP request and HTTP response writer
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push request identifier
+	L.Push(glua.LString(util.RequestID(req)))
+
+	return 1
+}
+
+// VerifySignedURL checks if the current request url was signed with url.sign and is not expired
+func VerifySignedURL(L *glua.LState) int {
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push verification result
+	L.Push(glua.LBool(util.VerifySignedURL(req.URL)))
+
+	return 1
+}
+
+// GetRequestMethod returns the method of the current request. The http.method field holds the
+// same value so the accessor uses another name
+func GetRequestMethod(L *glua.LState) int {
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push request method
+	L.Push(glua.LString(req.Method))
+
+	return 1
+}
+
+// GetRequestPath returns the path of the current request without the query string
+func GetRequestPath(L *glua.LState) int {
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push request path
+	L.Push(glua.LString(req.URL.Path))
+
+	return 1
+}
+
+// GetFullURL returns the absolute url of the current request using the validated host
+func GetFullURL(L *glua.LState) int {
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Get request scheme
+	scheme := "http"
+
+	if isSecureRequest(req) {
+		scheme = "https"
+	}
+
+	// Push absolute url
+	L.Push(glua.LString(scheme + "://" + util.RequestHost(req) + req.URL.RequestURI()))
+
+	return 1
+}
+
+// GetHost returns the validated host of the current request. Without Security.AllowedHosts
+// the host of the configured application URL is returned
+func GetHost(L *glua.LState) int {
+	// Get HTTP request and HTTP response writer
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push request host
+	L.Push(glua.LString(util.RequestHost(req)))
+
+	return 1
+}
+
 // SetCookie sets the given HTTP cookie by its name
 func SetCookie(L *glua.LState) int {
 	// Get HTTP request and HTTP response writer
@@ -109,7 +234,7 @@ func SetCookie(L *glua.LState) int {
 		Value:    L.ToString(3),
 		Path:     "/",
 		Expires:  time.Unix(L.ToInt64(4), 0),
-		Secure:   util.Config.Configuration.IsSSL(),
+		Secure:   util.Config.Get().IsSSL(),
 		HttpOnly: true,
 	}
 
@@ -143,6 +268,103 @@ func GetCookie(L *glua.LState) int {
 	return 1
 }
 
+// SetSignedCookie sets a HTTP cookie signed with the server cookie key. Options table
+// accepts expires (unix time), maxAge (seconds) and path
+func SetSignedCookie(L *glua.LState) int {
+	// Get HTTP request and HTTP response writer
+	_, w := getRequestAndResponseWriter(L)
+
+	// Get cookie name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != glua.LTString {
+		L.ArgError(1, "Invalid cookie name. Expected string")
+		return 0
+	}
+
+	// Get cookie value
+	value := L.Get(3)
+
+	// Check for valid value type
+	if value.Type() != glua.LTString && value.Type() != glua.LTNumber {
+		L.ArgError(2, "Invalid cookie value. Expected string")
+		return 0
+	}
+
+	// Create cookie
+	c := &http.Cookie{
+		Name:     name.String(),
+		Path:     "/",
+		Secure:   util.Config.Get().IsSSL(),
+		HttpOnly: true,
+	}
+
+	// Get cookie options
+	switch opts := L.Get(4); opts.Type() {
+	case glua.LTTable:
+		tbl := opts.(*glua.LTable)
+
+		if expires, ok := tbl.RawGetString("expires").(glua.LNumber); ok {
+			c.Expires = time.Unix(int64(expires), 0)
+		}
+
+		if maxAge, ok := tbl.RawGetString("maxAge").(glua.LNumber); ok {
+			c.MaxAge = int(maxAge)
+			c.Expires = time.Now().Add(time.Duration(maxAge) * time.Second)
+		}
+
+		if path, ok := tbl.RawGetString("path").(glua.LString); ok {
+			c.Path = string(path)
+		}
+	case glua.LTNil:
+	default:
+		L.ArgError(3, "Invalid cookie options. Expected table")
+		return 0
+	}
+
+	// Sign cookie value
+	c.Value = util.SignCookieValue(c.Name, value.String(), c.Expires)
+
+	// Set HTTP cookie
+	http.SetCookie(w, c)
+	return 0
+}
+
+// GetSignedCookie returns the value of the given signed HTTP cookie. Returns nil if the cookie
+// does not exist, has expired or its signature is not valid
+func GetSignedCookie(L *glua.LState) int {
+	// Get HTTP request and HTTP response writer
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Retrieve cookie
+	cookie, err := req.Cookie(L.ToString(2))
+
+	if err != nil {
+
+		// If cookie does not exists push nil
+		if err == http.ErrNoCookie {
+			L.Push(glua.LNil)
+			return 1
+		}
+
+		L.RaiseError("Unable to retrieve HTTP cookie: %v", err)
+		return 0
+	}
+
+	// Verify cookie signature
+	value, ok := util.VerifyCookieValue(cookie.Name, cookie.Value)
+
+	if !ok {
+		L.Push(glua.LNil)
+		return 1
+	}
+
+	// Return cookie value
+	L.Push(glua.LString(value))
+	return 1
+}
+
 // ParseMultiPartForm parses a multi-part form encoded
 func ParseMultiPartForm(L *glua.LState) int {
 	// Get HTTP request and HTTP response writer
@@ -193,10 +415,11 @@ func GetFormFile(L *glua.LState) int {
 	return 1
 }
 
-// WriteResponse writes string to the response writer
+// WriteResponse writes string to the response writer. The response is gzip compressed when the client
+// supports it unless the compress option is set to false
 func WriteResponse(L *glua.LState) int {
 	// Get HTTP request and HTTP response writer
-	_, w := getRequestAndResponseWriter(L)
+	req, w := getRequestAndResponseWriter(L)
 
 	// Get data
 	data := L.Get(2)
@@ -207,15 +430,203 @@ func WriteResponse(L *glua.LState) int {
 		return 0
 	}
 
+	// Get body
+	body := []byte(data.String())
+
+	// Compression is enabled by default
+	compress := true
+
+	// Get options table
+	if opts, ok := L.Get(3).(*glua.LTable); ok {
+		if v := L.GetField(opts, "compress"); v.Type() == glua.LTBool {
+			compress = bool(v.(glua.LBool))
+		}
+	}
+
+	// Compress response
+	if compress && shouldCompressResponse(req, w, body) {
+
+		// Compress body
+		buff := &bytes.Buffer{}
+		gz := gzip.NewWriter(buff)
+
+		if _, err := gz.Write(body); err != nil {
+			L.RaiseError("Cannot compress response: %v", err)
+			return 0
+		}
+
+		if err := gz.Close(); err != nil {
+			L.RaiseError("Cannot compress response: %v", err)
+			return 0
+		}
+
+		// Set content type before changing the body
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(body))
+		}
+
+		// Set compression headers
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Del("Content-Length")
+
+		body = buff.Bytes()
+	}
+
 	// Set status code
 	w.WriteHeader(200)
 
 	// Write to response writer
-	w.Write([]byte(data.String()))
+	w.Write(body)
+
+	return 0
+}
+
+// SetRequestLimits sets the body size limit and the read and write timeouts of the current
+// request. Missing fields use the configured values. Requests with bigger bodies get a 413
+// response and clients that exceed the read timeout are disconnected
+func SetRequestLimits(L *glua.LState) int {
+	// Get options table
+	opts, ok := L.Get(2).(*glua.LTable)
+
+	if !ok {
+		L.ArgError(1, "Invalid limits type. Expected table")
+		return 0
+	}
+
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Set body size limit
+	maxBody := util.Config.Get().HTTP.BodyLimit()
+
+	if v := opts.RawGetString("maxBody"); v != glua.LNil {
+		n, ok := v.(glua.LNumber)
+
+		if !ok || n <= 0 {
+			L.ArgError(1, "Invalid maxBody. Expected number of bytes")
+			return 0
+		}
+
+		maxBody = int64(n)
+	}
+
+	if body, ok := req.Body.(*util.LimitedBody); ok {
+		body.SetLimit(maxBody)
+	}
+
+	// Get timeouts
+	timeouts := map[string]time.Duration{
+		"readTimeout":  util.Config.Get().HTTP.ReadTimeoutDuration(),
+		"writeTimeout": util.Config.Get().HTTP.WriteTimeoutDuration(),
+	}
+
+	for field := range timeouts {
+		v := opts.RawGetString(field)
+
+		if v == glua.LNil {
+			continue
+		}
+
+		d, ok := durationFromValue(v)
+
+		if !ok {
+			L.ArgError(1, "Invalid "+field+". Expected number of seconds or duration string")
+			return 0
+		}
+
+		timeouts[field] = d
+	}
+
+	// Set connection deadlines
+	util.SetRequestDeadlines(req, timeouts["readTimeout"], timeouts["writeTimeout"])
+
+	return 0
+}
+
+// WriteJSON marshals the given value and writes it as a JSON response using the given status
+// code. Values that cannot be marshaled result in a 500 response
+func WriteJSON(L *glua.LState) int {
+	// Get HTTP request and HTTP response writer
+	req, w := getRequestAndResponseWriter(L)
+
+	// Get status code
+	status := L.OptInt(3, 200)
+
+	if status < 100 || status > 999 {
+		L.ArgError(2, "Invalid status code")
+		return 0
+	}
+
+	// Convert value
+	var v interface{}
+	var err error
+
+	if value := L.Get(2); value.Type() != glua.LTNil {
+		v, err = sessionValueToGo(value, map[*glua.LTable]bool{}, "JSON response")
+	}
+
+	// Marshal value
+	var body []byte
+
+	if err == nil {
+		body, err = json.Marshal(v)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if err != nil {
+		util.Logger.ForRequest(req).Errorf("Cannot marshal JSON response: %v", err)
+
+		w.WriteHeader(500)
+		w.Write([]byte(`{"error":"Internal server error"}`))
+
+		return 0
+	}
+
+	// Set status code
+	w.WriteHeader(status)
+
+	// Write to response writer
+	w.Write(body)
 
 	return 0
 }
 
+// shouldCompressResponse checks if the given response body should be gzip compressed
+func shouldCompressResponse(req *http.Request, w http.ResponseWriter, body []byte) bool {
+	// Skip tiny bodies
+	if len(body) < 1024 {
+		return false
+	}
+
+	// Check if client accepts gzip
+	if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		return false
+	}
+
+	// Skip already encoded responses
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	// Get content type
+	contentType := w.Header().Get("Content-Type")
+
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	// Skip already compressed content types
+	for _, t := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-gzip", "application/x-rar-compressed", "application/x-7z-compressed", "font/woff"} {
+		if strings.HasPrefix(contentType, t) {
+			return strings.HasPrefix(contentType, "image/svg")
+		}
+	}
+
+	return true
+}
+
 // RenderTemplate renders the given template with the given data as a LUA table
 func RenderTemplate(L *glua.LState) int {
 	// Get HTTP request and HTTP response writer
@@ -230,36 +641,129 @@ func RenderTemplate(L *glua.LState) int {
 	tableValue := L.Get(3)
 
 	// Compile widget list
-	widgets, err := compileWidgetList(req, w, session)
+	widgets, registeredWidgets, err := compileWidgetList(req, w, session)
 
 	if err != nil {
 		util.Logger.Logger.Errorf("Cannot compile widget list: %v", err)
 	}
 
-	// Set status code
-	w.WriteHeader(200)
+	// Get layout option
+	layout, customLayout := renderLayoutOption(L, 4)
+
+	// Args holder
+	args := map[string]interface{}{}
 
 	// Check if args is set
 	if tableValue.Type() == glua.LTTable {
 
 		// Convert table to map
-		args := TableToMap(tableValue.(*glua.LTable))
+		args = TableToMap(tableValue.(*glua.LTable))
+	}
 
-		args["widgets"] = widgets
+	args["widgets"] = widgets
+	args["registeredWidgets"] = registeredWidgets
 
-		// Render template with args
+	// Render template using the default layout. The status code is sent with the first write
+	// so missing request values can still answer with an internal server error
+	if !customLayout {
 		util.Template.RenderTemplate(w, req, templateName, args)
 		return 0
 	}
 
-	// Render template without args
-	util.Template.RenderTemplate(w, req, templateName, map[string]interface{}{
-		"widgets": widgets,
-	})
+	// Render template using the given layout
+	if err := util.Template.RenderTemplateLayout(w, req, templateName, layout, args); err != nil {
+		util.Logger.Logger.Error(err.Error())
+
+		if util.IsMissingRequestValue(err) {
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+		}
+	}
 
 	return 0
 }
 
+// renderLayoutOption returns the layout field of the render options table at the given position.
+// The second value is false when the default layout should be used. A false layout renders the bare template
+func renderLayoutOption(L *glua.LState, n int) (string, bool) {
+	// Get options table
+	opts, ok := L.Get(n).(*glua.LTable)
+
+	if !ok {
+		return "", false
+	}
+
+	layout := L.GetField(opts, "layout")
+
+	switch layout.Type() {
+	case glua.LTNil:
+		return "", false
+	case glua.LTBool:
+		return "", !glua.LVAsBool(layout)
+	case glua.LTString:
+		if layout.String() == "default" {
+			return "", false
+		}
+
+		return layout.String(), true
+	}
+
+	L.ArgError(n-1, "Invalid layout type. Expected string or boolean")
+	return "", false
+}
+
+// RenderTemplateString renders the given template and returns the result as a string
+func RenderTemplateString(L *glua.LState) int {
+	// Get HTTP request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Get template name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != glua.LTString {
+		L.ArgError(1, "Invalid template name type. Expected string")
+		return 0
+	}
+
+	// Get args table as LUA value
+	tableValue := L.Get(3)
+
+	// Args holder
+	args := map[string]interface{}{}
+
+	// Check if args is set
+	if tableValue.Type() == glua.LTTable {
+		args = TableToMap(tableValue.(*glua.LTable))
+	}
+
+	// Get layout option
+	layout, customLayout := renderLayoutOption(L, 4)
+
+	// Render template to string
+	var result string
+	var err error
+
+	if customLayout {
+		buff := &bytes.Buffer{}
+
+		err = util.Template.RenderTemplateLayout(buff, req, name.String(), layout, args)
+		result = buff.String()
+	} else {
+		result, err = util.Template.RenderTemplateString(req, name.String(), args)
+	}
+
+	if err != nil {
+		L.RaiseError("Cannot render template: %v", err)
+		return 0
+	}
+
+	// Push result
+	L.Push(glua.LString(result))
+
+	return 1
+}
+
 // Redirect redirects the user to the given location with a header
 func Redirect(L *glua.LState) int {
 	// Get HTTP request and HTTP response writer
@@ -288,7 +792,7 @@ func Redirect(L *glua.LState) int {
 	return 0
 }
 
-// ServeFile serves the given file
+// ServeFile serves the given file with support for range requests
 func ServeFile(L *glua.LState) int {
 	// Get file path
 	path := L.Get(2)
@@ -302,11 +806,32 @@ func ServeFile(L *glua.LState) int {
 	// Get request and response
 	req, w := getRequestAndResponseWriter(L)
 
-	// Set status code
-	w.WriteHeader(200)
+	// Open file
+	file, err := os.Open(path.String())
 
-	// Serve file
-	http.ServeFile(w, req, path.String())
+	if err != nil {
+		L.RaiseError("Cannot open file: %v", err)
+		return 0
+	}
+
+	// Close file
+	defer file.Close()
+
+	// Get file information
+	info, err := file.Stat()
+
+	if err != nil {
+		L.RaiseError("Cannot get file information: %v", err)
+		return 0
+	}
+
+	if info.IsDir() {
+		L.ArgError(1, "Invalid path. Expected file")
+		return 0
+	}
+
+	// Stream file contents. Range requests are answered with partial content
+	http.ServeContent(w, req, info.Name(), info.ModTime(), file)
 
 	return 0
 }
@@ -351,14 +876,23 @@ func GetRequest(L *glua.LState) int {
 		return 0
 	}
 
-	// Make get request
-	resp, err := http.Get(url.String())
+	// Get retry options
+	opts, err := httpRetryOptionsFromTable(L.Get(3))
 
 	if err != nil {
-		L.RaiseError("Cannot perform get request: %v", err)
+		L.ArgError(2, err.Error())
 		return 0
 	}
 
+	// Make get request
+	resp, err := doHTTPRequestWithRetry(opts, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, url.String(), nil)
+	})
+
+	if err != nil {
+		return raiseError(L, "Cannot perform get request", err)
+	}
+
 	// Close response body
 	defer resp.Body.Close()
 
@@ -366,8 +900,7 @@ func GetRequest(L *glua.LState) int {
 	buff, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		L.RaiseError("Cannot read response body: %v", err)
-		return 0
+		return raiseError(L, "Cannot read response body", err)
 	}
 
 	// Push response
@@ -393,14 +926,31 @@ func PostFormRequest(L *glua.LState) int {
 	// Get url values
 	values := TableToURLValues(data)
 
-	// Post form
-	resp, err := http.PostForm(url.String(), values)
+	// Get retry options
+	opts, err := httpRetryOptionsFromTable(L.Get(4))
 
 	if err != nil {
-		L.RaiseError("Cannot post form: %v", err)
+		L.ArgError(3, err.Error())
 		return 0
 	}
 
+	// Post form
+	resp, err := doHTTPRequestWithRetry(opts, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, url.String(), strings.NewReader(values.Encode()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return req, nil
+	})
+
+	if err != nil {
+		return raiseError(L, "Cannot post form", err)
+	}
+
 	// Close response body
 	defer resp.Body.Close()
 
@@ -408,8 +958,7 @@ func PostFormRequest(L *glua.LState) int {
 	buff, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		L.RaiseError("Cannot read response body: %v", err)
-		return 0
+		return raiseError(L, "Cannot read response body", err)
 	}
 
 	// Push response body
@@ -457,6 +1006,17 @@ func GetRemoteAddress(L *glua.LState) int {
 	return 1
 }
 
+// GetClientIP returns the client address reading the forwarding headers of trusted proxies
+func GetClientIP(L *glua.LState) int {
+	// Get request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Push client address
+	L.Push(glua.LString(util.ClientIP(req)))
+
+	return 1
+}
+
 // CreateRequestClient creates a HTTP client
 func CreateRequestClient(L *glua.LState) int {
 	// Get data table
@@ -524,58 +1084,63 @@ func CreateRequestClient(L *glua.LState) int {
 		contentString = content.String()
 	}
 
-	// Create client
-	client := &http.Client{
-		Timeout: timeoutDuration,
-	}
-
-	// Create request
-	req, err := http.NewRequest(
-		method.String(),
-		requestURL.String(),
-		bytes.NewBufferString(contentString),
-	)
+	// Get retry options
+	opts, err := httpRetryOptionsFromTable(data)
 
 	if err != nil {
-		L.RaiseError("Cannot create http request: %v", err)
+		L.RaiseError("%v", err)
 		return 0
 	}
 
+	opts.timeout = timeoutDuration
+
 	// Get request headers
 	headerTable := data.RawGetString("headers")
 
-	if headerTable.Type() == glua.LTTable {
+	// Get request authentication
+	authTable := data.RawGetString("authentication")
 
-		// Loop header table
-		headerTable.(*glua.LTable).ForEach(func(key glua.LValue, v glua.LValue) {
+	// Execute request
+	resp, err := doHTTPRequestWithRetry(opts, func() (*http.Request, error) {
+		// Create request
+		req, err := http.NewRequest(
+			method.String(),
+			requestURL.String(),
+			bytes.NewBufferString(contentString),
+		)
 
-			// Check valid header
-			if key.Type() == glua.LTString && v.Type() == glua.LTString {
+		if err != nil {
+			return nil, err
+		}
 
-				// Set header
-				req.Header.Set(key.String(), v.String())
-			}
-		})
-	}
+		if headerTable.Type() == glua.LTTable {
 
-	// Get request authentication
-	authTable := data.RawGetString("authentication")
+			// Loop header table
+			headerTable.(*glua.LTable).ForEach(func(key glua.LValue, v glua.LValue) {
 
-	if authTable.Type() == glua.LTTable {
+				// Check valid header
+				if key.Type() == glua.LTString && v.Type() == glua.LTString {
 
-		// Set request authentication
-		req.SetBasicAuth(
-			authTable.(*glua.LTable).RawGetString("username").String(),
-			authTable.(*glua.LTable).RawGetString("password").String(),
-		)
-	}
+					// Set header
+					req.Header.Set(key.String(), v.String())
+				}
+			})
+		}
 
-	// Execute request
-	resp, err := client.Do(req)
+		if authTable.Type() == glua.LTTable {
+
+			// Set request authentication
+			req.SetBasicAuth(
+				authTable.(*glua.LTable).RawGetString("username").String(),
+				authTable.(*glua.LTable).RawGetString("password").String(),
+			)
+		}
+
+		return req, nil
+	})
 
 	if err != nil {
-		L.RaiseError("Cannot execute http request: %v", err)
-		return 0
+		return raiseError(L, "Cannot execute http request", err)
 	}
 
 	// Close response body
@@ -585,8 +1150,7 @@ func CreateRequestClient(L *glua.LState) int {
 	responseContent, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		L.RaiseError("Cannot read http response: %v", err)
-		return 0
+		return raiseError(L, "Cannot read http response", err)
 	}
 
 	// Header holder
@@ -632,3 +1196,161 @@ func GetRelativeURL(L *glua.LState) int {
 	L.Push(glua.LString(req.URL.String()))
 	return 1
 }
+
+// IsSecureRequest checks if the current request was made over HTTPS
+func IsSecureRequest(L *glua.LState) int {
+	// Get request
+	req, _ := getRequestAndResponseWriter(L)
+
+	L.Push(glua.LBool(isSecureRequest(req)))
+	return 1
+}
+
+// RequireSecureRequest redirects plaintext requests to the HTTPS URL when SSL is enabled.
+// Returns true if the request was redirected
+func RequireSecureRequest(L *glua.LState) int {
+	// Get request and response writer
+	req, w := getRequestAndResponseWriter(L)
+
+	// Check if request needs to be redirected
+	if !util.Config.Get().IsSSL() || isSecureRequest(req) {
+		L.Push(glua.LBool(false))
+		return 1
+	}
+
+	// Redirect to the HTTPS URL
+	http.Redirect(w, req, "https://"+req.Host+req.URL.RequestURI(), http.StatusMovedPermanently)
+
+	L.Push(glua.LBool(true))
+	return 1
+}
+
+// isSecureRequest checks if the request was made over HTTPS. The X-Forwarded-Proto header
+// is only trusted when castro is configured to run behind a SSL proxy
+func isSecureRequest(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+
+	if util.Config.Get().SSL.Proxy {
+		return strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+	}
+
+	return false
+}
+
+// httpRetryOptions options of outbound requests
+type httpRetryOptions struct {
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+}
+
+// httpRetryOptionsFromTable parses the {retries, backoff, timeout} options table. Backoff is
+// given in milliseconds and timeout as a duration string
+func httpRetryOptionsFromTable(v glua.LValue) (httpRetryOptions, error) {
+	opts := httpRetryOptions{
+		backoff: 500 * time.Millisecond,
+	}
+
+	if v.Type() == glua.LTNil {
+		return opts, nil
+	}
+
+	tbl, ok := v.(*glua.LTable)
+
+	if !ok {
+		return opts, errors.New("Invalid options type. Expected table")
+	}
+
+	// Get number of retries
+	if retries := tbl.RawGetString("retries"); retries.Type() != glua.LTNil {
+		n, ok := retries.(glua.LNumber)
+
+		if !ok || n < 0 {
+			return opts, errors.New("Invalid retries value. Expected positive number")
+		}
+
+		opts.retries = int(n)
+	}
+
+	// Get initial backoff
+	if backoff := tbl.RawGetString("backoff"); backoff.Type() != glua.LTNil {
+		n, ok := backoff.(glua.LNumber)
+
+		if !ok || n < 0 {
+			return opts, errors.New("Invalid backoff value. Expected positive number")
+		}
+
+		opts.backoff = time.Duration(n) * time.Millisecond
+	}
+
+	// Get overall timeout
+	if timeout := tbl.RawGetString("timeout"); timeout.Type() == glua.LTString {
+		d, err := time.ParseDuration(timeout.String())
+
+		if err != nil {
+			return opts, fmt.Errorf("Cannot format timeout duration: %v", err)
+		}
+
+		opts.timeout = d
+	}
+
+	return opts, nil
+}
+
+// errRequestTimeout returned when the request timeout is exceeded between retries
+var errRequestTimeout = errors.New("Request timeout exceeded")
+
+// doHTTPRequestWithRetry executes the request built by newRequest retrying on connection
+// errors and 5xx responses with exponential backoff. 4xx responses are never retried and
+// the timeout applies to all attempts together
+func doHTTPRequestWithRetry(opts httpRetryOptions, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	// Calculate overall deadline
+	var deadline time.Time
+
+	if opts.timeout > 0 {
+		deadline = time.Now().Add(opts.timeout)
+	}
+
+	wait := opts.backoff
+
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Use the remaining time as attempt timeout
+		client := &http.Client{}
+
+		if !deadline.IsZero() {
+			client.Timeout = time.Until(deadline)
+
+			if client.Timeout <= 0 {
+				return nil, errRequestTimeout
+			}
+		}
+
+		resp, err := client.Do(req)
+
+		// Return successful and client error responses
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		// Check if there are retries left within the deadline
+		if attempt >= opts.retries || (!deadline.IsZero() && time.Now().Add(wait).After(deadline)) {
+			return resp, err
+		}
+
+		// Discard failed response
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
@@ -1,7 +1,10 @@
 package lua
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
@@ -24,6 +27,9 @@ func SetI18nUserData(luaState *lua.LState, lang []string) {
 
 	// Set language field
 	luaState.SetField(i18nMetatable, "Language", StringSliceToTable(lang))
+
+	// Remove locale set by the previous request
+	luaState.SetField(i18nMetatable, "Locale", lua.LNil)
 }
 
 // GetLanguageIndex retrieves the given language index
@@ -71,3 +77,162 @@ func GetLanguageIndex(L *lua.LState) int {
 	L.Push(lua.LNil)
 	return 1
 }
+
+// Translate retrieves the given key translated to the request locale. The locale can be
+// given as argument, otherwise the session "locale" value and the Accept-Language header are
+// used. Placeholders like {name} are replaced with the values of the vars table
+func Translate(L *lua.LState) int {
+	// Get translation key
+	key := L.Get(2)
+
+	// Check for valid key type
+	if key.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	// Get locales to try
+	locales := []string{}
+
+	switch locale := L.Get(3); locale.Type() {
+	case lua.LTString:
+		locales = append(locales, locale.String())
+	case lua.LTNil:
+		locales = requestLocales(L)
+	default:
+		L.ArgError(2, "Invalid locale type. Expected string")
+		return 0
+	}
+
+	// Get interpolation variables
+	vars := map[string]string{}
+
+	switch tbl := L.Get(4); tbl.Type() {
+	case lua.LTTable:
+		tbl.(*lua.LTable).ForEach(func(k, v lua.LValue) {
+			vars[k.String()] = v.String()
+		})
+	case lua.LTNil:
+	default:
+		L.ArgError(3, "Invalid vars type. Expected table")
+		return 0
+	}
+
+	// Retrieve translation, missing keys return the key itself
+	str, ok := util.LanguageFiles.Translate(key.String(), locales)
+
+	if !ok {
+		str = key.String()
+	}
+
+	L.Push(lua.LString(util.InterpolateTranslation(str, vars)))
+
+	return 1
+}
+
+// localeCookieName name of the cookie that stores the visitor locale
+const localeCookieName = "locale"
+
+// requestLocales returns the locale set with setLocale followed by the locales of the request
+func requestLocales(L *lua.LState) []string {
+	locales := []string{}
+
+	meta, _ := L.GetTypeMetatable(I18nMetaTableName).(*lua.LTable)
+
+	// Get locale set during this request
+	if meta != nil {
+		if locale, ok := L.GetField(meta, "Locale").(lua.LString); ok {
+			locales = append(locales, string(locale))
+		}
+	}
+
+	// Get session data
+	var session map[string]interface{}
+
+	if meta, ok := L.GetTypeMetatable(SessionMetaTable).(*lua.LTable); ok {
+		if data, ok := L.GetField(meta, SessionInstanceName).(*lua.LUserData); ok {
+			session, _ = data.Value.(map[string]interface{})
+		}
+	}
+
+	// Get cookie and session locales
+	if req := requestFromState(L); req != nil {
+		locales = append(locales, localesFromRequest(req, session)...)
+	} else if locale, ok := session["locale"].(string); ok && locale != "" {
+		locales = append(locales, locale)
+	}
+
+	// Get request languages
+	if meta != nil {
+		if lang, ok := L.GetField(meta, "Language").(*lua.LTable); ok {
+			lang.ForEach(func(_, v lua.LValue) {
+				locales = append(locales, v.String())
+			})
+		}
+	}
+
+	return locales
+}
+
+// localesFromRequest returns the locale cookie value followed by the session locale
+func localesFromRequest(req *http.Request, session map[string]interface{}) []string {
+	locales := []string{}
+
+	if cookie, err := req.Cookie(localeCookieName); err == nil && cookie.Value != "" {
+		locales = append(locales, cookie.Value)
+	}
+
+	if locale, ok := session["locale"].(string); ok && locale != "" {
+		locales = append(locales, locale)
+	}
+
+	return locales
+}
+
+// GetLocale returns the locale used for the current request. The locale cookie, the session
+// "locale" value and the Accept-Language header are checked in order. Returns "default" if
+// none of them has a loaded language file
+func GetLocale(L *lua.LState) int {
+	L.Push(lua.LString(util.LanguageFiles.Resolve(requestLocales(L))))
+
+	return 1
+}
+
+// SetLocale sets the locale of the current visitor and saves it on a cookie. The locale must
+// have a loaded language file. Returns true or nil and an error message
+func SetLocale(L *lua.LState) int {
+	// Get locale code
+	code := L.Get(2)
+
+	// Check for valid code type
+	if code.Type() != lua.LTString {
+		L.ArgError(1, "Invalid locale type. Expected string")
+		return 0
+	}
+
+	// Only loaded languages can be used
+	if _, ok := util.LanguageFiles.Get(code.String()); !ok || code.String() == "default" {
+		return pushError(L, "Cannot set locale", errors.New("Unknown locale "+code.String()))
+	}
+
+	// Get HTTP response writer
+	_, w := getRequestAndResponseWriter(L)
+
+	// Save locale cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     localeCookieName,
+		Value:    code.String(),
+		Path:     "/",
+		Expires:  time.Now().Add(time.Hour * 24 * 365),
+		Secure:   util.Config.Get().IsSSL(),
+		HttpOnly: true,
+	})
+
+	// Use the locale for the rest of the request
+	L.SetField(L.GetTypeMetatable(I18nMetaTableName), "Locale", code)
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
@@ -2,12 +2,18 @@ package lua
 
 import (
 	"bytes"
+	"fmt"
+	"image/color"
+	"path/filepath"
 
 	"github.com/lucasb-eyer/go-colorful"
-	"github.com/raggaer/goimage"
+	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+// maxTextStrokeWidth widest text outline in pixels
+const maxTextStrokeWidth = 10
+
 // SetImageMetaTable sets the image metatable of the given state
 func SetImageMetaTable(luaState *lua.LState) {
 	// Create and set the json metatable
@@ -19,7 +25,7 @@ func SetImageMetaTable(luaState *lua.LState) {
 }
 
 // getGoImage retrieves the user data goimage from the given state
-func getGoImage(luaState *lua.LState) goimage.Image {
+func getGoImage(luaState *lua.LState) *util.Image {
 	// Get metatable
 	meta := luaState.Get(1)
 
@@ -31,7 +37,7 @@ func getGoImage(luaState *lua.LState) goimage.Image {
 	}
 
 	// Retrieve goimage
-	img, ok := data.Value.(goimage.Image)
+	img, ok := data.Value.(*util.Image)
 
 	if !ok {
 		luaState.RaiseError("Cannot retrieve goimage from user data")
@@ -43,11 +49,73 @@ func getGoImage(luaState *lua.LState) goimage.Image {
 // NewGoImage creates and returns a new goimage image
 func NewGoImage(L *lua.LState) int {
 	// Create image
-	img := goimage.NewImage(
+	img := util.NewImage(
 		L.ToInt(2),
 		L.ToInt(3),
 	)
 
+	// Push metatable
+	L.Push(createGoImageMetaTable(L, img))
+
+	return 1
+}
+
+// LoadGoImage creates a new goimage image from the given image file
+func LoadGoImage(L *lua.LState) int {
+	// Get image path
+	path := L.Get(2)
+
+	// Check for valid path type
+	if path.Type() != lua.LTString {
+		L.ArgError(1, "Invalid path type. Expected string")
+		return 0
+	}
+
+	// Load image
+	img, err := util.LoadImage(path.String())
+
+	if err != nil {
+		L.RaiseError("Cannot load image: %v", err)
+		return 0
+	}
+
+	// Push metatable
+	L.Push(createGoImageMetaTable(L, img))
+
+	return 1
+}
+
+// ThumbnailGoImage returns a new goimage scaled to fit within the given size
+func ThumbnailGoImage(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Get max width
+	maxW := L.Get(2)
+
+	if maxW.Type() != lua.LTNumber || int(maxW.(lua.LNumber)) <= 0 {
+		L.ArgError(1, "Invalid max width. Expected positive number")
+		return 0
+	}
+
+	// Get max height
+	maxH := L.Get(3)
+
+	if maxH.Type() != lua.LTNumber || int(maxH.(lua.LNumber)) <= 0 {
+		L.ArgError(2, "Invalid max height. Expected positive number")
+		return 0
+	}
+
+	// Push thumbnail metatable
+	L.Push(
+		createGoImageMetaTable(L, img.Thumbnail(int(maxW.(lua.LNumber)), int(maxH.(lua.LNumber)))),
+	)
+
+	return 1
+}
+
+// createGoImageMetaTable creates the goimage table for the given image
+func createGoImageMetaTable(L *lua.LState, img *util.Image) *lua.LTable {
 	// Create metatable
 	tbl := L.NewTable()
 
@@ -61,13 +129,14 @@ func NewGoImage(L *lua.LState) int {
 	// Set the metatable methods
 	L.SetFuncs(tbl, goimageMethods)
 
-	// Push metatable
-	L.Push(tbl)
+	// Set thumbnail function. ThumbnailGoImage creates image tables so it cannot be part of goimageMethods
+	L.SetField(tbl, "thumbnail", L.NewFunction(ThumbnailGoImage))
 
-	return 1
+	return tbl
 }
 
-// WriteGoImageText writes text to the given goimage
+// WriteGoImageText writes text to the given goimage. The last argument can be a font path or an
+// options table with the font, maxWidth, strokeColor, strokeWidth and shadow fields
 func WriteGoImageText(L *lua.LState) int {
 	// Get goimage
 	img := getGoImage(L)
@@ -80,9 +149,38 @@ func WriteGoImageText(L *lua.LState) int {
 		return 0
 	}
 
+	// Text options
+	font := ""
+	maxWidth := 0
+	style := util.TextStyle{}
+
+	switch opts := L.Get(7).(type) {
+	case lua.LString:
+
+		// Get font path
+		font = string(opts)
+
+	case *lua.LTable:
+
+		// Get font path
+		if v := L.GetField(opts, "font"); v.Type() == lua.LTString {
+			font = v.String()
+		}
+
+		// Get max line width
+		if v := L.GetField(opts, "maxWidth"); v.Type() == lua.LTNumber {
+			maxWidth = int(v.(lua.LNumber))
+		}
+
+		// Get outline and shadow
+		if style, err = textStyleFromTable(opts); err != nil {
+			L.ArgError(6, err.Error())
+			return 0
+		}
+	}
+
 	// Check if font is beeing declared
-	font := L.Get(7)
-	if font.Type() == lua.LTNil {
+	if font == "" {
 
 		// Write text to the image
 		if err := img.WriteText(
@@ -91,6 +189,8 @@ func WriteGoImageText(L *lua.LState) int {
 			float64(L.ToInt(4)),
 			L.ToInt(5),
 			L.ToInt(6),
+			maxWidth,
+			style,
 		); err != nil {
 			L.RaiseError("Cannot write string to image: %v", err)
 		}
@@ -99,24 +199,136 @@ func WriteGoImageText(L *lua.LState) int {
 
 	// Write text with declared font
 	if err := img.WriteTextFont(
-		L.ToString(7),
+		font,
 		L.ToString(2),
 		textColor,
 		float64(L.ToInt(4)),
 		L.ToInt(5),
 		L.ToInt(6),
+		maxWidth,
+		style,
 	); err != nil {
 		L.RaiseError("Cannot write string to image: %v", err)
 	}
 	return 0
 }
 
+// textStyleFromTable reads the strokeColor, strokeWidth and shadow ({dx, dy, color}) text options
+func textStyleFromTable(opts *lua.LTable) (util.TextStyle, error) {
+	style := util.TextStyle{}
+
+	// Get outline
+	if v := opts.RawGetString("strokeColor"); v != lua.LNil {
+		c, err := colorful.Hex(v.String())
+
+		if err != nil {
+			return style, fmt.Errorf("Invalid strokeColor: %v", err)
+		}
+
+		style.StrokeColor = c
+		style.StrokeWidth = 1
+	}
+
+	if v := opts.RawGetString("strokeWidth"); v != lua.LNil {
+		n, ok := v.(lua.LNumber)
+
+		if !ok || n < 0 || n > maxTextStrokeWidth {
+			return style, fmt.Errorf("Invalid strokeWidth. Expected number between 0 and %d", maxTextStrokeWidth)
+		}
+
+		style.StrokeWidth = int(n)
+	}
+
+	// Get shadow
+	shadow, ok := opts.RawGetString("shadow").(*lua.LTable)
+
+	if !ok {
+		return style, nil
+	}
+
+	// Shadow fields can be named or positional
+	field := func(name string, i int) lua.LValue {
+		if v := shadow.RawGetString(name); v != lua.LNil {
+			return v
+		}
+
+		return shadow.RawGetInt(i)
+	}
+
+	style.Shadow = &util.TextShadow{
+		DX:    1,
+		DY:    1,
+		Color: color.Black,
+	}
+
+	if v, ok := field("dx", 1).(lua.LNumber); ok {
+		style.Shadow.DX = int(v)
+	}
+
+	if v, ok := field("dy", 2).(lua.LNumber); ok {
+		style.Shadow.DY = int(v)
+	}
+
+	if v := field("color", 3); v != lua.LNil {
+		c, err := colorful.Hex(v.String())
+
+		if err != nil {
+			return style, fmt.Errorf("Invalid shadow color: %v", err)
+		}
+
+		style.Shadow.Color = c
+	}
+
+	return style, nil
+}
+
+// MeasureGoImageText returns the size in pixels of the given text
+func MeasureGoImageText(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Get text
+	text := L.Get(2)
+
+	if text.Type() != lua.LTString {
+		L.ArgError(1, "Invalid text type. Expected string")
+		return 0
+	}
+
+	// Get font size
+	size := L.Get(3)
+
+	if size.Type() != lua.LTNumber {
+		L.ArgError(2, "Invalid font size type. Expected number")
+		return 0
+	}
+
+	// Measure text
+	width, height, err := img.MeasureText(text.String(), float64(size.(lua.LNumber)), L.OptInt(4, 0))
+
+	if err != nil {
+		L.RaiseError("Cannot measure text: %v", err)
+		return 0
+	}
+
+	// Create size table
+	tbl := L.NewTable()
+
+	tbl.RawSetString("width", lua.LNumber(width))
+	tbl.RawSetString("height", lua.LNumber(height))
+
+	// Push size table
+	L.Push(tbl)
+
+	return 1
+}
+
 // SetBackgroundGoImage sets the background of a goimage
 func SetBackgroundGoImage(L *lua.LState) int {
 	// Get goimage
 	img := getGoImage(L)
 
-	if err := img.SetBackGroundImage(L.ToString(2)); err != nil {
+	if err := img.SetBackgroundImage(L.ToString(2)); err != nil {
 		L.RaiseError("Cannot set background image: %v", err)
 		return 0
 	}
@@ -152,3 +364,257 @@ func SaveGoImage(L *lua.LState) int {
 
 	return 0
 }
+
+// RotateGoImage rotates the goimage by the given degrees expanding the canvas to fit the result
+func RotateGoImage(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Get rotation degrees
+	degrees := L.Get(2)
+
+	if degrees.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid degrees type. Expected number")
+		return 0
+	}
+
+	// Rotate image
+	img.Rotate(float64(degrees.(lua.LNumber)))
+
+	return 0
+}
+
+// DrawGoImageBar draws a progress bar on the goimage. The percent is clamped between 0 and 100.
+// An options table with the fg, bg and border colors and the borderWidth can be given
+func DrawGoImageBar(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Get bar rectangle and percent
+	values := make([]int, 4)
+
+	for i, name := range []string{"x", "y", "width", "height"} {
+		v, ok := L.Get(i + 2).(lua.LNumber)
+
+		if !ok {
+			L.ArgError(i+1, "Invalid "+name+" type. Expected number")
+			return 0
+		}
+
+		values[i] = int(v)
+	}
+
+	if values[2] <= 0 || values[3] <= 0 {
+		L.ArgError(3, "Invalid bar size. Expected positive width and height")
+		return 0
+	}
+
+	percent, ok := L.Get(6).(lua.LNumber)
+
+	if !ok {
+		L.ArgError(5, "Invalid percent type. Expected number")
+		return 0
+	}
+
+	// Default bar colors
+	style := util.BarStyle{
+		Fill:       color.RGBA{76, 175, 80, 255},
+		Background: color.RGBA{51, 51, 51, 255},
+	}
+
+	// Get bar options
+	switch opts := L.Get(7); opts.Type() {
+	case lua.LTTable:
+		tbl := opts.(*lua.LTable)
+
+		for _, c := range []struct {
+			field string
+			dst   *color.Color
+		}{
+			{"fg", &style.Fill},
+			{"bg", &style.Background},
+			{"border", &style.Border},
+		} {
+			v := tbl.RawGetString(c.field)
+
+			if v == lua.LNil {
+				continue
+			}
+
+			parsed, err := colorful.Hex(v.String())
+
+			if err != nil {
+				L.ArgError(6, fmt.Sprintf("Invalid %s color: %v", c.field, err))
+				return 0
+			}
+
+			*c.dst = parsed
+		}
+
+		if style.Border != nil {
+			style.BorderWidth = 1
+		}
+
+		if v := tbl.RawGetString("borderWidth"); v != lua.LNil {
+			n, ok := v.(lua.LNumber)
+
+			if !ok || n < 0 {
+				L.ArgError(6, "Invalid borderWidth. Expected positive number")
+				return 0
+			}
+
+			style.BorderWidth = int(n)
+		}
+	case lua.LTNil:
+	default:
+		L.ArgError(6, "Invalid options type. Expected table")
+		return 0
+	}
+
+	// Draw bar
+	img.DrawBar(values[0], values[1], values[2], values[3], float64(percent), style)
+
+	return 0
+}
+
+// GetGoImageAverageColor returns the mean color of the goimage as a {r, g, b} table
+func GetGoImageAverageColor(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Push color table
+	L.Push(colorToTable(L, img.AverageColor()))
+
+	return 1
+}
+
+// GetGoImageDominantColor returns the most common color of the goimage as a {r, g, b} table
+func GetGoImageDominantColor(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Push color table
+	L.Push(colorToTable(L, img.DominantColor()))
+
+	return 1
+}
+
+// colorToTable converts the given color to a {r, g, b} table
+func colorToTable(L *lua.LState, c color.RGBA) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("r", lua.LNumber(c.R))
+	tbl.RawSetString("g", lua.LNumber(c.G))
+	tbl.RawSetString("b", lua.LNumber(c.B))
+
+	return tbl
+}
+
+// FlipHorizontalGoImage flips the goimage horizontally
+func FlipHorizontalGoImage(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Flip image
+	img.FlipH()
+
+	return 0
+}
+
+// FlipVerticalGoImage flips the goimage vertically
+func FlipVerticalGoImage(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Flip image
+	img.FlipV()
+
+	return 0
+}
+
+// SetGoImageFont loads a font file from the datapack directory to be used by writeText
+func SetGoImageFont(L *lua.LState) int {
+	// Get goimage
+	img := getGoImage(L)
+
+	// Get font path
+	path := L.Get(2)
+
+	if path.Type() != lua.LTString {
+		L.ArgError(1, "Invalid font path type. Expected string")
+		return 0
+	}
+
+	// Get font size
+	size := L.OptNumber(3, 0)
+
+	// Fonts must be inside the datapack directory
+	fontPath, err := filepath.Abs(filepath.Join(util.Config.Get().Datapack, filepath.Clean("/"+path.String())))
+
+	if err != nil {
+		L.RaiseError("Cannot get font path: %v", err)
+		return 0
+	}
+
+	// Load font
+	if err := img.SetFont(fontPath, float64(size)); err != nil {
+		L.RaiseError("Cannot load font: %v", err)
+		return 0
+	}
+
+	return 0
+}
+
+// ConvertImage converts the given image file to another format. The source format is detected
+// by its content. The options table accepts the jpeg quality and keepMetadata fields, metadata
+// is stripped by default. Returns nil and the error message on unsupported images
+func ConvertImage(L *lua.LState) int {
+	// Get source path
+	src := L.Get(2)
+
+	// Check for valid source type
+	if src.Type() != lua.LTString {
+		L.ArgError(1, "Invalid source path type. Expected string")
+		return 0
+	}
+
+	// Get destination path
+	dst := L.Get(3)
+
+	// Check for valid destination type
+	if dst.Type() != lua.LTString {
+		L.ArgError(2, "Invalid destination path type. Expected string")
+		return 0
+	}
+
+	// Get output format
+	format := L.Get(4)
+
+	// Check for valid format type
+	if format.Type() != lua.LTString {
+		L.ArgError(3, "Invalid format type. Expected string")
+		return 0
+	}
+
+	// Get jpeg quality and metadata options
+	quality := 0
+	keepMetadata := false
+
+	if opts, ok := L.Get(5).(*lua.LTable); ok {
+		if q, ok := opts.RawGetString("quality").(lua.LNumber); ok {
+			quality = int(q)
+		}
+
+		keepMetadata = lua.LVAsBool(opts.RawGetString("keepMetadata"))
+	}
+
+	// Convert image
+	if err := util.ConvertImage(src.String(), dst.String(), format.String(), quality, keepMetadata); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
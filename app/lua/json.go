@@ -1,7 +1,12 @@
 package lua
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"strconv"
+	"strings"
 
 	"github.com/clbanning/mxj"
 	"github.com/yuin/gopher-lua"
@@ -57,7 +62,7 @@ func UnmarshalJSON(L *lua.LState) int {
 	}
 
 	// Unmarshal string
-	result, err := mxj.NewMapJson([]byte(src.String()))
+	result, err := unmarshalJSONMap(L, []byte(src.String()), L.Get(3))
 
 	if err != nil {
 		L.RaiseError("Cannot unmarshal the given string: %v", err)
@@ -84,8 +89,13 @@ func UnmarshalJSONFile(L *lua.LState) int {
 	// Read whole file
 	file, err := ioutil.ReadFile(src.String())
 
+	if err != nil {
+		L.RaiseError("Cannot read the given file: %v", err)
+		return 0
+	}
+
 	// Unmarshal string
-	result, err := mxj.NewMapJson(file)
+	result, err := unmarshalJSONMap(L, file, L.Get(3))
 
 	if err != nil {
 		L.RaiseError("Cannot unmarshal the given file: %v", err)
@@ -97,3 +107,181 @@ func UnmarshalJSONFile(L *lua.LState) int {
 
 	return 1
 }
+
+// unmarshalJSONMap unmarshals the given data using the unmarshal options table. When
+// the numbers option is set to "string" numbers are decoded as strings to keep precision
+func unmarshalJSONMap(L *lua.LState, data []byte, options lua.LValue) (map[string]interface{}, error) {
+	// Check for valid options type
+	if options.Type() != lua.LTTable {
+		return mxj.NewMapJson(data)
+	}
+
+	// Get numbers option
+	numbers := L.GetField(options, "numbers")
+
+	if numbers == lua.LNil || numbers.String() == "number" {
+		return mxj.NewMapJson(data)
+	}
+
+	if numbers.String() != "string" {
+		return nil, errors.New("Invalid numbers option. Expected number or string")
+	}
+
+	// Create decoder that keeps numbers as json.Number
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	// Result holder
+	result := map[string]interface{}{}
+
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return jsonNumbersToStrings(result).(map[string]interface{}), nil
+}
+
+// jsonNumbersToStrings replaces all json.Number values with their string representation
+func jsonNumbersToStrings(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		return val.String()
+	case map[string]interface{}:
+		for k, e := range val {
+			val[k] = jsonNumbersToStrings(e)
+		}
+	case []interface{}:
+		for i, e := range val {
+			val[i] = jsonNumbersToStrings(e)
+		}
+	}
+
+	return v
+}
+
+// GetJSONPath retrieves the value at the given path from an unmarshaled table
+func GetJSONPath(L *lua.LState) int {
+	// Get table
+	tbl := L.Get(2)
+
+	// Check for valid table type
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid value type. Expected table")
+		return 0
+	}
+
+	// Get path
+	path := L.Get(3)
+
+	// Check for valid path type
+	if path.Type() != lua.LTString {
+		L.ArgError(2, "Invalid path type. Expected string")
+		return 0
+	}
+
+	// Split path into segments
+	segments, err := parseJSONPath(path.String())
+
+	if err != nil {
+		L.ArgError(2, err.Error())
+		return 0
+	}
+
+	// Current value holder
+	var current lua.LValue = tbl
+
+	// Loop path segments
+	for _, segment := range segments {
+
+		// Every segment needs a table to look into
+		t, ok := current.(*lua.LTable)
+
+		if !ok {
+			L.Push(lua.LNil)
+			return 1
+		}
+
+		// Switch segment type
+		switch seg := segment.(type) {
+		case int:
+
+			// Array indexes are zero based like in JSON
+			current = t.RawGetInt(seg + 1)
+
+		case string:
+			current = t.RawGetString(seg)
+		}
+
+		if current == lua.LNil {
+			L.Push(lua.LNil)
+			return 1
+		}
+	}
+
+	// Push value
+	L.Push(current)
+
+	return 1
+}
+
+// parseJSONPath converts a path like "data.items[0].name" into a list of keys and indexes
+func parseJSONPath(path string) ([]interface{}, error) {
+	// Segments holder
+	segments := []interface{}{}
+
+	// Loop dotted parts
+	for _, part := range strings.Split(path, ".") {
+
+		// Get key before any bracket
+		bracket := strings.Index(part, "[")
+
+		key := part
+
+		if bracket >= 0 {
+			key = part[:bracket]
+		}
+
+		if key != "" {
+			segments = append(segments, key)
+		} else if bracket != 0 {
+			return nil, errors.New("Invalid path. Empty segment")
+		}
+
+		// Loop bracket indexes
+		for bracket >= 0 {
+
+			part = part[bracket:]
+
+			// Find closing bracket
+			end := strings.Index(part, "]")
+
+			if end < 0 {
+				return nil, errors.New("Invalid path. Missing closing bracket")
+			}
+
+			// Convert index to number
+			index, err := strconv.Atoi(part[1:end])
+
+			if err != nil || index < 0 {
+				return nil, errors.New("Invalid path. Expected numeric index")
+			}
+
+			segments = append(segments, index)
+
+			// Move to the next bracket
+			part = part[end+1:]
+
+			if part == "" {
+				break
+			}
+
+			if !strings.HasPrefix(part, "[") {
+				return nil, errors.New("Invalid path. Unexpected characters after index")
+			}
+
+			bracket = 0
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,151 @@
+package lua
+
+import (
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetJWTMetaTable sets the jwt metatable of the given state
+func SetJWTMetaTable(luaState *lua.LState) {
+	// Create and set the jwt metatable
+	jwtMetaTable := luaState.NewTypeMetatable(JWTMetaTableName)
+	luaState.SetGlobal(JWTMetaTableName, jwtMetaTable)
+
+	// Set all jwt metatable functions
+	luaState.SetFuncs(jwtMetaTable, jwtMethods)
+}
+
+// SignJWT signs the given claims table. The options table accepts the algorithm
+// (HS256, HS384 or HS512) and expiresIn (seconds or duration string) fields
+func SignJWT(L *lua.LState) int {
+	// Get claims
+	tbl := L.Get(2)
+
+	// Check for valid claims type
+	if tbl.Type() != lua.LTTable {
+
+		L.ArgError(1, "Invalid claims type. Expected table")
+		return 0
+	}
+
+	// Get secret
+	secret := L.Get(3)
+
+	// Check for valid secret type
+	if secret.Type() != lua.LTString || secret.String() == "" {
+
+		L.ArgError(2, "Invalid secret type. Expected non empty string")
+		return 0
+	}
+
+	// Convert claims
+	v, err := sessionValueToGo(tbl, map[*lua.LTable]bool{}, "token claims")
+
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	claims, ok := v.(map[string]interface{})
+
+	if !ok {
+		L.ArgError(1, "Invalid claims type. Expected table with string keys")
+		return 0
+	}
+
+	alg := "HS256"
+	now := time.Now()
+
+	// Get options
+	if opts, ok := L.Get(4).(*lua.LTable); ok {
+
+		if v := opts.RawGetString("algorithm"); v.Type() == lua.LTString {
+			alg = v.String()
+		}
+
+		// Set expiration claim
+		if v := opts.RawGetString("expiresIn"); v != lua.LNil {
+			ttl, ok := durationFromValue(v)
+
+			if !ok {
+				L.ArgError(3, "Invalid expiresIn. Expected number of seconds or duration string")
+				return 0
+			}
+
+			claims["exp"] = float64(now.Add(ttl).Unix())
+		}
+	}
+
+	// Set issued at claim
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = float64(now.Unix())
+	}
+
+	// Sign token
+	token, err := util.SignJWT(claims, []byte(secret.String()), alg)
+
+	if err != nil {
+		L.ArgError(3, err.Error())
+		return 0
+	}
+
+	L.Push(lua.LString(token))
+
+	return 1
+}
+
+// VerifyJWT verifies the token signature and time claims returning the claims table.
+// Returns nil and the error message if the token is invalid. The options table
+// accepts the leeway field (seconds or duration string)
+func VerifyJWT(L *lua.LState) int {
+	// Get token
+	token := L.Get(2)
+
+	// Check for valid token type
+	if token.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid token type. Expected string")
+		return 0
+	}
+
+	// Get secret
+	secret := L.Get(3)
+
+	// Check for valid secret type
+	if secret.Type() != lua.LTString || secret.String() == "" {
+
+		L.ArgError(2, "Invalid secret type. Expected non empty string")
+		return 0
+	}
+
+	// Get leeway
+	leeway := time.Duration(0)
+
+	if opts, ok := L.Get(4).(*lua.LTable); ok {
+		if v := opts.RawGetString("leeway"); v != lua.LNil {
+			d, ok := durationFromValue(v)
+
+			if !ok {
+				L.ArgError(3, "Invalid leeway. Expected number of seconds or duration string")
+				return 0
+			}
+
+			leeway = d
+		}
+	}
+
+	// Verify token
+	claims, err := util.VerifyJWT(token.String(), []byte(secret.String()), leeway)
+
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(sessionValueToLua(claims))
+
+	return 1
+}
@@ -21,7 +21,7 @@ func LogError(L *lua.LState) int {
 	content := L.Get(2)
 
 	// Log content
-	util.Logger.Logger.Errorf("%v", content.String())
+	util.Logger.Logger.WithFields(getLogFields(L)).Errorf("%v", content.String())
 
 	return 0
 }
@@ -32,7 +32,18 @@ func LogFatal(L *lua.LState) int {
 	content := L.Get(2)
 
 	// Log content
-	util.Logger.Logger.Fatalf("%v", content.String())
+	util.Logger.Logger.WithFields(getLogFields(L)).Fatalf("%v", content.String())
+
+	return 0
+}
+
+// LogWarn logs a message with the warning level
+func LogWarn(L *lua.LState) int {
+	// Get content to log
+	content := L.Get(2)
+
+	// Log content
+	util.Logger.Logger.WithFields(getLogFields(L)).Warnf("%v", content.String())
 
 	return 0
 }
@@ -43,7 +54,27 @@ func LogInfo(L *lua.LState) int {
 	content := L.Get(2)
 
 	// Log content
-	util.Logger.Logger.Infof("%v", content.String())
+	util.Logger.Logger.WithFields(getLogFields(L)).Infof("%v", content.String())
 
 	return 0
 }
+
+// getLogFields returns the optional fields table tagged with the request identifier
+func getLogFields(L *lua.LState) map[string]interface{} {
+	// Fields holder
+	fields := map[string]interface{}{}
+
+	// Get optional fields table
+	if tbl, ok := L.Get(3).(*lua.LTable); ok {
+		fields = TableToMap(tbl)
+	}
+
+	// Tag entry with the current request identifier
+	if req := requestFromState(L); req != nil {
+		if id := util.RequestID(req); id != "" {
+			fields["request_id"] = id
+		}
+	}
+
+	return fields
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 
@@ -18,14 +17,19 @@ import (
 
 // luaStatePool struct used for lua state pooling
 type luaStatePool struct {
-	m     sync.Mutex
-	saved []*glua.LState
+	m      sync.Mutex
+	saved  []*glua.LState
+	reuses map[*glua.LState]int
 }
 
+// maxPooledStates maximum number of idle states kept by the pool
+const maxPooledStates = 100
+
 var (
 	// Pool saves all lua state pointers to create a sync.Pool
 	Pool = &luaStatePool{
-		saved: make([]*glua.LState, 0, 10),
+		saved:  make([]*glua.LState, 0, 10),
+		reuses: map[*glua.LState]int{},
 	}
 
 	globalFuncList = map[string]func(l *glua.LState) int{
@@ -36,48 +40,119 @@ var (
 		"ternary": Ternary,
 	}
 	cryptoMethods = map[string]glua.LGFunction{
-		"sha1":         Sha1Hash,
-		"sha256":       Sha256Hash,
-		"hmacsha256":   HmacSha256,
-		"md5":          Md5Hash,
-		"randomString": RandomString,
-		"qr":           GenerateQRCode,
-		"qrKey":        GenerateAuthSecretKey,
+		"sha1":           Sha1Hash,
+		"sha256":         Sha256Hash,
+		"hmacsha256":     HmacSha256,
+		"md5":            Md5Hash,
+		"randomString":   RandomString,
+		"qr":             GenerateQRCode,
+		"qrDataURI":      GenerateQRCodeDataURI,
+		"qrKey":          GenerateAuthSecretKey,
+		"verifyTotp":     VerifyTOTP,
+		"verifyPassword": VerifyPassword,
+		"hashPassword":   HashPassword,
+		"base64Encode":   CryptoBase64Encode,
+		"base64Decode":   CryptoBase64Decode,
+		"uuid":           GenerateUUID,
+		"secureCompare":  SecureCompare,
 	}
 	base64Methods = map[string]glua.LGFunction{
 		"encode": Base64Encode,
 		"decode": Base64Decode,
 	}
 	mysqlMethods = map[string]glua.LGFunction{
-		"query":       Query,
-		"execute":     Execute,
-		"singleQuery": SingleQuery,
+		"query":               Query,
+		"queryEach":           QueryEach,
+		"execute":             Execute,
+		"singleQuery":         SingleQuery,
+		"paginate":            Paginate,
+		"prepare":             PrepareStatement,
+		"stats":               DatabaseStats,
+		"connect":             ConnectDatabase,
+		"use":                 UseDatabase,
+		"escapeLike":          EscapeLike,
+		"likeSearch":          LikeSearch,
+		"queryWithColumns":    QueryWithColumns,
+		"tryQuery":            tryFunction(Query),
+		"tryQueryEach":        tryFunction(QueryEach),
+		"tryExecute":          tryFunction(Execute),
+		"trySingleQuery":      tryFunction(SingleQuery),
+		"tryPaginate":         tryFunction(Paginate),
+		"tryLikeSearch":       tryFunction(LikeSearch),
+		"tryQueryWithColumns": tryFunction(QueryWithColumns),
+	}
+	namedDatabaseMethods = map[string]glua.LGFunction{
+		"query":               Query,
+		"queryEach":           QueryEach,
+		"execute":             Execute,
+		"singleQuery":         SingleQuery,
+		"paginate":            Paginate,
+		"stats":               DatabaseStats,
+		"escapeLike":          EscapeLike,
+		"likeSearch":          LikeSearch,
+		"queryWithColumns":    QueryWithColumns,
+		"tryQuery":            tryFunction(Query),
+		"tryQueryEach":        tryFunction(QueryEach),
+		"tryExecute":          tryFunction(Execute),
+		"trySingleQuery":      tryFunction(SingleQuery),
+		"tryPaginate":         tryFunction(Paginate),
+		"tryLikeSearch":       tryFunction(LikeSearch),
+		"tryQueryWithColumns": tryFunction(QueryWithColumns),
+	}
+	statementMethods = map[string]glua.LGFunction{
+		"query":   StatementQuery,
+		"execute": StatementExecute,
 	}
 	configMethods = map[string]glua.LGFunction{
 		"get":       GetConfigLuaValue,
 		"setCustom": SetConfigCustomValue,
+		"set":       SetConfigValue,
 	}
 	httpMethods = map[string]glua.LGFunction{
 		"setCookie":          SetCookie,
 		"getCookie":          GetCookie,
+		"setSignedCookie":    SetSignedCookie,
+		"getSignedCookie":    GetSignedCookie,
 		"redirect":           Redirect,
 		"render":             RenderTemplate,
+		"renderString":       RenderTemplateString,
 		"write":              WriteResponse,
+		"json":               WriteJSON,
+		"cachePage":          CachePage,
 		"serveFile":          ServeFile,
 		"get":                GetRequest,
 		"setHeader":          SetHeader,
 		"postForm":           PostFormRequest,
 		"getHeader":          GetHeader,
 		"getRemoteAddress":   GetRemoteAddress,
+		"getClientIP":        GetClientIP,
+		"requestID":          GetRequestID,
+		"host":               GetHost,
 		"curl":               CreateRequestClient,
 		"formFile":           GetFormFile,
 		"parseMultiPartForm": ParseMultiPartForm,
 		"GetRelativeURL":     GetRelativeURL,
+		"isSecure":           IsSecureRequest,
+		"requireSecure":      RequireSecureRequest,
+		"upgradeWebSocket":   UpgradeWebSocket,
+		"limit":              SetRequestLimits,
+		"cors":               SetCORSHeaders,
+		"verifySignedURL":    VerifySignedURL,
+		"requestMethod":      GetRequestMethod,
+		"path":               GetRequestPath,
+		"fullURL":            GetFullURL,
+		"tryGet":             tryFunction(GetRequest),
+		"tryPostForm":        tryFunction(PostFormRequest),
+		"tryCurl":            tryFunction(CreateRequestClient),
 	}
 	httpRegularMethods = map[string]glua.LGFunction{
-		"curl":     CreateRequestClient,
-		"postForm": PostFormRequest,
-		"get":      GetRequest,
+		"curl":        CreateRequestClient,
+		"postForm":    PostFormRequest,
+		"get":         GetRequest,
+		"onError":     SetErrorHandler,
+		"tryCurl":     tryFunction(CreateRequestClient),
+		"tryPostForm": tryFunction(PostFormRequest),
+		"tryGet":      tryFunction(GetRequest),
 	}
 	validatorMethods = map[string]glua.LGFunction{
 		"validate":       Validate,
@@ -96,21 +171,36 @@ var (
 		"isAdmin":       IsAdmin,
 		"getFlash":      GetFlash,
 		"setFlash":      SetFlash,
+		"getFlashes":    GetFlashes,
 		"set":           SetSessionData,
 		"get":           GetSessionData,
 		"destroy":       DestroySession,
 		"loggedAccount": GetLoggedAccount,
+		"csrfToken":     GetCsrfToken,
+		"verifyCsrf":    VerifyCsrfToken,
+		"touch":         TouchSession,
+		"expiresAt":     GetSessionExpiration,
+		"regenerate":    RegenerateSession,
 	}
 	captchaMethods = map[string]glua.LGFunction{
 		"isEnabled": IsEnabled,
 		"verify":    VerifyCaptcha,
 	}
 	mapMethods = map[string]glua.LGFunction{
-		"houseList":  HouseList,
-		"townList":   TownList,
-		"townByID":   GetTownByID,
-		"townByName": GetTownByName,
-		"encode":     EncodeMap,
+		"houseList":      HouseList,
+		"townList":       TownList,
+		"townByID":       GetTownByID,
+		"templePosition": GetTemplePosition,
+		"townByName":     GetTownByName,
+		"encode":         EncodeMap,
+		"onlinePlayers":  OnlinePlayers,
+		"latestDeaths":   LatestDeaths,
+		"highscores":     Highscores,
+		"searchPlayers":  SearchPlayers,
+		"playersByNames": PlayersByNames,
+		"playersByIDs":   PlayersByIDs,
+		"vocationStats":  VocationStats,
+		"townStats":      TownStats,
 	}
 	xmlMethods = map[string]glua.LGFunction{
 		"vocationList":   VocationList,
@@ -121,83 +211,127 @@ var (
 		"unmarshalFile":  UnmarshalXMLFile,
 		"monsterList":    MonsterList,
 		"monsterByName":  MonsterByName,
+		"basePromotion":  GetBasePromotion,
 	}
 	mailMethods = map[string]glua.LGFunction{
-		"send": SendMail,
+		"send":            SendMail,
+		"sendTemplate":    SendTemplateMail,
+		"sendBulk":        SendBulkMail,
+		"trySend":         tryFunction(SendMail),
+		"trySendTemplate": tryFunction(SendTemplateMail),
 	}
 	cacheMethods = map[string]glua.LGFunction{
-		"get":    GetCacheValue,
-		"set":    SetCacheValue,
-		"delete": DeleteCacheValue,
+		"get":           GetCacheValue,
+		"set":           SetCacheValue,
+		"delete":        DeleteCacheValue,
+		"invalidateTag": InvalidateCacheTag,
 	}
 	debugMethods = map[string]glua.LGFunction{
-		"value": DebugValue,
+		"value":     DebugValue,
+		"traceback": DebugTraceback,
+		"stateInfo": DebugStateInfo,
 	}
 	urlMethods = map[string]glua.LGFunction{
-		"decode": DecodeURL,
-		"encode": EncodeURL,
+		"decode":     DecodeURL,
+		"encode":     EncodeURL,
+		"build":      BuildURL,
+		"parseQuery": ParseQueryString,
+		"slugify":    SlugifyURL,
+		"sign":       SignURL,
 	}
 	timeMethods = map[string]glua.LGFunction{
-		"parseUnix":     ParseUnixTimestamp,
-		"parseDuration": ParseDurationString,
-		"parseDate":     ParseDate,
-		"newDuration":   NewDuration,
+		"parseUnix":        ParseUnixTimestamp,
+		"parseDuration":    ParseDurationString,
+		"parseDate":        ParseDate,
+		"newDuration":      NewDuration,
+		"humanizeDuration": HumanizeDuration,
 	}
 	reflectMethods = map[string]glua.LGFunction{
-		"getGlobal": nil,
+		"type": GetReflectType,
 	}
 	jsonMethods = map[string]glua.LGFunction{
 		"marshal":       MarshalJSON,
 		"unmarshal":     UnmarshalJSON,
 		"unmarshalFile": UnmarshalJSONFile,
+		"get":           GetJSONPath,
 	}
 	storageMethods = map[string]glua.LGFunction{
-		"get": GetStorageValue,
-		"set": SetStorageValue,
+		"get":       GetStorageValue,
+		"set":       SetStorageValue,
+		"claimOnce": ClaimOnce,
 	}
 	playerMethods = map[string]glua.LGFunction{
-		"getAccountId":    GetPlayerAccountID,
-		"isOnline":        IsPlayerOnline,
-		"getBankBalance":  GetPlayerBankBalance,
-		"setBankBalance":  SetPlayerBankBalance,
-		"getStorageValue": GetPlayerStorageValue,
-		"setStorageValue": SetPlayerStorageValue,
-		"getVocation":     GetPlayerVocation,
-		"getTown":         GetPlayerTown,
-		"getGender":       GetPlayerGender,
-		"getLevel":        GetPlayerLevel,
-		"getPremiumDays":  GetPlayerPremiumDays,
-		"getName":         GetPlayerName,
-		"getExperience":   GetPlayerExperience,
-		"getCapacity":     GetPlayerCapacity,
-		"getCustomField":  GetPlayerCustomField,
-		"setCustomField":  SetPlayerCustomField,
-		"getGuild":        GetPlayerGuild,
+		"getAccountId":     GetPlayerAccountID,
+		"isOnline":         IsPlayerOnline,
+		"isBanned":         IsPlayerBanned,
+		"getBankBalance":   GetPlayerBankBalance,
+		"setBankBalance":   SetPlayerBankBalance,
+		"getStorageValue":  GetPlayerStorageValue,
+		"setStorageValue":  SetPlayerStorageValue,
+		"getStorageValues": GetPlayerStorageValues,
+		"setStorageValues": SetPlayerStorageValues,
+		"hasAchievement":   HasPlayerAchievement,
+		"achievements":     GetPlayerAchievements,
+		"getVocation":      GetPlayerVocation,
+		"getTown":          GetPlayerTown,
+		"getGender":        GetPlayerGender,
+		"getLevel":         GetPlayerLevel,
+		"getPremiumDays":   GetPlayerPremiumDays,
+		"getName":          GetPlayerName,
+		"getExperience":    GetPlayerExperience,
+		"getCapacity":      GetPlayerCapacity,
+		"getCreationDate":  GetPlayerCreationDate,
+		"getCustomField":   GetPlayerCustomField,
+		"setCustomField":   SetPlayerCustomField,
+		"getGuild":         GetPlayerGuild,
+		"getDeaths":        GetPlayerDeaths,
+		"rename":           RenamePlayer,
+		"setLevel":         SetPlayerLevel,
+		"addExperience":    AddPlayerExperience,
+		"getOutfit":        GetPlayerOutfit,
 	}
 	guildMethods = map[string]glua.LGFunction{
 		"getOwner":   GetGuildOwner,
 		"getMembers": GetGuildMembers,
 		"getLeader":  GetGuildLeader,
+		"members":    GetGuildMembersWithRanks,
+		"invite":     InviteGuildPlayer,
 	}
 	widgetMethods = map[string]glua.LGFunction{
-		"render": RenderWidgetTemplate,
+		"render":   RenderWidgetTemplate,
+		"register": RegisterWidget,
+		"list":     ListRegisteredWidgets,
 	}
 	eventsMethods = map[string]glua.LGFunction{
-		"new": BackgroundEvent,
+		"new":        BackgroundEvent,
+		"addAt":      ScheduleEvent,
+		"onShutdown": OnShutdown,
+		"defer":      DeferEvent,
 	}
 	paypalMethods = map[string]glua.LGFunction{
 		"createPayment":      CreatePaypalPayment,
 		"paymentInformation": GetPaypalPayment,
 		"executePayment":     ExecutePaypalPayment,
+		"tryCreatePayment":   tryFunction(CreatePaypalPayment),
 	}
 	imgMethods = map[string]glua.LGFunction{
-		"new": NewGoImage,
+		"new":     NewGoImage,
+		"load":    LoadGoImage,
+		"convert": ConvertImage,
 	}
 	goimageMethods = map[string]glua.LGFunction{
 		"writeText":     WriteGoImageText,
 		"save":          SaveGoImage,
 		"setBackground": SetBackgroundGoImage,
 		"encode":        GetGoImageAsString,
+		"rotate":        RotateGoImage,
+		"flipH":         FlipHorizontalGoImage,
+		"flipV":         FlipVerticalGoImage,
+		"measureText":   MeasureGoImageText,
+		"setFont":       SetGoImageFont,
+		"averageColor":  GetGoImageAverageColor,
+		"dominantColor": GetGoImageDominantColor,
+		"drawBar":       DrawGoImageBar,
 	}
 	fileMethods = map[string]glua.LGFunction{
 		"mod":             GetFileModTime,
@@ -207,13 +341,16 @@ var (
 		"createDirectory": CreateDirectory,
 	}
 	envMethods = map[string]glua.LGFunction{
-		"set": SetEnvVariable,
-		"get": GetEnvVariable,
+		"set":    SetEnvVariable,
+		"get":    GetEnvVariable,
+		"delete": DeleteEnvVariable,
+		"list":   ListEnvVariables,
 	}
 	logMethods = map[string]glua.LGFunction{
 		"error": LogError,
 		"fatal": LogFatal,
 		"info":  LogInfo,
+		"warn":  LogWarn,
 	}
 	globalMethods = map[string]glua.LGFunction{
 		"set":    SetGlobalLuaValue,
@@ -231,12 +368,105 @@ var (
 	}
 	outfitMethods = map[string]glua.LGFunction{
 		"generate": GenerateOutfit,
+		"render":   RenderOutfit,
 	}
 	extensionMethods = map[string]glua.LGFunction{
 		"reload": ReloadExtensions,
 	}
 	i18nMethods = map[string]glua.LGFunction{
-		"get": GetLanguageIndex,
+		"get":       GetLanguageIndex,
+		"t":         Translate,
+		"locale":    GetLocale,
+		"setLocale": SetLocale,
+	}
+	rateLimitMethods = map[string]glua.LGFunction{
+		"allow": RateLimitAllow,
+		"reset": RateLimitReset,
+	}
+	accountMethods = map[string]glua.LGFunction{
+		"create":          CreateAccount,
+		"throttleReset":   ThrottlePasswordReset,
+		"banInfo":         GetAccountBanInfo,
+		"ban":             BanAccount,
+		"unban":           UnbanAccount,
+		"getCreationDate": GetAccountCreationDate,
+		"enable2FA":       EnableAccountTwoFactor,
+		"disable2FA":      DisableAccountTwoFactor,
+		"has2FA":          AccountHasTwoFactor,
+	}
+	serverMethods = map[string]glua.LGFunction{
+		"status": ServerStatus,
+	}
+	paygolMethods = map[string]glua.LGFunction{
+		"createPayment":      CreatePayGolPayment,
+		"paymentInformation": GetPayGolPayment,
+	}
+	guildModuleMethods = map[string]glua.LGFunction{
+		"byName": GetGuildByName,
+		"byID":   GetGuildByID,
+		"create": CreateGuild,
+	}
+	markdownMethods = map[string]glua.LGFunction{
+		"render": RenderMarkdown,
+	}
+	htmlMethods = map[string]glua.LGFunction{
+		"sanitize": SanitizeHTML,
+	}
+	csvMethods = map[string]glua.LGFunction{
+		"encode": EncodeCSV,
+		"decode": DecodeCSV,
+	}
+	sharedMethods = map[string]glua.LGFunction{
+		"get":    GetSharedValue,
+		"set":    SetSharedValue,
+		"delete": DeleteSharedValue,
+	}
+	metricsMethods = map[string]glua.LGFunction{
+		"increment": IncrementMetric,
+		"observe":   ObserveMetric,
+	}
+	tokenMethods = map[string]glua.LGFunction{
+		"create":  CreateToken,
+		"consume": ConsumeToken,
+	}
+	templateMethods = map[string]glua.LGFunction{
+		"registerFunc": RegisterTemplateFunc,
+	}
+	geoipMethods = map[string]glua.LGFunction{
+		"country": GeoIPCountry,
+		"lookup":  GeoIPLookup,
+	}
+	sitemapMethods = map[string]glua.LGFunction{
+		"new": NewSitemap,
+	}
+	sitemapObjectMethods = map[string]glua.LGFunction{
+		"add":    AddSitemapURL,
+		"count":  CountSitemapURLs,
+		"render": RenderSitemap,
+	}
+	websocketMethods = map[string]glua.LGFunction{
+		"broadcast": BroadcastWebSocket,
+		"count":     CountWebSocketChannel,
+	}
+	websocketConnMethods = map[string]glua.LGFunction{
+		"send":    SendWebSocketMessage,
+		"receive": ReceiveWebSocketMessage,
+		"close":   CloseWebSocket,
+		"join":    JoinWebSocketChannel,
+		"leave":   LeaveWebSocketChannel,
+	}
+	jwtMethods = map[string]glua.LGFunction{
+		"sign":   SignJWT,
+		"verify": VerifyJWT,
+	}
+	formatMethods = map[string]glua.LGFunction{
+		"money": FormatMoney,
+	}
+	discordMethods = map[string]glua.LGFunction{
+		"send": SendDiscordWebhook,
+	}
+	healthMethods = map[string]glua.LGFunction{
+		"check": HealthCheck,
 	}
 )
 
@@ -262,9 +492,11 @@ func CompileLua(filePath string) (*glua.FunctionProto, error) {
 // DoCompiledFile takes a FunctionProto, as returned by CompileLua, and runs it in the LState. It is equivalent
 // to calling DoFile on the LState with the original source file.
 func DoCompiledFile(state *glua.LState, proto *glua.FunctionProto) error {
-	lfunc := state.NewFunctionFromProto(proto)
-	state.Push(lfunc)
-	return state.PCall(0, glua.MultRet, nil)
+	return state.CallByParam(glua.P{
+		Fn:      state.NewFunctionFromProto(proto),
+		NRet:    glua.MultRet,
+		Protect: true,
+	})
 }
 
 // OverwriteConfigFile gathers all external config file and pushes globals
@@ -306,7 +538,7 @@ func OverwriteConfigFile() error {
 	}
 
 	// Convert table back to a map
-	util.Config.Configuration.Custom = TableToMap(customField)
+	util.Config.SetCustomValues(TableToMap(customField))
 
 	return nil
 }
@@ -326,11 +558,78 @@ func (p *luaStatePool) Get() *glua.LState {
 	x := p.saved[len(p.saved)-1]
 	p.saved = p.saved[0 : len(p.saved)-1]
 
+	// Count state reuse
+	p.reuses[x]++
+
+	// Refresh outdated app global
+	refreshConfigGlobal(x)
+
+	// Update pool metrics
+	util.Metrics.Increment("castro_lua_states_reused_total", 1)
+	util.Metrics.SetGauge("castro_lua_states_pooled", float64(len(p.saved)))
+
 	return x
 }
 
 // GetApplicationState returns a page configured lua state
 func GetApplicationState(luaState *glua.LState) {
+	// Create health metatable
+	SetHealthMetaTable(luaState)
+
+	// Create discord metatable
+	SetDiscordMetaTable(luaState)
+
+	// Create format metatable
+	SetFormatMetaTable(luaState)
+
+	// Create jwt metatable
+	SetJWTMetaTable(luaState)
+
+	// Create websocket metatable
+	SetWebSocketMetaTable(luaState)
+
+	// Create sitemap metatable
+	SetSitemapMetaTable(luaState)
+
+	// Create geoip metatable
+	SetGeoIPMetaTable(luaState)
+
+	// Create template metatable
+	SetTemplateMetaTable(luaState)
+
+	// Create token metatable
+	SetTokenMetaTable(luaState)
+
+	// Create metrics metatable
+	SetMetricsMetaTable(luaState)
+
+	// Create shared metatable
+	SetSharedMetaTable(luaState)
+
+	// Create csv metatable
+	SetCSVMetaTable(luaState)
+
+	// Create html metatable
+	SetHTMLMetaTable(luaState)
+
+	// Create markdown metatable
+	SetMarkdownMetaTable(luaState)
+
+	// Create guild metatable
+	SetGuildMetaTable(luaState)
+
+	// Create paygol metatable
+	SetPayGolMetaTable(luaState)
+
+	// Create server metatable
+	SetServerMetaTable(luaState)
+
+	// Create account metatable
+	SetAccountMetaTable(luaState)
+
+	// Create ratelimit metatable
+	SetRateLimitMetaTable(luaState)
+
 	// Create i18n metatable
 	SetI18nMetaTable(luaState)
 
@@ -412,6 +711,9 @@ func GetApplicationState(luaState *glua.LState) {
 	// Create json metatable
 	SetJSONMetaTable(luaState)
 
+	// Create widget metatable
+	setWidgetMetaTable(luaState)
+
 	// Loop global functions map
 	for funcName, luaFunc := range globalFuncList {
 
@@ -420,7 +722,7 @@ func GetApplicationState(luaState *glua.LState) {
 	}
 
 	// Set global variables
-	luaState.SetGlobal("serverPath", glua.LString(util.Config.Configuration.Datapack))
+	luaState.SetGlobal("serverPath", glua.LString(util.Config.Get().Datapack))
 	luaState.SetGlobal("logFile",
 		glua.LString(
 			fmt.Sprintf("%v-%v-%v.json", util.Logger.LastLoggerDay.Year(), util.Logger.LastLoggerDay.Month(), util.Logger.LastLoggerDay.Day()),
@@ -442,42 +744,63 @@ func GetApplicationState(luaState *glua.LState) {
 		pkg,
 		"path",
 		glua.LString(
-			filepath.Join(f, "engine", "?.lua"),
+			requirePath(f),
 		),
 	)
 
+	// Load lua modules from the compiled module list
+	if loaders, ok := luaState.GetField(pkg, "loaders").(*glua.LTable); ok {
+		loaders.RawSetInt(2, luaState.NewFunction(RequireLoader))
+	}
+
 	// Set config field
 	SetConfigGlobal(luaState)
 }
 
+// configVersionRegistryKey registry field holding the configuration version of the app global
+const configVersionRegistryKey = "castro_config_version"
+
+// refreshConfigGlobal sets the config global value again if the configuration changed since
+// the global was created
+func refreshConfigGlobal(L *glua.LState) {
+	if v, ok := L.G.Registry.RawGetString(configVersionRegistryKey).(glua.LNumber); ok && uint64(v) == util.ConfigVersion() {
+		return
+	}
+
+	SetConfigGlobal(L)
+}
+
 // SetConfigGlobal sets the config global value
 func SetConfigGlobal(L *glua.LState) {
+	// Save configuration version
+	L.G.Registry.RawSetString(configVersionRegistryKey, glua.LNumber(util.ConfigVersion()))
+
 	// Create table
 	tbl := L.NewTable()
 
 	// Create Security table
-	secTable := StructToTable(&util.Config.Configuration.Security)
+	secTable := StructToTable(&util.Config.Get().Security)
 
 	// Create CSP table
-	cspTable := StructToTable(&util.Config.Configuration.Security.CSP)
+	cspTable := StructToTable(&util.Config.Get().Security.CSP)
 
 	// Set CSP Frame table
-	L.SetField(cspTable, "Frame", StructToTable(&util.Config.Configuration.Security.CSP.Frame))
+	L.SetField(cspTable, "Frame", StructToTable(&util.Config.Get().Security.CSP.Frame))
 
 	// Set CSP Script table
-	L.SetField(cspTable, "Script", StructToTable(&util.Config.Configuration.Security.CSP.Script))
+	L.SetField(cspTable, "Script", StructToTable(&util.Config.Get().Security.CSP.Script))
 
 	// Set CSP Font table
-	L.SetField(cspTable, "Font", StructToTable(&util.Config.Configuration.Security.CSP.Font))
+	L.SetField(cspTable, "Font", StructToTable(&util.Config.Get().Security.CSP.Font))
 
 	// Set CSP Connect table
-	L.SetField(cspTable, "Connect", StructToTable(&util.Config.Configuration.Security.CSP.Connect))
+	L.SetField(cspTable, "Connect", StructToTable(&util.Config.Get().Security.CSP.Connect))
 
 	// Set CSP Style table
-	L.SetField(cspTable, "Style", StructToTable(&util.Config.Configuration.Security.CSP.Style))
+	L.SetField(cspTable, "Style", StructToTable(&util.Config.Get().Security.CSP.Style))
 
 	// Set CSP Image table
-	L.SetField(cspTable, "Image", StructToTable(&util.Config.Configuration.Security.CSP.Image))
+	L.SetField(cspTable, "Image", StructToTable(&util.Config.Get().Security.CSP.Image))
 
 	// Set CSP table inside Security table
 	L.SetField(secTable, "CSP", cspTable)
@@ -486,34 +809,34 @@ func SetConfigGlobal(L *glua.LState) {
 	L.SetField(tbl, "Security", secTable)
 
 	// Set Shop table
-	L.SetField(tbl, "Shop", StructToTable(&util.Config.Configuration.Shop))
+	L.SetField(tbl, "Shop", StructToTable(&util.Config.Get().Shop))
 
 	// Set Plugin value
-	L.SetField(tbl, "Plugin", StructToTable(&util.Config.Configuration.Plugin))
+	L.SetField(tbl, "Plugin", StructToTable(&util.Config.Get().Plugin))
 
 	// Set main value
-	L.SetField(tbl, "Main", StructToTable(util.Config.Configuration))
+	L.SetField(tbl, "Main", StructToTable(util.Config.Get()))
 
 	// Set PayPal value
-	L.SetField(tbl, "PayPal", StructToTable(&util.Config.Configuration.PayPal))
+	L.SetField(tbl, "PayPal", StructToTable(&util.Config.Get().PayPal))
 
 	// Set Fortumo value
-	L.SetField(tbl, "Fortumo", StructToTable(&util.Config.Configuration.Fortumo))
+	L.SetField(tbl, "Fortumo", StructToTable(&util.Config.Get().Fortumo))
 
 	// Set Captcha value
-	L.SetField(tbl, "Captcha", StructToTable(&util.Config.Configuration.Captcha))
+	L.SetField(tbl, "Captcha", StructToTable(&util.Config.Get().Captcha))
 
 	// Set Mail value
-	L.SetField(tbl, "Mail", StructToTable(&util.Config.Configuration.Mail))
+	L.SetField(tbl, "Mail", StructToTable(&util.Config.Get().Mail))
 
 	// Set Custom value
-	L.SetField(tbl, "Custom", MapToTable(util.Config.Configuration.Custom))
+	L.SetField(tbl, "Custom", MapToTable(util.Config.Get().Custom))
 
 	// Set PayGol value
-	L.SetField(tbl, "PayGol", StructToTable(&util.Config.Configuration.PayGol))
+	L.SetField(tbl, "PayGol", StructToTable(&util.Config.Get().PayGol))
 
 	// Set SSL value
-	L.SetField(tbl, "SSL", StructToTable(&util.Config.Configuration.SSL))
+	L.SetField(tbl, "SSL", StructToTable(&util.Config.Get().SSL))
 
 	// Set global value
 	L.SetGlobal("app", tbl)
@@ -521,21 +844,30 @@ func SetConfigGlobal(L *glua.LState) {
 	// Set default fields
 	L.SetField(tbl, "Version", glua.LString(util.VERSION))
 	L.SetField(tbl, "BuildDate", glua.LString(util.BUILD_DATE))
-	L.SetField(tbl, "CheckUpdates", glua.LBool(util.Config.Configuration.CheckUpdates))
-	L.SetField(tbl, "URL", glua.LString(util.Config.Configuration.URL))
-	L.SetField(tbl, "Port", glua.LNumber(util.Config.Configuration.Port))
-	L.SetField(tbl, "Mode", glua.LString(util.Config.Configuration.Mode))
-	L.SetField(tbl, "Datapack", glua.LString(util.Config.Configuration.Datapack))
+	L.SetField(tbl, "CheckUpdates", glua.LBool(util.Config.Get().CheckUpdates))
+	L.SetField(tbl, "Maintenance", glua.LBool(util.Config.Get().Maintenance))
+	L.SetField(tbl, "URL", glua.LString(util.Config.Get().URL))
+	L.SetField(tbl, "Port", glua.LNumber(util.Config.Get().Port))
+	L.SetField(tbl, "Mode", glua.LString(util.Config.Get().Mode))
+	L.SetField(tbl, "Datapack", glua.LString(util.Config.Get().Datapack))
 }
 
-// Put saves a lua state back to the pool
+// Put saves a lua state back to the pool. States over the pool size are closed
 func (p *luaStatePool) Put(state *glua.LState) {
 	// Lock and unlock our mutex to prevent data race
 	p.m.Lock()
 	defer p.m.Unlock()
 
+	if len(p.saved) >= maxPooledStates {
+		p.close(state)
+		return
+	}
+
 	// Append to the pool
 	p.saved = append(p.saved, state)
+
+	// Update pool metrics
+	util.Metrics.SetGauge("castro_lua_states_pooled", float64(len(p.saved)))
 }
 
 // New creates and returns a lua state
@@ -543,29 +875,55 @@ func (p *luaStatePool) New() *glua.LState {
 	// Create a new lua state
 	state := glua.NewState(
 		glua.Options{
-			IncludeGoStackTrace: util.Config.Configuration.IsDev(),
+			IncludeGoStackTrace: util.Config.Get().IsDev(),
 		},
 	)
 
 	// Set castro metatables
 	GetApplicationState(state)
 
+	// Track state reuses
+	p.reuses[state] = 0
+
+	// Count created states
+	util.Metrics.Increment("castro_lua_states_created_total", 1)
+
 	// Return the lua state
 	return state
 }
 
+// close closes the given state and removes its reuse counter. The caller must hold the lock
+func (p *luaStatePool) close(state *glua.LState) {
+	delete(p.reuses, state)
+	state.Close()
+}
+
+// Info returns whether the given state belongs to the pool and how many times it was reused
+func (p *luaStatePool) Info(state *glua.LState) (bool, int) {
+	// Lock and unlock our mutex to prevent data race
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	reuses, ok := p.reuses[state]
+
+	return ok, reuses
+}
+
 // NewState creates and returns a new lua state
 func NewState() *glua.LState {
 	// Create a new lua state
 	state := glua.NewState(
 		glua.Options{
-			IncludeGoStackTrace: util.Config.Configuration.IsDev(),
+			IncludeGoStackTrace: util.Config.Get().IsDev(),
 		},
 	)
 
 	// Set castro metatables
 	GetApplicationState(state)
 
+	// Count created states
+	util.Metrics.Increment("castro_lua_states_created_total", 1)
+
 	// Return the lua state
 	return state
 }
@@ -580,14 +938,20 @@ func ExecuteFile(luaState *glua.LState, path string) error {
 	return nil
 }
 
-// ExecuteControllerPage executes the given subtopic using call by param
+// HasControllerFunction checks if the loaded page defines the function of the given method
+func HasControllerFunction(luaState *glua.LState, method string) bool {
+	return luaState.GetGlobal(strings.ToLower(method)).Type() == glua.LTFunction
+}
+
+// ExecuteControllerPage executes the given subtopic using call by param. Errors are always
+// returned so the error page can be rendered
 func ExecuteControllerPage(luaState *glua.LState, method string) error {
 	// Call file function
 	if err := luaState.CallByParam(
 		glua.P{
 			Fn:      luaState.GetGlobal(strings.ToLower(method)),
 			NRet:    0,
-			Protect: !util.Config.Configuration.IsDev(),
+			Protect: true,
 		},
 	); err != nil {
 		return err
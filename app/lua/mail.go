@@ -1,6 +1,8 @@
 package lua
 
 import (
+	"bytes"
+
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 	"gopkg.in/gomail.v2"
@@ -55,11 +57,179 @@ func SendMail(L *lua.LState) int {
 		return 0
 	}
 
+	// Send email
+	if err := sendMailMessage(to, subject, body); err != nil {
+		return raiseError(L, "Cannot send email", err)
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// SendTemplateMail renders the given template and sends the result as the email body
+func SendTemplateMail(L *lua.LState) int {
+	// Get template name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid template name type. Expected string")
+		return 0
+	}
+
+	// Get template data
+	data := L.Get(3)
+
+	// Data holder
+	args := map[string]interface{}{}
+
+	// Check for valid data type
+	switch data.Type() {
+	case lua.LTTable:
+		args = TableToMap(data.(*lua.LTable))
+	case lua.LTNil:
+	default:
+		L.ArgError(2, "Invalid template data type. Expected table")
+		return 0
+	}
+
+	// Get options table
+	tbl := L.Get(4)
+
+	// Check for valid options type
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(3, "Invalid email options type. Expected table")
+		return 0
+	}
+
+	// Convert table to map
+	info := TableToMap(tbl.(*lua.LTable))
+
+	// Get to header
+	to, ok := info["to"].(string)
+
+	if !ok {
+		L.ArgError(3, "Missing 'to' table field")
+		return 0
+	}
+
+	// Get subject
+	subject, ok := info["subject"].(string)
+
+	if !ok {
+		L.ArgError(3, "Missing 'subject' table field")
+		return 0
+	}
+
+	// Render template to buffer
+	buff := &bytes.Buffer{}
+
+	if err := util.Template.Render(buff, name.String(), args); err != nil {
+		L.RaiseError("Cannot render email template: %v", err)
+		return 0
+	}
+
+	// Send email
+	if err := sendMailMessage(to, subject, buff.String()); err != nil {
+		return raiseError(L, "Cannot send email", err)
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// SendBulkMail sends the same email to a list of recipients using a single connection. Messages
+// are throttled to the rate option (messages per second) or Mail.BulkRate. Returns a report
+// table with an entry {to, success, error} for every recipient
+func SendBulkMail(L *lua.LState) int {
+	// Get recipients table
+	recipients := L.Get(2)
+
+	// Check for valid type
+	if recipients.Type() != lua.LTTable {
+
+		L.ArgError(1, "Invalid recipients type. Expected table")
+		return 0
+	}
+
+	// Get subject
+	subject := L.Get(3)
+
+	if subject.Type() != lua.LTString {
+
+		L.ArgError(2, "Invalid subject type. Expected string")
+		return 0
+	}
+
+	// Get email body
+	body := L.Get(4)
+
+	if body.Type() != lua.LTString {
+
+		L.ArgError(3, "Invalid body type. Expected string")
+		return 0
+	}
+
+	// Get sending rate
+	rate := util.Config.Get().Mail.BulkRate
+
+	switch opts := L.Get(5); opts.Type() {
+	case lua.LTTable:
+		if r, ok := opts.(*lua.LTable).RawGetString("rate").(lua.LNumber); ok {
+			rate = float64(r)
+		}
+	case lua.LTNil:
+	default:
+		L.ArgError(4, "Invalid options type. Expected table")
+		return 0
+	}
+
+	// Create a message for every recipient
+	to := []string{}
+	msgs := []*gomail.Message{}
+
+	recipients.(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		to = append(to, v.String())
+		msgs = append(msgs, newMailMessage(v.String(), subject.String(), body.String()))
+	})
+
+	// Send messages
+	errs := util.SendBulkMail(msgs, rate)
+
+	// Create report
+	report := L.NewTable()
+
+	for i, err := range errs {
+		entry := L.NewTable()
+		entry.RawSetString("to", lua.LString(to[i]))
+		entry.RawSetString("success", lua.LBool(err == nil))
+
+		if err != nil {
+			entry.RawSetString("error", lua.LString(err.Error()))
+		}
+
+		report.Append(entry)
+	}
+
+	L.Push(report)
+
+	return 1
+}
+
+// sendMailMessage creates and sends a HTML email using the configured mail server
+func sendMailMessage(to, subject, body string) error {
+	return util.SendMail(newMailMessage(to, subject, body))
+}
+
+// newMailMessage creates a HTML email sent from the configured mail account
+func newMailMessage(to, subject, body string) *gomail.Message {
 	// Create new gomail object
 	m := gomail.NewMessage()
 
 	// Set from header
-	m.SetHeader("From", util.Config.Configuration.Mail.Username)
+	m.SetHeader("From", util.Config.Get().Mail.Username)
 
 	// Set to header
 	m.SetHeader("To", to)
@@ -70,19 +240,5 @@ func SendMail(L *lua.LState) int {
 	// Set body
 	m.SetBody("text/html", body)
 
-	// Create dialer
-	d := gomail.NewPlainDialer(
-		util.Config.Configuration.Mail.Server,
-		util.Config.Configuration.Mail.Port,
-		util.Config.Configuration.Mail.Username,
-		util.Config.Configuration.Mail.Password,
-	)
-
-	// Send email
-	if err := d.DialAndSend(m); err != nil {
-		L.RaiseError("Cannot send email: %v", err)
-		return 0
-	}
-
-	return 0
+	return m
 }
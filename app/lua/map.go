@@ -2,10 +2,16 @@ package lua
 
 import (
 	"fmt"
-	"github.com/raggaer/castro/app/util"
-	"github.com/yuin/gopher-lua"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	"github.com/raggaer/otmap"
+	"github.com/yuin/gopher-lua"
 )
 
 // SetMapMetaTable sets a map metatable for the given state
@@ -22,7 +28,7 @@ func SetMapMetaTable(luaState *lua.LState) {
 func EncodeMap(L *lua.LState) int {
 	// Encode map
 	mapData, err := util.EncodeMap(
-		filepath.Join(util.Config.Configuration.Datapack, "data", "world", Config.GetGlobal("mapName").String()+".otbm"),
+		filepath.Join(util.Config.Get().Datapack, "data", "world", Config.GetGlobal("mapName").String()+".otbm"),
 	)
 
 	if err != nil {
@@ -38,11 +44,566 @@ func EncodeMap(L *lua.LState) int {
 	return 0
 }
 
-// HouseList returns the server house list as a lua table
+// maxBatchPlayers most players that can be retrieved with a single batch lookup
+const maxBatchPlayers = 1000
+
+// PlayersByNames returns the players of the given name list using a single query. The list keeps
+// the order of the names and missing players are left as nil
+func PlayersByNames(L *lua.LState) int {
+	// Get names table
+	tbl := L.Get(2)
+
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid names type. Expected table")
+		return 0
+	}
+
+	// Get names
+	names := []string{}
+
+	for i := 1; i <= tbl.(*lua.LTable).Len(); i++ {
+		name := tbl.(*lua.LTable).RawGetInt(i)
+
+		if name.Type() != lua.LTString {
+			L.ArgError(1, "Invalid player name type. Expected string")
+			return 0
+		}
+
+		names = append(names, name.String())
+	}
+
+	if len(names) > maxBatchPlayers {
+		L.ArgError(1, fmt.Sprintf("Too many players. Expected at most %d", maxBatchPlayers))
+		return 0
+	}
+
+	// Get players
+	players, err := models.GetPlayersByNames(names)
+
+	if err != nil {
+		L.RaiseError("Cannot get players: %v", err)
+		return 0
+	}
+
+	L.Push(playerListToTable(L, players))
+
+	return 1
+}
+
+// PlayersByIDs returns the players of the given identifier list using a single query. The list
+// keeps the order of the identifiers and missing players are left as nil
+func PlayersByIDs(L *lua.LState) int {
+	// Get identifiers table
+	tbl := L.Get(2)
+
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid identifiers type. Expected table")
+		return 0
+	}
+
+	// Get identifiers
+	ids := []int64{}
+
+	for i := 1; i <= tbl.(*lua.LTable).Len(); i++ {
+		id := tbl.(*lua.LTable).RawGetInt(i)
+
+		if id.Type() != lua.LTNumber {
+			L.ArgError(1, "Invalid player id type. Expected number")
+			return 0
+		}
+
+		ids = append(ids, int64(id.(lua.LNumber)))
+	}
+
+	if len(ids) > maxBatchPlayers {
+		L.ArgError(1, fmt.Sprintf("Too many players. Expected at most %d", maxBatchPlayers))
+		return 0
+	}
+
+	// Get players
+	players, err := models.GetPlayersByIDs(ids)
+
+	if err != nil {
+		L.RaiseError("Cannot get players: %v", err)
+		return 0
+	}
+
+	L.Push(playerListToTable(L, players))
+
+	return 1
+}
+
+// playerListToTable converts the given player list to a table of player metatables. Nil players
+// are left as holes so the table indexes match the list
+func playerListToTable(L *lua.LState, players []*models.Player) *lua.LTable {
+	tbl := L.CreateTable(len(players), 0)
+
+	for i, p := range players {
+		if p == nil {
+			continue
+		}
+
+		tbl.RawSetInt(i+1, createPlayerMetaTable(p, L))
+	}
+
+	return tbl
+}
+
+// OnlinePlayers returns the number of online players and a list of them. The list
+// is cached for a few seconds
+func OnlinePlayers(L *lua.LState) int {
+	// Check if list is on the cache
+	list, found := util.Cache.Get("online_players")
+
+	if !found {
+
+		// Get online players from database
+		players, err := models.GetOnlinePlayers()
+
+		if err != nil {
+			L.RaiseError("Cannot get online players: %v", err)
+			return 0
+		}
+
+		// Save list to cache
+		util.Cache.Add("online_players", players, time.Second*5)
+
+		list = players
+	}
+
+	// Get player list
+	players := list.([]*models.Player)
+
+	// Result table
+	tbl := L.NewTable()
+
+	for _, p := range players {
+
+		// Create player table
+		player := L.NewTable()
+		player.RawSetString("id", lua.LNumber(p.ID))
+		player.RawSetString("name", lua.LString(p.Name))
+		player.RawSetString("level", lua.LNumber(p.Level))
+		player.RawSetString("vocation", lua.LNumber(p.Vocation))
+
+		tbl.Append(player)
+	}
+
+	// Push count and list
+	L.Push(lua.LNumber(len(players)))
+	L.Push(tbl)
+
+	return 2
+}
+
+// LatestDeaths returns the latest deaths of the server. The list is cached for a few seconds
+func LatestDeaths(L *lua.LState) int {
+	// Get list limit
+	limit := deathListLimit(L, 2)
+
+	// Check if list is on the cache
+	list, found := util.Cache.Get(
+		fmt.Sprintf("latest_deaths_%v", limit),
+	)
+
+	if !found {
+
+		// Get deaths from database
+		deaths, err := models.GetLatestDeaths(limit)
+
+		if err != nil {
+			L.RaiseError("Cannot get latest deaths: %v", err)
+			return 0
+		}
+
+		// Save list to cache
+		util.Cache.Add(fmt.Sprintf("latest_deaths_%v", limit), deaths, time.Second*30)
+
+		list = deaths
+	}
+
+	L.Push(deathListToTable(list.([]*models.Death), true))
+
+	return 1
+}
+
+// deathListLimit returns the death list limit argument. Defaults to 10 with a maximum of 100
+func deathListLimit(L *lua.LState, n int) int {
+	// Get limit
+	limit := L.OptInt(n, 10)
+
+	if limit <= 0 {
+		return 10
+	}
+
+	if limit > 100 {
+		return 100
+	}
+
+	return limit
+}
+
+// deathListToTable converts a death list to a lua table
+func deathListToTable(deaths []*models.Death, victim bool) *lua.LTable {
+	// Result table
+	tbl := &lua.LTable{}
+
+	for _, d := range deaths {
+
+		// Create death table
+		death := &lua.LTable{}
+		death.RawSetString("time", lua.LNumber(d.Time))
+		death.RawSetString("level", lua.LNumber(d.Level))
+		death.RawSetString("killedBy", lua.LString(d.KilledBy))
+		death.RawSetString("isPlayer", lua.LBool(d.IsPlayer))
+
+		if victim {
+			death.RawSetString("victim", lua.LString(d.Victim))
+		}
+
+		tbl.Append(death)
+	}
+
+	return tbl
+}
+
+// highscoreColumns players table column of every highscore type
+var highscoreColumns = map[string]string{
+	"level":     "level",
+	"magic":     "maglevel",
+	"fist":      "skill_fist",
+	"club":      "skill_club",
+	"sword":     "skill_sword",
+	"axe":       "skill_axe",
+	"distance":  "skill_dist",
+	"shielding": "skill_shielding",
+	"fishing":   "skill_fishing",
+	"balance":   "balance",
+}
+
+// playerStatsCacheTime time the vocation and town player counts are cached
+const playerStatsCacheTime = time.Minute
+
+// publicMaxGroupID returns the highest group shown on public player lists (Highscores.MaxGroupID)
+func publicMaxGroupID() int {
+	if maxGroup := util.Config.Get().Highscores.MaxGroupID; maxGroup > 0 {
+		return maxGroup
+	}
+
+	return 3
+}
+
+// VocationStats returns the number of players of each vocation as a vocation name to count
+// table. Players with a group greater than Highscores.MaxGroupID are left out
+func VocationStats(L *lua.LState) int {
+	// Get player counts
+	list, err := cachedPlayerStats("vocation_stats", models.CountPlayersByVocation)
+
+	if err != nil {
+		L.RaiseError("Cannot get vocation stats: %v", err)
+		return 0
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	for _, c := range list {
+
+		// Get vocation name
+		name := fmt.Sprintf("%d", c.Value)
+
+		if voc := util.ServerVocationList.VocationByID(int(c.Value)); voc != nil {
+			name = voc.Name
+		}
+
+		addPlayerStat(tbl, name, c.Count)
+	}
+
+	L.Push(tbl)
+
+	return 1
+}
+
+// TownStats returns the number of players of each town as a town name to count table.
+// Players with a group greater than Highscores.MaxGroupID are left out
+func TownStats(L *lua.LState) int {
+	// Get player counts
+	list, err := cachedPlayerStats("town_stats", models.CountPlayersByTown)
+
+	if err != nil {
+		L.RaiseError("Cannot get town stats: %v", err)
+		return 0
+	}
+
+	// Get town names
+	towns := map[int64]string{}
+
+	for _, town := range util.OTBMap.Map.Towns {
+		towns[int64(town.ID)] = town.Name
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	for _, c := range list {
+
+		// Get town name
+		name, ok := towns[c.Value]
+
+		if !ok {
+			name = fmt.Sprintf("%d", c.Value)
+		}
+
+		addPlayerStat(tbl, name, c.Count)
+	}
+
+	L.Push(tbl)
+
+	return 1
+}
+
+// cachedPlayerStats returns the player counts saved under the given cache key, running
+// the given count function when they are not cached
+func cachedPlayerStats(key string, count func(int) ([]models.PlayerCount, error)) ([]models.PlayerCount, error) {
+	if list, found := util.Cache.Get(key); found {
+		return list.([]models.PlayerCount), nil
+	}
+
+	list, err := count(publicMaxGroupID())
+
+	if err != nil {
+		return nil, err
+	}
+
+	util.Cache.Set(key, list, playerStatsCacheTime)
+
+	return list, nil
+}
+
+// addPlayerStat adds the given count to the name field of the stats table
+func addPlayerStat(tbl *lua.LTable, name string, count int64) {
+	if v, ok := tbl.RawGetString(name).(lua.LNumber); ok {
+		count += int64(v)
+	}
+
+	tbl.RawSetString(name, lua.LNumber(count))
+}
+
+// Highscores returns a page of the server highscores for the given type. Players with a
+// group greater than Highscores.MaxGroupID are left out
+func Highscores(L *lua.LState) int {
+	// Get highscore type
+	t := L.OptString(2, "level")
+
+	// Get highscore column
+	column, ok := highscoreColumns[t]
+
+	if !ok {
+		L.ArgError(1, "Invalid highscore type")
+		return 0
+	}
+
+	// Get page
+	page := L.OptInt(3, 1)
+
+	if page < 1 {
+		L.ArgError(2, "Invalid page. Expected number greater than zero")
+		return 0
+	}
+
+	// Get items per page
+	perPage := L.OptInt(4, 10)
+
+	if perPage < 1 || perPage > 1000 {
+		L.ArgError(3, "Invalid items per page. Expected number between 1 and 1000")
+		return 0
+	}
+
+	// Query arguments
+	args := []interface{}{publicMaxGroupID()}
+
+	// Base query
+	base := "SELECT id, name, level, vocation, " + column + " AS value FROM players WHERE group_id <= ?"
+
+	// Optional vocation filter
+	if v := L.Get(5); v != lua.LNil {
+		base += " AND vocation = ?"
+		args = append(args, L.CheckInt(5))
+	}
+
+	// Run pagination queries
+	tbl, err := paginateQuery(
+		L,
+		database.DB,
+		fmt.Sprintf("%v ORDER BY value DESC, experience DESC, name LIMIT %d OFFSET %d", base, perPage, (page-1)*perPage),
+		"SELECT COUNT(*) FROM ("+base+") AS castro_paginate",
+		args,
+		page,
+		perPage,
+	)
+
+	if err != nil {
+		L.RaiseError("Cannot get highscores: %v", err)
+		return 0
+	}
+
+	// Set rank numbers
+	if rows, ok := tbl.RawGetString("rows").(*lua.LTable); ok {
+		rows.ForEach(func(i, row lua.LValue) {
+			if r, ok := row.(*lua.LTable); ok {
+				r.RawSetString("rank", lua.LNumber((page-1)*perPage+int(i.(lua.LNumber))))
+			}
+		})
+	}
+
+	// Push pagination table
+	L.Push(tbl)
+
+	return 1
+}
+
+// SearchPlayers returns a page of players matching the given filters table. Supported filters
+// are name (prefix match, or substring match if contains is true), minLevel, maxLevel, vocation
+// and online. Omitted filters are ignored
+func SearchPlayers(L *lua.LState) int {
+	// Get filters table
+	filters := L.Get(2)
+
+	if filters.Type() != lua.LTNil && filters.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid filters type. Expected table")
+		return 0
+	}
+
+	// Get page
+	page := L.OptInt(3, 1)
+
+	if page < 1 {
+		L.ArgError(2, "Invalid page. Expected number greater than zero")
+		return 0
+	}
+
+	// Get items per page
+	perPage := L.OptInt(4, 10)
+
+	if perPage < 1 || perPage > 1000 {
+		L.ArgError(3, "Invalid items per page. Expected number between 1 and 1000")
+		return 0
+	}
+
+	// Query conditions and arguments
+	conditions := []string{}
+	args := []interface{}{}
+
+	if tbl, ok := filters.(*lua.LTable); ok {
+
+		// Name filter
+		switch name := L.GetField(tbl, "name"); name.Type() {
+		case lua.LTNil:
+		case lua.LTString:
+			pattern := escapeLikePattern(name.String()) + "%"
+
+			if lua.LVAsBool(L.GetField(tbl, "contains")) {
+				pattern = "%" + pattern
+			}
+
+			conditions = append(conditions, "p.name LIKE ?")
+			args = append(args, pattern)
+		default:
+			L.ArgError(1, "Invalid name filter type. Expected string")
+			return 0
+		}
+
+		// Number filters
+		for _, filter := range []struct {
+			field     string
+			condition string
+		}{
+			{"minLevel", "p.level >= ?"},
+			{"maxLevel", "p.level <= ?"},
+			{"vocation", "p.vocation = ?"},
+		} {
+			switch v := L.GetField(tbl, filter.field); v.Type() {
+			case lua.LTNil:
+			case lua.LTNumber:
+				conditions = append(conditions, filter.condition)
+				args = append(args, int64(v.(lua.LNumber)))
+			default:
+				L.ArgError(1, "Invalid "+filter.field+" filter type. Expected number")
+				return 0
+			}
+		}
+
+		// Online filter
+		switch online := L.GetField(tbl, "online"); online.Type() {
+		case lua.LTNil:
+		case lua.LTBool:
+			if lua.LVAsBool(online) {
+				conditions = append(conditions, "po.player_id IS NOT NULL")
+			} else {
+				conditions = append(conditions, "po.player_id IS NULL")
+			}
+		default:
+			L.ArgError(1, "Invalid online filter type. Expected boolean")
+			return 0
+		}
+	}
+
+	// Base query
+	base := "SELECT p.id, p.name, p.level, p.vocation, p.account_id, po.player_id IS NOT NULL AS online FROM players p LEFT JOIN players_online po ON po.player_id = p.id"
+
+	if len(conditions) > 0 {
+		base += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Run pagination queries
+	tbl, err := paginateQuery(
+		L,
+		database.DB,
+		fmt.Sprintf("%v ORDER BY p.name LIMIT %d OFFSET %d", base, perPage, (page-1)*perPage),
+		"SELECT COUNT(*) FROM ("+base+") AS castro_paginate",
+		args,
+		page,
+		perPage,
+	)
+
+	if err != nil {
+		L.RaiseError("Cannot search players: %v", err)
+		return 0
+	}
+
+	// Convert online values to booleans
+	if rows, ok := tbl.RawGetString("rows").(*lua.LTable); ok {
+		rows.ForEach(func(_, row lua.LValue) {
+			if r, ok := row.(*lua.LTable); ok {
+				r.RawSetString("online", lua.LBool(lua.LVAsString(r.RawGetString("online")) == "1"))
+			}
+		})
+	}
+
+	// Push pagination table
+	L.Push(tbl)
+
+	return 1
+}
+
+// HouseList returns the server house list as a lua table. An options table with the sort
+// (id, name, size, rent or town), desc, limit and offset fields can be given after the
+// town id, in that case the total number of matching houses is also returned
 func HouseList(L *lua.LState) int {
 	// Check if user wants specific town
 	town := uint32(L.ToInt(2))
 
+	// Get list options
+	opts, ok := L.Get(3).(*lua.LTable)
+
+	if !ok {
+		opts, ok = L.Get(2).(*lua.LTable)
+	}
+
+	if ok {
+		return sortedHouseList(L, town, opts)
+	}
+
 	// Check if list is on the cache
 	list, found := util.Cache.Get(
 		fmt.Sprintf("house_list_%v", town),
@@ -85,7 +646,7 @@ func HouseList(L *lua.LState) int {
 	util.Cache.Add(
 		fmt.Sprintf("house_list_%v", town),
 		tbl,
-		util.Config.Configuration.Cache.Default.Duration,
+		util.Config.Get().Cache.Default.Duration,
 	)
 
 	// Push table to stack
@@ -94,8 +655,14 @@ func HouseList(L *lua.LState) int {
 	return 1
 }
 
-// TownList returns the server town list as a lua table
+// TownList returns the server town list as a lua table. An options table with the sort
+// (id or name), desc, limit and offset fields can be given, in that case the total number
+// of towns is also returned
 func TownList(L *lua.LState) int {
+	// Get list options
+	if opts, ok := L.Get(2).(*lua.LTable); ok {
+		return sortedTownList(L, opts)
+	}
 
 	// Check if the list is on the cache
 	list, found := util.Cache.Get("town_list")
@@ -122,7 +689,7 @@ func TownList(L *lua.LState) int {
 	}
 
 	// Save list to cache
-	util.Cache.Add("town_list", result, util.Config.Configuration.Cache.Default.Duration)
+	util.Cache.Add("town_list", result, util.Config.Get().Cache.Default.Duration)
 
 	// Push result
 	L.Push(result)
@@ -167,7 +734,7 @@ func GetTownByName(L *lua.LState) int {
 			util.Cache.Add(
 				fmt.Sprintf("town_%v", name.String()),
 				twn,
-				util.Config.Configuration.Cache.Default.Duration,
+				util.Config.Get().Cache.Default.Duration,
 			)
 
 			// Convert town to lua table and push
@@ -180,6 +747,45 @@ func GetTownByName(L *lua.LState) int {
 	return 0
 }
 
+// GetTemplePosition returns the temple position of the given town as a {x, y, z} table
+func GetTemplePosition(L *lua.LState) int {
+	// Get town ID
+	id := L.Get(2)
+
+	// Check for valid ID type
+	if id.Type() != lua.LTNumber {
+
+		L.ArgError(1, "Invalid ID format. Expected number")
+		return 0
+	}
+
+	// Convert town id to uint32
+	townid := uint32(L.ToInt(2))
+
+	// Get town
+	for _, town := range util.OTBMap.Map.Towns {
+
+		// If its the town we are looking for
+		if town.ID == townid {
+
+			// Create position table
+			pos := L.NewTable()
+			pos.RawSetString("x", lua.LNumber(town.TemplePosition.X))
+			pos.RawSetString("y", lua.LNumber(town.TemplePosition.Y))
+			pos.RawSetString("z", lua.LNumber(town.TemplePosition.Z))
+
+			L.Push(pos)
+
+			return 1
+		}
+	}
+
+	// Push nil for unknown towns
+	L.Push(lua.LNil)
+
+	return 1
+}
+
 // GetTownByID grabs a town by the given ID
 func GetTownByID(L *lua.LState) int {
 	// Get town ID
@@ -221,7 +827,7 @@ func GetTownByID(L *lua.LState) int {
 			util.Cache.Add(
 				fmt.Sprintf("town_%v", town.Name),
 				twn,
-				util.Config.Configuration.Cache.Default.Duration,
+				util.Config.Get().Cache.Default.Duration,
 			)
 
 			// Convert town to lua table and push
@@ -233,3 +839,119 @@ func GetTownByID(L *lua.LState) int {
 
 	return 0
 }
+
+// sortedHouseList pushes a sorted page of the house list and the total number of houses
+func sortedHouseList(L *lua.LState, town uint32, opts *lua.LTable) int {
+	// Get list options
+	field, desc, limit, offset := listOptions(L, opts)
+
+	// Get sorted houses
+	houses, err := util.ServerHouseList.Sorted(town, field, desc)
+
+	if err != nil {
+		L.ArgError(2, err.Error())
+		return 0
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	start, end := pageRange(len(houses), limit, offset)
+
+	for _, house := range houses[start:end] {
+		tbl.Append(StructToTable(house))
+	}
+
+	// Push page and total
+	L.Push(tbl)
+	L.Push(lua.LNumber(len(houses)))
+
+	return 2
+}
+
+// sortedTownList pushes a sorted page of the town list and the total number of towns
+func sortedTownList(L *lua.LState, opts *lua.LTable) int {
+	// Get list options
+	field, desc, limit, offset := listOptions(L, opts)
+
+	// Copy town list
+	towns := make([]otmap.Town, len(util.OTBMap.Map.Towns))
+	copy(towns, util.OTBMap.Map.Towns)
+
+	// Sort towns
+	switch field {
+	case "", "id":
+		sort.Slice(towns, func(i, j int) bool {
+			return (towns[i].ID < towns[j].ID) != desc
+		})
+	case "name":
+		sort.Slice(towns, func(i, j int) bool {
+			if towns[i].Name == towns[j].Name {
+				return towns[i].ID < towns[j].ID
+			}
+
+			return (towns[i].Name < towns[j].Name) != desc
+		})
+	default:
+		L.ArgError(1, "Invalid town sort field. Expected id or name")
+		return 0
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	start, end := pageRange(len(towns), limit, offset)
+
+	for i := start; i < end; i++ {
+		tbl.Append(StructToTable(&towns[i]))
+	}
+
+	// Push page and total
+	L.Push(tbl)
+	L.Push(lua.LNumber(len(towns)))
+
+	return 2
+}
+
+// listOptions reads the sort, desc, limit and offset fields of a list options table
+func listOptions(L *lua.LState, opts *lua.LTable) (string, bool, int, int) {
+	field := ""
+
+	if v := opts.RawGetString("sort"); v.Type() == lua.LTString {
+		field = v.String()
+	}
+
+	desc := lua.LVAsBool(opts.RawGetString("desc"))
+
+	limit := 0
+
+	if v, ok := opts.RawGetString("limit").(lua.LNumber); ok {
+		limit = int(v)
+	}
+
+	offset := 0
+
+	if v, ok := opts.RawGetString("offset").(lua.LNumber); ok {
+		offset = int(v)
+	}
+
+	if limit < 0 || offset < 0 {
+		L.RaiseError("Invalid list options. Limit and offset cannot be negative")
+	}
+
+	return field, desc, limit, offset
+}
+
+// pageRange returns the slice bounds of a list of n elements for the given limit and
+// offset. A zero limit includes every element after the offset
+func pageRange(n, limit, offset int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+
+	if limit == 0 || offset+limit > n {
+		return offset, n
+	}
+
+	return offset, offset + limit
+}
@@ -0,0 +1,34 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetMarkdownMetaTable sets the markdown metatable for the given state
+func SetMarkdownMetaTable(luaState *lua.LState) {
+	// Create and set the markdown metatable
+	markdownMetaTable := luaState.NewTypeMetatable(MarkdownMetaTableName)
+	luaState.SetGlobal(MarkdownMetaTableName, markdownMetaTable)
+
+	// Set all markdown metatable functions
+	luaState.SetFuncs(markdownMetaTable, markdownMethods)
+}
+
+// RenderMarkdown converts the given markdown string to sanitized HTML
+func RenderMarkdown(L *lua.LState) int {
+	// Get markdown input
+	str := L.Get(2)
+
+	// Check for valid string type
+	if str.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid markdown format. Expected string")
+		return 0
+	}
+
+	// Render and push HTML to stack
+	L.Push(lua.LString(util.RenderMarkdown(str.String())))
+
+	return 1
+}
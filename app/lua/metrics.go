@@ -0,0 +1,85 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetMetricsMetaTable sets the metrics metatable of the given state
+func SetMetricsMetaTable(luaState *lua.LState) {
+	// Create and set the metrics metatable
+	metricsMetaTable := luaState.NewTypeMetatable(MetricsMetaTableName)
+	luaState.SetGlobal(MetricsMetaTableName, metricsMetaTable)
+
+	// Set all metrics metatable functions
+	luaState.SetFuncs(metricsMetaTable, metricsMethods)
+}
+
+// IncrementMetric increments the given counter by one or by the given amount
+func IncrementMetric(L *lua.LState) int {
+	// Get metric name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid metric name. Expected string")
+		return 0
+	}
+
+	// Get increment amount
+	delta := float64(1)
+
+	switch v := L.Get(3); v.Type() {
+	case lua.LTNumber:
+		delta = float64(v.(lua.LNumber))
+
+		if delta < 0 {
+			L.ArgError(2, "Counters can only be incremented")
+			return 0
+		}
+	case lua.LTNil:
+	default:
+		L.ArgError(2, "Invalid increment amount. Expected number")
+		return 0
+	}
+
+	// Increment counter
+	if err := util.Metrics.Increment(util.MetricName(name.String()), delta); err != nil {
+		L.RaiseError("Cannot increment metric: %v", err)
+		return 0
+	}
+
+	return 0
+}
+
+// ObserveMetric records a value on the given histogram
+func ObserveMetric(L *lua.LState) int {
+	// Get metric name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid metric name. Expected string")
+		return 0
+	}
+
+	// Get observed value
+	value := L.Get(3)
+
+	// Check for valid value type
+	if value.Type() != lua.LTNumber {
+
+		L.ArgError(2, "Invalid metric value. Expected number")
+		return 0
+	}
+
+	// Record value
+	if err := util.Metrics.Observe(util.MetricName(name.String()), float64(value.(lua.LNumber))); err != nil {
+		L.RaiseError("Cannot observe metric: %v", err)
+		return 0
+	}
+
+	return 0
+}
@@ -1,6 +1,9 @@
 package lua
 
 import (
+	"fmt"
+	"io/ioutil"
+
 	"github.com/raggaer/castro/app/util"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -37,3 +40,117 @@ func GenerateOutfit(L *lua.LState) int {
 
 	return 1
 }
+
+// RenderOutfit renders the outfit described by the given table. The table uses the lookType, addons,
+// head, body, legs and feet fields, colors can also be given as a colors table. When the path
+// field is set the PNG image is saved there, otherwise a goimage is returned
+func RenderOutfit(L *lua.LState) int {
+	// Get options table
+	opts := L.Get(2)
+
+	if opts.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid options type. Expected table")
+		return 0
+	}
+
+	tbl := opts.(*lua.LTable)
+
+	// Get outfit values
+	look, err := outfitLookFromTable(tbl)
+
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	// Render outfit image
+	buff, err := util.RenderOutfit(look)
+
+	if err != nil {
+		return pushError(L, "Cannot render outfit", err)
+	}
+
+	// Save image to the given path
+	if path := tbl.RawGetString("path"); path != lua.LNil {
+		if path.Type() != lua.LTString {
+			L.ArgError(1, "Invalid path type. Expected string")
+			return 0
+		}
+
+		if err := ioutil.WriteFile(path.String(), buff, 0666); err != nil {
+			return pushError(L, "Cannot save outfit", err)
+		}
+
+		L.Push(lua.LTrue)
+
+		return 1
+	}
+
+	// Create goimage from the outfit
+	img, err := util.NewImageFromBytes(buff)
+
+	if err != nil {
+		return pushError(L, "Cannot decode outfit", err)
+	}
+
+	L.Push(createGoImageMetaTable(L, img))
+
+	return 1
+}
+
+// outfitLookFromTable converts the given render options table to outfit values
+func outfitLookFromTable(tbl *lua.LTable) (util.OutfitLook, error) {
+	look := util.OutfitLook{}
+
+	// Colors can be named or positional inside the colors table
+	colors, _ := tbl.RawGetString("colors").(*lua.LTable)
+
+	field := func(name string, i int) lua.LValue {
+		if v := tbl.RawGetString(name); v != lua.LNil {
+			return v
+		}
+
+		if colors == nil {
+			return lua.LNil
+		}
+
+		if v := colors.RawGetString(name); v != lua.LNil {
+			return v
+		}
+
+		return colors.RawGetInt(i)
+	}
+
+	values := []struct {
+		name  string
+		value lua.LValue
+		dst   *int
+	}{
+		{"lookType", tbl.RawGetString("lookType"), &look.LookType},
+		{"addons", tbl.RawGetString("addons"), &look.Addons},
+		{"head", field("head", 1), &look.Head},
+		{"body", field("body", 2), &look.Body},
+		{"legs", field("legs", 3), &look.Legs},
+		{"feet", field("feet", 4), &look.Feet},
+	}
+
+	for _, v := range values {
+		if v.value == lua.LNil {
+			continue
+		}
+
+		n, ok := v.value.(lua.LNumber)
+
+		if !ok {
+			return look, fmt.Errorf("Invalid %s type. Expected number", v.name)
+		}
+
+		*v.dst = int(n)
+	}
+
+	if err := look.Validate(); err != nil {
+		return look, err
+	}
+
+	return look, nil
+}
@@ -0,0 +1,256 @@
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// pageCacheFieldName the http metatable field holding the page being cached
+const pageCacheFieldName = "__pageCache"
+
+// cachedPage rendered page saved on the cache. The nonce and csrf token used when rendering
+// are replaced with the values of the request the page is served to
+type cachedPage struct {
+	status      int
+	contentType string
+	body        []byte
+	nonce       string
+	csrfToken   string
+}
+
+// pageCacheWriter response writer that keeps a copy of the written response
+type pageCacheWriter struct {
+	http.ResponseWriter
+	key    string
+	ttl    time.Duration
+	status int
+	buff   bytes.Buffer
+}
+
+// WriteHeader saves the status code and sends it to the client
+func (w *pageCacheWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write saves a copy of the data and sends it to the client
+func (w *pageCacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.buff.Write(b)
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack takes over the connection. Hijacked responses are not cached
+func (w *pageCacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+
+	return hijackResponseWriter(w.ResponseWriter)
+}
+
+// Flush sends the buffered data to the client
+func (w *pageCacheWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	flushResponseWriter(w.ResponseWriter)
+}
+
+// CachePage serves the current page from the cache if possible returning true, in that case the
+// handler should return. Otherwise the response written by the handler is cached for the given
+// duration. The vary option lists the request values the cache depends on (login, account, admin
+// and locale), by default pages are cached per account
+func CachePage(L *lua.LState) int {
+	// Get HTTP request and HTTP response writer
+	req, w := getRequestAndResponseWriter(L)
+
+	// Get cache duration
+	ttl, ok := durationFromValue(L.Get(2))
+
+	if !ok {
+		L.ArgError(1, "Invalid cache duration. Expected number of seconds or duration string")
+		return 0
+	}
+
+	// Get vary keys
+	vary := []string{"account"}
+
+	switch opts := L.Get(3).(type) {
+	case *lua.LTable:
+		if list, ok := L.GetField(opts, "vary").(*lua.LTable); ok {
+			vary = []string{}
+
+			list.ForEach(func(_, v lua.LValue) {
+				vary = append(vary, v.String())
+			})
+		}
+	case *lua.LNilType:
+	default:
+		L.ArgError(2, "Invalid options type. Expected table")
+		return 0
+	}
+
+	// Get session
+	session := getSessionData(L)
+
+	// Only cache safe requests. Pages showing flash messages are never cached
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || len(getSessionFlashes(session)) > 0 || util.Config.Get().IsDev() {
+		L.Push(lua.LFalse)
+		return 1
+	}
+
+	// Build cache key
+	key, err := pageCacheKey(req, session, vary)
+
+	if err != nil {
+		L.ArgError(2, err.Error())
+		return 0
+	}
+
+	// Serve cached page
+	if v, found := util.Cache.Get(key); found {
+		if page, ok := v.(*cachedPage); ok {
+			writeCachedPage(req, w, page)
+
+			L.Push(lua.LTrue)
+			return 1
+		}
+	}
+
+	// Save the response written by the handler
+	cw := &pageCacheWriter{
+		ResponseWriter: w,
+		key:            key,
+		ttl:            ttl,
+	}
+
+	httpMetaTable := L.GetTypeMetatable(HTTPMetaTableName)
+
+	httpW := L.NewUserData()
+	httpW.Value = cw
+	L.SetField(httpMetaTable, HTTPResponseWriterName, httpW)
+
+	pageData := L.NewUserData()
+	pageData.Value = cw
+	L.SetField(httpMetaTable, pageCacheFieldName, pageData)
+
+	L.Push(lua.LFalse)
+
+	return 1
+}
+
+// StorePageCache saves the response of the page cached with http.cachePage. Only successful
+// uncompressed responses are saved
+func StorePageCache(L *lua.LState) {
+	// Get page writer
+	data, ok := L.GetField(L.GetTypeMetatable(HTTPMetaTableName), pageCacheFieldName).(*lua.LUserData)
+
+	if !ok {
+		return
+	}
+
+	cw, ok := data.Value.(*pageCacheWriter)
+
+	if !ok || cw.status != http.StatusOK || cw.Header().Get("Content-Encoding") != "" {
+		return
+	}
+
+	// Get request
+	req, _ := getRequestAndResponseWriter(L)
+
+	nonce, _ := req.Context().Value("nonce").(string)
+	token, _ := req.Context().Value("csrf-token").(*models.CsrfToken)
+
+	page := &cachedPage{
+		status:      cw.status,
+		contentType: cw.Header().Get("Content-Type"),
+		body:        cw.buff.Bytes(),
+		nonce:       nonce,
+	}
+
+	if token != nil {
+		page.csrfToken = token.Token
+	}
+
+	util.Cache.Set(cw.key, page, cw.ttl)
+}
+
+// pageCacheKey returns the cache key of the given request using the given vary keys
+func pageCacheKey(req *http.Request, session map[string]interface{}, vary []string) (string, error) {
+	parts := []string{}
+
+	for _, v := range vary {
+		switch v {
+		case "login":
+			logged, _ := session["logged"].(bool)
+			parts = append(parts, "login="+boolToString(logged))
+		case "account":
+			account, _ := session["loggedAccount"].(string)
+			parts = append(parts, "account="+account)
+		case "admin":
+			admin, _ := session["admin"].(bool)
+			parts = append(parts, "admin="+boolToString(admin))
+		case "locale":
+			language, _ := req.Context().Value("language").([]string)
+			parts = append(parts, "locale="+util.LanguageFiles.Resolve(append(localesFromRequest(req, session), language...)))
+		default:
+			return "", errors.New("Invalid vary key " + v + ". Expected login, account, admin or locale")
+		}
+	}
+
+	sort.Strings(parts)
+
+	return "page:" + req.Host + req.URL.RequestURI() + "|" + strings.Join(parts, "|"), nil
+}
+
+// writeCachedPage writes the cached page replacing the nonce and csrf token
+func writeCachedPage(req *http.Request, w http.ResponseWriter, page *cachedPage) {
+	body := replaceRequestTokens(req, page.body, page.nonce, page.csrfToken)
+
+	if page.contentType != "" {
+		w.Header().Set("Content-Type", page.contentType)
+	}
+
+	w.WriteHeader(page.status)
+	w.Write(body)
+}
+
+// replaceRequestTokens replaces the nonce and csrf token a cached response was rendered with
+// by the values of the given request
+func replaceRequestTokens(req *http.Request, body []byte, cachedNonce, cachedToken string) []byte {
+	if nonce, ok := req.Context().Value("nonce").(string); ok && cachedNonce != "" {
+		body = bytes.Replace(body, []byte(cachedNonce), []byte(nonce), -1)
+	}
+
+	if token, ok := req.Context().Value("csrf-token").(*models.CsrfToken); ok && cachedToken != "" {
+		body = bytes.Replace(body, []byte(cachedToken), []byte(token.Token), -1)
+	}
+
+	return body
+}
+
+// boolToString returns 1 for true and 0 for false
+func boolToString(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
@@ -0,0 +1,173 @@
+package lua
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+const (
+	// paygolPaymentURL PayGol checkout page
+	paygolPaymentURL = "https://www.paygol.com/pay"
+
+	// paygolStatusURL PayGol payment status endpoint
+	paygolStatusURL = "https://www.paygol.com/api/v2/payments/status"
+)
+
+// paygolClient http client used for the PayGol API requests
+var paygolClient = &http.Client{
+	Timeout: time.Second * 10,
+}
+
+// SetPayGolMetaTable sets the paygol metatable of the given state
+func SetPayGolMetaTable(luaState *lua.LState) {
+	// Create and set the paygol metatable
+	paygolMetaTable := luaState.NewTypeMetatable(PayGolMetaTableName)
+	luaState.SetGlobal(PayGolMetaTableName, paygolMetaTable)
+
+	// Set all paygol metatable functions
+	luaState.SetFuncs(paygolMetaTable, paygolMethods)
+}
+
+// CreatePayGolPayment creates a PayGol payment returning the payment URL
+func CreatePayGolPayment(L *lua.LState) int {
+	// Get payment name
+	name := L.Get(2)
+
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid payment name type. Expected string")
+		return 0
+	}
+
+	// Get payment price
+	price := L.Get(3)
+
+	if price.Type() != lua.LTNumber {
+		L.ArgError(2, "Invalid payment price type. Expected number")
+		return 0
+	}
+
+	// Get PayGol configuration
+	cfg := util.Config.Get().PayGol
+
+	// Create payment values
+	values := url.Values{}
+	values.Set("pg_serviceid", strconv.Itoa(cfg.Service))
+	values.Set("pg_currency", cfg.Currency)
+	values.Set("pg_language", cfg.Language)
+	values.Set("pg_name", name.String())
+	values.Set("pg_price", price.String())
+	values.Set("pg_custom", L.OptString(4, ""))
+	values.Set("pg_cancel_url", L.OptString(5, ""))
+	values.Set("pg_return_url", L.OptString(6, ""))
+
+	// Data table
+	tbl := L.NewTable()
+
+	// Set payment fields
+	tbl.RawSetString("Custom", lua.LString(values.Get("pg_custom")))
+	tbl.RawSetString("Price", price)
+	tbl.RawSetString("Name", name)
+	tbl.RawSetString("Link", lua.LString(paygolPaymentURL+"?"+values.Encode()))
+
+	// Push data table
+	L.Push(tbl)
+
+	return 1
+}
+
+// GetPayGolPayment gets the status of a PayGol payment
+func GetPayGolPayment(L *lua.LState) int {
+	// Get transaction identifier
+	id := L.Get(2)
+
+	// Check valid identifier
+	if id.Type() != lua.LTString && id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid transaction identifier type. Expected string")
+		return 0
+	}
+
+	// Get PayGol configuration
+	cfg := util.Config.Get().PayGol
+
+	// Create request values
+	values := url.Values{}
+	values.Set("pg_serviceid", strconv.Itoa(cfg.Service))
+	values.Set("pg_transaction_id", id.String())
+	values.Set("pg_timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+
+	// Create request
+	req, err := http.NewRequest(http.MethodGet, paygolStatusURL+"?"+values.Encode(), nil)
+
+	if err != nil {
+		L.RaiseError("Cannot create paygol request: %v", err)
+		return 0
+	}
+
+	// Sign request with the service secret
+	mac := hmac.New(sha256.New, []byte(cfg.Secret))
+	mac.Write([]byte(values.Encode()))
+	req.Header.Set("X-PG-SIG", hex.EncodeToString(mac.Sum(nil)))
+
+	// Execute request
+	resp, err := paygolClient.Do(req)
+
+	if err != nil {
+		L.RaiseError("Cannot get paygol payment information: %v", err)
+		return 0
+	}
+
+	// Close response body
+	defer resp.Body.Close()
+
+	// Read response body
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		L.RaiseError("Cannot read paygol response: %v", err)
+		return 0
+	}
+
+	// Decode response
+	result := map[string]interface{}{}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		L.RaiseError("Cannot decode paygol response: %v", err)
+		return 0
+	}
+
+	// Surface provider errors
+	if resp.StatusCode != http.StatusOK || result["error"] != nil {
+		L.RaiseError("Cannot get paygol payment information: %v", paygolError(resp.StatusCode, result))
+		return 0
+	}
+
+	// Push result table
+	L.Push(MapToTable(result))
+
+	return 1
+}
+
+// paygolError returns the error message of a PayGol response
+func paygolError(status int, result map[string]interface{}) string {
+	switch e := result["error"].(type) {
+	case string:
+		return e
+	case map[string]interface{}:
+		if msg, ok := e["message"].(string); ok {
+			return msg
+		}
+	}
+
+	return fmt.Sprintf("unexpected status code %v", status)
+}
@@ -17,8 +17,8 @@ func CreatePaypalClient(sandbox bool) {
 	if !sandbox {
 
 		client = gopaypal.NewClient(
-			util.Config.Configuration.PayPal.PublicKey,
-			util.Config.Configuration.PayPal.SecretKey,
+			util.Config.Get().PayPal.PublicKey,
+			util.Config.Get().PayPal.SecretKey,
 			gopaypal.LiveURL,
 		)
 
@@ -27,8 +27,8 @@ func CreatePaypalClient(sandbox bool) {
 
 	// Create application client for sandbox settings
 	client = gopaypal.NewClient(
-		util.Config.Configuration.PayPal.PublicKey,
-		util.Config.Configuration.PayPal.SecretKey,
+		util.Config.Get().PayPal.PublicKey,
+		util.Config.Get().PayPal.SecretKey,
 		gopaypal.SandBoxURL,
 	)
 }
@@ -58,7 +58,7 @@ func CreatePaypalPayment(L *lua.LState) int {
 			{
 				Amount: gopaypal.Amount{
 					Total:    strconv.Itoa(price),
-					Currency: util.Config.Configuration.PayPal.Currency,
+					Currency: util.Config.Get().PayPal.Currency,
 					Details: gopaypal.Details{
 						SubTotal: strconv.Itoa(price),
 					},
@@ -70,7 +70,7 @@ func CreatePaypalPayment(L *lua.LState) int {
 						{
 							Name:     L.ToString(2),
 							Price:    strconv.Itoa(price),
-							Currency: util.Config.Configuration.PayPal.Currency,
+							Currency: util.Config.Get().PayPal.Currency,
 							Quantity: 1,
 						},
 					},
@@ -87,8 +87,7 @@ func CreatePaypalPayment(L *lua.LState) int {
 	info, err := client.CreatePayment(payment)
 
 	if err != nil {
-		L.RaiseError("Cannot create paypal payment: %v", err)
-		return 0
+		return raiseError(L, "Cannot create paypal payment", err)
 	}
 
 	// Data table
@@ -138,12 +137,11 @@ func GetPaypalPayment(L *lua.LState) int {
 	if err != nil {
 
 		// Log if development mode
-		if util.Config.Configuration.IsDev() {
+		if util.Config.Get().IsDev() {
 			util.Logger.Logger.Errorf("Cannot get paypal payment information: %v", err)
 		}
 
-		L.Push(lua.LNil)
-		return 1
+		return pushError(L, "Cannot get paypal payment information", err)
 	}
 
 	// Validate approved payment
@@ -159,8 +157,7 @@ func GetPaypalPayment(L *lua.LState) int {
 	price, err := strconv.ParseFloat(info.Transactions[0].Amount.Total, 10)
 
 	if err != nil {
-		L.RaiseError("Cannot get payment price: %v", err)
-		return 0
+		return raiseError(L, "Cannot get payment price", err)
 	}
 
 	// Set payment fields
@@ -202,12 +199,13 @@ func ExecutePaypalPayment(L *lua.LState) int {
 
 	if err != nil {
 		// Log if development mode
-		if util.Config.Configuration.IsDev() {
+		if util.Config.Get().IsDev() {
 			util.Logger.Logger.Errorf("Cannot execute paypal payment: %v", err)
 		}
 
 		L.Push(lua.LBool(false))
-		return 1
+		L.Push(errorToTable(L, "Cannot execute paypal payment", err))
+		return 2
 	}
 
 	L.Push(lua.LBool(true))
@@ -11,6 +11,9 @@ import (
 	"github.com/yuin/gopher-lua"
 )
 
+// maxPlayerLevel highest level that can be set from a page
+const maxPlayerLevel = 100000
+
 // PlayerConstructor returns a new player metatable for the given ID or name
 func PlayerConstructor(L *lua.LState) int {
 	// Retrieve player
@@ -112,6 +115,23 @@ func GetPlayerGuild(L *lua.LState) int {
 	return 1
 }
 
+// GetPlayerDeaths gets the latest deaths of the player
+func GetPlayerDeaths(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get deaths
+	deaths, err := models.GetPlayerDeaths(player.ID, deathListLimit(L, 2))
+
+	if err != nil {
+		L.RaiseError("Unable to retrieve player deaths: %v", err)
+		return 0
+	}
+
+	L.Push(deathListToTable(deaths, false))
+	return 1
+}
+
 // GetPlayerAccountID gets a player account ID
 func GetPlayerAccountID(L *lua.LState) int {
 	// Get player struct
@@ -176,6 +196,31 @@ func IsPlayerOnline(L *lua.LState) int {
 	return 1
 }
 
+// IsPlayerBanned checks if the player account is banned. Returns the ban information as
+// second value
+func IsPlayerBanned(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get account ban
+	ban, err := models.GetAccountBan(player.Account_id)
+
+	if err != nil {
+		L.RaiseError("Cannot get player ban status: %v", err)
+		return 0
+	}
+
+	if ban == nil {
+		L.Push(lua.LFalse)
+		return 1
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(accountBanToTable(L, ban))
+
+	return 2
+}
+
 // GetPlayerStorageValue gets a player storage value by the given key
 func GetPlayerStorageValue(L *lua.LState) int {
 	// Get player struct
@@ -235,6 +280,115 @@ func SetPlayerStorageValue(L *lua.LState) int {
 	return 0
 }
 
+// GetPlayerStorageValues gets several player storage values with a single query. Returns a
+// table keyed by storage key
+func GetPlayerStorageValues(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get keys table
+	tbl := L.Get(2)
+
+	// Check for valid keys type
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid keys type. Expected table")
+		return 0
+	}
+
+	// Convert keys
+	keys := []int{}
+	valid := true
+
+	tbl.(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		n, ok := v.(lua.LNumber)
+
+		if !ok {
+			valid = false
+			return
+		}
+
+		keys = append(keys, int(n))
+	})
+
+	if !valid {
+		L.ArgError(1, "Invalid key type. Expected number")
+		return 0
+	}
+
+	// Retrieve player storage values
+	values, err := player.GetStorageValues(keys)
+
+	if err != nil {
+		L.RaiseError("Unable to get player storage values: %v", err)
+		return 0
+	}
+
+	// Convert values to table
+	result := L.NewTable()
+
+	for key, value := range values {
+		result.RawSetInt(key, lua.LNumber(value))
+	}
+
+	L.Push(result)
+
+	return 1
+}
+
+// SetPlayerStorageValues sets several player storage values in a single statement. Returns
+// true or nil and the reason when the player is online
+func SetPlayerStorageValues(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get values table
+	tbl := L.Get(2)
+
+	// Check for valid values type
+	if tbl.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid values type. Expected table")
+		return 0
+	}
+
+	// Convert values
+	values := map[int]int{}
+	valid := true
+
+	tbl.(*lua.LTable).ForEach(func(k, v lua.LValue) {
+		key, ok := k.(lua.LNumber)
+		value, vok := v.(lua.LNumber)
+
+		if !ok || !vok {
+			valid = false
+			return
+		}
+
+		values[int(key)] = int(value)
+	})
+
+	if !valid {
+		L.ArgError(1, "Invalid storage value. Expected number keys and values")
+		return 0
+	}
+
+	// Set storage values
+	err := player.SetStorageValues(values)
+
+	if err == models.ErrPlayerOnline {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	if err != nil {
+		L.RaiseError("Unable to set player storage values: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LTrue)
+	return 1
+}
+
 // GetPlayerVocation gets the player vocation
 func GetPlayerVocation(L *lua.LState) int {
 	// Get player struct
@@ -350,6 +504,25 @@ func GetPlayerExperience(L *lua.LState) int {
 	return 1
 }
 
+// GetPlayerCreationDate gets the player creation timestamp. Returns zero if the schema does not
+// store the creation date
+func GetPlayerCreationDate(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get player creation date
+	created, err := player.GetCreationDate()
+	if err != nil {
+		L.RaiseError("Unable to get player creation date: %v", err)
+		return 0
+	}
+
+	// Push creation date as number
+	L.Push(lua.LNumber(created))
+
+	return 1
+}
+
 // GetPlayerCapacity gets the player capacity
 func GetPlayerCapacity(L *lua.LState) int {
 	// Get player struct
@@ -465,3 +638,241 @@ func GetPlayerCustomField(L *lua.LState) int {
 
 	return 1
 }
+
+// RenamePlayer changes the player name. Returns true on success or nil and the
+// reason when the name is invalid, already taken or the player is online
+func RenamePlayer(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get new name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid name type. Expected string")
+		return 0
+	}
+
+	// Validate name format
+	if !isValidUsername(name.String()) {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("Invalid character name format. Only letters A-Z and spaces allowed"))
+		return 2
+	}
+
+	// Rename player
+	err := player.Rename(name.String())
+
+	if err == models.ErrPlayerNameTaken || err == models.ErrPlayerOnline {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	if err != nil {
+		L.RaiseError("Unable to rename player: %v", err)
+		return 0
+	}
+
+	// Update player table fields
+	updatePlayerMetaTable(player, L, L.ToTable(1))
+
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// GetPlayerOutfit returns the player outfit as a {lookType, head, body, legs, feet, addons,
+// mount, online} table. Online players return the outfit of the last server save
+func GetPlayerOutfit(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get player outfit
+	outfit, err := player.GetOutfit()
+
+	if err != nil {
+		L.RaiseError("Unable to get player outfit: %v", err)
+		return 0
+	}
+
+	// Check if the outfit may be outdated
+	online, err := player.IsOnline()
+
+	if err != nil {
+		L.RaiseError("Unable to check if player is online: %v", err)
+		return 0
+	}
+
+	// Create outfit table
+	tbl := L.NewTable()
+
+	tbl.RawSetString("lookType", lua.LNumber(outfit.LookType))
+	tbl.RawSetString("head", lua.LNumber(outfit.LookHead))
+	tbl.RawSetString("body", lua.LNumber(outfit.LookBody))
+	tbl.RawSetString("legs", lua.LNumber(outfit.LookLegs))
+	tbl.RawSetString("feet", lua.LNumber(outfit.LookFeet))
+	tbl.RawSetString("addons", lua.LNumber(outfit.LookAddons))
+	tbl.RawSetString("mount", lua.LNumber(outfit.LookMount))
+	tbl.RawSetString("online", lua.LBool(online))
+
+	L.Push(tbl)
+
+	return 1
+}
+
+// SetPlayerLevel sets the player level and the matching experience. Returns true on success
+// or nil and the reason when the player is online
+func SetPlayerLevel(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get level
+	level := L.Get(2)
+
+	// Check for valid level type
+	if level.Type() != lua.LTNumber || level.(lua.LNumber) < 1 || level.(lua.LNumber) > maxPlayerLevel {
+		L.ArgError(1, "Invalid level. Expected number between 1 and 100000")
+		return 0
+	}
+
+	// Set player level
+	return pushPlayerExperienceResult(L, player, player.SetLevel(int(level.(lua.LNumber))))
+}
+
+// AddPlayerExperience adds experience to the player recalculating the level. Returns true on
+// success or nil and the reason when the player is online
+func AddPlayerExperience(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get experience amount
+	amount := L.Get(2)
+
+	// Check for valid amount type
+	if amount.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid experience type. Expected number")
+		return 0
+	}
+
+	// Check the resulting level stays in range
+	if int64(amount.(lua.LNumber)) > models.ExperienceForLevel(maxPlayerLevel) {
+		L.ArgError(1, "Invalid experience amount. Too much experience")
+		return 0
+	}
+
+	// Add player experience
+	return pushPlayerExperienceResult(L, player, player.AddExperience(int64(amount.(lua.LNumber))))
+}
+
+// pushPlayerExperienceResult pushes the result of a level or experience change
+func pushPlayerExperienceResult(L *lua.LState, player *models.Player, err error) int {
+	if err == models.ErrPlayerOnline {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	if err != nil {
+		L.RaiseError("Unable to set player experience: %v", err)
+		return 0
+	}
+
+	// Update player table fields
+	updatePlayerMetaTable(player, L, L.ToTable(1))
+
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// HasPlayerAchievement checks if the player unlocked the given achievement
+func HasPlayerAchievement(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get achievement id
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid achievement id type. Expected number")
+		return 0
+	}
+
+	// Get achievement definition
+	achievement := util.ServerAchievementList.ByID(L.ToInt(2))
+
+	if achievement == nil {
+		L.ArgError(1, "Unknown achievement id")
+		return 0
+	}
+
+	// Retrieve achievement storage value
+	values, err := player.GetStorageValues([]int{achievement.Storage})
+
+	if err != nil {
+		L.RaiseError("Unable to get player achievements: %v", err)
+		return 0
+	}
+
+	value, ok := values[achievement.Storage]
+
+	L.Push(lua.LBool(ok && value >= achievement.Value))
+
+	return 1
+}
+
+// GetPlayerAchievements returns every achievement definition resolved against the player
+// storage values as a list of {id, name, description, points, secret, unlocked, unlockedAt}
+func GetPlayerAchievements(L *lua.LState) int {
+	// Get player struct
+	player := getPlayerObject(L)
+
+	// Get achievement definitions
+	achievements := util.ServerAchievementList.All()
+
+	// Collect all storage keys
+	keys := []int{}
+
+	for _, a := range achievements {
+		keys = append(keys, a.Storage)
+
+		if a.TimeStorage != 0 {
+			keys = append(keys, a.TimeStorage)
+		}
+	}
+
+	// Retrieve storage values with a single query
+	values, err := player.GetStorageValues(keys)
+
+	if err != nil {
+		L.RaiseError("Unable to get player achievements: %v", err)
+		return 0
+	}
+
+	result := L.NewTable()
+
+	for _, a := range achievements {
+		value, ok := values[a.Storage]
+		unlocked := ok && value >= a.Value
+
+		entry := L.NewTable()
+		entry.RawSetString("id", lua.LNumber(a.ID))
+		entry.RawSetString("name", lua.LString(a.Name))
+		entry.RawSetString("description", lua.LString(a.Description))
+		entry.RawSetString("points", lua.LNumber(a.Points))
+		entry.RawSetString("secret", lua.LBool(a.Secret))
+		entry.RawSetString("unlocked", lua.LBool(unlocked))
+
+		// Set unlock time if known
+		if t, ok := values[a.TimeStorage]; unlocked && a.TimeStorage != 0 && ok {
+			entry.RawSetString("unlockedAt", lua.LNumber(t))
+		}
+
+		result.Append(entry)
+	}
+
+	L.Push(result)
+
+	return 1
+}
@@ -0,0 +1,123 @@
+package lua
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetRateLimitMetaTable sets the ratelimit metatable of the given state
+func SetRateLimitMetaTable(luaState *lua.LState) {
+	// Create and set the ratelimit metatable
+	rateLimitMetaTable := luaState.NewTypeMetatable(RateLimitMetaTableName)
+	luaState.SetGlobal(RateLimitMetaTableName, rateLimitMetaTable)
+
+	// Set all ratelimit metatable functions
+	luaState.SetFuncs(rateLimitMetaTable, rateLimitMethods)
+}
+
+// RateLimitAllow checks if the given key can perform another action inside the current window.
+// Returns if the action is allowed and the number of remaining actions
+func RateLimitAllow(L *lua.LState) int {
+	// Get limiter key
+	key := L.Get(2)
+
+	// Check valid key
+	if key.Type() != lua.LTString {
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	// Get max number of actions
+	max := L.CheckInt64(3)
+
+	if max < 1 {
+		L.ArgError(2, "Invalid max number of actions. Expected number greater than zero")
+		return 0
+	}
+
+	// Get window duration
+	window, ok := durationFromValue(L.Get(4))
+
+	if !ok {
+		L.ArgError(3, "Invalid window. Expected number of seconds or duration string greater than zero")
+		return 0
+	}
+
+	// Check action
+	allowed, remaining, err := allowRateLimitAction(key.String(), max, window)
+
+	if err != nil {
+		L.RaiseError("Cannot check rate limit: %v", err)
+		return 0
+	}
+
+	// Push results
+	L.Push(lua.LBool(allowed))
+	L.Push(lua.LNumber(remaining))
+
+	return 2
+}
+
+// RateLimitReset removes the counter of the given key
+func RateLimitReset(L *lua.LState) int {
+	// Get limiter key
+	key := L.Get(2)
+
+	// Check valid key
+	if key.Type() != lua.LTString {
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	// Remove counter
+	if err := util.LuaCache.Delete(fmt.Sprintf("ratelimit_%v", key.String())); err != nil {
+		L.RaiseError("Cannot reset rate limit: %v", err)
+		return 0
+	}
+
+	return 0
+}
+
+// durationFromValue converts the given number of seconds or duration string to a positive duration
+func durationFromValue(v lua.LValue) (time.Duration, bool) {
+	window := time.Duration(0)
+
+	switch v.Type() {
+	case lua.LTNumber:
+		window = time.Duration(int64(v.(lua.LNumber))) * time.Second
+	case lua.LTString:
+		d, err := time.ParseDuration(v.String())
+
+		if err != nil {
+			return 0, false
+		}
+
+		window = d
+	}
+
+	return window, window > 0
+}
+
+// allowRateLimitAction counts an action of the given key returning if it is allowed and
+// the number of remaining actions inside the current window. Counters are stored on the
+// lua cache backend so they are shared between instances
+func allowRateLimitAction(key string, max int64, window time.Duration) (bool, int64, error) {
+	// Increment window counter
+	n, err := util.LuaCache.Increment(fmt.Sprintf("ratelimit_%v", key), window)
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Get remaining actions
+	remaining := max - n
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return n <= max, remaining, nil
+}
@@ -2,10 +2,29 @@ package lua
 
 import (
 	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+// reflectObjectFields castro object tables identified by their user data field
+var reflectObjectFields = []struct {
+	Field string
+	Name  string
+}{
+	{"__player", "castro.player"},
+	{"__guild", "castro.guild"},
+	{"__img", "castro.image"},
+	{"__stmt", "castro.statement"},
+	{"__file", "castro.formfile"},
+	{"__sitemap", "castro.sitemap"},
+	{"__websocket", "castro.websocket"},
+}
+
 // SetReflectMetaTable sets the reflect metatable of the given state
 func SetReflectMetaTable(luaState *lua.LState) {
 	// Create and set the reflect metatable
@@ -14,20 +33,36 @@ func SetReflectMetaTable(luaState *lua.LState) {
 
 	// Set all reflect metatable functions
 	luaState.SetFuncs(reflectMetaTable, reflectMethods)
+
+	// Set getGlobal function. GetGlobal uses the state pool so it cannot be part of reflectMethods
+	luaState.SetField(reflectMetaTable, "getGlobal", luaState.NewFunction(GetGlobal))
 }
 
 // GetGlobal retrieves a global lua value from other script
 func GetGlobal(L *lua.LState) int {
 	// Get script location
-	path := L.ToString(2)
+	path := L.Get(2)
+
+	// Check for valid path type
+	if path.Type() != lua.LTString {
+		L.ArgError(1, "Invalid script path type. Expected string")
+		return 0
+	}
 
 	// Get value name
-	val := L.ToString(3)
+	val := L.Get(3)
+
+	// Check for valid name type
+	if val.Type() != lua.LTString {
+		L.ArgError(2, "Invalid global name type. Expected string")
+		return 0
+	}
+
+	// Get cache key
+	key := fmt.Sprintf("reflect_global_%v_%v", path.String(), val.String())
 
 	// Check if value is on cache
-	source, found := util.Cache.Get(
-		fmt.Sprintf("reflect_global_%v", val),
-	)
+	source, found := util.Cache.Get(key)
 
 	if found {
 
@@ -44,19 +79,25 @@ func GetGlobal(L *lua.LState) int {
 	defer Pool.Put(state)
 
 	// Execute the script
-	if err := state.DoFile(path); err != nil {
+	if err := state.DoFile(path.String()); err != nil {
 		L.RaiseError("Cannot execute the given script: %v", err)
 		return 0
 	}
 
 	// Get value from state
-	v := state.GetGlobal(val)
+	v := state.GetGlobal(val.String())
+
+	// Missing globals are not cached
+	if v == lua.LNil {
+		L.Push(lua.LNil)
+		return 1
+	}
 
 	// Save global to cache
 	util.Cache.Add(
-		fmt.Sprintf("reflect_global_%v", val),
+		key,
 		v,
-		util.Config.Configuration.Cache.Default.Duration,
+		util.Config.Get().Cache.Default.Duration,
 	)
 
 	// Push value
@@ -64,3 +105,65 @@ func GetGlobal(L *lua.LState) int {
 
 	return 1
 }
+
+// GetReflectType returns the type name of the given value. Castro objects and
+// modules are reported as castro.<name>, other values use the lua type name
+func GetReflectType(L *lua.LState) int {
+	// Push type name
+	L.Push(lua.LString(reflectTypeName(L, L.Get(2))))
+
+	return 1
+}
+
+// reflectTypeName returns the castro type name of a lua value
+func reflectTypeName(L *lua.LState, v lua.LValue) string {
+	switch val := v.(type) {
+	case *lua.LTable:
+
+		// Check for castro object fields
+		for _, obj := range reflectObjectFields {
+			if _, ok := val.RawGetString(obj.Field).(*lua.LUserData); ok {
+				return obj.Name
+			}
+		}
+
+		// Check if the table is a castro module metatable
+		name := ""
+
+		L.G.Registry.ForEach(func(k, m lua.LValue) {
+			if m == val && k.Type() == lua.LTString && !strings.HasPrefix(k.String(), "_") {
+				name = "castro." + k.String()
+			}
+		})
+
+		if name != "" {
+			return name
+		}
+
+	case *lua.LUserData:
+
+		// Check user data value type
+		switch val.Value.(type) {
+		case *util.Image:
+			return "castro.image"
+		case *models.Player:
+			return "castro.player"
+		case *models.Guild:
+			return "castro.guild"
+		case *statementHandle:
+			return "castro.statement"
+		case *formFileUserData:
+			return "castro.formfile"
+		case *http.Request:
+			return "castro.request"
+		case http.ResponseWriter:
+			return "castro.response"
+		case map[string]interface{}:
+			return "castro.session"
+		case template.HTML:
+			return "castro.html"
+		}
+	}
+
+	return v.Type().String()
+}
@@ -0,0 +1,78 @@
+package lua
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// defaultLibDirectory directory of the shared lua modules when Lua.Lib is not set
+const defaultLibDirectory = "lib"
+
+// requirePath returns the package.path of the given castro folder. Modules are searched
+// on the engine folder and then on the Lua.Lib directory
+func requirePath(folder string) string {
+	// Get lib directory
+	lib := util.Config.Get().Lua.Lib
+
+	if lib == "" {
+		lib = defaultLibDirectory
+	}
+
+	if !filepath.IsAbs(lib) {
+		lib = filepath.Join(folder, lib)
+	}
+
+	return strings.Join([]string{
+		filepath.Join(folder, "engine", "?.lua"),
+		filepath.Join(lib, "?.lua"),
+		filepath.Join(lib, "?", "init.lua"),
+	}, ";")
+}
+
+// RequireLoader package loader used by require. Modules are searched using package.path and
+// compiled once, the compiled module is reused until the file is modified
+func RequireLoader(L *glua.LState) int {
+	// Get module name
+	name := L.CheckString(1)
+
+	// Get search path
+	path := glua.LVAsString(L.GetField(L.GetGlobal("package"), "path"))
+
+	// Search module file
+	file := strings.Replace(name, ".", string(os.PathSeparator), -1)
+	messages := []string{}
+
+	for _, pattern := range strings.Split(path, ";") {
+		if pattern == "" {
+			continue
+		}
+
+		source := strings.Replace(pattern, "?", file, -1)
+
+		if _, err := os.Stat(source); err != nil {
+			messages = append(messages, fmt.Sprintf("\n\tno file '%s'", source))
+			continue
+		}
+
+		// Get compiled module
+		proto, err := CompiledModuleList.Load(source)
+
+		if err != nil {
+			L.RaiseError("Cannot load module %v: %v", name, err)
+			return 0
+		}
+
+		L.Push(L.NewFunctionFromProto(proto))
+
+		return 1
+	}
+
+	L.Push(glua.LString(strings.Join(messages, "")))
+
+	return 1
+}
@@ -0,0 +1,104 @@
+package lua
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetServerMetaTable sets the server metatable of the given state
+func SetServerMetaTable(luaState *lua.LState) {
+	// Create and set the server metatable
+	serverMetaTable := luaState.NewTypeMetatable(ServerMetaTableName)
+	luaState.SetGlobal(ServerMetaTableName, serverMetaTable)
+
+	// Set all server metatable functions
+	luaState.SetFuncs(serverMetaTable, serverMethods)
+}
+
+// ServerStatus queries the game server status port. Results are cached for
+// a few seconds since servers limit the number of status requests
+func ServerStatus(L *lua.LState) int {
+	// Check if status is on the cache
+	status, found := util.Cache.Get("server_status")
+
+	if !found {
+
+		// Get server status
+		s, err := util.GetServerStatus(serverStatusAddress(), serverStatusTimeout())
+
+		if err != nil {
+			util.Logger.Logger.Warnf("Cannot get server status: %v", err)
+		}
+
+		// Save status to cache
+		util.Cache.Add("server_status", s, time.Second*10)
+
+		status = s
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	// Get server status
+	s, ok := status.(*util.ServerStatus)
+
+	if !ok || s == nil {
+		tbl.RawSetString("online", lua.LFalse)
+		L.Push(tbl)
+		return 1
+	}
+
+	// Set status fields
+	tbl.RawSetString("online", lua.LTrue)
+	tbl.RawSetString("playersOnline", lua.LNumber(s.Players.Online))
+	tbl.RawSetString("maxPlayers", lua.LNumber(s.Players.Max))
+	tbl.RawSetString("playersPeak", lua.LNumber(s.Players.Peak))
+	tbl.RawSetString("uptime", lua.LNumber(s.ServerInfo.Uptime))
+	tbl.RawSetString("motd", lua.LString(s.MOTD))
+	tbl.RawSetString("name", lua.LString(s.ServerInfo.ServerName))
+	tbl.RawSetString("version", lua.LString(s.ServerInfo.Version))
+
+	// Push status table
+	L.Push(tbl)
+
+	return 1
+}
+
+// serverStatusAddress returns the game server status address. When not configured
+// the ip and statusProtocolPort values of config.lua are used
+func serverStatusAddress() string {
+	// Get configured values
+	host := util.Config.Get().Status.Host
+	port := util.Config.Get().Status.Port
+
+	if host == "" {
+		if ip := Config.GetGlobal("ip"); ip.Type() == lua.LTString {
+			host = ip.String()
+		} else {
+			host = "127.0.0.1"
+		}
+	}
+
+	if port == 0 {
+		port = int(lua.LVAsNumber(Config.GetGlobal("statusProtocolPort")))
+	}
+
+	if port == 0 {
+		port = 7171
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// serverStatusTimeout returns the status request timeout
+func serverStatusTimeout() time.Duration {
+	if util.Config.Get().Status.Timeout.Duration > 0 {
+		return util.Config.Get().Status.Timeout.Duration
+	}
+
+	return time.Second * 2
+}
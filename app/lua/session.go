@@ -1,8 +1,12 @@
 package lua
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/dchest/uniuri"
 	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
@@ -41,7 +45,7 @@ func getSessionData(L *lua.LState) map[string]interface{} {
 	return data.Value.(map[string]interface{})
 }
 
-// updateSessionData saves a new cookie with the encoded map
+// updateSessionData saves a new cookie with the encoded map. Raises an error if the session cannot be saved
 func updateSessionData(L *lua.LState) {
 	// Get response writer from state
 	_, w := getRequestAndResponseWriter(L)
@@ -50,10 +54,11 @@ func updateSessionData(L *lua.LState) {
 	session := getSessionData(L)
 
 	// Encode session map
-	encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
+	encoded, err := util.EncodeSession(session)
 
 	if err != nil {
-		util.Logger.Logger.Fatalf("Cannot encode cookie value: %v", err)
+		L.RaiseError("Cannot save session: %v", err)
+		return
 	}
 
 	// Create cookie
@@ -164,6 +169,14 @@ func DestroySession(L *lua.LState) int {
 	// Get session data from the user data field
 	session := getSessionData(L)
 
+	// Remove the stored session data so the old cookie cannot be reused
+	if id, ok := session["id"].(string); ok {
+		if err := util.DeleteSession(id); err != nil {
+			L.RaiseError("Cannot destroy session: %v", err)
+			return 0
+		}
+	}
+
 	// Loop map
 	for key := range session {
 
@@ -217,13 +230,23 @@ func SetSessionData(L *lua.LState) int {
 		// Assign element as bool
 		session[key.String()] = bool(lv)
 
+		// Rotate csrf token on login
+		if key.String() == "logged" && bool(lv) {
+			rotateCsrfToken(session)
+		}
+
 	case *lua.LTable:
 
-		// Convert table to map
-		m := TableToMap(val.(*lua.LTable))
+		// Convert table to a gob friendly value
+		v, err := sessionTableToGo(lv, map[*lua.LTable]bool{}, "session")
+
+		if err != nil {
+			L.RaiseError("Cannot set session value %v: %v", key.String(), err)
+			return 0
+		}
 
-		// Assign element as map
-		session[key.String()] = m
+		// Assign element
+		session[key.String()] = v
 	}
 
 	// Update session data
@@ -264,13 +287,10 @@ func GetSessionData(L *lua.LState) int {
 
 		// Push element as boolean
 		L.Push(lua.LBool(val.(bool)))
-	case map[string]interface{}:
-
-		// Convert map to lua table
-		tble := MapToTable(val.(map[string]interface{}))
+	case map[string]interface{}, []interface{}:
 
 		// Push element as table
-		L.Push(tble)
+		L.Push(sessionValueToLua(val))
 	default:
 		L.Push(lua.LNil)
 	}
@@ -278,6 +298,128 @@ func GetSessionData(L *lua.LState) int {
 	return 1
 }
 
+// sessionTableToGo converts a lua table to nested maps and slices that can be stored
+// in the session cookie. Tables with only sequential keys are stored as slices. The target
+// names where the value is stored on error messages
+func sessionTableToGo(tbl *lua.LTable, parents map[*lua.LTable]bool, target string) (interface{}, error) {
+	// Check for cyclic tables
+	if parents[tbl] {
+		return nil, fmt.Errorf("Cyclic tables cannot be stored in the %v", target)
+	}
+
+	parents[tbl] = true
+	defer delete(parents, tbl)
+
+	// Count table fields
+	count := 0
+	tbl.ForEach(func(_, _ lua.LValue) {
+		count++
+	})
+
+	// Check if table is an array
+	maxn := tbl.MaxN()
+
+	if maxn > 0 && maxn == count {
+
+		// Data holder
+		list := make([]interface{}, 0, maxn)
+
+		for i := 1; i <= maxn; i++ {
+
+			// Convert element
+			v, err := sessionValueToGo(tbl.RawGetInt(i), parents, target)
+
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+
+		return list, nil
+	}
+
+	// Data holder
+	m := map[string]interface{}{}
+
+	// Error holder
+	var err error
+
+	tbl.ForEach(func(k, v lua.LValue) {
+		if err != nil {
+			return
+		}
+
+		// Convert element
+		value, verr := sessionValueToGo(v, parents, target)
+
+		if verr != nil {
+			err = verr
+			return
+		}
+
+		m[k.String()] = value
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// sessionValueToGo converts a lua value to a value that can be stored in the session cookie.
+// The target names where the value is stored on error messages
+func sessionValueToGo(v lua.LValue, parents map[*lua.LTable]bool, target string) (interface{}, error) {
+	switch lv := v.(type) {
+	case lua.LString:
+		return string(lv), nil
+	case lua.LNumber:
+		return float64(lv), nil
+	case lua.LBool:
+		return bool(lv), nil
+	case *lua.LTable:
+		return sessionTableToGo(lv, parents, target)
+	}
+
+	return nil, fmt.Errorf("Values of type %v cannot be stored in the %v", v.Type().String(), target)
+}
+
+// sessionValueToLua converts a session value back to a lua value
+func sessionValueToLua(v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case float64:
+		return lua.LNumber(val)
+	case string:
+		return lua.LString(val)
+	case bool:
+		return lua.LBool(val)
+	case map[string]interface{}:
+
+		// Convert map to table
+		tbl := &lua.LTable{}
+
+		for k, e := range val {
+			tbl.RawSetString(k, sessionValueToLua(e))
+		}
+
+		return tbl
+
+	case []interface{}:
+
+		// Convert slice to table
+		tbl := &lua.LTable{}
+
+		for _, e := range val {
+			tbl.Append(sessionValueToLua(e))
+		}
+
+		return tbl
+	}
+
+	return lua.LNil
+}
+
 // IsLogged checks if the current user is logged in
 func IsLogged(L *lua.LState) int {
 	// Get session data from the user data field
@@ -301,7 +443,8 @@ func IsLogged(L *lua.LState) int {
 	return 1
 }
 
-// GetFlash gets a flash value from the user session
+// GetFlash gets a flash value from the user session. When called with a type it returns the
+// message of the first flash with that type, without arguments it returns the first queued flash
 func GetFlash(L *lua.LState) int {
 	// Get session data from the user data field
 	session := getSessionData(L)
@@ -309,6 +452,29 @@ func GetFlash(L *lua.LState) int {
 	// Get flash key
 	key := L.Get(2)
 
+	// Get flash queue
+	flashes := getSessionFlashes(session)
+
+	// Pop first queued flash
+	if key == lua.LNil {
+
+		if len(flashes) == 0 {
+			L.Push(lua.LNil)
+			return 1
+		}
+
+		// Remove flash from queue
+		session["flashes"] = flashes[1:]
+
+		// Update session data
+		updateSessionData(L)
+
+		// Push flash table
+		L.Push(MapToTable(flashes[0].(map[string]interface{})))
+
+		return 1
+	}
+
 	// Check for valid key
 	if key.Type() != lua.LTString {
 
@@ -316,7 +482,27 @@ func GetFlash(L *lua.LState) int {
 		return 0
 	}
 
-	// Get value from the flash map
+	// Get first flash with the given type
+	for i, f := range flashes {
+		flash := f.(map[string]interface{})
+
+		if flash["type"] != key.String() {
+			continue
+		}
+
+		// Remove flash from queue
+		session["flashes"] = append(flashes[:i:i], flashes[i+1:]...)
+
+		// Update session data
+		updateSessionData(L)
+
+		// Push flash message
+		L.Push(lua.LString(flash["message"].(string)))
+
+		return 1
+	}
+
+	// Get value from the legacy flash map
 	v, ok := session[key.String()].(string)
 
 	if !ok {
@@ -336,7 +522,37 @@ func GetFlash(L *lua.LState) int {
 	return 1
 }
 
-// SetFlash sets a flash value to the user session
+// GetFlashes gets all the queued flash values from the user session
+func GetFlashes(L *lua.LState) int {
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Get flash queue
+	flashes := getSessionFlashes(session)
+
+	// Create flashes table
+	tbl := L.NewTable()
+
+	for _, f := range flashes {
+		tbl.Append(MapToTable(f.(map[string]interface{})))
+	}
+
+	// Clear flash queue
+	if len(flashes) > 0 {
+		delete(session, "flashes")
+
+		// Update session data
+		updateSessionData(L)
+	}
+
+	// Push flashes table
+	L.Push(tbl)
+
+	return 1
+}
+
+// SetFlash queues a flash value on the user session. When called with a single argument the
+// flash type defaults to info
 func SetFlash(L *lua.LState) int {
 
 	// Get session data from the user data field
@@ -355,18 +571,185 @@ func SetFlash(L *lua.LState) int {
 	// Get flash data
 	content := L.Get(3)
 
+	// Use single argument form
+	if content == lua.LNil {
+		content = key
+		key = lua.LString("info")
+	}
+
 	// Check for valid content
 	if content.Type() != lua.LTString {
 
-		L.ArgError(1, "Invalid flash content. Expected string")
+		L.ArgError(2, "Invalid flash content. Expected string")
 		return 0
 	}
 
-	// Set flash value
-	session[key.String()] = content.String()
+	// Queue flash value
+	session["flashes"] = append(getSessionFlashes(session), map[string]interface{}{
+		"type":    key.String(),
+		"message": content.String(),
+	})
+
+	// Update session data
+	updateSessionData(L)
+
+	return 0
+}
+
+// getSessionFlashes returns the flash queue of the given session
+func getSessionFlashes(session map[string]interface{}) []interface{} {
+	// Get flash queue
+	flashes, ok := session["flashes"].([]interface{})
+
+	if !ok {
+		return []interface{}{}
+	}
+
+	return flashes
+}
+
+// TouchSession refreshes the session idle timer
+func TouchSession(L *lua.LState) int {
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Refresh session timestamps
+	util.TouchSession(session)
+
+	// Update session data
+	updateSessionData(L)
+
+	return 0
+}
+
+// GetSessionExpiration returns the session expiration unix timestamp and the remaining seconds
+func GetSessionExpiration(L *lua.LState) int {
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Get expiration time
+	expires := util.SessionExpiresAt(session)
+
+	// Push nil if session never expires
+	if expires.IsZero() {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Get remaining lifetime
+	remaining := time.Until(expires)
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	// Push expiration values
+	L.Push(lua.LNumber(expires.Unix()))
+	L.Push(lua.LNumber(int64(remaining.Seconds())))
+
+	return 2
+}
+
+// RegenerateSession issues a new session identifier keeping the session data. Should be called
+// right after the user authenticates to prevent session fixation
+func RegenerateSession(L *lua.LState) int {
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Remove the stored data of the previous identifier
+	if id, ok := session["id"].(string); ok {
+		if err := util.DeleteSession(id); err != nil {
+			L.RaiseError("Cannot remove previous session: %v", err)
+			return 0
+		}
+	}
+
+	// Set new session identifier
+	session["id"] = uniuri.NewLen(32)
+
+	// Restart session lifetime
+	delete(session, "created-at")
+	util.TouchSession(session)
+
+	// Rotate csrf token
+	rotateCsrfToken(session)
 
 	// Update session data
 	updateSessionData(L)
 
 	return 0
 }
+
+// GetCsrfToken returns the session csrf token creating it if needed
+func GetCsrfToken(L *lua.LState) int {
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Get token from session
+	token, ok := session["csrf-token"].(*models.CsrfToken)
+
+	if !ok {
+
+		// Create and save a new token
+		token = rotateCsrfToken(session)
+
+		// Update session data
+		updateSessionData(L)
+	}
+
+	// Push token
+	L.Push(lua.LString(token.Token))
+
+	return 1
+}
+
+// VerifyCsrfToken checks if the given token matches the session csrf token
+func VerifyCsrfToken(L *lua.LState) int {
+	// Get token
+	tkn := L.Get(2)
+
+	// Check valid token
+	if tkn.Type() != lua.LTString {
+		L.ArgError(1, "Invalid token type. Expected string")
+		return 0
+	}
+
+	// Get session data from the user data field
+	session := getSessionData(L)
+
+	// Get token from session
+	token, ok := session["csrf-token"].(*models.CsrfToken)
+
+	if !ok || token.Token == "" {
+		L.Push(lua.LBool(false))
+		return 1
+	}
+
+	// Compare tokens
+	L.Push(lua.LBool(
+		subtle.ConstantTimeCompare([]byte(tkn.String()), []byte(token.Token)) == 1,
+	))
+
+	return 1
+}
+
+// rotateCsrfToken generates a new csrf token for the given session
+func rotateCsrfToken(session map[string]interface{}) *models.CsrfToken {
+	// Get current token
+	token, ok := session["csrf-token"].(*models.CsrfToken)
+
+	if !ok {
+
+		// Create token
+		token = &models.CsrfToken{}
+
+		// Set session value
+		session["csrf-token"] = token
+	}
+
+	// Set new token value
+	token.Token = uniuri.New()
+	token.At = time.Now()
+
+	return token
+}
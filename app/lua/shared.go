@@ -0,0 +1,118 @@
+package lua
+
+import (
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// sharedStore process-wide key/value store shared by every lua state
+var sharedStore = &sharedValues{
+	values: map[string]interface{}{},
+}
+
+// sharedValues mutex guarded map of shared values
+type sharedValues struct {
+	rw     sync.RWMutex
+	values map[string]interface{}
+}
+
+// SetSharedMetaTable sets the shared metatable of the given state
+func SetSharedMetaTable(luaState *lua.LState) {
+	// Create and set the shared metatable
+	sharedMetaTable := luaState.NewTypeMetatable(SharedMetaTableName)
+	luaState.SetGlobal(SharedMetaTableName, sharedMetaTable)
+
+	// Set all shared metatable functions
+	luaState.SetFuncs(sharedMetaTable, sharedMethods)
+}
+
+// SetSharedValue stores a value on the process-wide shared map. Tables are deep-copied
+// so later changes to the table do not affect the stored value. Setting nil deletes the key
+func SetSharedValue(L *lua.LState) int {
+	// Get key
+	key := L.Get(2)
+
+	// Check for valid key type
+	if key.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid key format. Expected string")
+		return 0
+	}
+
+	// Get value
+	val := L.Get(3)
+
+	// Delete key on nil values
+	if val == lua.LNil {
+		sharedStore.rw.Lock()
+		delete(sharedStore.values, key.String())
+		sharedStore.rw.Unlock()
+
+		return 0
+	}
+
+	// Copy value to a Go type
+	v, err := sessionValueToGo(val, map[*lua.LTable]bool{}, "shared storage")
+
+	if err != nil {
+		L.RaiseError("Cannot set shared value %v: %v", key.String(), err)
+		return 0
+	}
+
+	// Store value
+	sharedStore.rw.Lock()
+	sharedStore.values[key.String()] = v
+	sharedStore.rw.Unlock()
+
+	return 0
+}
+
+// GetSharedValue retrieves a value from the process-wide shared map. Tables are returned
+// as a fresh copy so they can be modified without affecting other requests
+func GetSharedValue(L *lua.LState) int {
+	// Get key
+	key := L.Get(2)
+
+	// Check for valid key type
+	if key.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid key format. Expected string")
+		return 0
+	}
+
+	// Retrieve value
+	sharedStore.rw.RLock()
+	v, ok := sharedStore.values[key.String()]
+	sharedStore.rw.RUnlock()
+
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Push a copy of the value
+	L.Push(sessionValueToLua(v))
+
+	return 1
+}
+
+// DeleteSharedValue removes a value from the process-wide shared map
+func DeleteSharedValue(L *lua.LState) int {
+	// Get key
+	key := L.Get(2)
+
+	// Check for valid key type
+	if key.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid key format. Expected string")
+		return 0
+	}
+
+	// Remove value
+	sharedStore.rw.Lock()
+	delete(sharedStore.values, key.String())
+	sharedStore.rw.Unlock()
+
+	return 0
+}
@@ -0,0 +1,179 @@
+package lua
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetSitemapMetaTable sets the sitemap metatable of the given state
+func SetSitemapMetaTable(luaState *lua.LState) {
+	// Create and set the sitemap metatable
+	sitemapMetaTable := luaState.NewTypeMetatable(SitemapMetaTableName)
+	luaState.SetGlobal(SitemapMetaTableName, sitemapMetaTable)
+
+	// Set all sitemap metatable functions
+	luaState.SetFuncs(sitemapMetaTable, sitemapMethods)
+}
+
+// getSitemap retrieves the sitemap user data from the given state
+func getSitemap(luaState *lua.LState) *util.Sitemap {
+	// Get metatable
+	meta := luaState.Get(1)
+
+	// Get user data
+	data, ok := luaState.GetField(meta, "__sitemap").(*lua.LUserData)
+
+	if !ok {
+		luaState.RaiseError("Cannot retrieve sitemap user data")
+	}
+
+	// Retrieve sitemap
+	s, ok := data.Value.(*util.Sitemap)
+
+	if !ok {
+		luaState.RaiseError("Cannot retrieve sitemap from user data")
+	}
+
+	return s
+}
+
+// NewSitemap creates and returns a new empty sitemap
+func NewSitemap(L *lua.LState) int {
+	// Create metatable
+	tbl := L.NewTable()
+
+	// Create sitemap user data
+	sitemapUserData := L.NewUserData()
+	sitemapUserData.Value = &util.Sitemap{}
+
+	// Set the user data field
+	L.SetField(tbl, "__sitemap", sitemapUserData)
+
+	// Set the metatable methods
+	L.SetFuncs(tbl, sitemapObjectMethods)
+
+	// Push sitemap table
+	L.Push(tbl)
+
+	return 1
+}
+
+// AddSitemapURL adds an url to the sitemap. The options table accepts the lastmod
+// (unix timestamp or string), changefreq and priority fields
+func AddSitemapURL(L *lua.LState) int {
+	// Get sitemap
+	s := getSitemap(L)
+
+	// Get url
+	loc := L.Get(2)
+
+	// Check for valid url type
+	if loc.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid url type. Expected string")
+		return 0
+	}
+
+	entry := util.SitemapURL{
+		Loc: loc.String(),
+	}
+
+	// Get options table
+	if opts, ok := L.Get(3).(*lua.LTable); ok {
+
+		// Get last modification date
+		switch lastmod := opts.RawGetString("lastmod"); lastmod.Type() {
+		case lua.LTNumber:
+			entry.LastMod = time.Unix(int64(lastmod.(lua.LNumber)), 0).UTC().Format(time.RFC3339)
+		case lua.LTString:
+			entry.LastMod = lastmod.String()
+		}
+
+		// Get change frequency
+		if changefreq := opts.RawGetString("changefreq"); changefreq.Type() == lua.LTString {
+			entry.ChangeFreq = changefreq.String()
+		}
+
+		// Get priority
+		if priority := opts.RawGetString("priority"); priority.Type() == lua.LTNumber {
+			p := float64(priority.(lua.LNumber))
+
+			if p < 0 || p > 1 {
+				L.ArgError(2, "Invalid priority. Expected a number between 0 and 1")
+				return 0
+			}
+
+			entry.Priority = strconv.FormatFloat(p, 'f', 1, 64)
+		}
+	}
+
+	// Add entry
+	if err := s.Add(entry); err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	return 0
+}
+
+// CountSitemapURLs returns the number of urls of the sitemap
+func CountSitemapURLs(L *lua.LState) int {
+	// Get sitemap
+	s := getSitemap(L)
+
+	// Push url count
+	L.Push(lua.LNumber(len(s.URLs)))
+
+	return 1
+}
+
+// RenderSitemap renders the sitemap as XML. Sitemaps with more than 50000 urls are split
+// into several files, returning a sitemap index and a list of files. The chunkURL option
+// is used to build the index locations and must contain a %d verb
+func RenderSitemap(L *lua.LState) int {
+	// Get sitemap
+	s := getSitemap(L)
+
+	// Render sitemap files
+	files, err := s.Render()
+
+	if err != nil {
+		L.RaiseError("Cannot render sitemap: %v", err)
+		return 0
+	}
+
+	// Push single sitemap
+	if len(files) == 1 {
+		L.Push(lua.LString(files[0]))
+		return 1
+	}
+
+	// Get chunk url
+	var chunkURL lua.LValue = lua.LNil
+
+	if opts, ok := L.Get(2).(*lua.LTable); ok {
+		chunkURL = opts.RawGetString("chunkURL")
+	}
+
+	if chunkURL.Type() != lua.LTString {
+		L.ArgError(1, "Sitemaps with more than 50000 urls need the chunkURL option")
+		return 0
+	}
+
+	// Render sitemap index
+	index, err := util.RenderSitemapIndex(chunkURL.String(), len(files))
+
+	if err != nil {
+		L.ArgError(1, err.Error())
+		return 0
+	}
+
+	// Push index and files
+	L.Push(lua.LString(index))
+	L.Push(StringSliceToTable(files))
+
+	return 2
+}
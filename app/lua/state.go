@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/util"
@@ -22,15 +23,31 @@ var (
 
 	// CompiledPageList list of compiled subtopic states
 	CompiledPageList = &compiledStateList{
-		List: make(map[string]*glua.FunctionProto),
-		Type: "page",
+		List:  make(map[string]*compiledProto),
+		cache: make(map[string]*compiledProto),
+		Type:  "page",
+	}
+
+	// CompiledModuleList list of compiled modules loaded with require
+	CompiledModuleList = &compiledStateList{
+		List:  make(map[string]*compiledProto),
+		cache: make(map[string]*compiledProto),
+		Type:  "module",
 	}
 )
 
 type compiledStateList struct {
-	rw   sync.Mutex
-	List map[string]*glua.FunctionProto
-	Type string
+	rw    sync.RWMutex
+	List  map[string]*compiledProto
+	cache map[string]*compiledProto
+	Type  string
+}
+
+// compiledProto compiled lua file and the modification time of its source
+type compiledProto struct {
+	proto   *glua.FunctionProto
+	source  string
+	modTime time.Time
 }
 
 type stateList struct {
@@ -41,6 +58,9 @@ type stateList struct {
 
 // Exists checks if a proto path exists
 func (s *compiledStateList) Exists(path string) bool {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
 	path = strings.ToLower(path)
 	for p, _ := range s.List {
 		if strings.ToLower(p) == path {
@@ -54,7 +74,7 @@ func (s *compiledStateList) Exists(path string) bool {
 func (s *compiledStateList) CompileFiles(dir string) error {
 	s.rw.Lock()
 	defer s.rw.Unlock()
-	files := map[string]*glua.FunctionProto{}
+	files := map[string]*compiledProto{}
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -64,7 +84,7 @@ func (s *compiledStateList) CompileFiles(dir string) error {
 		}
 		if strings.HasSuffix(info.Name(), ".lua") {
 			// Compile lua file
-			proto, err := CompileLua(path)
+			proto, err := s.compile(path, info.ModTime())
 			if err != nil {
 				return err
 			}
@@ -115,7 +135,7 @@ func (s *compiledStateList) CompileExtensions(extType string) error {
 			}
 			if strings.HasSuffix(info.Name(), ".lua") {
 				// Compile lua file
-				proto, err := CompileLua(path)
+				proto, err := s.compile(path, info.ModTime())
 				if err != nil {
 					return err
 				}
@@ -135,15 +155,99 @@ func (s *compiledStateList) CompileExtensions(extType string) error {
 	return nil
 }
 
-// Get retrieves a compiled lua function proto
+// compile returns the cached proto of the given file, compiling it again only
+// when the file modification time changed
+func (s *compiledStateList) compile(source string, modTime time.Time) (*compiledProto, error) {
+	if c, ok := s.cache[source]; ok && c.modTime.Equal(modTime) {
+		return c, nil
+	}
+
+	// Compile lua file
+	proto, err := CompileLua(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiledProto{
+		proto:   proto,
+		source:  source,
+		modTime: modTime,
+	}
+	s.cache[source] = c
+
+	return c, nil
+}
+
+// Get retrieves a compiled lua function proto. The file is compiled again if
+// it was modified since the last compilation. The source file is checked without
+// holding the lock so requests do not wait on each other
 func (s *compiledStateList) Get(path string) (*glua.FunctionProto, error) {
+	// Find compiled file
+	s.rw.RLock()
+
 	path = strings.ToLower(path)
-	for p, proto := range s.List {
+	name := ""
+	var c *compiledProto
+
+	for p, compiled := range s.List {
 		if strings.ToLower(p) == path {
-			return proto, nil
+			name, c = p, compiled
+			break
 		}
 	}
-	return nil, errors.New("Compiled lua proto not found")
+
+	s.rw.RUnlock()
+
+	if c == nil {
+		return nil, errors.New("Compiled lua proto not found")
+	}
+
+	// Check source modification time
+	info, err := os.Stat(c.source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.ModTime().Equal(c.modTime) {
+		return c.proto, nil
+	}
+
+	// Compile modified file
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	compiled, err := s.compile(c.source, info.ModTime())
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the list unchanged if it was reloaded meanwhile
+	if s.List[name] == c {
+		s.List[name] = compiled
+	}
+
+	return compiled.proto, nil
+}
+
+// Load returns the compiled proto of the given file. The file is compiled on the first
+// load and again when it is modified
+func (s *compiledStateList) Load(path string) (*glua.FunctionProto, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	// Check source modification time
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.compile(path, info.ModTime())
+	if err != nil {
+		return nil, err
+	}
+	s.List[path] = c
+
+	return c.proto, nil
 }
 
 // Load loads the given state list
@@ -294,6 +398,9 @@ func (s *stateList) Get(path string) (*glua.LState, error) {
 	x := s.List[path][len(s.List[path])-1]
 	s.List[path] = s.List[path][0 : len(s.List[path])-1]
 
+	// Refresh outdated app global
+	refreshConfigGlobal(x)
+
 	return x, nil
 }
 
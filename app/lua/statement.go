@@ -0,0 +1,269 @@
+package lua
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/util"
+	"github.com/yuin/gopher-lua"
+)
+
+// preparedStatement a prepared statement shared by all the handles of the same query
+type preparedStatement struct {
+	query string
+	stmt  *sqlx.Stmt
+	refs  int
+}
+
+// statementHandle a lua reference to a prepared statement
+type statementHandle struct {
+	statement *preparedStatement
+}
+
+var (
+	// preparedStatements list of prepared statements by query
+	preparedStatements = map[string]*preparedStatement{}
+
+	// preparedStatementsMutex mutex used for the prepared statement list
+	preparedStatementsMutex sync.Mutex
+)
+
+// newStatementHandle returns a handle to the prepared statement of the given query
+func newStatementHandle(query string) (*statementHandle, error) {
+	// Lock mutex
+	preparedStatementsMutex.Lock()
+	defer preparedStatementsMutex.Unlock()
+
+	// Get prepared statement
+	statement, ok := preparedStatements[query]
+
+	if !ok {
+
+		// Prepare query
+		stmt, err := database.DB.Preparex(query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Save prepared statement
+		statement = &preparedStatement{
+			query: query,
+			stmt:  stmt,
+		}
+
+		preparedStatements[query] = statement
+	}
+
+	// Increase handle count
+	statement.refs++
+
+	// Create handle
+	handle := &statementHandle{
+		statement: statement,
+	}
+
+	// Release statement when the handle is garbage collected
+	runtime.SetFinalizer(handle, releaseStatementHandle)
+
+	return handle, nil
+}
+
+// releaseStatementHandle closes the prepared statement once there are no handles left
+func releaseStatementHandle(handle *statementHandle) {
+	// Lock mutex
+	preparedStatementsMutex.Lock()
+	defer preparedStatementsMutex.Unlock()
+
+	// Decrease handle count
+	handle.statement.refs--
+
+	if handle.statement.refs > 0 {
+		return
+	}
+
+	// Close statement
+	if err := handle.statement.stmt.Close(); err != nil {
+		util.Logger.Logger.Errorf("Cannot close prepared statement: %v", err)
+	}
+
+	// Remove statement from the list
+	delete(preparedStatements, handle.statement.query)
+}
+
+// PrepareStatement prepares the given query returning a statement object
+func PrepareStatement(L *lua.LState) int {
+	// Get query
+	query := L.Get(2)
+
+	// Check if query is valid
+	if query.Type() != lua.LTString {
+		L.ArgError(1, "Invalid query type. Expected string")
+		return 0
+	}
+
+	// Get statement handle
+	handle, err := newStatementHandle(query.String())
+
+	if err != nil {
+		L.RaiseError("Cannot prepare query: %v", err)
+		return 0
+	}
+
+	// Create statement table
+	tbl := L.NewTable()
+
+	// Set user data
+	u := L.NewUserData()
+	u.Value = handle
+
+	// Set user data field
+	L.SetField(tbl, "__stmt", u)
+
+	// Set all statement functions
+	L.SetFuncs(tbl, statementMethods)
+
+	// Push statement table
+	L.Push(tbl)
+
+	return 1
+}
+
+// getStatementHandle gets the statement handle from the statement table
+func getStatementHandle(L *lua.LState) *statementHandle {
+	// Get statement table
+	tbl := L.ToTable(1)
+
+	if tbl == nil {
+		L.ArgError(0, "Invalid statement. Expected statement object")
+		return nil
+	}
+
+	// Get user data field
+	u, ok := L.GetField(tbl, "__stmt").(*lua.LUserData)
+
+	if !ok {
+		L.ArgError(0, "Invalid statement. Expected statement object")
+		return nil
+	}
+
+	return u.Value.(*statementHandle)
+}
+
+// getStatementArgs gets the statement params from the given table
+func getStatementArgs(L *lua.LState, handle *statementHandle) ([]interface{}, bool) {
+	// Arguments holder
+	args := []interface{}{}
+
+	switch params := L.Get(2).(type) {
+	case *lua.LTable:
+
+		// Get all params in order
+		for i := 1; i <= params.Len(); i++ {
+			args = append(args, params.RawGetInt(i).String())
+		}
+
+	case *lua.LNilType:
+	default:
+		L.ArgError(1, "Invalid query params. Expected table")
+		return nil, false
+	}
+
+	// Log query on development mode
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
+		util.Logger.Logger.Infof("statement: "+strings.Replace(handle.statement.query, "?", "%v", -1), args...)
+	}
+
+	return args, true
+}
+
+// StatementQuery runs the prepared statement returning all the rows
+func StatementQuery(L *lua.LState) int {
+	// Get statement handle
+	handle := getStatementHandle(L)
+
+	if handle == nil {
+		return 0
+	}
+
+	// Get params
+	args, ok := getStatementArgs(L, handle)
+
+	if !ok {
+		return 0
+	}
+
+	// Run query
+	rows, err := handle.statement.stmt.Queryx(args...)
+
+	if err != nil {
+		L.RaiseError("Cannot execute query: %v", err)
+		return 0
+	}
+
+	// Close rows
+	defer rows.Close()
+
+	// Scan rows to lua table
+	results, err := scanQueryRows(L, rows)
+
+	if err != nil {
+		L.RaiseError("Cannot map row to map: %v", err)
+		return 0
+	}
+
+	// Push results
+	L.Push(results)
+
+	return 1
+}
+
+// StatementExecute runs the prepared statement returning the last inserted id and the number of affected rows
+func StatementExecute(L *lua.LState) int {
+	// Get statement handle
+	handle := getStatementHandle(L)
+
+	if handle == nil {
+		return 0
+	}
+
+	// Get params
+	args, ok := getStatementArgs(L, handle)
+
+	if !ok {
+		return 0
+	}
+
+	// Execute statement
+	result, err := handle.statement.stmt.Exec(args...)
+
+	if err != nil {
+		L.RaiseError("Cannot execute query: %v", err)
+		return 0
+	}
+
+	// Get last inserted id
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		L.RaiseError("Cannot get last inserted id: %v", err)
+		return 0
+	}
+
+	// Get number of affected rows
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		L.RaiseError("Cannot get affected rows: %v", err)
+		return 0
+	}
+
+	// Push results
+	L.Push(lua.LNumber(id))
+	L.Push(lua.LNumber(affected))
+
+	return 2
+}
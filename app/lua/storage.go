@@ -2,6 +2,7 @@ package lua
 
 import (
 	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/models"
 	"github.com/yuin/gopher-lua"
 )
 
@@ -64,3 +65,35 @@ func SetStorageValue(L *lua.LState) int {
 
 	return 0
 }
+
+// maxClaimKeyLength longest key accepted by claimOnce
+const maxClaimKeyLength = 255
+
+// ClaimOnce atomically records the given key. Returns true only the first time a key is
+// claimed so duplicated payment callbacks can be ignored
+func ClaimOnce(L *lua.LState) int {
+	// Get claim key
+	key := L.Get(2)
+
+	if key.Type() != lua.LTString && key.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid key type. Expected string")
+		return 0
+	}
+
+	if len(key.String()) == 0 || len(key.String()) > maxClaimKeyLength {
+		L.ArgError(1, "Invalid key length. Expected between 1 and 255 characters")
+		return 0
+	}
+
+	// Claim key
+	claimed, err := models.ClaimOnce(key.String())
+
+	if err != nil {
+		L.RaiseError("Cannot claim key: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(claimed))
+
+	return 1
+}
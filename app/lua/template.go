@@ -0,0 +1,145 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+)
+
+var (
+	// templateFuncs functions registered with template.registerFunc
+	templateFuncs = &templateFuncList{
+		protos: map[string]*lua.FunctionProto{},
+	}
+
+	// templateFuncName valid template function names
+	templateFuncName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// templateFuncList list of compiled template functions
+type templateFuncList struct {
+	rw     sync.RWMutex
+	protos map[string]*lua.FunctionProto
+}
+
+// SetTemplateMetaTable sets the template metatable of the given state
+func SetTemplateMetaTable(luaState *lua.LState) {
+	// Create and set the template metatable
+	templateMetaTable := luaState.NewTypeMetatable(TemplateMetaTableName)
+	luaState.SetGlobal(TemplateMetaTableName, templateMetaTable)
+
+	// Set all template metatable functions
+	luaState.SetFuncs(templateMetaTable, templateMethods)
+}
+
+// RegisterTemplateFunc registers a lua function usable inside templates. Template functions
+// run on a pooled state so they cannot use upvalues. Functions must be registered on
+// engine/templatefuncs.lua since templates are parsed right after that file runs
+func RegisterTemplateFunc(L *lua.LState) int {
+	// Get function name
+	name := L.Get(2)
+
+	// Check for valid name type
+	if name.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid function name type. Expected string")
+		return 0
+	}
+
+	if !templateFuncName.MatchString(name.String()) {
+		L.ArgError(1, "Invalid function name. Expected an identifier")
+		return 0
+	}
+
+	// Get function
+	f := L.Get(3)
+
+	if f.Type() != lua.LTFunction {
+		L.ArgError(2, "Invalid template function type. Expected function")
+		return 0
+	}
+
+	// Get lua function
+	fn := f.(*lua.LFunction)
+
+	if fn.IsG || fn.Proto.NumUpvalues > 0 {
+		L.ArgError(2, "Template functions cannot be Go functions or use upvalues")
+		return 0
+	}
+
+	// Save function proto
+	templateFuncs.rw.Lock()
+	templateFuncs.protos[name.String()] = fn.Proto
+	templateFuncs.rw.Unlock()
+
+	return 0
+}
+
+// TemplateFuncMap returns the registered lua template functions as a template FuncMap
+func TemplateFuncMap() template.FuncMap {
+	templateFuncs.rw.RLock()
+	defer templateFuncs.rw.RUnlock()
+
+	funcs := template.FuncMap{}
+
+	for name, proto := range templateFuncs.protos {
+		funcs[name] = templateFuncCaller(name, proto)
+	}
+
+	return funcs
+}
+
+// templateFuncCaller returns a template function that executes the given proto on a pooled state
+func templateFuncCaller(name string, proto *lua.FunctionProto) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		// Get a lua state from the pool
+		state := Pool.Get()
+
+		// Return state
+		defer Pool.Put(state)
+
+		// Convert arguments
+		params := make([]lua.LValue, 0, len(args))
+
+		for _, arg := range args {
+			params = append(params, templateArgToLua(arg))
+		}
+
+		// Call template function
+		if err := state.CallByParam(lua.P{
+			Fn:      state.NewFunctionFromProto(proto),
+			NRet:    1,
+			Protect: true,
+		}, params...); err != nil {
+			return nil, fmt.Errorf("Cannot execute template function %v: %v", name, err)
+		}
+
+		// Get returned value
+		ret := state.Get(-1)
+		state.Pop(1)
+
+		if ret.Type() == lua.LTFunction || ret.Type() == lua.LTUserData {
+			return nil, errors.New("Template function " + name + " returned an invalid value")
+		}
+
+		return ValueToGo(ret), nil
+	}
+}
+
+// templateArgToLua converts a template argument to a lua value
+func templateArgToLua(v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case int:
+		return lua.LNumber(val)
+	case template.HTML:
+		return lua.LString(val)
+	}
+
+	return MapToTable(map[string]interface{}{"value": v}).RawGetString("value")
+}
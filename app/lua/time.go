@@ -2,6 +2,8 @@ package lua
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/raggaer/castro/app/util"
@@ -19,6 +21,25 @@ type Date struct {
 	Result string
 }
 
+// durationUnit unit used when humanizing durations
+type durationUnit struct {
+	name    string
+	seconds int64
+	one     string
+	other   string
+	short   string
+}
+
+// durationUnits units used by humanizeDuration from the largest to the smallest. The strings are
+// the fallback translations of the duration.<name>, duration.<name>s and duration.short.<name> keys
+var durationUnits = []durationUnit{
+	{"year", 365 * 24 * 60 * 60, "{n} year", "{n} years", "{n}y"},
+	{"day", 24 * 60 * 60, "{n} day", "{n} days", "{n}d"},
+	{"hour", 60 * 60, "{n} hour", "{n} hours", "{n}h"},
+	{"minute", 60, "{n} minute", "{n} minutes", "{n}m"},
+	{"second", 1, "{n} second", "{n} seconds", "{n}s"},
+}
+
 // SetTimeMetaTable sets the time metatable of the given state
 func SetTimeMetaTable(luaState *lua.LState) {
 	// Create and set the time metatable
@@ -182,3 +203,106 @@ func ParseDate(L *lua.LState) int {
 
 	return 1
 }
+
+// HumanizeDuration converts the given number of seconds to a "2 days, 3 hours" string. An options
+// table with the short ("2d 3h"), maxUnits (default 2) and locale fields can be given. Unit names
+// are translated using the duration.* i18n keys. Zero and negative durations return "expired"
+func HumanizeDuration(L *lua.LState) int {
+	// Get seconds
+	seconds := L.Get(2)
+
+	if seconds.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid seconds type. Expected number")
+		return 0
+	}
+
+	// Get options
+	short := false
+	maxUnits := 2
+	locales := []string{}
+
+	switch opts := L.Get(3); opts.Type() {
+	case lua.LTTable:
+		tbl := opts.(*lua.LTable)
+		short = lua.LVAsBool(tbl.RawGetString("short"))
+
+		if v := tbl.RawGetString("maxUnits"); v != lua.LNil {
+			n, ok := v.(lua.LNumber)
+
+			if !ok || n < 1 {
+				L.ArgError(2, "Invalid maxUnits. Expected number greater than zero")
+				return 0
+			}
+
+			maxUnits = int(n)
+		}
+
+		if locale, ok := tbl.RawGetString("locale").(lua.LString); ok {
+			locales = append(locales, string(locale))
+		}
+	case lua.LTNil:
+	default:
+		L.ArgError(2, "Invalid options type. Expected table")
+		return 0
+	}
+
+	if len(locales) == 0 {
+		locales = requestLocales(L)
+	}
+
+	// Translate the given key using the fallback string when missing
+	translate := func(key, fallback string, n int64) string {
+		str, ok := util.LanguageFiles.Translate(key, locales)
+
+		if !ok {
+			str = fallback
+		}
+
+		return util.InterpolateTranslation(str, map[string]string{
+			"n": strconv.FormatInt(n, 10),
+		})
+	}
+
+	// Expired durations
+	remaining := int64(seconds.(lua.LNumber))
+
+	if remaining <= 0 {
+		L.Push(lua.LString(translate("duration.expired", "expired", 0)))
+		return 1
+	}
+
+	// Split duration into units
+	parts := []string{}
+
+	for _, unit := range durationUnits {
+		if len(parts) >= maxUnits {
+			break
+		}
+
+		n := remaining / unit.seconds
+
+		if n == 0 {
+			continue
+		}
+
+		remaining -= n * unit.seconds
+
+		switch {
+		case short:
+			parts = append(parts, translate("duration.short."+unit.name, unit.short, n))
+		case n == 1:
+			parts = append(parts, translate("duration."+unit.name, unit.one, n))
+		default:
+			parts = append(parts, translate("duration."+unit.name+"s", unit.other, n))
+		}
+	}
+
+	// Join units
+	if short {
+		L.Push(lua.LString(strings.Join(parts, " ")))
+	} else {
+		L.Push(lua.LString(strings.Join(parts, ", ")))
+	}
+
+	return 1
+}
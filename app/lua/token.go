@@ -0,0 +1,134 @@
+package lua
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/raggaer/castro/app/models"
+	"github.com/yuin/gopher-lua"
+)
+
+// SetTokenMetaTable sets the token metatable of the given state
+func SetTokenMetaTable(luaState *lua.LState) {
+	// Create and set the token metatable
+	tokenMetaTable := luaState.NewTypeMetatable(TokenMetaTableName)
+	luaState.SetGlobal(TokenMetaTableName, tokenMetaTable)
+
+	// Set all token metatable functions
+	luaState.SetFuncs(tokenMetaTable, tokenMethods)
+}
+
+// CreateToken creates a single-use token for the given purpose. The token expires after
+// the given ttl (duration string or seconds)
+func CreateToken(L *lua.LState) int {
+	// Get purpose
+	purpose := L.Get(2)
+
+	// Check for valid purpose type
+	if purpose.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid purpose type. Expected string")
+		return 0
+	}
+
+	// Get data
+	data := L.Get(3)
+
+	// Encode data as JSON
+	var v interface{}
+
+	if data != lua.LNil {
+		converted, err := sessionValueToGo(data, map[*lua.LTable]bool{}, "token data")
+
+		if err != nil {
+			L.ArgError(2, err.Error())
+			return 0
+		}
+
+		v = converted
+	}
+
+	encoded, err := json.Marshal(v)
+
+	if err != nil {
+		L.RaiseError("Cannot encode token data: %v", err)
+		return 0
+	}
+
+	// Get time to live
+	var ttl time.Duration
+
+	switch t := L.Get(4); t.Type() {
+	case lua.LTNumber:
+		ttl = time.Duration(float64(t.(lua.LNumber)) * float64(time.Second))
+	case lua.LTString:
+		d, err := time.ParseDuration(t.String())
+
+		if err != nil {
+			L.ArgError(3, "Invalid ttl format. Unexpected format")
+			return 0
+		}
+
+		ttl = d
+	default:
+		L.ArgError(3, "Invalid ttl type. Expected string or number")
+		return 0
+	}
+
+	if ttl <= 0 {
+		L.ArgError(3, "Invalid ttl. Expected a positive duration")
+		return 0
+	}
+
+	// Create token
+	token, err := models.CreateToken(purpose.String(), string(encoded), ttl)
+
+	if err != nil {
+		L.RaiseError("Cannot create token: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(token))
+
+	return 1
+}
+
+// ConsumeToken returns the data and purpose of the given token invalidating it. Returns
+// nil if the token does not exist, expired or was already consumed
+func ConsumeToken(L *lua.LState) int {
+	// Get token
+	token := L.Get(2)
+
+	// Check for valid token type
+	if token.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid token type. Expected string")
+		return 0
+	}
+
+	// Consume token
+	t, ok, err := models.ConsumeToken(token.String())
+
+	if err != nil {
+		L.RaiseError("Cannot consume token: %v", err)
+		return 0
+	}
+
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Decode token data
+	var data interface{}
+
+	if err := json.Unmarshal([]byte(t.Data), &data); err != nil {
+		L.RaiseError("Cannot decode token data: %v", err)
+		return 0
+	}
+
+	L.Push(sessionValueToLua(data))
+	L.Push(lua.LString(t.Purpose))
+
+	return 2
+}
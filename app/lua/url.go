@@ -1,9 +1,11 @@
 package lua
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
-	"net/url"
 )
 
 // SetURLMetaTable sets the url metatable of the given state
@@ -55,3 +57,162 @@ func EncodeURL(L *lua.LState) int {
 
 	return 1
 }
+
+// BuildURL joins the given base url with a path and an encoded query string
+func BuildURL(L *lua.LState) int {
+	// Get base url
+	base := L.Get(2)
+
+	// Check for valid base type
+	if base.Type() != lua.LTString {
+		L.ArgError(1, "Invalid base url type. Expected string")
+		return 0
+	}
+
+	// Parse base url
+	u, err := url.Parse(base.String())
+
+	if err != nil {
+		L.ArgError(1, "Invalid base url: "+err.Error())
+		return 0
+	}
+
+	// Get options table
+	opts := L.Get(3)
+
+	// Push base url if there are no options
+	if opts.Type() != lua.LTTable {
+		L.Push(lua.LString(u.String()))
+		return 1
+	}
+
+	// Get path segment
+	path := opts.(*lua.LTable).RawGetString("path")
+
+	if path.Type() == lua.LTString && path.String() != "" {
+
+		// Join paths without duplicated slashes
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path.String(), "/")
+	}
+
+	// Get query table
+	query := opts.(*lua.LTable).RawGetString("query")
+
+	if query.Type() == lua.LTTable {
+
+		// Keep any query values already present on the base url
+		values := u.Query()
+
+		// Loop query table
+		query.(*lua.LTable).ForEach(func(key lua.LValue, v lua.LValue) {
+
+			// Multiple values are given as a list
+			if list, ok := v.(*lua.LTable); ok {
+				list.ForEach(func(_ lua.LValue, item lua.LValue) {
+					values.Add(key.String(), item.String())
+				})
+				return
+			}
+
+			values.Set(key.String(), v.String())
+		})
+
+		// Encode sorts by key so the result is stable
+		u.RawQuery = values.Encode()
+	}
+
+	// Push result url
+	L.Push(lua.LString(u.String()))
+
+	return 1
+}
+
+// ParseQueryString decodes the given query string into a table
+func ParseQueryString(L *lua.LState) int {
+	// Get query string
+	query := L.Get(2)
+
+	// Check for valid query type
+	if query.Type() != lua.LTString {
+		L.ArgError(1, "Invalid query type. Expected string")
+		return 0
+	}
+
+	// Parse query ignoring malformed pairs
+	values, err := url.ParseQuery(strings.TrimPrefix(query.String(), "?"))
+
+	if err != nil && (util.Config.Get().IsDev() || util.Config.Get().IsLog()) {
+		util.Logger.Logger.Errorf("Malformed query string: %v", err)
+	}
+
+	// Result table
+	tbl := L.NewTable()
+
+	// Loop values
+	for key, v := range values {
+
+		// Single values are set as strings
+		if len(v) == 1 {
+			tbl.RawSetString(key, lua.LString(v[0]))
+			continue
+		}
+
+		// Multiple values are set as a list
+		tbl.RawSetString(key, StringSliceToTable(v))
+	}
+
+	// Push result table
+	L.Push(tbl)
+
+	return 1
+}
+
+// SlugifyURL converts the given text to a lowercase slug suitable for URLs
+func SlugifyURL(L *lua.LState) int {
+	// Get text
+	text := L.Get(2)
+
+	// Check for valid text type
+	if text.Type() != lua.LTString {
+		L.ArgError(1, "Invalid text type. Expected string")
+		return 0
+	}
+
+	// Push slug
+	L.Push(lua.LString(util.Slugify(text.String())))
+
+	return 1
+}
+
+// SignURL returns the given url with an expiration time and a signature. The ttl can be a
+// number of seconds or a duration string. Signed urls are checked with http.verifySignedURL
+func SignURL(L *lua.LState) int {
+	// Get url
+	uri := L.Get(2)
+
+	// Check for valid url type
+	if uri.Type() != lua.LTString {
+		L.ArgError(1, "Invalid url type. Expected string")
+		return 0
+	}
+
+	// Get time to live
+	ttl, ok := durationFromValue(L.Get(3))
+
+	if !ok {
+		L.ArgError(2, "Invalid ttl. Expected positive number of seconds or duration string")
+		return 0
+	}
+
+	// Sign url
+	signed, err := util.SignURL(uri.String(), ttl)
+
+	if err != nil {
+		L.ArgError(1, "Invalid url: "+err.Error())
+		return 0
+	}
+
+	L.Push(lua.LString(signed))
+
+	return 1
+}
@@ -3,6 +3,7 @@ package lua
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"net/url"
 	"reflect"
 	"strconv"
@@ -17,93 +18,105 @@ type castroInterfaceField struct {
 	Type reflect.Kind
 }
 
-// MapToTable converts a Go map to a lua table
+// MapToTable converts a Go map to a lua table. Nested maps and slices are converted recursively
 func MapToTable(m map[string]interface{}) *lua.LTable {
 	// Main table pointer
 	resultTable := &lua.LTable{}
 
 	// Loop map
 	for key, element := range m {
+		resultTable.RawSetString(key, ValueToLua(element))
+	}
 
-		switch element.(type) {
-		case float64:
-			resultTable.RawSetString(key, lua.LNumber(element.(float64)))
-		case int64:
-			resultTable.RawSetString(key, lua.LNumber(element.(int64)))
-		case string:
-			resultTable.RawSetString(key, lua.LString(element.(string)))
-		case bool:
-			resultTable.RawSetString(key, lua.LBool(element.(bool)))
-		case []byte:
-			resultTable.RawSetString(key, lua.LString(string(element.([]byte))))
-		case map[string]interface{}:
-
-			// Get table from map
-			tble := MapToTable(element.(map[string]interface{}))
-
-			resultTable.RawSetString(key, tble)
-
-		case time.Time:
-			resultTable.RawSetString(key, lua.LNumber(element.(time.Time).Unix()))
-
-		case []map[string]interface{}:
-
-			// Create slice table
-			sliceTable := &lua.LTable{}
-
-			// Loop element
-			for _, s := range element.([]map[string]interface{}) {
-
-				// Get table from map
-				tble := MapToTable(s)
-
-				sliceTable.Append(tble)
-			}
+	return resultTable
+}
 
-			// Set slice table
-			resultTable.RawSetString(key, sliceTable)
+// ValueToLua converts a Go value to a lua value. Maps with string keys, slices and structs are
+// converted recursively. Unsupported values are converted to nil
+func ValueToLua(v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(val)
+	case []byte:
+		return lua.LString(string(val))
+	case bool:
+		return lua.LBool(val)
+	case float64:
+		return lua.LNumber(val)
+	case int64:
+		return lua.LNumber(val)
+	case time.Time:
+		return lua.LNumber(val.Unix())
+	case sql.NullString:
+		if !val.Valid {
+			return lua.LNil
+		}
 
-		case []interface{}:
+		return lua.LString(val.String)
+	case map[string]interface{}:
+		return MapToTable(val)
+	}
 
-			// Create slice table
-			sliceTable := &lua.LTable{}
+	// Convert any other value using reflection
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lua.LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float())
+	case reflect.String:
+		return lua.LString(rv.String())
+	case reflect.Bool:
+		return lua.LBool(rv.Bool())
+	case reflect.Slice, reflect.Array:
+
+		// Create slice table
+		sliceTable := &lua.LTable{}
+
+		for i := 0; i < rv.Len(); i++ {
+			sliceTable.RawSetInt(i+1, ValueToLua(rv.Index(i).Interface()))
+		}
 
-			// Loop interface slice
-			for _, s := range element.([]interface{}) {
+		return sliceTable
 
-				// Switch interface type
-				switch s.(type) {
-				case map[string]interface{}:
+	case reflect.Map:
 
-					// Convert map to table
-					t := MapToTable(s.(map[string]interface{}))
+		// Only string keys can be converted
+		if rv.Type().Key().Kind() != reflect.String {
+			return lua.LNil
+		}
 
-					// Append result
-					sliceTable.Append(t)
+		// Create map table
+		mapTable := &lua.LTable{}
 
-				case float64:
+		for _, key := range rv.MapKeys() {
+			mapTable.RawSetString(key.String(), ValueToLua(rv.MapIndex(key).Interface()))
+		}
 
-					// Append result as number
-					sliceTable.Append(lua.LNumber(s.(float64)))
+		return mapTable
 
-				case string:
+	case reflect.Ptr:
+		if rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+			return lua.LNil
+		}
 
-					// Append result as string
-					sliceTable.Append(lua.LString(s.(string)))
+		return StructToTable(v)
 
-				case bool:
+	case reflect.Struct:
 
-					// Append result as bool
-					sliceTable.Append(lua.LBool(s.(bool)))
-				}
-			}
+		// Copy struct so it can be addressed
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
 
-			// Append to main table
-			resultTable.RawSetString(key, sliceTable)
-		}
+		return StructToTable(ptr.Interface())
 	}
 
-	return resultTable
+	return lua.LNil
 }
 
 // TableToMap converts a LUA table to a Go map[string]interface{}
@@ -154,8 +167,8 @@ func TableToMap(table *lua.LTable) map[string]interface{} {
 
 		case lua.LNumber:
 
-			// Convert to number to float64
-			m[index] = float64(lv)
+			// Convert number to int64 or float64
+			m[index] = numberToGo(lv)
 
 		case lua.LBool:
 
@@ -252,7 +265,7 @@ func ValueToGo(lv lua.LValue) interface{} {
 	case lua.LString:
 		return string(v)
 	case lua.LNumber:
-		return float64(v)
+		return numberToGo(v)
 	case *lua.LTable:
 		maxn := v.MaxN()
 		if maxn == 0 { // table
@@ -277,6 +290,18 @@ func ValueToGo(lv lua.LValue) interface{} {
 	}
 }
 
+// numberToGo converts a lua number to int64 when it has no fractional part so
+// templates print integers instead of exponent notation
+func numberToGo(v lua.LNumber) interface{} {
+	f := float64(v)
+
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return int64(f)
+	}
+
+	return f
+}
+
 // MergeTableFields merges two tables into one
 func MergeTableFields(src *lua.LTable, dest *lua.LTable) {
 	src.ForEach(func(k lua.LValue, v lua.LValue) {
@@ -284,7 +309,7 @@ func MergeTableFields(src *lua.LTable, dest *lua.LTable) {
 	})
 }
 
-// StructToTable converts a go struct pointer to a lua table
+// StructToTable converts a go struct pointer to a lua table. Nested structs, maps and slices are converted recursively
 func StructToTable(s interface{}) *lua.LTable {
 	// Data holder
 	t := &lua.LTable{}
@@ -298,6 +323,11 @@ func StructToTable(s interface{}) *lua.LTable {
 		// Get current field
 		field := elem.Field(i)
 
+		// Skip unexported fields
+		if !field.CanInterface() {
+			continue
+		}
+
 		// Get field name
 		fieldName := elem.Type().Field(i).Name
 
@@ -377,6 +407,11 @@ func StructToTable(s interface{}) *lua.LTable {
 
 			// Set value
 			t.RawSetString(fieldName, holder)
+
+		default:
+
+			// Convert nested structs, maps and slices
+			t.RawSetString(fieldName, ValueToLua(inter))
 		}
 	}
 
@@ -0,0 +1,47 @@
+package lua
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestTableToMapTemplateNumbers(t *testing.T) {
+	// Create table like the ones returned by db:query
+	tbl := &lua.LTable{}
+	tbl.RawSetString("experience", lua.LNumber(15000000))
+	tbl.RawSetString("balance", lua.LNumber(9007199254740992))
+	tbl.RawSetString("rate", lua.LNumber(1.5))
+
+	tmpl := template.Must(template.New("test").Parse("{{ .experience }} {{ .balance }} {{ .rate }}"))
+
+	buff := &bytes.Buffer{}
+
+	if err := tmpl.Execute(buff, TableToMap(tbl)); err != nil {
+		t.Fatalf("cannot execute template: %v", err)
+	}
+
+	if want := "15000000 9007199254740992 1.5"; buff.String() != want {
+		t.Errorf("template output is %q. Expected %q", buff.String(), want)
+	}
+}
+
+func TestValueToGoNumbers(t *testing.T) {
+	tests := []struct {
+		value lua.LNumber
+		want  interface{}
+	}{
+		{lua.LNumber(0), int64(0)},
+		{lua.LNumber(-25), int64(-25)},
+		{lua.LNumber(15000000), int64(15000000)},
+		{lua.LNumber(0.25), 0.25},
+	}
+
+	for _, test := range tests {
+		if v := ValueToGo(test.value); v != test.want {
+			t.Errorf("value %v converted to %v (%T). Expected %v (%T)", test.value, v, v, test.want, test.want)
+		}
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/yuin/gopher-lua"
 )
 
+// validUsernameRegexp regular expression of valid character names
+var validUsernameRegexp = regexp.MustCompile("^[a-zA-Z ]+$")
+
 // methods holds all the validation methods related to govalidator
 var methods = map[string]govalidator.Validator{
 	"IsURL":          govalidator.IsURL,
@@ -44,6 +47,22 @@ func CheckQRCode(L *lua.LState) int {
 	// Get secret key
 	secret := L.ToString(3)
 
+	// Validate token
+	valid, err := verifyTOTP(token, secret)
+
+	if err != nil {
+		L.RaiseError("Cannot authenticate token: %v", err)
+		return 0
+	}
+
+	// Push status of validation as bool
+	L.Push(lua.LBool(valid))
+
+	return 1
+}
+
+// verifyTOTP checks if the given time-based token is valid for the given secret key
+func verifyTOTP(token, secret string) (bool, error) {
 	// Create two-factor config
 	otpConfig := &dgoogauth.OTPConfig{
 		Secret:      secret,
@@ -52,24 +71,14 @@ func CheckQRCode(L *lua.LState) int {
 	}
 
 	// Validate token
-	s, err := otpConfig.Authenticate(token)
+	valid, err := otpConfig.Authenticate(token)
 
-	if err != nil {
-
-		// Check for invalid code
-		if err == dgoogauth.ErrInvalidCode {
-			L.Push(lua.LBool(false))
-			return 1
-		}
-
-		L.RaiseError("Cannot authenticate token: %v", err)
-		return 0
+	// Check for invalid code
+	if err == dgoogauth.ErrInvalidCode {
+		return false, nil
 	}
 
-	// Push status of validation as bool
-	L.Push(lua.LBool(s))
-
-	return 1
+	return valid, err
 }
 
 // ValidGender checks if the given gender is valid
@@ -312,19 +321,17 @@ func ValidUsername(L *lua.LState) int {
 		return 0
 	}
 
-	// Check against regexp
-	match, err := regexp.MatchString("^[a-zA-Z ]+$", v.String())
-
-	if err != nil {
-		L.RaiseError("Cannot compare string against regexp: %v", err)
-	}
-
 	// Push regexp result
-	L.Push(lua.LBool(match))
+	L.Push(lua.LBool(isValidUsername(v.String())))
 
 	return 1
 }
 
+// isValidUsername checks if the given string is a valid character name
+func isValidUsername(name string) bool {
+	return validUsernameRegexp.MatchString(name)
+}
+
 // Validate executes the given govalidator func and returns its output
 func Validate(L *lua.LState) int {
 	// Get function name
@@ -0,0 +1,392 @@
+package lua
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// websocketMaxPayload maximum size of a received message
+	websocketMaxPayload = 64 * 1024
+
+	// websocketWriteTimeout maximum time a message send can take
+	websocketWriteTimeout = 10 * time.Second
+)
+
+// websocketChannels connections grouped by channel name
+var websocketChannels = &websocketHub{
+	channels: map[string]map[*websocketConn]bool{},
+}
+
+// websocketHub mutex guarded list of channels
+type websocketHub struct {
+	rw       sync.RWMutex
+	channels map[string]map[*websocketConn]bool
+}
+
+// websocketConn upgraded connection of a request
+type websocketConn struct {
+	ws     *websocket.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// SetWebSocketMetaTable sets the websocket metatable of the given state
+func SetWebSocketMetaTable(luaState *lua.LState) {
+	// Create and set the websocket metatable
+	websocketMetaTable := luaState.NewTypeMetatable(WebSocketMetaTableName)
+	luaState.SetGlobal(WebSocketMetaTableName, websocketMetaTable)
+
+	// Set all websocket metatable functions
+	luaState.SetFuncs(websocketMetaTable, websocketMethods)
+}
+
+// join adds the connection to the given channel
+func (h *websocketHub) join(channel string, c *websocketConn) {
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	if h.channels[channel] == nil {
+		h.channels[channel] = map[*websocketConn]bool{}
+	}
+
+	h.channels[channel][c] = true
+}
+
+// leave removes the connection from the given channel
+func (h *websocketHub) leave(channel string, c *websocketConn) {
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	delete(h.channels[channel], c)
+
+	if len(h.channels[channel]) == 0 {
+		delete(h.channels, channel)
+	}
+}
+
+// leaveAll removes the connection from every channel
+func (h *websocketHub) leaveAll(c *websocketConn) {
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	for channel, conns := range h.channels {
+		delete(conns, c)
+
+		if len(conns) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+}
+
+// members returns the connections of the given channel
+func (h *websocketHub) members(channel string) []*websocketConn {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+
+	conns := make([]*websocketConn, 0, len(h.channels[channel]))
+
+	for c := range h.channels[channel] {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// send writes a text message to the connection. Failed connections are closed
+func (c *websocketConn) send(msg string) error {
+	c.ws.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+
+	if err := websocket.Message.Send(c.ws, msg); err != nil {
+		c.close()
+		return err
+	}
+
+	return nil
+}
+
+// close closes the connection and removes it from every channel
+func (c *websocketConn) close() {
+	c.once.Do(func() {
+		websocketChannels.leaveAll(c)
+		c.ws.Close()
+		close(c.closed)
+	})
+}
+
+// checkWebSocketOrigin rejects handshakes coming from other sites
+func checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	// Get origin header
+	origin, err := websocket.Origin(config, req)
+
+	if err != nil {
+		return err
+	}
+
+	if origin == nil || origin.Host != req.Host {
+		return errors.New("Invalid websocket origin")
+	}
+
+	config.Origin = origin
+
+	return nil
+}
+
+// UpgradeWebSocket upgrades the current request to a websocket connection. The connection
+// stays open until it is closed, the client disconnects or the page function returns
+func UpgradeWebSocket(L *lua.LState) int {
+	// Get request and response writer
+	req, w := getRequestAndResponseWriter(L)
+
+	c := &websocketConn{
+		closed: make(chan struct{}),
+	}
+
+	upgraded := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	// Create websocket server
+	server := websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = websocketMaxPayload
+
+			// Remove the HTTP server deadlines
+			ws.SetDeadline(time.Time{})
+
+			c.ws = ws
+			upgraded <- struct{}{}
+
+			// Keep the connection open while the request is running
+			select {
+			case <-c.closed:
+			case <-req.Context().Done():
+			}
+
+			c.close()
+		},
+	}
+
+	// The handshake needs to take over the connection
+	if _, ok := w.(http.Hijacker); !ok {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("Cannot upgrade connection to websocket"))
+		return 2
+	}
+
+	// Serve handshake. The handler runs until the connection is closed
+	go func() {
+		server.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	select {
+	case <-upgraded:
+	case <-done:
+		L.Push(lua.LNil)
+		L.Push(lua.LString("Cannot upgrade connection to websocket"))
+		return 2
+	}
+
+	// Create connection table
+	tbl := L.NewTable()
+
+	// Create connection user data
+	connUserData := L.NewUserData()
+	connUserData.Value = c
+
+	// Set the user data field
+	L.SetField(tbl, "__websocket", connUserData)
+
+	// Set the connection methods
+	L.SetFuncs(tbl, websocketConnMethods)
+
+	// Push connection table
+	L.Push(tbl)
+
+	return 1
+}
+
+// getWebSocketConn retrieves the websocket connection user data from the given state
+func getWebSocketConn(luaState *lua.LState) *websocketConn {
+	// Get metatable
+	meta := luaState.Get(1)
+
+	// Get user data
+	data, ok := luaState.GetField(meta, "__websocket").(*lua.LUserData)
+
+	if !ok {
+		luaState.RaiseError("Cannot retrieve websocket user data")
+	}
+
+	// Retrieve connection
+	c, ok := data.Value.(*websocketConn)
+
+	if !ok {
+		luaState.RaiseError("Cannot retrieve websocket connection from user data")
+	}
+
+	return c
+}
+
+// SendWebSocketMessage sends a text message. Returns false if the connection is closed
+func SendWebSocketMessage(L *lua.LState) int {
+	// Get connection
+	c := getWebSocketConn(L)
+
+	// Get message
+	msg := L.Get(2)
+
+	// Check for valid message type
+	if msg.Type() != lua.LTString && msg.Type() != lua.LTNumber {
+
+		L.ArgError(1, "Invalid message type. Expected string")
+		return 0
+	}
+
+	if err := c.send(msg.String()); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+
+	return 1
+}
+
+// ReceiveWebSocketMessage waits for the next message. Returns nil once the client disconnects
+func ReceiveWebSocketMessage(L *lua.LState) int {
+	// Get connection
+	c := getWebSocketConn(L)
+
+	// Read message
+	msg := ""
+
+	if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+		c.close()
+
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(lua.LString(msg))
+
+	return 1
+}
+
+// CloseWebSocket closes the connection
+func CloseWebSocket(L *lua.LState) int {
+	// Get connection
+	c := getWebSocketConn(L)
+
+	c.close()
+
+	return 0
+}
+
+// JoinWebSocketChannel subscribes the connection to the given channel
+func JoinWebSocketChannel(L *lua.LState) int {
+	// Get connection
+	c := getWebSocketConn(L)
+
+	// Get channel
+	channel := L.Get(2)
+
+	// Check for valid channel type
+	if channel.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid channel type. Expected string")
+		return 0
+	}
+
+	// Closed connections cannot join channels
+	select {
+	case <-c.closed:
+		return 0
+	default:
+	}
+
+	websocketChannels.join(channel.String(), c)
+
+	return 0
+}
+
+// LeaveWebSocketChannel unsubscribes the connection from the given channel
+func LeaveWebSocketChannel(L *lua.LState) int {
+	// Get connection
+	c := getWebSocketConn(L)
+
+	// Get channel
+	channel := L.Get(2)
+
+	// Check for valid channel type
+	if channel.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid channel type. Expected string")
+		return 0
+	}
+
+	websocketChannels.leave(channel.String(), c)
+
+	return 0
+}
+
+// BroadcastWebSocket sends a message to every connection of the given channel. Returns
+// the number of connections that received the message
+func BroadcastWebSocket(L *lua.LState) int {
+	// Get channel
+	channel := L.Get(2)
+
+	// Check for valid channel type
+	if channel.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid channel type. Expected string")
+		return 0
+	}
+
+	// Get message
+	msg := L.Get(3)
+
+	// Check for valid message type
+	if msg.Type() != lua.LTString && msg.Type() != lua.LTNumber {
+
+		L.ArgError(2, "Invalid message type. Expected string")
+		return 0
+	}
+
+	// Send message
+	sent := 0
+
+	for _, c := range websocketChannels.members(channel.String()) {
+		if err := c.send(msg.String()); err == nil {
+			sent++
+		}
+	}
+
+	L.Push(lua.LNumber(sent))
+
+	return 1
+}
+
+// CountWebSocketChannel returns the number of connections of the given channel
+func CountWebSocketChannel(L *lua.LState) int {
+	// Get channel
+	channel := L.Get(2)
+
+	// Check for valid channel type
+	if channel.Type() != lua.LTString {
+
+		L.ArgError(1, "Invalid channel type. Expected string")
+		return 0
+	}
+
+	L.Push(lua.LNumber(len(websocketChannels.members(channel.String()))))
+
+	return 1
+}
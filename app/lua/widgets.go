@@ -1,15 +1,71 @@
 package lua
 
 import (
+	"crypto/sha1"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+// registeredWidget a widget registered at runtime
+type registeredWidget struct {
+	Name     string
+	Template string
+	Order    int
+	Data     *lua.FunctionProto
+}
+
+// registeredWidgetList list of widgets registered at runtime
+type registeredWidgetList struct {
+	rw   sync.RWMutex
+	List []*registeredWidget
+}
+
+// RegisteredWidgets holds all the widgets registered using widget.register
+var RegisteredWidgets = &registeredWidgetList{}
+
+// Add adds or replaces a widget keeping the list sorted by order
+func (r *registeredWidgetList) Add(widget *registeredWidget) {
+	// Lock mutex
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	// Remove widget with the same name
+	for i, w := range r.List {
+		if w.Name == widget.Name {
+			r.List = append(r.List[:i], r.List[i+1:]...)
+			break
+		}
+	}
+
+	// Append widget
+	r.List = append(r.List, widget)
+
+	// Sort widgets by order
+	sort.SliceStable(r.List, func(i, j int) bool {
+		return r.List[i].Order < r.List[j].Order
+	})
+}
+
+// Widgets returns a copy of the registered widget list
+func (r *registeredWidgetList) Widgets() []*registeredWidget {
+	// Lock mutex
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	return append([]*registeredWidget{}, r.List...)
+}
+
 // setWidgetMetaTable sets the widget metatable to the given state
 func setWidgetMetaTable(luaState *lua.LState) {
 	// Create and set the widget metatable
@@ -20,7 +76,17 @@ func setWidgetMetaTable(luaState *lua.LState) {
 	luaState.SetFuncs(widgetMetaTable, widgetMethods)
 }
 
-// RenderWidgetTemplate renders the given widget template
+// cachedWidget rendered widget saved on the cache. The nonce and csrf token used when rendering
+// are replaced with the values of the request the widget is served to
+type cachedWidget struct {
+	body      []byte
+	nonce     string
+	csrfToken string
+}
+
+// RenderWidgetTemplate renders the given widget template. When a cache duration in seconds is given
+// the rendered result is cached using the widget name, the template arguments and the logged
+// account as key
 func RenderWidgetTemplate(L *lua.LState) int {
 	// Get template name
 	templateName := L.Get(2)
@@ -34,38 +100,227 @@ func RenderWidgetTemplate(L *lua.LState) int {
 	// Get template args
 	tableArgs := L.ToTable(3)
 
+	// Convert args to map
+	args := TableToMap(tableArgs)
+
+	// Get cache duration
+	cacheSeconds := L.ToInt(4)
+
 	// Get http fields
 	req, _ := getRequestAndResponseWriter(L)
 
+	// Cache key holder
+	cacheKey := ""
+
+	if cacheSeconds > 0 {
+
+		// Encode template args
+		encoded, err := json.Marshal(args)
+
+		if err != nil {
+			L.RaiseError("Cannot encode widget data: %v", err)
+			return 0
+		}
+
+		// Rendered widgets contain session data so they are cached per account
+		session, _ := req.Context().Value("session").(map[string]interface{})
+		account, _ := session["loggedAccount"].(string)
+
+		// Set cache key
+		cacheKey = fmt.Sprintf("widget_%v_%x_%v", templateName.String(), sha1.Sum(encoded), account)
+
+		// Get rendered widget from cache
+		if v, found := util.Cache.Get(cacheKey); found {
+			if cached, ok := v.(*cachedWidget); ok {
+				setWidgetTemplateData(L, template.HTML(replaceRequestTokens(req, cached.body, cached.nonce, cached.csrfToken)))
+				return 0
+			}
+		}
+	}
+
 	// Render widget template
-	buff, err := util.WidgetTemplate.RenderWidget(req, templateName.String(), TableToMap(tableArgs))
+	buff, err := util.WidgetTemplate.RenderWidget(req, templateName.String(), args)
 
 	if err != nil {
 		L.RaiseError("Cannot parse widget template: %v", err)
 		return 0
 	}
 
+	// Save rendered widget to cache
+	if cacheSeconds > 0 {
+		cached := &cachedWidget{
+			body: append([]byte{}, buff.Bytes()...),
+		}
+
+		cached.nonce, _ = req.Context().Value("nonce").(string)
+
+		if token, ok := req.Context().Value("csrf-token").(*models.CsrfToken); ok {
+			cached.csrfToken = token.Token
+		}
+
+		util.Cache.Set(cacheKey, cached, time.Duration(cacheSeconds)*time.Second)
+	}
+
+	// Set widget template data
+	setWidgetTemplateData(L, template.HTML(buff.String()))
+
+	return 0
+}
+
+// setWidgetTemplateData sets the widget metatable rendered data field
+func setWidgetTemplateData(L *lua.LState, result template.HTML) {
 	// Set widget template user data
 	templateData := L.NewUserData()
-	templateData.Value = template.HTML(buff.String())
+	templateData.Value = result
 
 	// Get main metatable
 	tbl := L.GetTypeMetatable(WidgetMetaTableName)
 
 	// Set data field
 	L.SetField(tbl, "__data", templateData)
+}
+
+// RegisterWidget registers a widget to be rendered with every template
+func RegisterWidget(L *lua.LState) int {
+	// Get widget options
+	opts := L.Get(2)
+
+	// Check valid options type
+	if opts.Type() != lua.LTTable {
+		L.ArgError(1, "Invalid widget options. Expected table")
+		return 0
+	}
+
+	// Get widget name
+	name := L.GetField(opts, "name")
+
+	if name.Type() != lua.LTString {
+		L.ArgError(1, "Invalid widget name. Expected string")
+		return 0
+	}
+
+	// Create widget
+	widget := &registeredWidget{
+		Name:     name.String(),
+		Template: name.String(),
+	}
+
+	// Get widget template
+	if tmpl := L.GetField(opts, "template"); tmpl.Type() == lua.LTString {
+		widget.Template = tmpl.String()
+	}
+
+	// Get widget order
+	if order := L.GetField(opts, "order"); order.Type() == lua.LTNumber {
+		widget.Order = int(order.(lua.LNumber))
+	}
+
+	// Get widget data function
+	switch data := L.GetField(opts, "data").(type) {
+	case *lua.LFunction:
+
+		// Data functions run on their own state so they cannot use upvalues
+		if data.IsG || data.Proto.NumUpvalues > 0 {
+			L.ArgError(1, "Invalid widget data function. Function cannot use local variables from outer scopes")
+			return 0
+		}
+
+		widget.Data = data.Proto
+
+	case *lua.LNilType:
+	default:
+		L.ArgError(1, "Invalid widget data. Expected function")
+		return 0
+	}
+
+	// Add widget to the registry
+	RegisteredWidgets.Add(widget)
 
 	return 0
 }
 
-func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string]interface{}) (map[string]template.HTML, error) {
+// ListRegisteredWidgets returns the registered widgets in order
+func ListRegisteredWidgets(L *lua.LState) int {
+	// Create widgets table
+	tbl := L.NewTable()
+
+	for _, widget := range RegisteredWidgets.Widgets() {
+
+		// Create widget table
+		w := L.NewTable()
+
+		w.RawSetString("name", lua.LString(widget.Name))
+		w.RawSetString("template", lua.LString(widget.Template))
+		w.RawSetString("order", lua.LNumber(widget.Order))
+
+		tbl.Append(w)
+	}
+
+	// Push widgets table
+	L.Push(tbl)
+
+	return 1
+}
+
+// executeRegisteredWidget runs the widget data function and renders the widget template
+func executeRegisteredWidget(widget *registeredWidget, req *http.Request, w http.ResponseWriter, sess map[string]interface{}, language []string) (template.HTML, error) {
+	// Template arguments holder
+	args := map[string]interface{}{}
+
+	if widget.Data != nil {
+
+		// Get a lua state from the pool
+		state := Pool.Get()
+
+		// Return state
+		defer Pool.Put(state)
+
+		// Set language user data
+		SetI18nUserData(state, language)
+
+		// Set HTTP user data
+		SetHTTPUserData(state, w, req)
+
+		// Set session user data
+		SetSessionMetaTableUserData(state, sess)
+
+		// Call data function
+		if err := state.CallByParam(lua.P{
+			Fn:      state.NewFunctionFromProto(widget.Data),
+			NRet:    1,
+			Protect: true,
+		}); err != nil {
+			return "", err
+		}
+
+		// Get data table
+		if tbl, ok := state.Get(-1).(*lua.LTable); ok {
+			args = TableToMap(tbl)
+		}
+
+		state.Pop(1)
+	}
+
+	// Render widget template
+	buff, err := util.WidgetTemplate.RenderWidget(req, widget.Template, args)
+
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(buff.String()), nil
+}
+
+// compileWidgetList renders the widgets of the widgets directory and the widgets registered with
+// widget.register. Registered widgets are also returned in order
+func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string]interface{}) (map[string]template.HTML, []template.HTML, error) {
 	// Data holder
 	results := map[string]template.HTML{}
 
 	// Get request language
 	language, ok := req.Context().Value("language").([]string)
 	if !ok {
-		return nil, errors.New("Unable to retrieve language contenxt at widget list")
+		return nil, nil, errors.New("Unable to retrieve language contenxt at widget list")
 	}
 
 	// Loop widget list
@@ -75,7 +330,7 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 		state, err := WidgetList.Get(filepath.Join("widgets", widget.Name, widget.Name+".lua"))
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// Set language user data
@@ -96,7 +351,7 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 		// Call widget function
 		if err := ExecuteControllerPage(state, "widget"); err != nil {
 			WidgetList.Put(state, filepath.Join("widgets", widget.Name, widget.Name+".lua"))
-			return nil, err
+			return nil, nil, err
 		}
 
 		// Get widget metatable
@@ -110,7 +365,7 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 
 		if !ok {
 			WidgetList.Put(state, filepath.Join("widgets", widget.Name, widget.Name+".lua"))
-			return nil, errors.New("Cannot convert widget data to user data")
+			return nil, nil, errors.New("Cannot convert widget data to user data")
 		}
 
 		// Convert data value to template HTML
@@ -118,7 +373,7 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 
 		if !ok {
 			WidgetList.Put(state, filepath.Join("widgets", widget.Name, widget.Name+".lua"))
-			return nil, errors.New("Cannot convert widget user data to template HTML")
+			return nil, nil, errors.New("Cannot convert widget user data to template HTML")
 		}
 
 		// Append data
@@ -126,5 +381,22 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 		WidgetList.Put(state, filepath.Join("widgets", widget.Name, widget.Name+".lua"))
 	}
 
-	return results, nil
+	// Loop registered widgets in order
+	registered := []template.HTML{}
+
+	for _, widget := range RegisteredWidgets.Widgets() {
+
+		// Execute widget
+		templateData, err := executeRegisteredWidget(widget, req, w, sess, language)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Append data
+		results[widget.Name] = templateData
+		registered = append(registered, templateData)
+	}
+
+	return results, registered, nil
 }
@@ -83,7 +83,8 @@ func MonsterByName(L *lua.LState) int {
 	return 1
 }
 
-// MarshalXML marshals the given lua table
+// MarshalXML marshals the given lua table. An optional options table {root, attributes, indent}
+// sets the root element name, the root element attributes and enables pretty-printing
 func MarshalXML(L *lua.LState) int {
 	// Get table
 	tbl := L.Get(2)
@@ -94,12 +95,64 @@ func MarshalXML(L *lua.LState) int {
 		return 0
 	}
 
+	// Get options table
+	opts := L.Get(3)
+
+	if opts.Type() != lua.LTTable && opts.Type() != lua.LTNil {
+		L.ArgError(2, "Invalid marshal options. Expected table")
+		return 0
+	}
+
 	// Convert table to map
 	mxj.XmlCharsetReader = charset.NewReaderLabel
 	r := mxj.Map(TableToMap(L.ToTable(2)))
 
+	// Root element holder
+	root := []string{}
+	indent := false
+
+	if opts, ok := opts.(*lua.LTable); ok {
+
+		// Get root element name
+		if name, ok := opts.RawGetString("root").(lua.LString); ok && name != "" {
+			root = append(root, string(name))
+		}
+
+		// Get root attributes
+		if attributes, ok := opts.RawGetString("attributes").(*lua.LTable); ok {
+
+			// Without root name use the single top-level element as root
+			if len(root) == 0 && len(r) == 1 {
+				for key, v := range r {
+					if inner, ok := v.(map[string]interface{}); ok {
+						root = append(root, key)
+						r = mxj.Map(inner)
+					}
+				}
+			}
+
+			if len(root) == 0 {
+				root = append(root, "doc")
+			}
+
+			// Set attributes using the mxj attribute prefix
+			attributes.ForEach(func(k, v lua.LValue) {
+				r["-"+k.String()] = v.String()
+			})
+		}
+
+		indent = lua.LVAsBool(opts.RawGetString("indent"))
+	}
+
 	// Marshal converted table
-	buff, err := r.Xml()
+	var buff []byte
+	var err error
+
+	if indent {
+		buff, err = r.XmlIndent("", "  ", root...)
+	} else {
+		buff, err = r.Xml(root...)
+	}
 
 	if err != nil {
 		L.RaiseError("Cannot marshal the given table: %v", err)
@@ -160,7 +213,7 @@ func UnmarshalXMLFile(L *lua.LState) int {
 	util.Cache.Add(
 		fmt.Sprintf("xml_table_%v", src.String()),
 		r,
-		util.Config.Configuration.Cache.Default.Duration,
+		util.Config.Get().Cache.Default.Duration,
 	)
 
 	// Push result as table
@@ -227,7 +280,7 @@ func GetVocationByName(L *lua.LState) int {
 		if voc.Name == name.String() {
 
 			// Convert vocation to table
-			vocation := StructToTable(voc)
+			vocation := vocationToTable(voc)
 
 			// Save vocation on the cache
 			util.Cache.Add(
@@ -281,7 +334,7 @@ func GetVocationByID(L *lua.LState) int {
 			}
 
 			// Convert vocation to table
-			v := StructToTable(voc)
+			v := vocationToTable(voc)
 
 			// Save vocation to cache
 			util.Cache.Add(
@@ -330,7 +383,7 @@ func VocationList(L *lua.LState) int {
 	for _, vocation := range util.ServerVocationList.List.Vocations {
 
 		// Convert vocation to table
-		v := StructToTable(vocation)
+		v := vocationToTable(vocation)
 
 		// Check if user wants base vocations
 		if base {
@@ -357,3 +410,37 @@ func VocationList(L *lua.LState) int {
 
 	return 1
 }
+
+// GetBasePromotion resolves a promoted vocation id back to its base vocation id
+func GetBasePromotion(L *lua.LState) int {
+	// Get ID
+	id := L.Get(2)
+
+	// Check for valid id type
+	if id.Type() != lua.LTNumber {
+		L.ArgError(1, "Invalid ID format. Expected number")
+		return 0
+	}
+
+	// Push base vocation id
+	L.Push(lua.LNumber(util.ServerVocationList.BaseVocation(L.ToInt(2))))
+
+	return 1
+}
+
+// vocationToTable converts a vocation to a lua table including its promotion links
+func vocationToTable(voc *util.Vocation) *lua.LTable {
+	// Convert vocation to table
+	tbl := StructToTable(voc)
+
+	// Set base vocation id
+	tbl.RawSetString("BaseVoc", lua.LNumber(util.ServerVocationList.BaseVocation(voc.ID)))
+
+	// Set promoted vocation
+	if promoted, ok := util.ServerVocationList.PromotedVocation(voc.ID); ok {
+		tbl.RawSetString("PromotedVoc", lua.LNumber(promoted.ID))
+		tbl.RawSetString("PromotedName", lua.LString(promoted.Name))
+	}
+
+	return tbl
+}
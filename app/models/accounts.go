@@ -1,11 +1,24 @@
 package models
 
 import (
+	"crypto/sha1"
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/raggaer/castro/app/database"
 )
 
+var (
+	// ErrAccountNameTaken error returned when the account name is already in use
+	ErrAccountNameTaken = errors.New("Account name already in use by another user")
+
+	// ErrAccountEmailTaken error returned when the account email is already in use
+	ErrAccountEmailTaken = errors.New("Email already in use by another user")
+)
+
 // Account struct used for tfs accounts
 type Account struct {
 	ID       int64
@@ -44,3 +57,147 @@ func GetAccountByName(name string) (Account, CastroAccount, error) {
 
 	return account, castroAccount, nil
 }
+
+// GetAccountCreationDate returns the account creation timestamp. Schemas without the creation
+// column return zero
+func GetAccountCreationDate(accountID int64) (int64, error) {
+	// Creation date placeholder
+	creation := sql.NullInt64{}
+
+	// Retrieve creation date from database
+	if err := database.DB.Get(&creation, "SELECT creation FROM accounts WHERE id = ?", accountID); err != nil {
+		if isUnknownColumnError(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return creation.Int64, nil
+}
+
+// HashPassword hashes an account password using the server password scheme
+func HashPassword(password string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(password)))
+}
+
+// CreateAccount creates a new account and its castro account. The password is hashed
+// using the server password scheme
+func CreateAccount(name, password, email string, premdays int) (int64, error) {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Check if name is taken
+	exists := false
+
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM accounts WHERE name = ?)", name); err != nil {
+		return 0, err
+	}
+
+	if exists {
+		return 0, ErrAccountNameTaken
+	}
+
+	// Check if email is taken
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM accounts WHERE email = ?)", email); err != nil {
+		return 0, err
+	}
+
+	if exists {
+		return 0, ErrAccountEmailTaken
+	}
+
+	// Insert account
+	result, err := tx.Exec(
+		"INSERT INTO accounts (name, password, premdays, email, creation) VALUES (?, ?, ?, ?, ?)",
+		name,
+		HashPassword(password),
+		premdays,
+		email,
+		time.Now().Unix(),
+	)
+
+	if err != nil {
+
+		// Duplicate entry means the name was taken by a concurrent request
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			return 0, ErrAccountNameTaken
+		}
+
+		return 0, err
+	}
+
+	// Get account identifier
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Insert castro account
+	if _, err := tx.Exec("INSERT INTO castro_accounts (account_id) VALUES (?)", id); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// EnableAccountTwoFactor stores the two-factor authentication secret of the given account
+func EnableAccountTwoFactor(accountID int64, secret string) error {
+	result, err := database.DB.Exec("UPDATE accounts SET secret = ? WHERE id = ?", secret, accountID)
+
+	if err != nil {
+		return err
+	}
+
+	// Check if the account exists
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		if err := database.DB.Get(new(int64), "SELECT id FROM accounts WHERE id = ?", accountID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DisableAccountTwoFactor removes the two-factor authentication secret of the given account.
+// Returns false if the account had no secret
+func DisableAccountTwoFactor(accountID int64) (bool, error) {
+	result, err := database.DB.Exec("UPDATE accounts SET secret = NULL WHERE id = ? AND secret IS NOT NULL", accountID)
+
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// AccountHasTwoFactor checks if the given account has two-factor authentication enabled
+func AccountHasTwoFactor(accountID int64) (bool, error) {
+	enabled := false
+
+	if err := database.DB.Get(&enabled, "SELECT EXISTS(SELECT 1 FROM accounts WHERE id = ? AND secret IS NOT NULL AND secret <> '')", accountID); err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+// AccountBan struct used for tfs account bans. Permanent bans have a zero expiration
+type AccountBan struct {
+	Account_id     int64
+	Reason         string
+	Banned_at      int64
+	Expires_at     int64
+	Banned_by      int64
+	Banned_by_name sql.NullString
+}
+
+// Permanent checks if the ban never expires
+func (b *AccountBan) Permanent() bool {
+	return b.Expires_at == 0
+}
+
+// GetAccountBan returns the active ban of the given account or nil if the account is not banned
+func GetAccountBan(accountID int64) (*AccountBan, error) {
+	ban := AccountBan{}
+
+	if err := database.DB.Get(
+		&ban,
+		"SELECT b.account_id, b.reason, b.banned_at, b.expires_at, b.banned_by, p.name AS banned_by_name FROM account_bans b LEFT JOIN players p ON p.id = b.banned_by WHERE b.account_id = ?",
+		accountID,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	// Expired bans are moved to the history by the server on the next login
+	if !ban.Permanent() && ban.Expires_at <= time.Now().Unix() {
+		return nil, nil
+	}
+
+	return &ban, nil
+}
+
+// BanAccount bans the given account replacing any previous ban. A zero expiration creates a
+// permanent ban. If ip is not zero the address (as stored on players.lastip) is banned too
+func BanAccount(accountID int64, reason string, expiresAt, bannedBy int64, ip uint32) error {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+
+	// Save account ban
+	if _, err := tx.Exec(
+		"INSERT INTO account_bans (account_id, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE reason = VALUES(reason), banned_at = VALUES(banned_at), expires_at = VALUES(expires_at), banned_by = VALUES(banned_by)",
+		accountID,
+		reason,
+		now,
+		expiresAt,
+		bannedBy,
+	); err != nil {
+		return err
+	}
+
+	// Save IP ban
+	if ip != 0 {
+		if _, err := tx.Exec(
+			"INSERT INTO ip_bans (ip, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE reason = VALUES(reason), banned_at = VALUES(banned_at), expires_at = VALUES(expires_at), banned_by = VALUES(banned_by)",
+			ip,
+			reason,
+			now,
+			expiresAt,
+			bannedBy,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UnbanAccount lifts the ban of the given account moving it to the ban history. Returns
+// false if the account was not banned
+func UnbanAccount(accountID int64) (bool, error) {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return false, err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Lock ban row
+	ban := AccountBan{}
+
+	if err := tx.Get(&ban, "SELECT account_id, reason, banned_at, expires_at, banned_by FROM account_bans WHERE account_id = ? FOR UPDATE", accountID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	// Save ban history
+	if _, err := tx.Exec(
+		"INSERT INTO account_ban_history (account_id, reason, banned_at, expired_at, banned_by) VALUES (?, ?, ?, ?, ?)",
+		ban.Account_id,
+		ban.Reason,
+		ban.Banned_at,
+		time.Now().Unix(),
+		ban.Banned_by,
+	); err != nil {
+		return false, err
+	}
+
+	// Remove ban
+	if _, err := tx.Exec("DELETE FROM account_bans WHERE account_id = ?", accountID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/raggaer/castro/app/database"
+)
+
+// ClaimOnce records the given key on the castro_claims table. Returns true only for the first
+// claim of a key, the unique key makes concurrent claims of the same key safe
+func ClaimOnce(key string) (bool, error) {
+	_, err := database.DB.Exec("INSERT INTO castro_claims (claim_key, created_at) VALUES (?, ?)", key, time.Now().Unix())
+
+	if err == nil {
+		return true, nil
+	}
+
+	// Duplicate entry means the key was already claimed
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return false, nil
+	}
+
+	return false, err
+}
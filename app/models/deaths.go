@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+// Death struct used for player deaths
+type Death struct {
+	Time     int64  `db:"time"`
+	Level    int    `db:"level"`
+	Victim   string `db:"victim"`
+	KilledBy string `db:"killed_by"`
+	IsPlayer bool   `db:"is_player"`
+}
+
+const (
+	// deathsQuery death list query for the player_deaths layout with the killed_by column
+	deathsQuery = "SELECT d.time, d.level, p.name AS victim, d.killed_by, d.is_player FROM player_deaths d INNER JOIN players p ON p.id = d.player_id %v ORDER BY d.time DESC LIMIT ?"
+
+	// killersDeathsQuery death list query for the player_deaths and killers layout
+	killersDeathsQuery = "SELECT d.date AS time, d.level, p.name AS victim, COALESCE(kp.name, ek.name, '') AS killed_by, pk.player_id IS NOT NULL AS is_player FROM player_deaths d INNER JOIN players p ON p.id = d.player_id INNER JOIN killers k ON k.death_id = d.id AND k.final_hit = 1 LEFT JOIN player_killers pk ON pk.kill_id = k.id LEFT JOIN players kp ON kp.id = pk.player_id LEFT JOIN environment_killers ek ON ek.kill_id = k.id %v ORDER BY d.date DESC LIMIT ?"
+)
+
+var (
+	// deathsLayout caches the detected death table layout
+	deathsLayout struct {
+		sync.Mutex
+		checked bool
+		killers bool
+	}
+)
+
+// GetPlayerDeaths returns the latest deaths of a player
+func GetPlayerDeaths(id int64, limit int) ([]*Death, error) {
+	return getDeaths("WHERE d.player_id = ?", id, limit)
+}
+
+// GetLatestDeaths returns the latest deaths of the server
+func GetLatestDeaths(limit int) ([]*Death, error) {
+	return getDeaths("", limit)
+}
+
+// getDeaths runs the death list query for the server layout with the given filter
+func getDeaths(filter string, args ...interface{}) ([]*Death, error) {
+	// Get death table layout
+	killers, err := usesKillersLayout()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Get query for the layout
+	query := deathsQuery
+
+	if killers {
+		query = killersDeathsQuery
+	}
+
+	// Data holder
+	list := []*Death{}
+
+	if err := database.DB.Select(&list, fmt.Sprintf(query, filter), args...); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// usesKillersLayout checks if the server stores deaths using the killers table layout
+func usesKillersLayout() (bool, error) {
+	deathsLayout.Lock()
+	defer deathsLayout.Unlock()
+
+	if deathsLayout.checked {
+		return deathsLayout.killers, nil
+	}
+
+	// Check for the killed_by column
+	count := 0
+
+	if err := database.DB.Get(&count, "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'player_deaths' AND column_name = 'killed_by'"); err != nil {
+		return false, err
+	}
+
+	deathsLayout.checked = true
+	deathsLayout.killers = count == 0
+
+	return deathsLayout.killers, nil
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/raggaer/castro/app/database"
 )
 
@@ -74,3 +77,132 @@ func GetGuildMember(id, pd int64) (*Player, error) {
 
 	return p, nil
 }
+
+// GuildMember struct used for guild members with their rank
+type GuildMember struct {
+	ID        int64  `db:"id"`
+	Name      string `db:"name"`
+	Level     int    `db:"level"`
+	Vocation  int    `db:"vocation"`
+	Nick      string `db:"nick"`
+	Rank      string `db:"rank"`
+	RankLevel int    `db:"rank_level"`
+}
+
+var (
+	// ErrGuildNameTaken error returned when the guild name is already in use
+	ErrGuildNameTaken = errors.New("Guild name already in use")
+
+	// ErrPlayerInGuild error returned when the player is already member of a guild
+	ErrPlayerInGuild = errors.New("Character is member of a guild")
+)
+
+// GetGuildMembersWithRanks retrieves all guild members with their rank ordered by rank level
+func GetGuildMembersWithRanks(id int64) ([]*GuildMember, error) {
+	list := []*GuildMember{}
+
+	// Retrieve guild members
+	if err := database.DB.Select(&list, "SELECT a.id, a.name, a.level, a.vocation, b.nick, c.name AS rank, c.level AS rank_level FROM guild_membership b INNER JOIN players a ON a.id = b.player_id INNER JOIN guild_ranks c ON c.id = b.rank_id WHERE b.guild_id = ? ORDER BY c.level DESC, a.name", id); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// CreateGuild creates a guild with the given owner. Default ranks are created when the
+// database has no trigger for them and the owner joins the guild as leader
+func CreateGuild(name string, ownerID int64) (*Guild, error) {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Check if name is taken
+	exists := false
+
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM guilds WHERE name = ?)", name); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, ErrGuildNameTaken
+	}
+
+	// Check if owner is member of a guild
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM guild_membership WHERE player_id = ?)", ownerID); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, ErrPlayerInGuild
+	}
+
+	// Guild holder
+	g := &Guild{
+		Name:         name,
+		Ownerid:      ownerID,
+		Creationdata: time.Now().Unix(),
+		Motd:         "Guild leader must edit this text",
+	}
+
+	// Insert guild
+	result, err := tx.Exec("INSERT INTO guilds (name, ownerid, creationdata, motd) VALUES (?, ?, ?, ?)", g.Name, g.Ownerid, g.Creationdata, g.Motd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if g.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	// Check if the ranks were created by the database trigger
+	ranks := 0
+
+	if err := tx.Get(&ranks, "SELECT COUNT(*) FROM guild_ranks WHERE guild_id = ?", g.ID); err != nil {
+		return nil, err
+	}
+
+	if ranks == 0 {
+		if _, err := tx.Exec("INSERT INTO guild_ranks (guild_id, name, level) VALUES (?, 'the Leader', 3), (?, 'a Vice-Leader', 2), (?, 'a Member', 1)", g.ID, g.ID, g.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Get leader rank
+	leaderRank := int64(0)
+
+	if err := tx.Get(&leaderRank, "SELECT id FROM guild_ranks WHERE guild_id = ? AND level = 3", g.ID); err != nil {
+		return nil, err
+	}
+
+	// Add owner as leader
+	if _, err := tx.Exec("INSERT INTO guild_membership (player_id, guild_id, rank_id) VALUES (?, ?, ?)", ownerID, g.ID, leaderRank); err != nil {
+		return nil, err
+	}
+
+	return g, tx.Commit()
+}
+
+// InvitePlayer invites a player to the guild
+func (g *Guild) InvitePlayer(playerID int64) error {
+	// Check if player is member of a guild
+	exists := false
+
+	if err := database.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM guild_membership WHERE player_id = ?)", playerID); err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrPlayerInGuild
+	}
+
+	// Insert invitation
+	_, err := database.DB.Exec("INSERT IGNORE INTO guild_invites (player_id, guild_id) VALUES (?, ?)", playerID, g.ID)
+	return err
+}
@@ -1,6 +1,12 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
 	"github.com/raggaer/castro/app/database"
 )
 
@@ -20,6 +26,14 @@ type Player struct {
 	Experience int
 }
 
+var (
+	// ErrPlayerNameTaken error returned when the character name is already in use
+	ErrPlayerNameTaken = errors.New("Character name already in use")
+
+	// ErrPlayerOnline error returned when the character is logged in the game server
+	ErrPlayerOnline = errors.New("Character must be offline")
+)
+
 // GetPlayerByID returns a player by the identifier
 func GetPlayerByID(id int64) (*Player, error) {
 	// Data holder
@@ -44,6 +58,124 @@ func GetPlayerByName(name string) (*Player, error) {
 	return p, nil
 }
 
+// GetPlayersByIDs returns the players with the given identifiers in a single query. The list
+// keeps the order of the identifiers and missing players are nil
+func GetPlayersByIDs(ids []int64) ([]*Player, error) {
+	// Data holder
+	players := make([]*Player, len(ids))
+
+	if len(ids) == 0 {
+		return players, nil
+	}
+
+	// Expand identifiers
+	query, args, err := sqlx.In("SELECT id, sex, account_id, name, level, vocation, town_id FROM players WHERE id IN (?)", ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Get players
+	list := []*Player{}
+
+	if err := database.DB.Select(&list, database.DB.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*Player, len(list))
+
+	for _, p := range list {
+		byID[p.ID] = p
+	}
+
+	// Sort players by the given identifiers
+	for i, id := range ids {
+		players[i] = byID[id]
+	}
+
+	return players, nil
+}
+
+// GetPlayersByNames returns the players with the given names in a single query. The list
+// keeps the order of the names and missing players are nil. Names are case insensitive
+func GetPlayersByNames(names []string) ([]*Player, error) {
+	// Data holder
+	players := make([]*Player, len(names))
+
+	if len(names) == 0 {
+		return players, nil
+	}
+
+	// Expand names
+	query, args, err := sqlx.In("SELECT id, sex, account_id, name, level, vocation, town_id FROM players WHERE name IN (?)", names)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Get players
+	list := []*Player{}
+
+	if err := database.DB.Select(&list, database.DB.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Player, len(list))
+
+	for _, p := range list {
+		byName[strings.ToLower(p.Name)] = p
+	}
+
+	// Sort players by the given names
+	for i, name := range names {
+		players[i] = byName[strings.ToLower(name)]
+	}
+
+	return players, nil
+}
+
+// PlayerCount number of players sharing the same column value
+type PlayerCount struct {
+	Value int64
+	Count int64
+}
+
+// CountPlayersByVocation returns the number of players of each vocation. Players with a group
+// greater than the given group are left out
+func CountPlayersByVocation(maxGroup int) ([]PlayerCount, error) {
+	return countPlayersBy("vocation", maxGroup)
+}
+
+// CountPlayersByTown returns the number of players of each town. Players with a group greater
+// than the given group are left out
+func CountPlayersByTown(maxGroup int) ([]PlayerCount, error) {
+	return countPlayersBy("town_id", maxGroup)
+}
+
+// countPlayersBy groups the players by the given column
+func countPlayersBy(column string, maxGroup int) ([]PlayerCount, error) {
+	// Data holder
+	list := []PlayerCount{}
+
+	if err := database.DB.Select(&list, "SELECT "+column+" AS value, COUNT(*) AS count FROM players WHERE group_id <= ? GROUP BY "+column, maxGroup); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetOnlinePlayers returns the list of online players ordered by name
+func GetOnlinePlayers() ([]*Player, error) {
+	// Data holder
+	list := []*Player{}
+
+	if err := database.DB.Select(&list, "SELECT p.id, p.name, p.level, p.vocation FROM players_online po INNER JOIN players p ON p.id = po.player_id ORDER BY p.name"); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
 // GetBalance returns the player balance
 func (p *Player) GetBalance() (int, error) {
 	// Data holder
@@ -69,7 +201,7 @@ func (p *Player) IsOnline() (bool, error) {
 	online := false
 
 	// Get online value
-	if err := database.DB.Get(&online, "SELECT 1 FROM players_online WHERE player_id = ?", p.ID); err != nil {
+	if err := database.DB.Get(&online, "SELECT EXISTS(SELECT 1 FROM players_online WHERE player_id = ?)", p.ID); err != nil {
 		return false, err
 	}
 
@@ -95,6 +227,81 @@ func (p *Player) SetStorageValue(key, value int) error {
 	return err
 }
 
+// GetStorageValues returns the player storage values of the given keys. Keys without
+// value are not present on the returned map
+func (p *Player) GetStorageValues(keys []int) (map[int]int, error) {
+	// Data holder
+	values := map[int]int{}
+
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	// Expand keys
+	query, args, err := sqlx.In("SELECT `key`, value FROM player_storage WHERE player_id = ? AND `key` IN (?)", p.ID, keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Get storage values
+	list := []Storage{}
+
+	if err := database.DB.Select(&list, database.DB.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	for _, storage := range list {
+		values[storage.Key] = storage.Value
+	}
+
+	return values, nil
+}
+
+// SetStorageValues writes the given storage values in a single statement. Online players are
+// refused since the game server keeps their storage in memory and overwrites it on logout
+func (p *Player) SetStorageValues(values map[int]int) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Check if player is online
+	online := false
+
+	if err := tx.Get(&online, "SELECT EXISTS(SELECT 1 FROM players_online WHERE player_id = ?)", p.ID); err != nil {
+		return err
+	}
+
+	if online {
+		return ErrPlayerOnline
+	}
+
+	// Build multi-row insert
+	rows := make([]string, 0, len(values))
+	args := make([]interface{}, 0, len(values)*3)
+
+	for key, value := range values {
+		rows = append(rows, "(?, ?, ?)")
+		args = append(args, p.ID, key, value)
+	}
+
+	if _, err := tx.Exec("INSERT INTO player_storage (player_id, `key`, value) VALUES "+strings.Join(rows, ", ")+" ON DUPLICATE KEY UPDATE value = VALUES(value)", args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // GetPremiumDays returns the player premium days
 func (p *Player) GetPremiumDays() (int, error) {
 	// Premium days holder
@@ -121,6 +328,152 @@ func (p *Player) GetExperience() (int, error) {
 	return experience, nil
 }
 
+// GetCreationDate returns the player creation timestamp. Schemas without the created column return zero
+func (p *Player) GetCreationDate() (int64, error) {
+	// Creation date placeholder
+	created := sql.NullInt64{}
+
+	// Retrieve creation date from database
+	if err := database.DB.Get(&created, "SELECT created FROM players WHERE id = ?", p.ID); err != nil {
+		if isUnknownColumnError(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return created.Int64, nil
+}
+
+// isUnknownColumnError checks if the given error was caused by a column missing on the schema
+func isUnknownColumnError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1054
+}
+
+// ExperienceForLevel returns the experience needed to reach the given level using the
+// server experience formula
+func ExperienceForLevel(level int) int64 {
+	lv := int64(level) - 1
+
+	if lv <= 0 {
+		return 0
+	}
+
+	return ((50 * lv * lv * lv) - (150 * lv * lv) + (400 * lv)) / 3
+}
+
+// LevelForExperience returns the level reached with the given experience
+func LevelForExperience(experience int64) int {
+	level := 1
+
+	for ExperienceForLevel(level+1) <= experience {
+		level++
+	}
+
+	return level
+}
+
+// SetLevel sets the player level and the experience needed to reach it. Online players are
+// refused since the game server overwrites their level on logout
+func (p *Player) SetLevel(level int) error {
+	return p.setExperience(func(int64) int64 {
+		return ExperienceForLevel(level)
+	})
+}
+
+// AddExperience adds the given amount of experience recalculating the player level. Negative
+// amounts remove experience. Online players are refused
+func (p *Player) AddExperience(amount int64) error {
+	return p.setExperience(func(current int64) int64 {
+		return current + amount
+	})
+}
+
+// setExperience updates the player experience and the matching level using the
+// experience returned by the given function
+func (p *Player) setExperience(fn func(current int64) int64) error {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Lock player row
+	current := int64(0)
+
+	if err := tx.Get(&current, "SELECT experience FROM players WHERE id = ? FOR UPDATE", p.ID); err != nil {
+		return err
+	}
+
+	// Check if player is online
+	online := false
+
+	if err := tx.Get(&online, "SELECT EXISTS(SELECT 1 FROM players_online WHERE player_id = ?)", p.ID); err != nil {
+		return err
+	}
+
+	if online {
+		return ErrPlayerOnline
+	}
+
+	// Calculate new experience and level
+	experience := fn(current)
+
+	if experience < 0 {
+		experience = 0
+	}
+
+	level := LevelForExperience(experience)
+
+	if _, err := tx.Exec("UPDATE players SET level = ?, experience = ? WHERE id = ?", level, experience, p.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.Level = level
+	p.Experience = int(experience)
+
+	return nil
+}
+
+// Outfit struct used for the player look columns
+type Outfit struct {
+	LookType   int
+	LookHead   int
+	LookBody   int
+	LookLegs   int
+	LookFeet   int
+	LookAddons int
+	LookMount  int
+}
+
+// GetOutfit returns the player outfit. Online players return the outfit saved by the server on
+// the last save. Schemas without the lookmount column return a zero mount
+func (p *Player) GetOutfit() (*Outfit, error) {
+	outfit := Outfit{}
+
+	// Retrieve outfit from database
+	err := database.DB.Get(&outfit, "SELECT looktype, lookhead, lookbody, looklegs, lookfeet, lookaddons, lookmount FROM players WHERE id = ?", p.ID)
+
+	if err != nil && isUnknownColumnError(err) {
+		err = database.DB.Get(&outfit, "SELECT looktype, lookhead, lookbody, looklegs, lookfeet, lookaddons FROM players WHERE id = ?", p.ID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &outfit, nil
+}
+
 // GetCapacity returns the player capacity
 func (p *Player) GetCapacity() (int, error) {
 	// Capacity placeholder
@@ -133,3 +486,77 @@ func (p *Player) GetCapacity() (int, error) {
 
 	return capacity, nil
 }
+
+// Rename changes the player name. The player row is locked while the name is checked so
+// two renames cannot take the same name. Online players cannot be renamed and any
+// namelock of the player is removed once the new name is set
+func (p *Player) Rename(name string) error {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Lock player row
+	current := ""
+
+	if err := tx.Get(&current, "SELECT name FROM players WHERE id = ? FOR UPDATE", p.ID); err != nil {
+		return err
+	}
+
+	// Check if player is online
+	online := false
+
+	if err := tx.Get(&online, "SELECT EXISTS(SELECT 1 FROM players_online WHERE player_id = ?)", p.ID); err != nil {
+		return err
+	}
+
+	if online {
+		return ErrPlayerOnline
+	}
+
+	// Check if name is taken by another player
+	taken := false
+
+	if err := tx.Get(&taken, "SELECT EXISTS(SELECT 1 FROM players WHERE name = ? AND id <> ?)", name, p.ID); err != nil {
+		return err
+	}
+
+	if taken {
+		return ErrPlayerNameTaken
+	}
+
+	// Update player name
+	if _, err := tx.Exec("UPDATE players SET name = ? WHERE id = ?", name, p.ID); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			return ErrPlayerNameTaken
+		}
+
+		return err
+	}
+
+	// Remove namelock if the server uses them
+	namelocks := false
+
+	if err := tx.Get(&namelocks, "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'player_namelocks')"); err != nil {
+		return err
+	}
+
+	if namelocks {
+		if _, err := tx.Exec("DELETE FROM player_namelocks WHERE player_id = ?", p.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.Name = name
+
+	return nil
+}
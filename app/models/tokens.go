@@ -0,0 +1,101 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+// Token struct used for single-use expiring tokens
+type Token struct {
+	Token      string
+	Purpose    string
+	Data       string
+	Expires_at int64
+	Created_at int64
+}
+
+// CreateToken stores a new single-use token for the given purpose and returns it.
+// Only the SHA-256 hash of the token is saved so leaked rows cannot be redeemed
+func CreateToken(purpose, data string, ttl time.Duration) (string, error) {
+	// Generate random token
+	buff := make([]byte, 32)
+
+	if _, err := rand.Read(buff); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buff)
+	now := time.Now()
+
+	// Remove expired tokens
+	if _, err := database.DB.Exec("DELETE FROM castro_tokens WHERE expires_at < ?", now.Unix()); err != nil {
+		return "", err
+	}
+
+	// Save token hash
+	if _, err := database.DB.Exec(
+		"INSERT INTO castro_tokens (token, purpose, data, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		hashToken(token),
+		purpose,
+		data,
+		now.Add(ttl).Unix(),
+		now.Unix(),
+	); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeToken retrieves and deletes the given token. The returned bool is false when
+// the token does not exist, expired or was already consumed
+func ConsumeToken(token string) (*Token, bool, error) {
+	// Start transaction
+	tx, err := database.DB.Beginx()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Rollback if the transaction is not committed
+	defer tx.Rollback()
+
+	// Lock token row
+	t := Token{}
+
+	if err := tx.Get(&t, "SELECT token, purpose, data, expires_at, created_at FROM castro_tokens WHERE token = ? FOR UPDATE", hashToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	// Invalidate token
+	if _, err := tx.Exec("DELETE FROM castro_tokens WHERE token = ?", t.Token); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	// Check expiration date
+	if t.Expires_at < time.Now().Unix() {
+		return nil, false, nil
+	}
+
+	return &t, true, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the given token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
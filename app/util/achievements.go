@@ -0,0 +1,93 @@
+package util
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ServerAchievementList holds the achievement definitions
+var ServerAchievementList = &ServerAchievements{
+	List: &AchievementList{},
+}
+
+// Achievement holds the definition of an achievement. The achievement is unlocked when the
+// player storage value of Storage is greater or equal than Value. TimeStorage optionally
+// holds the storage key where the unlock unix time is saved
+type Achievement struct {
+	ID          int    `xml:"id,attr"`
+	Name        string `xml:"name,attr"`
+	Description string `xml:"description,attr"`
+	Points      int    `xml:"points,attr"`
+	Secret      bool   `xml:"secret,attr"`
+	Storage     int    `xml:"storage,attr"`
+	Value       int    `xml:"value,attr"`
+	TimeStorage int    `xml:"timestorage,attr"`
+}
+
+// AchievementList holds the XML list of achievements
+type AchievementList struct {
+	XMLName      xml.Name       `xml:"achievements"`
+	Achievements []*Achievement `xml:"achievement"`
+}
+
+// ServerAchievements contains the list of achievement definitions
+type ServerAchievements struct {
+	List *AchievementList
+	rw   sync.RWMutex
+}
+
+// LoadAchievements parses the achievements xml file. A missing file leaves the list empty
+func (s *ServerAchievements) LoadAchievements(file string) error {
+	// Load achievements file
+	f, err := ioutil.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal achievements file
+	list := &AchievementList{}
+
+	if err := xml.Unmarshal(f, list); err != nil {
+		return err
+	}
+
+	// Achievements are unlocked with storage value 1 by default
+	for _, a := range list.Achievements {
+		if a.Value == 0 {
+			a.Value = 1
+		}
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	s.List = list
+
+	return nil
+}
+
+// All returns the list of achievement definitions
+func (s *ServerAchievements) All() []*Achievement {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	return s.List.Achievements
+}
+
+// ByID returns the achievement with the given id
+func (s *ServerAchievements) ByID(id int) *Achievement {
+	for _, a := range s.All() {
+		if a.ID == id {
+			return a
+		}
+	}
+
+	return nil
+}
@@ -1,8 +1,279 @@
 package util
 
 import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
 	c "github.com/patrickmn/go-cache"
 )
 
 // Cache variable that holds the main cache instance of the application
 var Cache *c.Cache
+
+// LuaCache holds the cache backend used by the lua cache module
+var LuaCache CacheBackend
+
+// CacheBackend interface used to store the lua cache values
+type CacheBackend interface {
+	Get(key string) (interface{}, bool, error)
+	Set(key string, v interface{}, d time.Duration) error
+	Delete(key string) error
+	Tag(key string, tags []string) error
+	InvalidateTag(tag string) error
+	Increment(key string, d time.Duration) (int64, error)
+}
+
+// maxIncrementAttempts number of times a counter increment is retried when the counter
+// expires between the add and increment operations
+const maxIncrementAttempts = 5
+
+// NewCacheBackend creates the lua cache backend using the cache configuration. The in-memory
+// cache is used unless the redis backend is configured
+func NewCacheBackend(config CacheConfig, memory *c.Cache) CacheBackend {
+	if config.Backend == "redis" {
+		return &redisCache{
+			client: NewRedisClient(config.Redis),
+		}
+	}
+
+	m := &memoryCache{
+		cache: memory,
+		tags:  map[string]map[string]struct{}{},
+		keys:  map[string][]string{},
+	}
+
+	// Remove evicted keys from the tag index
+	memory.OnEvicted(func(key string, _ interface{}) {
+		m.rw.Lock()
+		defer m.rw.Unlock()
+
+		m.untag(key)
+	})
+
+	return m
+}
+
+// memoryCache in-memory cache backend. Tags are kept on an index of tag to keys
+type memoryCache struct {
+	cache *c.Cache
+	rw    sync.Mutex
+	tags  map[string]map[string]struct{}
+	keys  map[string][]string
+}
+
+// Get retrieves a value from the in-memory cache
+func (m *memoryCache) Get(key string) (interface{}, bool, error) {
+	v, found := m.cache.Get(key)
+	return v, found, nil
+}
+
+// Set saves a value to the in-memory cache. Previous tags of the key are removed
+func (m *memoryCache) Set(key string, v interface{}, d time.Duration) error {
+	m.rw.Lock()
+	m.untag(key)
+	m.rw.Unlock()
+
+	m.cache.Set(key, v, d)
+	return nil
+}
+
+// Delete removes a value from the in-memory cache
+func (m *memoryCache) Delete(key string) error {
+	m.cache.Delete(key)
+	return nil
+}
+
+// Tag adds the given tags to a key
+func (m *memoryCache) Tag(key string, tags []string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	for _, tag := range tags {
+
+		// Create tag key set
+		if m.tags[tag] == nil {
+			m.tags[tag] = map[string]struct{}{}
+		}
+
+		m.tags[tag][key] = struct{}{}
+		m.keys[key] = append(m.keys[key], tag)
+	}
+
+	return nil
+}
+
+// InvalidateTag removes every key with the given tag
+func (m *memoryCache) InvalidateTag(tag string) error {
+	// Get tagged keys
+	m.rw.Lock()
+
+	keys := make([]string, 0, len(m.tags[tag]))
+
+	for key := range m.tags[tag] {
+		keys = append(keys, key)
+	}
+
+	m.rw.Unlock()
+
+	// Delete keys. The eviction callback takes the lock so it cannot be held here
+	for _, key := range keys {
+		m.cache.Delete(key)
+	}
+
+	return nil
+}
+
+// Increment increments the counter of the given key. Missing counters start at one with
+// the given expiration. Values of another type are replaced by a new counter
+func (m *memoryCache) Increment(key string, d time.Duration) (int64, error) {
+	for i := 0; i < maxIncrementAttempts; i++ {
+
+		// Start a new counter if there is no value
+		if err := m.cache.Add(key, int64(1), d); err == nil {
+			return 1, nil
+		}
+
+		// Increment current counter
+		n, err := m.cache.IncrementInt64(key, 1)
+
+		if err == nil {
+			return n, nil
+		}
+
+		// Replace values that are not counters
+		if _, found := m.cache.Get(key); found {
+			m.Set(key, int64(1), d)
+			return 1, nil
+		}
+	}
+
+	return 0, errors.New("Cannot increment counter " + key)
+}
+
+// untag removes a key from the tag index. The caller must hold the lock
+func (m *memoryCache) untag(key string) {
+	for _, tag := range m.keys[key] {
+		delete(m.tags[tag], key)
+
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+
+	delete(m.keys, key)
+}
+
+// redisCache redis cache backend. Values are stored as JSON
+type redisCache struct {
+	client *RedisClient
+}
+
+// Get retrieves a value from redis
+func (r *redisCache) Get(key string) (interface{}, bool, error) {
+	// Get encoded value
+	reply, err := r.client.Do("GET", key)
+
+	if err == errRedisNil {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Decode value
+	var v interface{}
+
+	if err := json.Unmarshal([]byte(reply.(string)), &v); err != nil {
+		return nil, false, err
+	}
+
+	return v, true, nil
+}
+
+// Set saves a value to redis with the given expiration
+func (r *redisCache) Set(key string, v interface{}, d time.Duration) error {
+	// Encode value
+	buff, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	// Save value without expiration
+	if d <= 0 {
+		_, err = r.client.Do("SET", key, string(buff))
+		return err
+	}
+
+	_, err = r.client.Do("SET", key, string(buff), "PX", strconv.FormatInt(int64(d/time.Millisecond), 10))
+	return err
+}
+
+// Delete removes a value from redis
+func (r *redisCache) Delete(key string) error {
+	_, err := r.client.Do("DEL", key)
+	return err
+}
+
+// Tag adds the key to the redis set of every given tag
+func (r *redisCache) Tag(key string, tags []string) error {
+	for _, tag := range tags {
+		if _, err := r.client.Do("SADD", "castro_tag:"+tag, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InvalidateTag removes every key stored on the tag set and the tag set itself
+func (r *redisCache) InvalidateTag(tag string) error {
+	// Get tagged keys
+	reply, err := r.client.Do("SMEMBERS", "castro_tag:"+tag)
+
+	if err != nil {
+		return err
+	}
+
+	// Delete command arguments
+	args := []string{"DEL", "castro_tag:" + tag}
+
+	if members, ok := reply.([]interface{}); ok {
+		for _, member := range members {
+			if key, ok := member.(string); ok {
+				args = append(args, key)
+			}
+		}
+	}
+
+	_, err = r.client.Do(args...)
+	return err
+}
+
+// Increment increments the counter of the given key. New counters expire after the given duration
+func (r *redisCache) Increment(key string, d time.Duration) (int64, error) {
+	reply, err := r.client.Do("INCR", key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := reply.(int64)
+
+	if !ok {
+		return 0, errors.New("Invalid INCR reply")
+	}
+
+	// Set expiration of new counters
+	if n == 1 && d > 0 {
+		if _, err := r.client.Do("PEXPIRE", key, strconv.FormatInt(int64(d/time.Millisecond), 10)); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"crypto/subtle"
 	"gopkg.in/square/go-jose.v1/json"
 	"io/ioutil"
 	"net/http"
@@ -16,18 +17,41 @@ type captchaResponse struct {
 
 // CaptchaConfig struct used for the TOML configuration file
 type CaptchaConfig struct {
-	Enabled bool
-	Public  string
-	Secret  string
+	Enabled         bool
+	Public          string
+	Secret          string
+	Forms           map[string]bool
+	TestBypassToken string
+}
+
+// IsEnabled checks if the captcha is enabled for the given form. Forms missing from
+// the forms map use the global value
+func (c CaptchaConfig) IsEnabled(form string) bool {
+	if !c.Enabled {
+		return false
+	}
+
+	if enabled, ok := c.Forms[form]; ok {
+		return enabled
+	}
+
+	return true
 }
 
 // VerifyCaptcha checks if the given captcha answer is valid
 func VerifyCaptcha(answer string) (bool, error) {
+	// Accept the test bypass token. Only allowed on development mode
+	if bypass := Config.Get().Captcha.TestBypassToken; bypass != "" && Config.Get().IsDev() {
+		if subtle.ConstantTimeCompare([]byte(answer), []byte(bypass)) == 1 {
+			return true, nil
+		}
+	}
+
 	// Post form to google service
 	resp, err := http.PostForm(captchaURL,
 		url.Values{
 			"secret": {
-				Config.Configuration.Captcha.Secret,
+				Config.Get().Captcha.Secret,
 			},
 			"response": {
 				answer,
@@ -1,10 +1,17 @@
 package util
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -25,6 +32,67 @@ type CookieConfig struct {
 	BlockKey string
 }
 
+// SessionConfig struct used for the session expiration options
+type SessionConfig struct {
+	IdleTimeout     StringDuration
+	AbsoluteTimeout StringDuration
+	Backend         string
+	Redis           RedisConfig
+}
+
+// StatusConfig struct used for the game server status options
+type StatusConfig struct {
+	Host    string
+	Port    int
+	Timeout StringDuration
+}
+
+// ShutdownConfig struct used for the graceful shutdown options
+type ShutdownConfig struct {
+	Timeout StringDuration
+}
+
+// HighscoresConfig struct used for the highscores options
+type HighscoresConfig struct {
+	MaxGroupID int
+}
+
+// DatabaseConfig struct used for the database connection options
+type DatabaseConfig struct {
+	Retries int
+	Backoff StringDuration
+	Ping    StringDuration
+}
+
+// HTTPConfig struct used for the default request limits
+type HTTPConfig struct {
+	MaxBody      int64
+	ReadTimeout  StringDuration
+	WriteTimeout StringDuration
+}
+
+// LuaConfig struct used for the lua module options
+type LuaConfig struct {
+	Lib string
+}
+
+// OutfitConfig struct used for the outfit image options
+type OutfitConfig struct {
+	ServiceURL string
+	Cache      StringDuration
+}
+
+// GeoIPConfig struct used for the geolocation options
+type GeoIPConfig struct {
+	Database string
+}
+
+// MetricsConfig struct used for the metrics endpoint options
+type MetricsConfig struct {
+	Enabled bool
+	Path    string
+}
+
 // MapWatchConfig map watcher goroutine configuration options
 type MapWatchConfig struct {
 	Enabled bool
@@ -33,7 +101,8 @@ type MapWatchConfig struct {
 
 // ShopConfig struct used for the shop configuration options
 type ShopConfig struct {
-	Enabled bool
+	Enabled  bool
+	Currency string
 }
 
 // PluginConfig struct used for the plugin listener
@@ -49,10 +118,24 @@ type RateLimiterConfig struct {
 	Time    StringDuration
 }
 
+// PasswordResetConfig struct used for the password reset throttling options
+type PasswordResetConfig struct {
+	Limit  int64
+	Window StringDuration
+}
+
+// CSRFConfig struct used for the automatic CSRF protection options
+type CSRFConfig struct {
+	Enforce bool
+	Exempt  []string
+}
+
 // CacheConfig struct used for the cache configuration options
 type CacheConfig struct {
 	Default StringDuration
 	Purge   StringDuration
+	Backend string
+	Redis   RedisConfig
 }
 
 // SSLConfig struct used for the ssl configuration options
@@ -66,11 +149,14 @@ type SSLConfig struct {
 
 // MailConfig struct used for the mail configuration options
 type MailConfig struct {
-	Enabled  bool
-	Server   string
-	Port     int
-	Username string
-	Password string
+	Enabled            bool
+	Server             string
+	Port               int
+	Username           string
+	Password           string
+	TLSMode            string
+	InsecureSkipVerify bool
+	BulkRate           float64
 }
 
 // PaygolConfig struct used for the paygol configuration options
@@ -125,6 +211,11 @@ type SecurityConfig struct {
 	ContentType       string
 	ReferrerPolicy    string
 	CrossDomainPolicy string
+	TrustedProxies    []string
+	ProxyHeader       string
+	AllowedHosts      []string
+	PasswordReset     PasswordResetConfig
+	CSRF              CSRFConfig
 	CSP               ContentSecurityPolicyConfig
 }
 
@@ -137,6 +228,7 @@ type ConfigTown struct {
 // Configuration struct used for the main Castro config file TOML file
 type Configuration struct {
 	CheckUpdates bool
+	Maintenance  bool
 	LoadMap      bool
 	MapHouseFile string
 	Towns        []ConfigTown
@@ -156,16 +248,27 @@ type Configuration struct {
 	Fortumo      FortumoConfig
 	Shop         ShopConfig
 	Cookies      CookieConfig
+	Session      SessionConfig
 	Cache        CacheConfig
 	RateLimit    RateLimiterConfig
 	Static       StaticConfig
+	Status       StatusConfig
+	Shutdown     ShutdownConfig
+	Highscores   HighscoresConfig
+	Metrics      MetricsConfig
+	GeoIP        GeoIPConfig
+	Database     DatabaseConfig
+	HTTP         HTTPConfig
+	Outfit       OutfitConfig
+	Lua          LuaConfig
 	Custom       map[string]interface{}
 }
 
-// ConfigurationFile struct used to store a configuration pointer
+// ConfigurationFile struct used to store a configuration pointer. Changes are published as a new
+// configuration so readers never see a partially updated value
 type ConfigurationFile struct {
-	rw            sync.RWMutex
-	Configuration *Configuration
+	rw            sync.Mutex
+	configuration atomic.Value
 }
 
 // StringDuration struct used to convert strings to time duration during config encoding or vice-versa
@@ -185,9 +288,25 @@ var (
 	BUILD_DATE string
 )
 
+// safeConfigFields list of configuration fields that can be changed at runtime
+var safeConfigFields = map[string]bool{
+	"CheckUpdates":      true,
+	"Maintenance":       true,
+	"URL":               true,
+	"Mail.Enabled":      true,
+	"Captcha.Enabled":   true,
+	"PayPal.Enabled":    true,
+	"PayGol.Enabled":    true,
+	"Fortumo.Enabled":   true,
+	"Shop.Enabled":      true,
+	"Plugin.Enabled":    true,
+	"RateLimit.Enabled": true,
+	"Static.Enabled":    true,
+}
+
 func init() {
 	Config = &ConfigurationFile{}
-	Config.Configuration = &Configuration{}
+	Config.configuration.Store(&Configuration{})
 }
 
 // NewStringDuration returns a new string duration struct
@@ -205,6 +324,7 @@ func (s StringDuration) MarshalText() ([]byte, error) {
 // UnmarshalText use toml interface to convert strings to durations
 func (s *StringDuration) UnmarshalText(text []byte) error {
 	var err error
+	s.String = string(text)
 	s.Duration, err = time.ParseDuration(string(text))
 	return err
 }
@@ -215,14 +335,110 @@ func LoadConfig(path string) error {
 	Config.rw.Lock()
 	defer Config.rw.Unlock()
 
-	// Decode the given file to the given interface
-	if _, err := toml.DecodeFile(path, Config.Configuration); err != nil {
+	// Decode the given file into a new configuration
+	next := &Configuration{}
+
+	if _, err := toml.DecodeFile(path, next); err != nil {
 		return err
 	}
 
+	Config.set(next)
+
 	return nil
 }
 
+// configVersion incremented every time the configuration changes at runtime
+var configVersion uint64
+
+// ConfigVersion returns the current configuration version. Used to know when the values
+// copied from the configuration are outdated
+func ConfigVersion() uint64 {
+	return atomic.LoadUint64(&configVersion)
+}
+
+// ReloadConfig decodes the configuration file again replacing the current configuration. Fields
+// that cannot be changed while the server is running keep their old value and are returned
+func ReloadConfig(path string) ([]string, error) {
+	// Decode the given file into a new configuration
+	next := &Configuration{}
+
+	if _, err := toml.DecodeFile(path, next); err != nil {
+		return nil, err
+	}
+
+	// Lock mutex
+	Config.rw.Lock()
+	defer Config.rw.Unlock()
+
+	// Ignored fields holder
+	ignored := []string{}
+
+	// Get current configuration
+	current := Config.Get()
+
+	// Listen port is only used when the server starts
+	if next.Port != current.Port {
+		ignored = append(ignored, "Port")
+		next.Port = current.Port
+	}
+
+	// Certificates and redirect servers are only created when the server starts
+	if next.SSL != current.SSL {
+		ignored = append(ignored, "SSL")
+		next.SSL = current.SSL
+	}
+
+	// Session store keys are only used when the server starts
+	if next.Cookies != current.Cookies {
+		ignored = append(ignored, "Cookies")
+		next.Cookies = current.Cookies
+	}
+
+	// Rate-limiter is only created when the server starts
+	if next.RateLimit.Number != current.RateLimit.Number || next.RateLimit.Time.Duration != current.RateLimit.Time.Duration {
+		ignored = append(ignored, "RateLimit")
+		next.RateLimit.Number = current.RateLimit.Number
+		next.RateLimit.Time = current.RateLimit.Time
+	}
+
+	// Cache backend is only created when the server starts
+	if next.Cache.Backend != current.Cache.Backend || next.Cache.Redis != current.Cache.Redis {
+		ignored = append(ignored, "Cache")
+		next.Cache.Backend = current.Cache.Backend
+		next.Cache.Redis = current.Cache.Redis
+	}
+
+	// Database connection is only opened when the server starts
+	if next.Database != current.Database {
+		ignored = append(ignored, "Database")
+		next.Database = current.Database
+	}
+
+	// Session backend is only created when the server starts
+	if next.Session.Backend != current.Session.Backend || next.Session.Redis != current.Session.Redis {
+		ignored = append(ignored, "Session")
+		next.Session.Backend = current.Session.Backend
+		next.Session.Redis = current.Session.Redis
+	}
+
+	// Publish new configuration. Readers keep using the previous configuration
+	// until they get it again
+	Config.set(next)
+
+	return ignored, nil
+}
+
+// Get returns the current configuration. The returned configuration must not be modified
+func (c *ConfigurationFile) Get() *Configuration {
+	return c.configuration.Load().(*Configuration)
+}
+
+// set publishes the given configuration
+func (c *ConfigurationFile) set(next *Configuration) {
+	c.configuration.Store(next)
+	atomic.AddUint64(&configVersion, 1)
+}
+
 // IsDev checks if castro is running on development mode
 func (c Configuration) IsDev() bool {
 	return c.Mode == "dev"
@@ -288,12 +504,9 @@ func (c Configuration) IsSSL() bool {
 	return false
 }
 
-// EncodeConfig encodes the given io writer
+// EncodeConfig encodes the given configuration into the given io writer. Published configurations
+// are never modified so they can be encoded without locking
 func EncodeConfig(configFile io.Writer, c *Configuration) error {
-	// Lock mutex
-	Config.rw.Lock()
-	defer Config.rw.Unlock()
-
 	// Encode the given writer with the given interface
 	return toml.NewEncoder(configFile).Encode(c)
 }
@@ -304,17 +517,149 @@ func (c *ConfigurationFile) SetCustomValue(key string, v interface{}) {
 	c.rw.Lock()
 	defer c.rw.Unlock()
 
+	// Copy custom values
+	current := c.Get()
+	custom := make(map[string]interface{}, len(current.Custom)+1)
+
+	for k, value := range current.Custom {
+		custom[k] = value
+	}
+
 	// Set custom value
-	c.Configuration.Custom[key] = v
+	custom[key] = v
+
+	// Publish configuration copy with the new values
+	next := *current
+	next.Custom = custom
+	c.set(&next)
 }
 
-// GetCustomValue returns a custom config value
-func (c *ConfigurationFile) GetCustomValue(key string) interface{} {
+// SetCustomValues replaces all the config custom values
+func (c *ConfigurationFile) SetCustomValues(values map[string]interface{}) {
 	// Lock mutex
-	c.rw.RLock()
-	defer c.rw.RUnlock()
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	// Publish configuration copy with the new values
+	next := *c.Get()
+	next.Custom = values
+	c.set(&next)
+}
+
+// SetValue sets a configuration value using its dotted name. Custom values are set using the
+// Custom prefix, any other key must be part of the runtime safe fields list
+func (c *ConfigurationFile) SetValue(key string, v interface{}) error {
+	// Set custom value
+	if strings.HasPrefix(key, "Custom.") {
+		name := strings.TrimPrefix(key, "Custom.")
+
+		if name == "" {
+			return errors.New("Missing custom value name")
+		}
+
+		c.SetCustomValue(name, v)
+
+		return nil
+	}
+
+	// Check if field is safe
+	if !safeConfigFields[key] {
+		return fmt.Errorf("Configuration field %v cannot be changed", key)
+	}
+
+	// Lock mutex
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	// Walk a copy of the configuration struct
+	next := *c.Get()
+	field := reflect.ValueOf(&next).Elem()
+
+	for _, name := range strings.Split(key, ".") {
+		field = field.FieldByName(name)
+	}
+
+	// Get value
+	value := reflect.ValueOf(v)
+
+	switch field.Kind() {
+	case reflect.Bool:
+
+		if value.Kind() != reflect.Bool {
+			return fmt.Errorf("Invalid value for %v. Expected bool", key)
+		}
+
+	case reflect.String:
+
+		if value.Kind() != reflect.String {
+			return fmt.Errorf("Invalid value for %v. Expected string", key)
+		}
 
-	if v, ok := c.Configuration.Custom[key]; ok {
+	case reflect.Int:
+
+		if value.Kind() != reflect.Float64 {
+			return fmt.Errorf("Invalid value for %v. Expected number", key)
+		}
+
+		// Convert numbers to the field type
+		value = value.Convert(field.Type())
+	}
+
+	// Set field value and publish the configuration copy
+	field.Set(value)
+	c.set(&next)
+
+	return nil
+}
+
+// SaveConfig encodes the current configuration into the given file. The configuration is
+// written to a temporary file that replaces the given file so a failed write cannot leave
+// a truncated configuration behind
+func SaveConfig(path string) error {
+	// Encode the published configuration
+	buff := &bytes.Buffer{}
+
+	if err := EncodeConfig(buff, Config.Get()); err != nil {
+		return err
+	}
+
+	// Create temporary file on the same directory
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	// Remove temporary file if it was not renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buff.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Keep the permissions of the current file
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// GetCustomValue returns a custom config value
+func (c *ConfigurationFile) GetCustomValue(key string) interface{} {
+	if v, ok := c.Get().Custom[key]; ok {
 		return v
 	}
 
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignCookieValue signs the given cookie value using the cookie hash key. The cookie name
+// and expiration time are part of the signature so values cannot be moved between cookies
+// or used after they expire. A zero expiration means the value never expires
+func SignCookieValue(name, value string, expires time.Time) string {
+	// Encode value and expiration
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	exp := "0"
+
+	if !expires.IsZero() {
+		exp = strconv.FormatInt(expires.Unix(), 10)
+	}
+
+	return encoded + "." + exp + "." + cookieSignature(name, encoded, exp)
+}
+
+// VerifyCookieValue checks the signature of the given signed cookie value and
+// returns the original value
+func VerifyCookieValue(name, signed string) (string, bool) {
+	// Split value parts
+	parts := strings.Split(signed, ".")
+
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	// Compare signatures in constant time
+	if !hmac.Equal([]byte(parts[2]), []byte(cookieSignature(name, parts[0], parts[1]))) {
+		return "", false
+	}
+
+	// Check expiration time
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+
+	if err != nil || (exp != 0 && time.Now().Unix() > exp) {
+		return "", false
+	}
+
+	// Decode value
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return "", false
+	}
+
+	return string(value), true
+}
+
+// cookieSignature returns the HMAC-SHA256 signature of a cookie value
+func cookieSignature(name, value, expires string) string {
+	mac := hmac.New(sha256.New, []byte(Config.Get().Cookies.HashKey))
+	mac.Write([]byte(name + "|" + value + "|" + expires))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,212 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// exifHeader prefix of the jpeg APP1 segment holding EXIF metadata
+var exifHeader = []byte("Exif\x00\x00")
+
+// DecodeImage decodes the given image data applying the EXIF orientation so the result is
+// displayed upright. Re-encoding the result drops all the image metadata
+func DecodeImage(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, format, err
+	}
+
+	if format != "jpeg" {
+		return img, format, nil
+	}
+
+	// Apply orientation
+	orientation, _ := exifOrientation(jpegEXIF(data))
+
+	return orientImage(img, orientation), format, nil
+}
+
+// jpegEXIF returns the EXIF segment payload of the given jpeg data or nil if there is none
+func jpegEXIF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+
+		marker := data[i+1]
+
+		// Skip fill bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// Metadata segments are placed before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+
+		segment := data[i+4 : i+2+length]
+
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment
+		}
+
+		i += 2 + length
+	}
+
+	return nil
+}
+
+// exifOrientation returns the orientation tag of the given EXIF payload and the offset of its
+// value. Returns 1 and -1 when the tag is missing
+func exifOrientation(exif []byte) (int, int) {
+	if len(exif) < len(exifHeader)+8 {
+		return 1, -1
+	}
+
+	tiff := exif[len(exifHeader):]
+
+	// Get byte order
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1, -1
+	}
+
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1, -1
+	}
+
+	// Read first IFD entries
+	ifd := int(order.Uint32(tiff[4:]))
+
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1, -1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		// Orientation tag stored as SHORT
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+
+			if orientation < 1 || orientation > 8 {
+				return 1, -1
+			}
+
+			return orientation, len(exifHeader) + entry + 8
+		}
+	}
+
+	return 1, -1
+}
+
+// orientImage transforms the given image using the EXIF orientation value
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	// Copy source so pixels can be read with a zero origin
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+
+	// Orientations from 5 to 8 swap width and height
+	dw, dh := w, h
+
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			dst.SetRGBA(x, y, rgba.RGBAAt(sx, sy))
+		}
+	}
+
+	return dst
+}
+
+// insertJPEGEXIF adds the given EXIF payload to the encoded jpeg data. The orientation is reset
+// since the image pixels are already upright
+func insertJPEGEXIF(data, exif []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("Invalid jpeg data")
+	}
+
+	if len(exif)+2 > 0xFFFF {
+		return nil, errors.New("EXIF metadata is too big")
+	}
+
+	// Reset orientation on a copy of the payload
+	exif = append([]byte{}, exif...)
+
+	if _, offset := exifOrientation(exif); offset >= 0 {
+		if string(exif[len(exifHeader):len(exifHeader)+2]) == "II" {
+			binary.LittleEndian.PutUint16(exif[offset:], 1)
+		} else {
+			binary.BigEndian.PutUint16(exif[offset:], 1)
+		}
+	}
+
+	// Write APP1 segment right after the SOI marker
+	buff := &bytes.Buffer{}
+	buff.Write(data[:2])
+	buff.Write([]byte{0xFF, 0xE1})
+	binary.Write(buff, binary.BigEndian, uint16(len(exif)+2))
+	buff.Write(exif)
+	buff.Write(data[2:])
+
+	return buff.Bytes(), nil
+}
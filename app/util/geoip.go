@@ -0,0 +1,449 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"sync"
+)
+
+var (
+	// GeoIP holds the loaded MaxMind database
+	GeoIP = &GeoIPDatabase{}
+
+	// geoipMetadataMarker marks the start of the database metadata section
+	geoipMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+	// geoipPrivateNetworks networks that are never resolved
+	geoipPrivateNetworks = parseCIDRList(
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	)
+)
+
+// GeoIPDatabase MaxMind DB (GeoLite2) reader
+type GeoIPDatabase struct {
+	rw         sync.RWMutex
+	buff       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+// GeoIPLocation location information of an IP address
+type GeoIPLocation struct {
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+	HasCoords bool
+}
+
+// Load reads the given MaxMind database file into memory
+func (g *GeoIPDatabase) Load(path string) error {
+	// Read database file
+	buff, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	// Find metadata section
+	idx := bytes.LastIndex(buff, geoipMetadataMarker)
+
+	if idx == -1 {
+		return errors.New("Invalid MaxMind database. Missing metadata section")
+	}
+
+	metadataStart := uint(idx + len(geoipMetadataMarker))
+
+	// Decode metadata
+	metadata, _, err := decodeGeoIPValue(buff[metadataStart:], 0)
+
+	if err != nil {
+		return fmt.Errorf("Cannot decode MaxMind metadata: %v", err)
+	}
+
+	m, ok := metadata.(map[string]interface{})
+
+	if !ok {
+		return errors.New("Invalid MaxMind metadata")
+	}
+
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return fmt.Errorf("Unsupported MaxMind record size %v", recordSize)
+	}
+
+	// Search tree is followed by 16 zero bytes
+	treeSize := uint(nodeCount) * uint(recordSize) / 4
+
+	if treeSize+16 > metadataStart {
+		return errors.New("Invalid MaxMind database. Search tree is too big")
+	}
+
+	db := GeoIPDatabase{
+		buff:       buff[:metadataStart-uint(len(geoipMetadataMarker))],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+		dataStart:  treeSize + 16,
+	}
+
+	// IPv4 addresses live under ::/96 on IPv6 databases
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.readRecord(db.ipv4Start, 0)
+		}
+	}
+
+	// Swap loaded database
+	g.rw.Lock()
+	defer g.rw.Unlock()
+
+	g.buff = db.buff
+	g.nodeCount = db.nodeCount
+	g.recordSize = db.recordSize
+	g.ipVersion = db.ipVersion
+	g.dataStart = db.dataStart
+	g.ipv4Start = db.ipv4Start
+
+	return nil
+}
+
+// Loaded checks if a database is loaded
+func (g *GeoIPDatabase) Loaded() bool {
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+
+	return g.buff != nil
+}
+
+// Lookup resolves the given address. Returns nil for private, invalid or unknown addresses
+func (g *GeoIPDatabase) Lookup(addr string) (*GeoIPLocation, error) {
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+
+	if g.buff == nil {
+		return nil, errors.New("GeoIP database is not loaded")
+	}
+
+	// Parse address
+	ip := net.ParseIP(addr)
+
+	if ip == nil || isPrivateIP(ip) {
+		return nil, nil
+	}
+
+	// Find data record
+	record, err := g.find(ip)
+
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	location := &GeoIPLocation{}
+
+	// Country iso code
+	if country, ok := record["country"].(map[string]interface{}); ok {
+		location.Country, _ = country["iso_code"].(string)
+	}
+
+	// City english name
+	if city, ok := record["city"].(map[string]interface{}); ok {
+		if names, ok := city["names"].(map[string]interface{}); ok {
+			location.City, _ = names["en"].(string)
+		}
+	}
+
+	// Coordinates
+	if loc, ok := record["location"].(map[string]interface{}); ok {
+		lat, latOk := loc["latitude"].(float64)
+		lon, lonOk := loc["longitude"].(float64)
+
+		if latOk && lonOk {
+			location.Latitude = lat
+			location.Longitude = lon
+			location.HasCoords = true
+		}
+	}
+
+	if location.Country == "" {
+		return nil, nil
+	}
+
+	return location, nil
+}
+
+// find walks the search tree and decodes the data record of the given address
+func (g *GeoIPDatabase) find(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := ip.To16()
+
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+
+		if g.ipVersion == 6 {
+			node = g.ipv4Start
+		}
+	} else if g.ipVersion == 4 {
+		return nil, nil
+	}
+
+	// Walk the tree bit by bit
+	for i := 0; i < len(bits)*8 && node < g.nodeCount; i++ {
+		bit := uint(bits[i>>3]>>(7-uint(i&7))) & 1
+		node = g.readRecord(node, bit)
+	}
+
+	// Node count means the address was not found
+	if node <= g.nodeCount {
+		return nil, nil
+	}
+
+	// Resolve data section offset
+	offset := node - g.nodeCount - 16
+
+	if g.dataStart+offset >= uint(len(g.buff)) {
+		return nil, errors.New("Invalid MaxMind database. Data pointer out of range")
+	}
+
+	v, _, err := decodeGeoIPValue(g.buff[g.dataStart:], offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := v.(map[string]interface{})
+
+	return record, nil
+}
+
+// readRecord reads the left (0) or right (1) record of the given node
+func (g *GeoIPDatabase) readRecord(node, bit uint) uint {
+	base := node * g.recordSize / 4
+	b := g.buff
+
+	switch g.recordSize {
+	case 24:
+		i := base + bit*3
+		return uint(b[i])<<16 | uint(b[i+1])<<8 | uint(b[i+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[base+3]&0xF0)<<20 | uint(b[base])<<16 | uint(b[base+1])<<8 | uint(b[base+2])
+		}
+		return uint(b[base+3]&0x0F)<<24 | uint(b[base+4])<<16 | uint(b[base+5])<<8 | uint(b[base+6])
+	}
+
+	return uint(binary.BigEndian.Uint32(b[base+bit*4:]))
+}
+
+// decodeGeoIPValue decodes the value at the given offset of a MaxMind data section
+// returning the value and the offset of the next value
+func decodeGeoIPValue(data []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, errors.New("Unexpected end of data")
+	}
+
+	// Read control byte
+	ctrl := data[offset]
+	offset++
+
+	kind := uint(ctrl >> 5)
+
+	// Pointers use the size bits for the address
+	if kind == 1 {
+		size := uint(ctrl>>3) & 0x3
+		vvv := uint(ctrl & 0x7)
+
+		if offset+size+1 > uint(len(data)) {
+			return nil, 0, errors.New("Unexpected end of data")
+		}
+
+		var pointer uint
+
+		switch size {
+		case 0:
+			pointer = vvv<<8 | uint(data[offset])
+		case 1:
+			pointer = (vvv<<16 | uint(data[offset])<<8 | uint(data[offset+1])) + 2048
+		case 2:
+			pointer = (vvv<<24 | uint(data[offset])<<16 | uint(data[offset+1])<<8 | uint(data[offset+2])) + 526336
+		case 3:
+			pointer = uint(binary.BigEndian.Uint32(data[offset:]))
+		}
+
+		v, _, err := decodeGeoIPValue(data, pointer)
+
+		return v, offset + size + 1, err
+	}
+
+	// Extended types
+	if kind == 0 {
+		if offset >= uint(len(data)) {
+			return nil, 0, errors.New("Unexpected end of data")
+		}
+
+		kind = 7 + uint(data[offset])
+		offset++
+	}
+
+	// Read payload size
+	size := uint(ctrl & 0x1f)
+
+	if size >= 29 {
+		n := size - 28
+
+		if offset+n > uint(len(data)) {
+			return nil, 0, errors.New("Unexpected end of data")
+		}
+
+		extra := uint(0)
+
+		for i := uint(0); i < n; i++ {
+			extra = extra<<8 | uint(data[offset+i])
+		}
+
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		case 3:
+			size = 65821 + extra
+		}
+
+		offset += n
+	}
+
+	// Maps and arrays contain entries instead of bytes
+	switch kind {
+	case 7:
+		m := make(map[string]interface{}, size)
+
+		for i := uint(0); i < size; i++ {
+			key, next, err := decodeGeoIPValue(data, offset)
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			value, after, err := decodeGeoIPValue(data, next)
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			m[fmt.Sprintf("%v", key)] = value
+			offset = after
+		}
+
+		return m, offset, nil
+	case 11:
+		list := make([]interface{}, 0, size)
+
+		for i := uint(0); i < size; i++ {
+			value, next, err := decodeGeoIPValue(data, offset)
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			list = append(list, value)
+			offset = next
+		}
+
+		return list, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errors.New("Unexpected end of data")
+	}
+
+	payload := data[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2:
+		return string(payload), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.New("Invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case 4:
+		return payload, offset, nil
+	case 5, 6, 9:
+		n := uint64(0)
+
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+
+		return n, offset, nil
+	case 8:
+		n := int32(0)
+
+		for _, b := range payload {
+			n = n<<8 | int32(b)
+		}
+
+		return int64(n), offset, nil
+	case 10:
+		return payload, offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.New("Invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("Unknown MaxMind data type %v", kind)
+}
+
+// isPrivateIP checks if the given address belongs to a private or reserved network
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range geoipPrivateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCIDRList parses the given networks panicking on invalid values
+func parseCIDRList(list ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+
+	for _, cidr := range list {
+		_, n, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks
+}
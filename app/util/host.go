@@ -0,0 +1,77 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AllowedHost checks the given Host header against Security.AllowedHosts. Entries can be
+// exact hosts, hosts with a port or *.domain wildcards. An empty list allows every host
+func AllowedHost(host string) bool {
+	allowed := Config.Get().Security.AllowedHosts
+
+	if len(allowed) == 0 {
+		return true
+	}
+
+	name, port := splitHostHeader(host)
+
+	if name == "" {
+		return false
+	}
+
+	for _, entry := range allowed {
+		entryName, entryPort := splitHostHeader(entry)
+
+		// Ports only need to match when the entry has one
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if strings.HasPrefix(entryName, "*.") {
+			if strings.HasSuffix(name, entryName[1:]) {
+				return true
+			}
+			continue
+		}
+
+		if name == entryName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConfiguredHost returns the host of the configured application URL
+func ConfiguredHost() string {
+	u, err := url.Parse("http://" + strings.TrimPrefix(strings.TrimPrefix(Config.Get().URL, "http://"), "https://"))
+
+	if err != nil {
+		return Config.Get().URL
+	}
+
+	return u.Host
+}
+
+// splitHostHeader splits a host header value into its lowercase name and port
+func splitHostHeader(host string) (string, string) {
+	host = strings.ToLower(strings.TrimSpace(host))
+
+	if name, port, err := net.SplitHostPort(host); err == nil {
+		return strings.TrimSuffix(name, "."), port
+	}
+
+	return strings.TrimSuffix(strings.Trim(host, "[]"), "."), ""
+}
+
+// RequestHost returns the validated host of the given request
+func RequestHost(req *http.Request) string {
+	if host, ok := req.Context().Value("host").(string); ok {
+		return host
+	}
+
+	return ConfiguredHost()
+}
@@ -1,6 +1,9 @@
 package util
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -35,19 +38,58 @@ func Loadi18n(path string) error {
 	// Walk over i18n directory
 	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		// Check if valid language file
-		if !strings.HasSuffix(info.Name(), ".i18n") || info.IsDir() {
+		if info.IsDir() {
 			return nil
 		}
 
-		// Decode language file
-		langData := map[string]string{}
-		if _, err := toml.DecodeFile(path, &langData); err != nil {
-			return err
+		var (
+			name     string
+			langData map[string]string
+		)
+
+		switch {
+		case strings.HasSuffix(info.Name(), ".i18n"):
+			// Decode TOML language file
+			name = strings.TrimSuffix(info.Name(), ".i18n")
+			langData = map[string]string{}
+
+			if _, err := toml.DecodeFile(path, &langData); err != nil {
+				return err
+			}
+		case strings.HasSuffix(info.Name(), ".json"):
+			// Decode JSON language file
+			name = strings.TrimSuffix(info.Name(), ".json")
+
+			buff, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			data := map[string]interface{}{}
+
+			if err := json.Unmarshal(buff, &data); err != nil {
+				return fmt.Errorf("Cannot decode language file %v: %v", path, err)
+			}
+
+			langData = map[string]string{}
+			flattenLanguageData(langData, "", data)
+		default:
+			return nil
+		}
+
+		// Merge with files of the same language
+		if lang, ok := LanguageFiles.List[name]; ok {
+			for k, v := range langData {
+				lang.Data[k] = v
+			}
+
+			return nil
 		}
 
 		// Append language file
-		LanguageFiles.List[strings.TrimSuffix(info.Name(), ".i18n")] = &Language{
-			Name: strings.TrimSuffix(info.Name(), ".i18n"),
+		LanguageFiles.List[name] = &Language{
+			Name: name,
 			Data: langData,
 		}
 
@@ -69,3 +111,103 @@ func (l *LanguageHolder) Get(lang string) (*Language, bool) {
 
 	return ng, true
 }
+
+// Resolve returns the first of the given locales that has a loaded language file. Regional
+// locales fall back to their base language and "default" is returned if none is loaded
+func (l *LanguageHolder) Resolve(locales []string) string {
+	for _, locale := range localeCandidates(locales) {
+		if _, ok := l.Get(locale); ok {
+			return locale
+		}
+	}
+
+	return "default"
+}
+
+// Translate looks up the given key on the first locale that defines it. Regional locales
+// fall back to their base language (pt-BR to pt) and the default language is tried last
+func (l *LanguageHolder) Translate(key string, locales []string) (string, bool) {
+	for _, locale := range append(localeCandidates(locales), "default") {
+		lang, ok := l.Get(locale)
+
+		if !ok {
+			continue
+		}
+
+		if str, ok := lang.Data[key]; ok {
+			return str, true
+		}
+	}
+
+	return "", false
+}
+
+// InterpolateTranslation replaces every {name} placeholder with the given variables
+func InterpolateTranslation(str string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return str
+	}
+
+	replace := make([]string, 0, len(vars)*2)
+
+	for k, v := range vars {
+		replace = append(replace, "{"+k+"}", v)
+	}
+
+	return strings.NewReplacer(replace...).Replace(str)
+}
+
+// localeCandidates converts a list of locales or Accept-Language values
+// (en-US;q=0.8) to the ordered list of language file names to try
+func localeCandidates(locales []string) []string {
+	candidates := []string{}
+	seen := map[string]bool{}
+
+	add := func(locale string) {
+		if locale != "" && !seen[locale] {
+			seen[locale] = true
+			candidates = append(candidates, locale)
+		}
+	}
+
+	for _, locale := range locales {
+		// Remove quality value
+		if idx := strings.Index(locale, ";"); idx >= 0 {
+			locale = locale[:idx]
+		}
+
+		locale = strings.TrimSpace(locale)
+
+		if locale == "*" {
+			continue
+		}
+
+		// Try the full locale and then the base language
+		add(locale)
+		add(strings.Replace(locale, "-", "_", -1))
+
+		if idx := strings.IndexAny(locale, "-_"); idx > 0 {
+			add(locale[:idx])
+		}
+	}
+
+	return candidates
+}
+
+// flattenLanguageData flattens nested language objects using dot separated keys
+func flattenLanguageData(dst map[string]string, prefix string, data map[string]interface{}) {
+	for k, v := range data {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		switch value := v.(type) {
+		case map[string]interface{}:
+			flattenLanguageData(dst, k, value)
+		case string:
+			dst[k] = value
+		default:
+			dst[k] = fmt.Sprint(value)
+		}
+	}
+}
@@ -0,0 +1,675 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+
+	// Register webp decoder
+	_ "golang.org/x/image/webp"
+)
+
+// maxConvertPixels maximum number of pixels of an image that can be converted
+const maxConvertPixels = 40000000
+
+// ErrUnsupportedImage returned when an image format cannot be decoded
+var ErrUnsupportedImage = errors.New("Unsupported image format")
+
+// Image drawable image used by the goimage lua module
+type Image struct {
+	RGBA     *image.RGBA
+	Font     *truetype.Font
+	FontSize float64
+}
+
+// NewImage creates a new transparent image with the given size
+func NewImage(w, h int) *Image {
+	return &Image{
+		RGBA: image.NewRGBA(image.Rect(0, 0, w, h)),
+	}
+}
+
+// LoadImage creates a new image from the given image file. The EXIF orientation is applied
+// and the image metadata is dropped
+func LoadImage(path string) (*Image, error) {
+	// Read image file
+	buff, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewImageFromBytes(buff)
+}
+
+// NewImageFromBytes creates a new image from the given encoded image
+func NewImageFromBytes(buff []byte) (*Image, error) {
+	// Decode image
+	src, _, err := DecodeImage(buff)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Create image with the file size
+	img := NewImage(src.Bounds().Dx(), src.Bounds().Dy())
+
+	// Draw file contents
+	draw.Draw(img.RGBA, img.RGBA.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	return img, nil
+}
+
+// SetBackgroundImage replaces the image contents with the given image file scaled to the image size
+func (i *Image) SetBackgroundImage(path string) error {
+	// Open image file
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	// Close image file
+	defer f.Close()
+
+	// Decode image
+	bg, _, err := image.Decode(f)
+
+	if err != nil {
+		return err
+	}
+
+	// Draw background scaled to the image size
+	xdraw.CatmullRom.Scale(i.RGBA, i.RGBA.Bounds(), bg, bg.Bounds(), xdraw.Src, nil)
+
+	return nil
+}
+
+// TextStyle outline and shadow drawn behind a text
+type TextStyle struct {
+	StrokeColor color.Color
+	StrokeWidth int
+	Shadow      *TextShadow
+}
+
+// TextShadow copy of a text drawn at the given offset
+type TextShadow struct {
+	DX    int
+	DY    int
+	Color color.Color
+}
+
+// WriteText draws the given text using the image font. The y position is the baseline of the first
+// line and lines are wrapped to the given max width when greater than zero
+func (i *Image) WriteText(text string, c color.Color, size float64, x, y, maxWidth int, style TextStyle) error {
+	// Get image font
+	f, err := i.font()
+
+	if err != nil {
+		return err
+	}
+
+	return i.writeText(f, text, c, size, x, y, maxWidth, style)
+}
+
+// WriteTextFont draws the given text using the given font file
+func (i *Image) WriteTextFont(fontPath, text string, c color.Color, size float64, x, y, maxWidth int, style TextStyle) error {
+	// Read font file
+	buff, err := ioutil.ReadFile(fontPath)
+
+	if err != nil {
+		return err
+	}
+
+	// Parse font
+	f, err := freetype.ParseFont(buff)
+
+	if err != nil {
+		return err
+	}
+
+	return i.writeText(f, text, c, size, x, y, maxWidth, style)
+}
+
+// SetFont loads the given font file to be used for the next texts. Only TrueType outlines are supported
+func (i *Image) SetFont(path string, size float64) error {
+	// Read font file
+	buff, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	// Parse font
+	f, err := freetype.ParseFont(buff)
+
+	if err != nil {
+		return fmt.Errorf("Unsupported font file %v: %v", path, err)
+	}
+
+	// Set image font
+	i.Font = f
+	i.FontSize = size
+
+	return nil
+}
+
+// MeasureText returns the width and height in pixels of the given text using the image font
+func (i *Image) MeasureText(text string, size float64, maxWidth int) (int, int, error) {
+	// Get image font
+	f, err := i.font()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Use the image font size when no size is given
+	if size <= 0 {
+		size = i.FontSize
+	}
+
+	// Split text into lines
+	lines := wrapText(f, text, size, maxWidth)
+
+	// Get widest line
+	width := 0
+
+	for _, line := range lines {
+		if w := measureString(f, line, size); w > width {
+			width = w
+		}
+	}
+
+	return width, len(lines) * lineHeight(f, size), nil
+}
+
+// font returns the image font or the default font
+func (i *Image) font() (*truetype.Font, error) {
+	if i.Font != nil {
+		return i.Font, nil
+	}
+
+	return freetype.ParseFont(goregular.TTF)
+}
+
+// writeText draws the given text using the given font. The shadow and the outline are drawn
+// before the text so they never cover it
+func (i *Image) writeText(f *truetype.Font, text string, c color.Color, size float64, x, y, maxWidth int, style TextStyle) error {
+	// Use the image font size when no size is given
+	if size <= 0 {
+		size = i.FontSize
+	}
+
+	// Create freetype context
+	ctx := freetype.NewContext()
+	ctx.SetDPI(72)
+	ctx.SetFont(f)
+	ctx.SetFontSize(size)
+	ctx.SetClip(i.RGBA.Bounds())
+	ctx.SetDst(i.RGBA)
+
+	// Get line height
+	height := lineHeight(f, size)
+	lines := wrapText(f, text, size, maxWidth)
+
+	// drawLines draws every line using the given color and offset
+	drawLines := func(src color.Color, dx, dy int) error {
+		ctx.SetSrc(image.NewUniform(src))
+
+		for n, line := range lines {
+
+			// Draw line using the given position as baseline
+			if _, err := ctx.DrawString(line, freetype.Pt(x+dx, y+dy+n*height)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Draw shadow
+	if style.Shadow != nil {
+		if err := drawLines(style.Shadow.Color, style.Shadow.DX, style.Shadow.DY); err != nil {
+			return err
+		}
+	}
+
+	// Draw outline as copies of the text around its position
+	if style.StrokeColor != nil && style.StrokeWidth > 0 {
+		w := style.StrokeWidth
+
+		for dy := -w; dy <= w; dy++ {
+			for dx := -w; dx <= w; dx++ {
+				if (dx == 0 && dy == 0) || dx*dx+dy*dy > w*w {
+					continue
+				}
+
+				if err := drawLines(style.StrokeColor, dx, dy); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return drawLines(c, 0, 0)
+}
+
+// measureString returns the width in pixels of the given string
+func measureString(f *truetype.Font, text string, size float64) int {
+	// Create font face
+	face := truetype.NewFace(f, &truetype.Options{
+		Size: size,
+		DPI:  72,
+	})
+
+	// Close font face
+	defer face.Close()
+
+	return font.MeasureString(face, text).Ceil()
+}
+
+// lineHeight returns the height in pixels of a single line of text
+func lineHeight(f *truetype.Font, size float64) int {
+	// Get font bounds at the given size
+	b := f.Bounds(fixed.Int26_6(size * 64))
+
+	return (b.Max.Y - b.Min.Y).Ceil()
+}
+
+// wrapText splits the given text into lines no wider than the given max width. Words wider than
+// the max width are placed on their own line
+func wrapText(f *truetype.Font, text string, size float64, maxWidth int) []string {
+	// Split explicit line breaks
+	paragraphs := strings.Split(text, "\n")
+
+	if maxWidth <= 0 {
+		return paragraphs
+	}
+
+	// Lines holder
+	lines := []string{}
+
+	for _, paragraph := range paragraphs {
+
+		// Current line holder
+		line := ""
+
+		for _, word := range strings.Fields(paragraph) {
+
+			// Start line with the first word
+			if line == "" {
+				line = word
+				continue
+			}
+
+			// Append word if the line still fits
+			if measureString(f, line+" "+word, size) <= maxWidth {
+				line += " " + word
+				continue
+			}
+
+			lines = append(lines, line)
+			line = word
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Rotate rotates the image counter-clockwise by the given degrees. The canvas is expanded to fit the
+// rotated image and the uncovered area is left transparent
+func (i *Image) Rotate(degrees float64) {
+	// Get rotation angle
+	angle := degrees * math.Pi / 180
+	sin, cos := math.Sin(angle), math.Cos(angle)
+
+	// Get source size
+	w := float64(i.RGBA.Bounds().Dx())
+	h := float64(i.RGBA.Bounds().Dy())
+
+	// Get rotated bounds
+	nw := math.Abs(w*cos) + math.Abs(h*sin)
+	nh := math.Abs(w*sin) + math.Abs(h*cos)
+
+	// Create rotated canvas
+	dst := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(nw-1e-9)), int(math.Ceil(nh-1e-9))))
+
+	// Move source center to the origin, rotate and move to the canvas center
+	m := f64.Aff3{
+		cos, sin, nw/2 - cos*w/2 - sin*h/2,
+		-sin, cos, nh/2 + sin*w/2 - cos*h/2,
+	}
+
+	// Draw rotated image
+	xdraw.BiLinear.Transform(dst, m, i.RGBA, i.RGBA.Bounds(), xdraw.Src, nil)
+
+	i.RGBA = dst
+}
+
+// Thumbnail returns a new image scaled to fit within the given box keeping the aspect ratio.
+// Images smaller than the box are never upscaled
+func (i *Image) Thumbnail(maxW, maxH int) *Image {
+	// Get source size
+	w := i.RGBA.Bounds().Dx()
+	h := i.RGBA.Bounds().Dy()
+
+	// Get scale factor that fits both dimensions
+	scale := math.Min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+
+	if scale > 1 {
+		scale = 1
+	}
+
+	// Get thumbnail size
+	tw := int(math.Max(1, math.Floor(float64(w)*scale+0.5)))
+	th := int(math.Max(1, math.Floor(float64(h)*scale+0.5)))
+
+	// Create thumbnail
+	thumb := &Image{
+		RGBA:     image.NewRGBA(image.Rect(0, 0, tw, th)),
+		Font:     i.Font,
+		FontSize: i.FontSize,
+	}
+
+	// Draw scaled image
+	xdraw.CatmullRom.Scale(thumb.RGBA, thumb.RGBA.Bounds(), i.RGBA, i.RGBA.Bounds(), xdraw.Src, nil)
+
+	return thumb
+}
+
+// AverageColor returns the mean color of the image. Pixels are weighted by their alpha so
+// transparent areas do not darken the result
+func (i *Image) AverageColor() color.RGBA {
+	var r, g, b, a uint64
+
+	// Sum premultiplied channels
+	pix := i.RGBA.Pix
+
+	for p := 0; p+3 < len(pix); p += 4 {
+		r += uint64(pix[p])
+		g += uint64(pix[p+1])
+		b += uint64(pix[p+2])
+		a += uint64(pix[p+3])
+	}
+
+	if a == 0 {
+		return color.RGBA{}
+	}
+
+	return color.RGBA{
+		R: uint8(r * 255 / a),
+		G: uint8(g * 255 / a),
+		B: uint8(b * 255 / a),
+		A: 255,
+	}
+}
+
+// DominantColor returns the most common color of the image. Pixels are quantized to 4 bits per
+// channel and the mean color of the most populated bucket is returned. Mostly transparent pixels
+// are ignored
+func (i *Image) DominantColor() color.RGBA {
+	// Bucket holder
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+
+	buckets := map[int]*bucket{}
+	var best *bucket
+
+	for y := i.RGBA.Bounds().Min.Y; y < i.RGBA.Bounds().Max.Y; y++ {
+		for x := i.RGBA.Bounds().Min.X; x < i.RGBA.Bounds().Max.X; x++ {
+			// Get non premultiplied color
+			c := color.NRGBAModel.Convert(i.RGBA.At(x, y)).(color.NRGBA)
+
+			if c.A < 128 {
+				continue
+			}
+
+			// Quantize color
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+
+			bk, ok := buckets[key]
+
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+
+			bk.count++
+			bk.r += int(c.R)
+			bk.g += int(c.G)
+			bk.b += int(c.B)
+
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return color.RGBA{}
+	}
+
+	return color.RGBA{
+		R: uint8(best.r / best.count),
+		G: uint8(best.g / best.count),
+		B: uint8(best.b / best.count),
+		A: 255,
+	}
+}
+
+// BarStyle colors used to draw a progress bar. Nil colors are not drawn
+type BarStyle struct {
+	Fill        color.Color
+	Background  color.Color
+	Border      color.Color
+	BorderWidth int
+}
+
+// DrawBar draws a progress bar filled to the given percent. The percent is clamped between
+// 0 and 100 and the border is drawn inside the bar rectangle
+func (i *Image) DrawBar(x, y, w, h int, percent float64, style BarStyle) {
+	// Clamp percent
+	if percent < 0 {
+		percent = 0
+	}
+
+	if percent > 100 {
+		percent = 100
+	}
+
+	bar := image.Rect(x, y, x+w, y+h)
+
+	// Draw border
+	if style.Border != nil && style.BorderWidth > 0 {
+		draw.Draw(i.RGBA, bar, image.NewUniform(style.Border), image.ZP, draw.Over)
+		bar = bar.Inset(style.BorderWidth)
+	}
+
+	if bar.Empty() {
+		return
+	}
+
+	// Draw background
+	if style.Background != nil {
+		draw.Draw(i.RGBA, bar, image.NewUniform(style.Background), image.ZP, draw.Src)
+	}
+
+	// Draw filled portion
+	filled := int(float64(bar.Dx())*percent/100 + 0.5)
+
+	if style.Fill != nil && filled > 0 {
+		draw.Draw(i.RGBA, image.Rect(bar.Min.X, bar.Min.Y, bar.Min.X+filled, bar.Max.Y), image.NewUniform(style.Fill), image.ZP, draw.Src)
+	}
+}
+
+// FlipH flips the image horizontally
+func (i *Image) FlipH() {
+	// Get image bounds
+	b := i.RGBA.Bounds()
+
+	// Create flipped canvas
+	dst := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.X-1-(x-b.Min.X), y, i.RGBA.At(x, y))
+		}
+	}
+
+	i.RGBA = dst
+}
+
+// FlipV flips the image vertically
+func (i *Image) FlipV() {
+	// Get image bounds
+	b := i.RGBA.Bounds()
+
+	// Create flipped canvas
+	dst := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x, b.Max.Y-1-(y-b.Min.Y), i.RGBA.At(x, y))
+		}
+	}
+
+	i.RGBA = dst
+}
+
+// Encode encodes the image as png to the given writer
+func (i *Image) Encode(w io.Writer) error {
+	return png.Encode(w, i.RGBA)
+}
+
+// Save encodes the image as png to the given file
+func (i *Image) Save(path string) error {
+	// Create image file
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	// Close image file
+	defer f.Close()
+
+	return i.Encode(f)
+}
+
+// ConvertImage decodes the given image file detecting the format by its content and
+// encodes it to dst using the given format (png, jpeg, gif, bmp or tiff). Quality is
+// only used by jpeg, zero uses the default quality. The EXIF orientation is applied and
+// the metadata is dropped unless keepMetadata is set, which is only supported for jpeg files
+func ConvertImage(src, dst, format string, quality int, keepMetadata bool) error {
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	// Get encoder
+	var encode func(w io.Writer, img image.Image) error
+
+	switch strings.ToLower(format) {
+	case "png":
+		encode = png.Encode
+	case "jpeg", "jpg":
+		encode = func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, flattenImage(img, color.White), &jpeg.Options{Quality: quality})
+		}
+	case "gif":
+		encode = func(w io.Writer, img image.Image) error {
+			return gif.Encode(w, img, nil)
+		}
+	case "bmp":
+		encode = bmp.Encode
+	case "tiff":
+		encode = func(w io.Writer, img image.Image) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+		}
+	default:
+		return fmt.Errorf("Unsupported output format %v", format)
+	}
+
+	// Read source file so it can be overwritten
+	buff, err := ioutil.ReadFile(src)
+
+	if err != nil {
+		return err
+	}
+
+	// Check image size before decoding
+	config, _, err := image.DecodeConfig(bytes.NewReader(buff))
+
+	if err != nil {
+		return ErrUnsupportedImage
+	}
+
+	if config.Width*config.Height > maxConvertPixels {
+		return fmt.Errorf("Image is too big (%vx%v)", config.Width, config.Height)
+	}
+
+	// Decode image
+	img, srcFormat, err := DecodeImage(buff)
+
+	if err != nil {
+		return ErrUnsupportedImage
+	}
+
+	// Encode image
+	out := &bytes.Buffer{}
+
+	if err := encode(out, img); err != nil {
+		return err
+	}
+
+	data := out.Bytes()
+
+	// Copy metadata
+	if keepMetadata {
+		if srcFormat != "jpeg" || (strings.ToLower(format) != "jpeg" && strings.ToLower(format) != "jpg") {
+			return errors.New("Metadata can only be kept when converting jpeg images to jpeg")
+		}
+
+		if exif := jpegEXIF(buff); exif != nil {
+			if data, err = insertJPEGEXIF(data, exif); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+// flattenImage draws the given image over a solid background removing transparency
+func flattenImage(src image.Image, background color.Color) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.ZP, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+
+	return dst
+}
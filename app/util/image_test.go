@@ -0,0 +1,177 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// newFilledImage creates an image of the given size filled with the given color
+func newFilledImage(w, h int, c color.RGBA) *Image {
+	img := NewImage(w, h)
+
+	for p := 0; p+3 < len(img.RGBA.Pix); p += 4 {
+		img.RGBA.Pix[p] = c.R
+		img.RGBA.Pix[p+1] = c.G
+		img.RGBA.Pix[p+2] = c.B
+		img.RGBA.Pix[p+3] = c.A
+	}
+
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name       string
+		w, h       int
+		maxW, maxH int
+		wantW      int
+		wantH      int
+	}{
+		{"landscape", 400, 200, 100, 100, 100, 50},
+		{"portrait", 200, 400, 100, 100, 50, 100},
+		{"landscape wide box", 300, 200, 200, 50, 75, 50},
+		{"portrait tall box", 150, 300, 60, 200, 60, 120},
+		{"square", 256, 256, 64, 128, 64, 64},
+		{"smaller than box", 40, 20, 100, 100, 40, 20},
+	}
+
+	for _, test := range tests {
+		thumb := newFilledImage(test.w, test.h, color.RGBA{R: 255, A: 255}).Thumbnail(test.maxW, test.maxH)
+
+		w, h := thumb.RGBA.Bounds().Dx(), thumb.RGBA.Bounds().Dy()
+
+		if w != test.wantW || h != test.wantH {
+			t.Errorf("%v: thumbnail size is %dx%d. Expected %dx%d", test.name, w, h, test.wantW, test.wantH)
+		}
+
+		if w > test.maxW || h > test.maxH {
+			t.Errorf("%v: thumbnail %dx%d does not fit within %dx%d", test.name, w, h, test.maxW, test.maxH)
+		}
+	}
+}
+
+func TestAverageColor(t *testing.T) {
+	want := color.RGBA{R: 12, G: 200, B: 90, A: 255}
+
+	if c := newFilledImage(16, 16, want).AverageColor(); c != want {
+		t.Errorf("average color of a solid image is %v. Expected %v", c, want)
+	}
+
+	// Transparent images have no color
+	if c := NewImage(16, 16).AverageColor(); c != (color.RGBA{}) {
+		t.Errorf("average color of a transparent image is %v. Expected zero color", c)
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	want := color.RGBA{R: 30, G: 60, B: 220, A: 255}
+
+	if c := newFilledImage(16, 16, want).DominantColor(); c != want {
+		t.Errorf("dominant color of a solid image is %v. Expected %v", c, want)
+	}
+
+	// Paint a quarter of the image using another color
+	img := newFilledImage(16, 16, want)
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 16; x++ {
+			img.RGBA.SetRGBA(x, y, color.RGBA{R: 250, G: 10, B: 10, A: 255})
+		}
+	}
+
+	if c := img.DominantColor(); c != want {
+		t.Errorf("dominant color is %v. Expected %v", c, want)
+	}
+}
+
+func TestWriteTextStyle(t *testing.T) {
+	background := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	fill := color.RGBA{R: 220, G: 20, B: 20, A: 255}
+	stroke := color.RGBA{A: 255}
+	shadow := color.RGBA{B: 200, A: 255}
+
+	// Render text without style
+	plain := newFilledImage(180, 48, background)
+
+	if err := plain.WriteText("Castro", fill, 28, 10, 34, 0, TextStyle{}); err != nil {
+		t.Fatalf("cannot write text: %v", err)
+	}
+
+	// Render text with outline and shadow
+	styled := newFilledImage(180, 48, background)
+
+	if err := styled.WriteText("Castro", fill, 28, 10, 34, 0, TextStyle{
+		StrokeColor: stroke,
+		StrokeWidth: 2,
+		Shadow: &TextShadow{
+			DX:    4,
+			DY:    4,
+			Color: shadow,
+		},
+	}); err != nil {
+		t.Fatalf("cannot write styled text: %v", err)
+	}
+
+	// isFill checks if the plain text covers the given pixel
+	isFill := func(x, y int) bool {
+		return image.Pt(x, y).In(plain.RGBA.Bounds()) && plain.RGBA.RGBAAt(x, y) == fill
+	}
+
+	fillPixels, strokePixels, shadowPixels := 0, 0, 0
+
+	for y := 0; y < plain.RGBA.Bounds().Dy(); y++ {
+		for x := 0; x < plain.RGBA.Bounds().Dx(); x++ {
+			got := styled.RGBA.RGBAAt(x, y)
+
+			// The fill is drawn last so it is never covered by the outline or the shadow
+			if isFill(x, y) {
+				fillPixels++
+
+				if got != fill {
+					t.Fatalf("text fill at %d,%d is %v. Expected %v", x, y, got, fill)
+				}
+
+				continue
+			}
+
+			// Pixels outside the text next to the fill are covered by the outline
+			if plain.RGBA.RGBAAt(x, y) == background && (isFill(x-1, y) || isFill(x+1, y) || isFill(x, y-1) || isFill(x, y+1)) {
+				strokePixels++
+
+				if got != stroke {
+					t.Fatalf("outline at %d,%d is %v. Expected %v", x, y, got, stroke)
+				}
+			}
+
+			if got == shadow {
+				shadowPixels++
+			}
+		}
+	}
+
+	if fillPixels == 0 {
+		t.Fatal("text has no fill pixels")
+	}
+
+	if strokePixels == 0 {
+		t.Error("styled text has no outline pixels")
+	}
+
+	if shadowPixels == 0 {
+		t.Error("styled text has no shadow pixels")
+	}
+
+	// The shadow is only drawn at its offset from the text
+	for y := 4; y < styled.RGBA.Bounds().Dy(); y++ {
+		for x := 4; x < styled.RGBA.Bounds().Dx(); x++ {
+			if styled.RGBA.RGBAAt(x, y) != shadow {
+				continue
+			}
+
+			if plain.RGBA.RGBAAt(x-4, y-4) == background {
+				t.Fatalf("shadow pixel at %d,%d is not at the shadow offset", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the client address of the given request. Forwarding headers are only read
+// when the request comes from a trusted proxy listed on Security.TrustedProxies. When
+// Security.ProxyHeader is set only that header is used, otherwise X-Forwarded-For is walked
+func ClientIP(req *http.Request) string {
+	// Get connection address
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	// Check if peer is a trusted proxy
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	// Use the configured proxy header
+	if header := Config.Get().Security.ProxyHeader; header != "" && !strings.EqualFold(header, "X-Forwarded-For") {
+		if ip := strings.TrimSpace(req.Header.Get(header)); net.ParseIP(ip) != nil {
+			return ip
+		}
+
+		return host
+	}
+
+	// Walk X-Forwarded-For from the right skipping trusted proxies
+	forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+
+		if net.ParseIP(ip) == nil {
+			break
+		}
+
+		if i == 0 || !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+
+	return host
+}
+
+// isTrustedProxy checks if the given address is a trusted proxy. No peer is trusted when
+// Security.TrustedProxies is empty
+func isTrustedProxy(addr string) bool {
+	// Get trusted proxy list
+	proxies := Config.Get().Security.TrustedProxies
+
+	// Parse address
+	ip := net.ParseIP(addr)
+
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range proxies {
+
+		// Check CIDR ranges
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// Check single addresses
+		if p := net.ParseIP(proxy); p != nil && p.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrJWTMalformed returned when the token is not a valid JWT
+	ErrJWTMalformed = errors.New("Malformed token")
+
+	// ErrJWTSignature returned when the token signature does not match
+	ErrJWTSignature = errors.New("Invalid token signature")
+
+	// ErrJWTExpired returned when the token exp claim is in the past
+	ErrJWTExpired = errors.New("Token is expired")
+
+	// ErrJWTNotValidYet returned when the token nbf claim is in the future
+	ErrJWTNotValidYet = errors.New("Token is not valid yet")
+
+	// jwtAlgorithms supported HMAC signing algorithms
+	jwtAlgorithms = map[string]func() hash.Hash{
+		"HS256": sha256.New,
+		"HS384": sha512.New384,
+		"HS512": sha512.New,
+	}
+)
+
+// jwtHeader JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// SignJWT encodes and signs the given claims using the given HMAC algorithm
+func SignJWT(claims map[string]interface{}, secret []byte, alg string) (string, error) {
+	h, ok := jwtAlgorithms[alg]
+
+	if !ok {
+		return "", errors.New("Unsupported token algorithm " + alg)
+	}
+
+	// Encode header
+	header, err := json.Marshal(jwtHeader{
+		Alg: alg,
+		Typ: "JWT",
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	// Encode claims
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signJWTPart(h, secret, unsigned)), nil
+}
+
+// VerifyJWT checks the token signature and the exp and nbf claims returning the token claims.
+// Leeway is the allowed clock difference when checking the time claims
+func VerifyJWT(token string, secret []byte, leeway time.Duration) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	// Decode header
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	header := jwtHeader{}
+
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	// Only HMAC algorithms are accepted so "none" tokens are rejected
+	h, ok := jwtAlgorithms[header.Alg]
+
+	if !ok {
+		return nil, errors.New("Unsupported token algorithm " + header.Alg)
+	}
+
+	// Check signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	if !hmac.Equal(signature, signJWTPart(h, secret, parts[0]+"."+parts[1])) {
+		return nil, ErrJWTSignature
+	}
+
+	// Decode claims
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	claims := map[string]interface{}{}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	now := time.Now()
+
+	// Check expiration time
+	if v, ok := claims["exp"]; ok {
+		exp, ok := v.(float64)
+
+		if !ok {
+			return nil, ErrJWTMalformed
+		}
+
+		if now.Add(-leeway).Unix() >= int64(exp) {
+			return nil, ErrJWTExpired
+		}
+	}
+
+	// Check not before time
+	if v, ok := claims["nbf"]; ok {
+		nbf, ok := v.(float64)
+
+		if !ok {
+			return nil, ErrJWTMalformed
+		}
+
+		if now.Add(leeway).Unix() < int64(nbf) {
+			return nil, ErrJWTNotValidYet
+		}
+	}
+
+	return claims, nil
+}
+
+// signJWTPart returns the HMAC of the given token part
+func signJWTPart(h func() hash.Hash, secret []byte, part string) []byte {
+	mac := hmac.New(h, secret)
+	mac.Write([]byte(part))
+
+	return mac.Sum(nil)
+}
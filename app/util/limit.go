@@ -0,0 +1,123 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxBody default maximum size of a request body
+	defaultMaxBody = 32 << 20
+
+	// defaultRequestTimeout default read and write timeout of a request
+	defaultRequestTimeout = 10 * time.Second
+)
+
+// BodyLimit returns the configured maximum size of a request body
+func (c HTTPConfig) BodyLimit() int64 {
+	if c.MaxBody > 0 {
+		return c.MaxBody
+	}
+
+	return defaultMaxBody
+}
+
+// ReadTimeoutDuration returns the configured request read timeout
+func (c HTTPConfig) ReadTimeoutDuration() time.Duration {
+	if c.ReadTimeout.Duration > 0 {
+		return c.ReadTimeout.Duration
+	}
+
+	return defaultRequestTimeout
+}
+
+// WriteTimeoutDuration returns the configured response write timeout
+func (c HTTPConfig) WriteTimeoutDuration() time.Duration {
+	if c.WriteTimeout.Duration > 0 {
+		return c.WriteTimeout.Duration
+	}
+
+	return defaultRequestTimeout
+}
+
+// LimitedBody request body with a size limit that can be changed while the body is being read
+type LimitedBody struct {
+	w        http.ResponseWriter
+	body     io.ReadCloser
+	reader   io.ReadCloser
+	read     int64
+	exceeded bool
+}
+
+// NewLimitedBody wraps the given request body using the given size limit
+func NewLimitedBody(w http.ResponseWriter, body io.ReadCloser, limit int64) *LimitedBody {
+	b := &LimitedBody{
+		w:    w,
+		body: body,
+	}
+	b.SetLimit(limit)
+
+	return b
+}
+
+// SetLimit sets the maximum size of the body. Bytes already read count towards the limit
+func (b *LimitedBody) SetLimit(limit int64) {
+	remaining := limit - b.read
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	b.reader = http.MaxBytesReader(b.w, b.body, remaining)
+}
+
+// Exceeded checks if the client sent more bytes than allowed
+func (b *LimitedBody) Exceeded() bool {
+	return b.exceeded
+}
+
+// Read reads from the body. Reading past the limit returns an error
+func (b *LimitedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+
+	if _, ok := err.(*http.MaxBytesError); ok {
+		b.exceeded = true
+	}
+
+	return n, err
+}
+
+// Close closes the underlying body
+func (b *LimitedBody) Close() error {
+	return b.body.Close()
+}
+
+// ConnContext saves the client connection on the connection context so
+// handlers can change the connection deadlines
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, "conn", c)
+}
+
+// SetRequestDeadlines sets the read and write deadlines of the connection serving the given
+// request. Zero durations are ignored
+func SetRequestDeadlines(req *http.Request, read, write time.Duration) bool {
+	c, ok := req.Context().Value("conn").(net.Conn)
+
+	if !ok {
+		return false
+	}
+
+	if read > 0 {
+		c.SetReadDeadline(time.Now().Add(read))
+	}
+
+	if write > 0 {
+		c.SetWriteDeadline(time.Now().Add(write))
+	}
+
+	return true
+}
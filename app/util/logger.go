@@ -2,9 +2,11 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
@@ -32,6 +34,11 @@ type ApplicationLogger struct {
 	LastLoggerDay time.Time
 }
 
+// ForRequest returns a log entry tagged with the identifier of the given request
+func (a *ApplicationLogger) ForRequest(req *http.Request) *logrus.Entry {
+	return a.Logger.WithField("request_id", RequestID(req))
+}
+
 // Custom logrus formatter interface
 type castroFormatter struct {
 }
@@ -39,8 +46,24 @@ type castroFormatter struct {
 // Format converts a logrus text into a valid byte array for castro logging
 func (c *castroFormatter) Format(e *logrus.Entry) ([]byte, error) {
 	buff := &bytes.Buffer{}
+
+	// Log entries without fields
+	if len(e.Data) == 0 {
+		buff.WriteString(
+			fmt.Sprintf("[%s] (%s) %s \r\n", e.Level, e.Time.Format("2006-01-02 15:04:05"), e.Message),
+		)
+		return buff.Bytes(), nil
+	}
+
+	// Encode entry fields
+	fields, err := json.Marshal(e.Data)
+
+	if err != nil {
+		return nil, err
+	}
+
 	buff.WriteString(
-		fmt.Sprintf("[%s] (%s) %s \r\n", e.Level, e.Time.Format("2006-01-02 15:04:05"), e.Message),
+		fmt.Sprintf("[%s] (%s) %s %s \r\n", e.Level, e.Time.Format("2006-01-02 15:04:05"), e.Message, fields),
 	)
 	return buff.Bytes(), nil
 }
@@ -0,0 +1,218 @@
+package util
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// smtpSender gomail sender that uses an already connected smtp client
+type smtpSender struct {
+	client *smtp.Client
+}
+
+// Send sends a single message using the smtp client
+func (s *smtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	// Set sender
+	if err := s.client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP sender rejected: %v", err)
+	}
+
+	// Set recipients
+	for _, addr := range to {
+		if err := s.client.Rcpt(addr); err != nil {
+			return fmt.Errorf("SMTP recipient %v rejected: %v", addr, err)
+		}
+	}
+
+	// Start message data
+	w, err := s.client.Data()
+
+	if err != nil {
+		return fmt.Errorf("SMTP data command failed: %v", err)
+	}
+
+	// Write message
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// SendMail sends the given messages using the configured mail server
+func SendMail(msgs ...*gomail.Message) error {
+	// Connect to the mail server
+	client, err := dialMailServer(Config.Get().Mail)
+
+	if err != nil {
+		return err
+	}
+
+	// Close connection
+	defer client.Close()
+
+	// Send messages
+	if err := gomail.Send(&smtpSender{client: client}, msgs...); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// SendBulkMail sends every message over a single connection throttled to rate messages per
+// second. Individual failures do not stop the batch, the connection is reset or reopened and
+// the returned slice holds the error of each message (nil when it was sent)
+func SendBulkMail(msgs []*gomail.Message, rate float64) []error {
+	errs := make([]error, len(msgs))
+
+	// Get delay between messages
+	var delay time.Duration
+
+	if rate > 0 {
+		delay = time.Duration(float64(time.Second) / rate)
+	}
+
+	var client *smtp.Client
+	var last time.Time
+
+	for i, msg := range msgs {
+		// Throttle messages
+		if wait := delay - time.Since(last); !last.IsZero() && wait > 0 {
+			time.Sleep(wait)
+		}
+
+		last = time.Now()
+
+		// Connect to the mail server if needed
+		if client == nil {
+			c, err := dialMailServer(Config.Get().Mail)
+
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			client = c
+		}
+
+		// Send message
+		if err := gomail.Send(&smtpSender{client: client}, msg); err != nil {
+			errs[i] = err
+
+			// Reset the session or reconnect on the next message
+			if client.Reset() != nil {
+				client.Close()
+				client = nil
+			}
+		}
+	}
+
+	// Close connection
+	if client != nil {
+		client.Quit()
+	}
+
+	return errs
+}
+
+// dialMailServer connects and authenticates to the mail server using the configured TLS mode.
+// Supported modes are none, starttls and ssl. When no mode is set ssl is used for port 465 and
+// STARTTLS is used if the server supports it
+func dialMailServer(c MailConfig) (*smtp.Client, error) {
+	// Get server address
+	addr := net.JoinHostPort(c.Server, strconv.Itoa(c.Port))
+
+	// Create TLS configuration
+	tlsConfig := &tls.Config{
+		ServerName:         c.Server,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	// Get TLS mode
+	mode := c.TLSMode
+
+	if mode == "" && c.Port == 465 {
+		mode = "ssl"
+	}
+
+	// Credentials are never sent over a plain connection
+	if mode == "none" && c.Username != "" {
+		return nil, errors.New("Mail credentials cannot be used with TLS mode none")
+	}
+
+	// Connection holder
+	var conn net.Conn
+	var err error
+
+	switch mode {
+	case "ssl":
+
+		// Connect using implicit TLS
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 10}, "tcp", addr, tlsConfig)
+
+		if err != nil {
+			return nil, fmt.Errorf("SMTP TLS connection to %v failed: %v", addr, err)
+		}
+
+	case "", "none", "starttls":
+
+		// Connect using plain TCP
+		conn, err = net.DialTimeout("tcp", addr, time.Second*10)
+
+		if err != nil {
+			return nil, fmt.Errorf("SMTP connection to %v failed: %v", addr, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown mail TLS mode %v. Expected none, starttls or ssl", mode)
+	}
+
+	// Create smtp client
+	client, err := smtp.NewClient(conn, c.Server)
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP handshake with %v failed: %v", addr, err)
+	}
+
+	// Upgrade connection using STARTTLS
+	if mode == "" || mode == "starttls" {
+
+		// Check if server supports STARTTLS
+		if ok, _ := client.Extension("STARTTLS"); ok {
+
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("SMTP STARTTLS handshake with %v failed: %v", addr, err)
+			}
+
+		} else if mode == "starttls" {
+			client.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+	}
+
+	// Authenticate
+	if c.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("SMTP server does not support authentication")
+		}
+
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Server)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	return client, nil
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/xml"
+	"fmt"
 	"io/ioutil"
+	"sort"
 	"sync"
 
 	"github.com/raggaer/otmap"
@@ -40,6 +42,7 @@ type House struct {
 	EntryY uint16 `xml:"entryy,attr"`
 	EntryZ uint16 `xml:"entryz,attr"`
 	Size   int    `xml:"size,attr"`
+	Rent   int    `xml:"rent,attr"`
 	TownID uint32 `xml:"townid,attr"`
 }
 
@@ -82,6 +85,64 @@ func (s *ServerHouses) LoadHouses(file string) error {
 	return xml.Unmarshal(f, &s.List)
 }
 
+// Sorted returns the houses of the given town (0 for every town) sorted by the given
+// field (id, name, size, rent or town). Ties are sorted by id so the order is stable
+func (s *ServerHouses) Sorted(town uint32, field string, desc bool) ([]*House, error) {
+	// Lock mutex
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	// Get sort function
+	var less func(a, b *House) bool
+
+	switch field {
+	case "", "id":
+		less = func(a, b *House) bool { return false }
+	case "name":
+		less = func(a, b *House) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b *House) bool { return a.Size < b.Size }
+	case "rent":
+		less = func(a, b *House) bool { return a.Rent < b.Rent }
+	case "town":
+		less = func(a, b *House) bool { return a.TownID < b.TownID }
+	default:
+		return nil, fmt.Errorf("Invalid house sort field %v", field)
+	}
+
+	// Filter houses
+	houses := []*House{}
+
+	if s.List != nil {
+		for _, house := range s.List.Houses {
+			if town == 0 || house.TownID == town {
+				houses = append(houses, house)
+			}
+		}
+	}
+
+	// Sort houses
+	sort.Slice(houses, func(i, j int) bool {
+		a, b := houses[i], houses[j]
+
+		if desc {
+			a, b = b, a
+		}
+
+		if less(a, b) {
+			return true
+		}
+
+		if less(b, a) {
+			return false
+		}
+
+		return a.ID < b.ID
+	})
+
+	return houses, nil
+}
+
 // EncodeMap encodes the server map
 func EncodeMap(path string) ([]byte, error) {
 	// Parse server map
@@ -0,0 +1,596 @@
+package util
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	markdownHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	markdownRule       = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	markdownFence      = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	markdownListItem   = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])([ \t]+|$)`)
+	markdownQuote      = regexp.MustCompile(`^ {0,3}> ?`)
+	markdownSetext     = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	markdownHTMLBlock  = regexp.MustCompile(`^ {0,3}</?[a-zA-Z][a-zA-Z0-9-]*(\s|/?>|$)`)
+	markdownInlineHTML = regexp.MustCompile(`^</?[a-zA-Z][a-zA-Z0-9-]*(\s+[a-zA-Z_:][a-zA-Z0-9_.:-]*(\s*=\s*("[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*\s*/?>`)
+	markdownAutolink   = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)>`)
+	markdownEntity     = regexp.MustCompile(`^&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+)
+
+// RenderMarkdown converts the given CommonMark input to HTML. The output is sanitized
+// so scripts, event handlers and unsafe URLs are removed even from raw HTML blocks
+func RenderMarkdown(input string) string {
+	// Normalize line endings and tabs
+	input = strings.Replace(input, "\r\n", "\n", -1)
+	input = strings.Replace(input, "\r", "\n", -1)
+	input = strings.Replace(input, "\t", "    ", -1)
+
+	buff := &bytes.Buffer{}
+	renderMarkdownBlocks(buff, strings.Split(input, "\n"))
+
+	return markdownPolicy.sanitize(buff.String())
+}
+
+// isMarkdownBlank checks if the given line is empty
+func isMarkdownBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// startsMarkdownBlock checks if the given line interrupts a paragraph
+func startsMarkdownBlock(line string) bool {
+	if markdownHeading.MatchString(line) || markdownRule.MatchString(line) || markdownFence.MatchString(line) || markdownQuote.MatchString(line) {
+		return true
+	}
+
+	if m := markdownListItem.FindStringSubmatch(line); m != nil && strings.TrimSpace(line[len(m[0]):]) != "" {
+		return m[3] == "" || m[3] == "1"
+	}
+
+	return false
+}
+
+// renderMarkdownBlocks renders the given lines as block elements
+func renderMarkdownBlocks(buff *bytes.Buffer, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		// Skip blank lines
+		if isMarkdownBlank(line) {
+			i++
+			continue
+		}
+
+		// Fenced code block
+		if m := markdownFence.FindStringSubmatch(line); m != nil {
+			i = renderMarkdownFence(buff, lines, i, m[1], m[2])
+			continue
+		}
+
+		// Indented code block
+		if strings.HasPrefix(line, "    ") {
+			code := []string{}
+
+			for i < len(lines) && (strings.HasPrefix(lines[i], "    ") || isMarkdownBlank(lines[i])) {
+				if len(lines[i]) >= 4 {
+					code = append(code, lines[i][4:])
+				} else {
+					code = append(code, "")
+				}
+				i++
+			}
+
+			// Remove trailing blank lines
+			for len(code) > 0 && isMarkdownBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+
+			buff.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+			continue
+		}
+
+		// ATX heading
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			level := strconv.Itoa(len(m[1]))
+			buff.WriteString("<h" + level + ">" + renderMarkdownInline(strings.TrimSpace(m[2])) + "</h" + level + ">\n")
+			i++
+			continue
+		}
+
+		// Thematic break
+		if markdownRule.MatchString(line) {
+			buff.WriteString("<hr>\n")
+			i++
+			continue
+		}
+
+		// Block quote
+		if markdownQuote.MatchString(line) {
+			quote := []string{}
+
+			for i < len(lines) && !isMarkdownBlank(lines[i]) {
+				if loc := markdownQuote.FindStringIndex(lines[i]); loc != nil {
+					quote = append(quote, lines[i][loc[1]:])
+				} else if len(quote) > 0 && !startsMarkdownBlock(lines[i]) {
+					// Lazy continuation line
+					quote = append(quote, lines[i])
+				} else {
+					break
+				}
+				i++
+			}
+
+			buff.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(buff, quote)
+			buff.WriteString("</blockquote>\n")
+			continue
+		}
+
+		// List
+		if markdownListItem.MatchString(line) {
+			i = renderMarkdownList(buff, lines, i)
+			continue
+		}
+
+		// Raw HTML block, sanitized afterwards
+		if markdownHTMLBlock.MatchString(line) {
+			for i < len(lines) && !isMarkdownBlank(lines[i]) {
+				buff.WriteString(lines[i] + "\n")
+				i++
+			}
+			continue
+		}
+
+		// Paragraph
+		paragraph := []string{}
+
+		for i < len(lines) && !isMarkdownBlank(lines[i]) {
+			// Setext heading underline
+			if len(paragraph) > 0 {
+				if m := markdownSetext.FindStringSubmatch(lines[i]); m != nil {
+					tag := "h2"
+
+					if m[1][0] == '=' {
+						tag = "h1"
+					}
+
+					buff.WriteString("<" + tag + ">" + renderMarkdownInline(strings.TrimSpace(strings.Join(paragraph, "\n"))) + "</" + tag + ">\n")
+					paragraph = nil
+					i++
+					break
+				}
+
+				if startsMarkdownBlock(lines[i]) {
+					break
+				}
+			}
+
+			paragraph = append(paragraph, strings.TrimLeft(lines[i], " "))
+			i++
+		}
+
+		if len(paragraph) > 0 {
+			buff.WriteString("<p>" + renderMarkdownInline(strings.TrimRight(strings.Join(paragraph, "\n"), " ")) + "</p>\n")
+		}
+	}
+}
+
+// renderMarkdownFence renders a fenced code block and returns the next line index
+func renderMarkdownFence(buff *bytes.Buffer, lines []string, i int, fence, info string) int {
+	code := []string{}
+	i++
+
+	for i < len(lines) {
+		trimmed := strings.TrimSpace(lines[i])
+
+		// Closing fence must use the same character and be at least as long
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+
+		code = append(code, lines[i])
+		i++
+	}
+
+	buff.WriteString("<pre><code")
+
+	if info != "" {
+		buff.WriteString(` class="language-` + html.EscapeString(info) + `"`)
+	}
+
+	buff.WriteString(">")
+
+	if len(code) > 0 {
+		buff.WriteString(html.EscapeString(strings.Join(code, "\n")) + "\n")
+	}
+
+	buff.WriteString("</code></pre>\n")
+
+	return i
+}
+
+// renderMarkdownList renders a list starting at the given line and returns the next line index
+func renderMarkdownList(buff *bytes.Buffer, lines []string, i int) int {
+	first := markdownListItem.FindStringSubmatch(lines[i])
+	ordered := first[3] != ""
+	marker := first[2][len(first[2])-1:]
+
+	items := [][]string{}
+	loose := false
+
+	for i < len(lines) {
+		m := markdownListItem.FindStringSubmatch(lines[i])
+
+		// Stop when the marker type changes
+		if m == nil || (m[3] != "") != ordered || m[2][len(m[2])-1:] != marker {
+			break
+		}
+
+		// Content indentation of this item
+		indent := len(m[0])
+
+		if strings.TrimSpace(lines[i][len(m[0]):]) == "" {
+			indent = len(m[1]) + len(m[2]) + 1
+		}
+
+		item := []string{lines[i][len(m[0]):]}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+
+			if isMarkdownBlank(line) {
+				// Blank line keeps the item open only if indented content follows
+				if i+1 < len(lines) && strings.HasPrefix(lines[i+1], strings.Repeat(" ", indent)) && !isMarkdownBlank(lines[i+1]) {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				break
+			}
+
+			if strings.HasPrefix(line, strings.Repeat(" ", indent)) {
+				item = append(item, line[indent:])
+			} else if !startsMarkdownBlock(line) && !markdownListItem.MatchString(line) {
+				// Lazy continuation line
+				item = append(item, strings.TrimLeft(line, " "))
+			} else {
+				break
+			}
+			i++
+		}
+
+		items = append(items, item)
+
+		// A blank line between items makes the list loose
+		if i+1 < len(lines) && isMarkdownBlank(lines[i]) {
+			if n := markdownListItem.FindStringSubmatch(lines[i+1]); n != nil && (n[3] != "") == ordered {
+				loose = true
+				i++
+			}
+		}
+	}
+
+	// Open list tag
+	if ordered {
+		start, _ := strconv.Atoi(first[3])
+
+		if start != 1 {
+			buff.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			buff.WriteString("<ol>\n")
+		}
+	} else {
+		buff.WriteString("<ul>\n")
+	}
+
+	for _, item := range items {
+		content := &bytes.Buffer{}
+		renderMarkdownBlocks(content, item)
+
+		result := content.String()
+
+		// Tight lists do not wrap items on paragraphs
+		if !loose {
+			result = strings.Replace(result, "<p>", "", -1)
+			result = strings.Replace(result, "</p>\n", "\n", -1)
+		}
+
+		buff.WriteString("<li>" + strings.TrimSuffix(result, "\n") + "</li>\n")
+	}
+
+	if ordered {
+		buff.WriteString("</ol>\n")
+	} else {
+		buff.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+// renderMarkdownInline renders inline elements of the given text
+func renderMarkdownInline(text string) string {
+	buff := &bytes.Buffer{}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch c {
+		case '\\':
+			// Escaped punctuation and hard line breaks
+			if i+1 < len(text) {
+				if text[i+1] == '\n' {
+					buff.WriteString("<br>\n")
+					i += 2
+					continue
+				}
+
+				if strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", text[i+1]) >= 0 {
+					buff.WriteString(html.EscapeString(text[i+1 : i+2]))
+					i += 2
+					continue
+				}
+			}
+		case '`':
+			// Code span delimited by equal backtick runs
+			run := markdownRunLength(text, i, '`')
+			delimiter := strings.Repeat("`", run)
+
+			if end := strings.Index(text[i+run:], delimiter); end >= 0 {
+				code := strings.Replace(text[i+run:i+run+end], "\n", " ", -1)
+
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+					code = code[1 : len(code)-1]
+				}
+
+				buff.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += run + end + run
+				continue
+			}
+
+			buff.WriteString(delimiter)
+			i += run
+			continue
+		case '!', '[':
+			// Links and images
+			image := c == '!'
+
+			if image && (i+1 >= len(text) || text[i+1] != '[') {
+				break
+			}
+
+			start := i
+
+			if image {
+				start++
+			}
+
+			if label, dest, title, n, ok := parseMarkdownLink(text, start); ok {
+				if image {
+					buff.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(markdownPlainText(label)) + `"`)
+
+					if title != "" {
+						buff.WriteString(` title="` + html.EscapeString(title) + `"`)
+					}
+
+					buff.WriteString(">")
+				} else {
+					buff.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+
+					if title != "" {
+						buff.WriteString(` title="` + html.EscapeString(title) + `"`)
+					}
+
+					buff.WriteString(">" + renderMarkdownInline(label) + "</a>")
+				}
+
+				i = start + n
+				continue
+			}
+		case '<':
+			// Autolinks
+			if m := markdownAutolink.FindStringSubmatch(text[i:]); m != nil {
+				buff.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+
+			// Inline HTML, sanitized afterwards
+			if m := markdownInlineHTML.FindString(text[i:]); m != "" {
+				buff.WriteString(m)
+				i += len(m)
+				continue
+			}
+		case '&':
+			// Keep entities as they are
+			if m := markdownEntity.FindString(text[i:]); m != "" {
+				buff.WriteString(m)
+				i += len(m)
+				continue
+			}
+		case '*', '_':
+			if n, ok := renderMarkdownEmphasis(buff, text, i); ok {
+				i = n
+				continue
+			}
+
+			// Write the whole delimiter run as text
+			run := markdownRunLength(text, i, c)
+			buff.WriteString(text[i : i+run])
+			i += run
+			continue
+		case '\n':
+			// Hard line break with two trailing spaces
+			if strings.HasSuffix(buff.String(), "  ") {
+				trimmed := strings.TrimRight(buff.String(), " ")
+				buff.Reset()
+				buff.WriteString(trimmed + "<br>\n")
+			} else {
+				buff.WriteString("\n")
+			}
+			i++
+			continue
+		}
+
+		buff.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+
+	return buff.String()
+}
+
+// renderMarkdownEmphasis renders an emphasis span starting at the given position
+func renderMarkdownEmphasis(buff *bytes.Buffer, text string, i int) (int, bool) {
+	c := text[i]
+	run := markdownRunLength(text, i, c)
+
+	// Opening delimiter must be followed by non whitespace
+	if i+run >= len(text) || text[i+run] == ' ' || text[i+run] == '\n' {
+		return 0, false
+	}
+
+	// Underscores can not open emphasis inside words
+	if c == '_' && i > 0 && isMarkdownWordChar(text[i-1]) {
+		return 0, false
+	}
+
+	if run > 3 {
+		return 0, false
+	}
+
+	delimiter := strings.Repeat(string(c), run)
+
+	// Find closing delimiter
+	for j := i + run; j < len(text); j++ {
+		if text[j] == '`' {
+			// Skip code spans
+			codeRun := markdownRunLength(text, j, '`')
+
+			if end := strings.Index(text[j+codeRun:], strings.Repeat("`", codeRun)); end >= 0 {
+				j += codeRun + end + codeRun - 1
+				continue
+			}
+		}
+
+		if text[j] == '\\' {
+			j++
+			continue
+		}
+
+		if !strings.HasPrefix(text[j:], delimiter) || markdownRunLength(text, j, c) != run {
+			if text[j] == c {
+				j += markdownRunLength(text, j, c) - 1
+			}
+			continue
+		}
+
+		// Closing delimiter must be preceded by non whitespace
+		if text[j-1] == ' ' || text[j-1] == '\n' {
+			j += run - 1
+			continue
+		}
+
+		// Underscores can not close emphasis inside words
+		if c == '_' && j+run < len(text) && isMarkdownWordChar(text[j+run]) {
+			j += run - 1
+			continue
+		}
+
+		inner := renderMarkdownInline(text[i+run : j])
+
+		switch run {
+		case 1:
+			buff.WriteString("<em>" + inner + "</em>")
+		case 2:
+			buff.WriteString("<strong>" + inner + "</strong>")
+		default:
+			buff.WriteString("<em><strong>" + inner + "</strong></em>")
+		}
+
+		return j + run, true
+	}
+
+	return 0, false
+}
+
+// parseMarkdownLink parses a [label](destination "title") link starting at the given position.
+// Returns the number of bytes consumed
+func parseMarkdownLink(text string, start int) (string, string, string, int, bool) {
+	// Find matching closing bracket
+	depth := 0
+	end := -1
+
+	for j := start; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+
+		if depth == 0 {
+			end = j
+			break
+		}
+	}
+
+	if end < 0 || end+1 >= len(text) || text[end+1] != '(' {
+		return "", "", "", 0, false
+	}
+
+	label := text[start+1 : end]
+
+	// Find closing parenthesis
+	closing := strings.IndexByte(text[end+2:], ')')
+
+	if closing < 0 {
+		return "", "", "", 0, false
+	}
+
+	inside := strings.TrimSpace(text[end+2 : end+2+closing])
+	dest, title := inside, ""
+
+	// Split optional title
+	if idx := strings.IndexAny(inside, " \n"); idx >= 0 {
+		dest = inside[:idx]
+		title = strings.TrimSpace(inside[idx:])
+
+		if len(title) < 2 || !((title[0] == '"' && title[len(title)-1] == '"') || (title[0] == '\'' && title[len(title)-1] == '\'')) {
+			return "", "", "", 0, false
+		}
+
+		title = title[1 : len(title)-1]
+	}
+
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+
+	return label, dest, title, end + 2 + closing + 1 - start, true
+}
+
+// markdownPlainText removes markdown formatting characters from the given text
+func markdownPlainText(text string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "", "[", "", "]", "").Replace(text)
+}
+
+// markdownRunLength returns the number of consecutive c characters at the given position
+func markdownRunLength(text string, i int, c byte) int {
+	n := 0
+
+	for i+n < len(text) && text[i+n] == c {
+		n++
+	}
+
+	return n
+}
+
+// isMarkdownWordChar checks if the given character is alphanumeric
+func isMarkdownWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
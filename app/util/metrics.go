@@ -0,0 +1,205 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metrics global metrics registry
+var Metrics = &MetricsRegistry{
+	counters:   map[string]float64{},
+	gauges:     map[string]float64{},
+	histograms: map[string]*metricHistogram{},
+}
+
+// metricBuckets default histogram bucket upper bounds
+var metricBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// MetricsRegistry holds the application counters, gauges and histograms
+type MetricsRegistry struct {
+	rw         sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*metricHistogram
+}
+
+// metricHistogram cumulative histogram of observed values
+type metricHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// MetricName converts the given name to a valid prometheus metric name prefixed with castro_
+func MetricName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if strings.HasPrefix(name, "castro_") {
+		return name
+	}
+
+	return "castro_" + name
+}
+
+// Increment adds delta to the given counter
+func (m *MetricsRegistry) Increment(name string, delta float64) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	// Check metric type
+	if err := m.checkType(name, "counter"); err != nil {
+		return err
+	}
+
+	m.counters[name] += delta
+
+	return nil
+}
+
+// SetGauge sets the value of the given gauge
+func (m *MetricsRegistry) SetGauge(name string, value float64) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	// Check metric type
+	if err := m.checkType(name, "gauge"); err != nil {
+		return err
+	}
+
+	m.gauges[name] = value
+
+	return nil
+}
+
+// Observe records a value on the given histogram
+func (m *MetricsRegistry) Observe(name string, value float64) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	// Check metric type
+	if err := m.checkType(name, "histogram"); err != nil {
+		return err
+	}
+
+	// Get or create histogram
+	h, ok := m.histograms[name]
+
+	if !ok {
+		h = &metricHistogram{
+			buckets: make([]uint64, len(metricBuckets)),
+		}
+		m.histograms[name] = h
+	}
+
+	// Update buckets
+	for i, bound := range metricBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += value
+
+	return nil
+}
+
+// checkType checks the given metric name is not registered with another type
+func (m *MetricsRegistry) checkType(name, kind string) error {
+	if _, ok := m.counters[name]; ok && kind != "counter" {
+		return fmt.Errorf("Metric %v is a counter", name)
+	}
+
+	if _, ok := m.gauges[name]; ok && kind != "gauge" {
+		return fmt.Errorf("Metric %v is a gauge", name)
+	}
+
+	if _, ok := m.histograms[name]; ok && kind != "histogram" {
+		return fmt.Errorf("Metric %v is a histogram", name)
+	}
+
+	return nil
+}
+
+// WritePrometheus writes all metrics using the prometheus text exposition format
+func (m *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	// Write counters and gauges
+	for _, set := range []struct {
+		kind   string
+		values map[string]float64
+	}{
+		{"counter", m.counters},
+		{"gauge", m.gauges},
+	} {
+		for _, name := range sortedMetricNames(set.values) {
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", name, set.kind, name, set.values[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write histograms
+	names := make([]string, 0, len(m.histograms))
+
+	for name := range m.histograms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		h := m.histograms[name]
+
+		if _, err := fmt.Fprintf(w, "# TYPE %s histogram\n", name); err != nil {
+			return err
+		}
+
+		for i, bound := range metricBuckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%v\"} %d\n", name, bound, h.buckets[i]); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %v\n%s_count %d\n", name, h.count, name, h.sum, name, h.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MetricsHandler returns a handler exposing the metrics registry
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		if err := Metrics.WritePrometheus(w); err != nil {
+			Logger.Logger.Errorf("Cannot write metrics: %v", err)
+		}
+	})
+}
+
+// sortedMetricNames returns the metric names sorted alphabetically
+func sortedMetricNames(values map[string]float64) []string {
+	names := make([]string, 0, len(values))
+
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// currencyFormat formatting rules of a currency
+type currencyFormat struct {
+	Symbol      string
+	Decimals    int
+	Thousands   string
+	Decimal     string
+	SymbolAfter bool
+	Space       bool
+}
+
+// currencyFormats list of supported currencies by ISO 4217 code
+var currencyFormats = map[string]currencyFormat{
+	"USD": {Symbol: "$", Decimals: 2, Thousands: ",", Decimal: "."},
+	"CAD": {Symbol: "CA$", Decimals: 2, Thousands: ",", Decimal: "."},
+	"AUD": {Symbol: "A$", Decimals: 2, Thousands: ",", Decimal: "."},
+	"MXN": {Symbol: "MX$", Decimals: 2, Thousands: ",", Decimal: "."},
+	"GBP": {Symbol: "£", Decimals: 2, Thousands: ",", Decimal: "."},
+	"EUR": {Symbol: "€", Decimals: 2, Thousands: ".", Decimal: ",", SymbolAfter: true, Space: true},
+	"BRL": {Symbol: "R$", Decimals: 2, Thousands: ".", Decimal: ",", Space: true},
+	"PLN": {Symbol: "zł", Decimals: 2, Thousands: " ", Decimal: ",", SymbolAfter: true, Space: true},
+	"SEK": {Symbol: "kr", Decimals: 2, Thousands: " ", Decimal: ",", SymbolAfter: true, Space: true},
+	"JPY": {Symbol: "¥", Decimals: 0, Thousands: ",", Decimal: "."},
+}
+
+// DefaultCurrency returns the configured shop currency. Falls back to the PayPal currency or USD
+func DefaultCurrency() string {
+	if Config.Get().Shop.Currency != "" {
+		return Config.Get().Shop.Currency
+	}
+
+	if Config.Get().PayPal.Currency != "" {
+		return Config.Get().PayPal.Currency
+	}
+
+	return "USD"
+}
+
+// FormatMoney formats the given amount using the rules of the given currency code
+func FormatMoney(amount float64, code string) (string, error) {
+	format, ok := currencyFormats[strings.ToUpper(code)]
+
+	if !ok {
+		return "", errors.New("Unsupported currency " + code)
+	}
+
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return "", errors.New("Invalid money amount")
+	}
+
+	negative := amount < 0
+
+	// Round to the currency minor unit
+	scale := math.Pow10(format.Decimals)
+	units := int64(math.Round(math.Abs(amount) * scale))
+
+	if units == 0 {
+		negative = false
+	}
+
+	// Group integer part digits
+	integer := strconv.FormatInt(units/int64(scale), 10)
+	grouped := ""
+
+	for i, c := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped += format.Thousands
+		}
+
+		grouped += string(c)
+	}
+
+	// Append fractional part
+	if format.Decimals > 0 {
+		fraction := strconv.FormatInt(units%int64(scale), 10)
+		grouped += format.Decimal + strings.Repeat("0", format.Decimals-len(fraction)) + fraction
+	}
+
+	separator := ""
+
+	if format.Space {
+		separator = " "
+	}
+
+	result := format.Symbol + separator + grouped
+
+	if format.SymbolAfter {
+		result = grouped + separator + format.Symbol
+	}
+
+	if negative {
+		return "-" + result, nil
+	}
+
+	return result, nil
+}
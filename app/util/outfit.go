@@ -2,13 +2,22 @@ package util
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/anthonynsimon/bild/blend"
 	"github.com/lucasb-eyer/go-colorful"
@@ -40,6 +49,169 @@ var outfitColors = []string{
 	"7F0000",
 }
 
+// maxOutfitServiceResponse biggest image accepted from the outfit image service
+const maxOutfitServiceResponse = 5 << 20
+
+var (
+	// ErrInvalidOutfit returned when an outfit value is out of range
+	ErrInvalidOutfit = errors.New("Invalid outfit values")
+
+	// ErrOutfitUnavailable returned when there is no sprite data or image service for an outfit
+	ErrOutfitUnavailable = errors.New("No outfit sprite data or outfit service available")
+)
+
+// outfitServiceClient http client used for the outfit image service requests
+var outfitServiceClient = &http.Client{
+	Timeout: time.Second * 10,
+}
+
+// OutfitLook values used to render an outfit image
+type OutfitLook struct {
+	LookType int
+	Head     int
+	Body     int
+	Legs     int
+	Feet     int
+	Addons   int
+}
+
+// Validate checks that all the outfit values are in range
+func (o OutfitLook) Validate() error {
+	if o.LookType <= 0 || o.Addons < 0 || o.Addons > 3 {
+		return ErrInvalidOutfit
+	}
+
+	for _, c := range []int{o.Head, o.Body, o.Legs, o.Feet} {
+		if c < 0 || c >= len(outfitColors) {
+			return ErrInvalidOutfit
+		}
+	}
+
+	return nil
+}
+
+// cacheKey returns the cache key of the outfit values
+func (o OutfitLook) cacheKey() string {
+	h := sha256.Sum256([]byte(fmt.Sprintf(
+		"%d_%d_%d_%d_%d_%d",
+		o.LookType,
+		o.Head,
+		o.Body,
+		o.Legs,
+		o.Feet,
+		o.Addons,
+	)))
+
+	return "outfit_render_" + hex.EncodeToString(h[:])
+}
+
+// RenderOutfit returns the PNG image of the given outfit. The image is generated from the
+// sprite data when available, otherwise the configured outfit service is used. Results are cached
+func RenderOutfit(o OutfitLook) ([]byte, error) {
+	// Check outfit values
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Check for cached outfit
+	key := o.cacheKey()
+
+	if buff, found := Cache.Get(key); found {
+		return buff.([]byte), nil
+	}
+
+	var buff []byte
+	var err error
+
+	// Render from sprite data or the outfit service
+	if outfitSpriteExists(o.LookType) {
+		buff, err = GenerateOutfitImage(o.LookType, o.Feet, o.Legs, o.Body, o.Head, o.Addons)
+	} else if Config.Get().Outfit.ServiceURL != "" {
+		buff, err = fetchOutfitImage(Config.Get().Outfit.ServiceURL, o)
+	} else {
+		return nil, ErrOutfitUnavailable
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Save outfit to cache
+	Cache.Set(key, buff, Config.Get().Outfit.Cache.Duration)
+
+	return buff, nil
+}
+
+// outfitSpriteExists checks if there is sprite data for the given look type
+func outfitSpriteExists(lookType int) bool {
+	_, err := os.Stat(filepath.Join(
+		"public",
+		"images",
+		"outfits",
+		"generator",
+		strconv.Itoa(lookType),
+		"1_1_1_3.png",
+	))
+
+	return err == nil
+}
+
+// fetchOutfitImage retrieves the outfit image from the given service. The outfit values are sent as
+// the id, addons, head, body, legs and feet query parameters and the response is converted to PNG
+func fetchOutfitImage(serviceURL string, o OutfitLook) ([]byte, error) {
+	// Parse service url
+	u, err := url.Parse(serviceURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Set outfit values
+	q := u.Query()
+	q.Set("id", strconv.Itoa(o.LookType))
+	q.Set("addons", strconv.Itoa(o.Addons))
+	q.Set("head", strconv.Itoa(o.Head))
+	q.Set("body", strconv.Itoa(o.Body))
+	q.Set("legs", strconv.Itoa(o.Legs))
+	q.Set("feet", strconv.Itoa(o.Feet))
+	u.RawQuery = q.Encode()
+
+	// Execute request
+	resp, err := outfitServiceClient.Get(u.String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Outfit service returned status %d", resp.StatusCode)
+	}
+
+	// Read response image
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOutfitServiceResponse))
+
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := DecodeImage(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode image as png
+	buff := &bytes.Buffer{}
+
+	if err := png.Encode(buff, img); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
 // GenerateOutfitImage generates an outfit image for the given values
 func GenerateOutfitImage(t, feet, legs, body, head, addons int) ([]byte, error) {
 	// Parse colors
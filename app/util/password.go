@@ -0,0 +1,45 @@
+package util
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// VerifyPassword checks the given password against a stored bcrypt or sha1 hash. The second
+// value reports if the stored hash should be replaced by a new bcrypt hash
+func VerifyPassword(stored, password string) (bool, bool) {
+	// Check bcrypt hashes
+	if strings.HasPrefix(stored, "$2") {
+		if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
+			return false, false
+		}
+
+		cost, err := bcrypt.Cost([]byte(stored))
+
+		return true, err != nil || cost < bcrypt.DefaultCost
+	}
+
+	// Check sha1 hashes
+	if len(stored) == sha1.Size*2 {
+		sum := sha1.Sum([]byte(password))
+
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)), []byte(hex.EncodeToString(sum[:]))) == 1, true
+	}
+
+	return false, false
+}
+
+// HashPasswordBcrypt hashes the given password using bcrypt with the default cost
+func HashPasswordBcrypt(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
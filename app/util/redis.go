@@ -0,0 +1,223 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// errRedisNil is returned when redis replies with a nil value
+var errRedisNil = errors.New("redis: nil")
+
+// RedisConfig struct used for the redis connection options
+type RedisConfig struct {
+	Address  string
+	Password string
+	DB       int
+}
+
+// RedisClient minimal redis client using the RESP protocol
+type RedisClient struct {
+	config  RedisConfig
+	timeout time.Duration
+	pool    chan *redisConn
+}
+
+// redisConn a single redis connection
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient creates a new redis client with the given options
+func NewRedisClient(config RedisConfig) *RedisClient {
+	return &RedisClient{
+		config:  config,
+		timeout: time.Second * 5,
+		pool:    make(chan *redisConn, 10),
+	}
+}
+
+// Do executes the given redis command
+func (r *RedisClient) Do(args ...string) (interface{}, error) {
+	// Get connection from the pool
+	c, err := r.get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute command
+	reply, err := c.do(r.timeout, args...)
+
+	if err != nil && err != errRedisNil {
+
+		// Do not reuse broken connections
+		if _, ok := err.(redisError); !ok {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+
+	// Save connection back to the pool
+	r.put(c)
+
+	return reply, err
+}
+
+// get retrieves a connection from the pool or dials a new one
+func (r *RedisClient) get() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+
+	// Dial redis server
+	conn, err := net.DialTimeout("tcp", r.config.Address, r.timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	// Authenticate connection
+	if r.config.Password != "" {
+		if _, err := c.do(r.timeout, "AUTH", r.config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Select database
+	if r.config.DB != 0 {
+		if _, err := c.do(r.timeout, "SELECT", strconv.Itoa(r.config.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// put saves a connection back to the pool
+func (r *RedisClient) put(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// redisError error replied by the redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// do writes the given command and reads the reply
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	// Set connection deadline
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	// Encode command as an array of bulk strings
+	buff := fmt.Sprintf("*%d\r\n", len(args))
+
+	for _, arg := range args {
+		buff += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	// Write command
+	if _, err := io.WriteString(c.conn, buff); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// read reads a single reply from the connection
+func (c *redisConn) read() (interface{}, error) {
+	// Read reply line
+	line, err := c.reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 {
+		return nil, errors.New("redis: invalid reply")
+	}
+
+	// Remove line ending
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+
+		// Get bulk string size
+		n, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, errRedisNil
+		}
+
+		// Read bulk string with its line ending
+		data := make([]byte, n+2)
+
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:n]), nil
+	case '*':
+
+		// Get array size
+		n, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 0 {
+			return nil, errRedisNil
+		}
+
+		// Read array elements
+		elements := make([]interface{}, n)
+
+		for i := range elements {
+			v, err := c.read()
+
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+
+			elements[i] = v
+		}
+
+		return elements, nil
+	}
+
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader response header holding the request identifier
+const RequestIDHeader = "X-Request-ID"
+
+// NewRequestID generates a random request identifier
+func NewRequestID() string {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the identifier of the given request
+func RequestID(req *http.Request) string {
+	id, _ := req.Context().Value("request-id").(string)
+	return id
+}
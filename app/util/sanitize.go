@@ -0,0 +1,286 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// htmlPolicy allowlist of tags and attributes kept when sanitizing
+type htmlPolicy struct {
+	Tags map[string][]string
+}
+
+var (
+	// htmlPolicies named policies available to html.sanitize
+	htmlPolicies = map[string]htmlPolicy{
+		"strict": strictPolicy,
+		"basic":  basicPolicy,
+	}
+
+	// strictPolicy allows no tags at all, only escaped text
+	strictPolicy = htmlPolicy{
+		Tags: map[string][]string{},
+	}
+
+	// basicPolicy allowlist of safe formatting tags for user submitted content
+	basicPolicy = htmlPolicy{
+		Tags: map[string][]string{
+			"a":          {"href", "title"},
+			"p":          {},
+			"br":         {},
+			"strong":     {},
+			"b":          {},
+			"em":         {},
+			"i":          {},
+			"u":          {},
+			"s":          {},
+			"del":        {},
+			"sup":        {},
+			"sub":        {},
+			"small":      {},
+			"code":       {},
+			"pre":        {},
+			"blockquote": {},
+			"ul":         {},
+			"ol":         {},
+			"li":         {},
+		},
+	}
+
+	// markdownPolicy allowlist used for rendered markdown
+	markdownPolicy = htmlPolicy{
+		Tags: map[string][]string{
+			"a":          {"href", "title"},
+			"img":        {"src", "alt", "title", "width", "height"},
+			"p":          {},
+			"br":         {},
+			"hr":         {},
+			"h1":         {},
+			"h2":         {},
+			"h3":         {},
+			"h4":         {},
+			"h5":         {},
+			"h6":         {},
+			"strong":     {},
+			"b":          {},
+			"em":         {},
+			"i":          {},
+			"u":          {},
+			"s":          {},
+			"del":        {},
+			"sup":        {},
+			"sub":        {},
+			"code":       {"class"},
+			"pre":        {},
+			"blockquote": {},
+			"ul":         {},
+			"ol":         {"start"},
+			"li":         {},
+			"table":      {},
+			"thead":      {},
+			"tbody":      {},
+			"tr":         {},
+			"th":         {"align"},
+			"td":         {"align"},
+			"span":       {},
+			"div":        {},
+		},
+	}
+
+	// htmlDroppedContent tags removed together with their content
+	htmlDroppedContent = map[string]bool{
+		"script":   true,
+		"style":    true,
+		"iframe":   true,
+		"object":   true,
+		"embed":    true,
+		"noscript": true,
+		"template": true,
+		"textarea": true,
+		"title":    true,
+		"head":     true,
+		"svg":      true,
+		"math":     true,
+	}
+
+	// htmlVoidTags tags without closing tag
+	htmlVoidTags = map[string]bool{
+		"br":  true,
+		"hr":  true,
+		"img": true,
+	}
+
+	// htmlURLAttributes attributes holding an URL
+	htmlURLAttributes = map[string]bool{
+		"href": true,
+		"src":  true,
+	}
+
+	// htmlLanguageClass valid class values for code blocks
+	htmlLanguageClass = regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)
+)
+
+// SanitizeHTML cleans the given input using the named policy. The strict policy
+// returns text only while the basic policy keeps safe formatting tags
+func SanitizeHTML(input, policy string) (string, error) {
+	p, ok := htmlPolicies[policy]
+
+	if !ok {
+		return "", fmt.Errorf("Unknown sanitize policy %s", policy)
+	}
+
+	return p.sanitize(input), nil
+}
+
+// sanitize removes every tag and attribute not allowed by the policy. Text is always
+// escaped and tags are balanced so the output can be safely embedded on a page
+func (p htmlPolicy) sanitize(input string) string {
+	buff := &bytes.Buffer{}
+	tokenizer := nethtml.NewTokenizer(strings.NewReader(input))
+
+	// Stack of open allowed tags
+	open := []string{}
+
+	// Depth inside dropped content
+	dropped := 0
+
+	for {
+		tokenType := tokenizer.Next()
+
+		if tokenType == nethtml.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case nethtml.TextToken:
+			if dropped == 0 {
+				buff.WriteString(html.EscapeString(token.Data))
+			}
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			// Skip dropped content
+			if htmlDroppedContent[token.Data] {
+				if tokenType == nethtml.StartTagToken {
+					dropped++
+				}
+				continue
+			}
+
+			attributes, ok := p.Tags[token.Data]
+
+			if dropped > 0 || !ok {
+				continue
+			}
+
+			p.writeTag(buff, token, attributes)
+
+			if tokenType == nethtml.StartTagToken && !htmlVoidTags[token.Data] {
+				open = append(open, token.Data)
+			}
+		case nethtml.EndTagToken:
+			if htmlDroppedContent[token.Data] {
+				if dropped > 0 {
+					dropped--
+				}
+				continue
+			}
+
+			if dropped > 0 {
+				continue
+			}
+
+			// Close every tag up to the matching one
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != token.Data {
+					continue
+				}
+
+				for j := len(open) - 1; j >= i; j-- {
+					buff.WriteString("</" + open[j] + ">")
+				}
+
+				open = open[:i]
+				break
+			}
+		}
+	}
+
+	// Close remaining tags
+	for i := len(open) - 1; i >= 0; i-- {
+		buff.WriteString("</" + open[i] + ">")
+	}
+
+	return buff.String()
+}
+
+// writeTag writes the given start tag keeping only the allowed attributes
+func (p htmlPolicy) writeTag(buff *bytes.Buffer, token nethtml.Token, allowed []string) {
+	buff.WriteString("<" + token.Data)
+
+	for _, attr := range token.Attr {
+		if attr.Namespace != "" || !htmlAttributeAllowed(attr.Key, allowed) {
+			continue
+		}
+
+		// Validate URL attributes
+		if htmlURLAttributes[attr.Key] && !isSafeURL(attr.Val) {
+			continue
+		}
+
+		// Only allow language classes
+		if attr.Key == "class" && !htmlLanguageClass.MatchString(attr.Val) {
+			continue
+		}
+
+		buff.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+
+	// Prevent tabnabbing on links
+	if token.Data == "a" {
+		buff.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+
+	buff.WriteString(">")
+}
+
+// htmlAttributeAllowed checks if the attribute is on the allowed list
+func htmlAttributeAllowed(key string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSafeURL checks if the given URL is relative or uses a safe scheme
+func isSafeURL(raw string) bool {
+	// Remove whitespace and control characters browsers ignore
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	u, err := url.Parse(cleaned)
+
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+
+	return false
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"github.com/gorilla/securecookie"
 	"net/http"
+	"time"
 )
 
 // SessionStore main application session storage
@@ -11,11 +12,79 @@ var SessionStore *securecookie.SecureCookie
 // SessionCookie returns a session cookie pointer
 func SessionCookie(v string) *http.Cookie {
 	return &http.Cookie{
-		Name:     Config.Configuration.Cookies.Name,
+		Name:     Config.Get().Cookies.Name,
 		Value:    v,
 		Path:     "/",
-		Secure:   Config.Configuration.IsSSL(),
-		MaxAge:   Config.Configuration.Cookies.MaxAge,
+		Secure:   Config.Get().IsSSL(),
+		MaxAge:   Config.Get().Cookies.MaxAge,
 		HttpOnly: true,
 	}
 }
+
+// TouchSession refreshes the session idle timer setting the creation time if needed
+func TouchSession(session map[string]interface{}) {
+	// Get current time
+	now := time.Now().Unix()
+
+	// Set creation time
+	if _, ok := session["created-at"].(int64); !ok {
+		session["created-at"] = now
+	}
+
+	// Set last activity time
+	session["last-seen"] = now
+}
+
+// SessionExpiresAt returns the time the given session expires. A zero time means the session never expires
+func SessionExpiresAt(session map[string]interface{}) time.Time {
+	// Expiration holder
+	expires := time.Time{}
+
+	// Check idle timeout
+	if idle := Config.Get().Session.IdleTimeout.Duration; idle > 0 {
+		if seen, ok := session["last-seen"].(int64); ok {
+			expires = time.Unix(seen, 0).Add(idle)
+		}
+	}
+
+	// Check absolute timeout
+	if absolute := Config.Get().Session.AbsoluteTimeout.Duration; absolute > 0 {
+		if created, ok := session["created-at"].(int64); ok {
+
+			// Use the earliest expiration time
+			t := time.Unix(created, 0).Add(absolute)
+
+			if expires.IsZero() || t.Before(expires) {
+				expires = t
+			}
+		}
+	}
+
+	return expires
+}
+
+// SessionExpired checks if the given session exceeded its idle or absolute timeout
+func SessionExpired(session map[string]interface{}) bool {
+	// Get expiration time
+	expires := SessionExpiresAt(session)
+
+	if expires.IsZero() {
+		return false
+	}
+
+	return time.Now().After(expires)
+}
+
+// PurgeSession removes all the session data except the issuer
+func PurgeSession(session map[string]interface{}) {
+	for key := range session {
+
+		// Omit issuer element
+		if key == "issuer" {
+			continue
+		}
+
+		// Delete each element
+		delete(session, key)
+	}
+}
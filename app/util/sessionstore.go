@@ -0,0 +1,276 @@
+package util
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/raggaer/castro/app/database"
+)
+
+// SessionBackend server side session storage. When nil the whole session is stored on the cookie
+var SessionBackend SessionStorage
+
+// SessionStorage interface used to store the session data outside of the cookie
+type SessionStorage interface {
+	Load(id string) ([]byte, bool, error)
+	Save(id string, data []byte, ttl time.Duration) error
+	Delete(id string) error
+	Purge() error
+}
+
+// NewSessionStorage creates the session storage using the session configuration. Returns nil
+// for cookie sessions
+func NewSessionStorage(config SessionConfig) (SessionStorage, error) {
+	switch config.Backend {
+	case "", "cookie":
+		return nil, nil
+	case "database":
+		return &databaseSessionStorage{}, nil
+	case "redis":
+		return &redisSessionStorage{
+			client: NewRedisClient(config.Redis),
+		}, nil
+	}
+
+	return nil, errors.New("Invalid session backend " + config.Backend + ". Expected cookie, database or redis")
+}
+
+// sessionCookieFields session fields that do not need to be stored on the backend. Sessions
+// holding only these fields are kept on the cookie so cookieless clients do not create rows
+var sessionCookieFields = map[string]bool{
+	"issuer":     true,
+	"id":         true,
+	"created-at": true,
+	"last-seen":  true,
+	"csrf-token": true,
+	"stored":     true,
+}
+
+// sessionStoredField field set when the session data is stored on the backend
+const sessionStoredField = "stored"
+
+// sessionHasData checks if the given session holds data that needs to be stored on the backend
+func sessionHasData(session map[string]interface{}) bool {
+	for k := range session {
+		if !sessionCookieFields[k] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncodeSession returns the cookie value of the given session. Cookie sessions hold the whole
+// session while other backends save the session data and only keep the identifier on the cookie.
+// Sessions are only saved on the backend once they hold data
+func EncodeSession(session map[string]interface{}) (string, error) {
+	if SessionBackend == nil {
+		return SessionStore.Encode(Config.Get().Cookies.Name, session)
+	}
+
+	// Destroyed sessions need a new identifier
+	id, ok := session["id"].(string)
+
+	if !ok || id == "" {
+		id = uniuri.NewLen(32)
+		session["id"] = id
+	}
+
+	// Keep sessions without data on the cookie. Data stored before is removed
+	if !sessionHasData(session) {
+		if stored, _ := session[sessionStoredField].(bool); stored {
+			if err := SessionBackend.Delete(id); err != nil {
+				return "", err
+			}
+
+			delete(session, sessionStoredField)
+		}
+
+		return SessionStore.Encode(Config.Get().Cookies.Name, session)
+	}
+
+	session[sessionStoredField] = true
+
+	// Encode session data
+	buff := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(buff).Encode(session); err != nil {
+		return "", err
+	}
+
+	// Save session data
+	if err := SessionBackend.Save(id, buff.Bytes(), sessionTTL()); err != nil {
+		return "", err
+	}
+
+	return SessionStore.Encode(Config.Get().Cookies.Name, map[string]interface{}{
+		"id":               id,
+		sessionStoredField: true,
+	})
+}
+
+// DecodeSession returns the session of the given cookie value. Sessions missing from the
+// backend are returned with only the issuer and identifier fields
+func DecodeSession(value string) (map[string]interface{}, error) {
+	session := map[string]interface{}{}
+
+	if err := SessionStore.Decode(Config.Get().Cookies.Name, value, &session); err != nil {
+		return nil, err
+	}
+
+	// Sessions without data are kept on the cookie
+	if stored, _ := session[sessionStoredField].(bool); SessionBackend == nil || !stored {
+		return session, nil
+	}
+
+	// Load session data
+	id, _ := session["id"].(string)
+	data, found, err := SessionBackend.Load(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return map[string]interface{}{
+			"issuer": "Castro",
+			"id":     id,
+		}, nil
+	}
+
+	session = map[string]interface{}{}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session); err != nil {
+		return nil, err
+	}
+
+	session[sessionStoredField] = true
+
+	return session, nil
+}
+
+// DeleteSession removes the data of the given session identifier from the backend
+func DeleteSession(id string) error {
+	if SessionBackend == nil || id == "" {
+		return nil
+	}
+
+	return SessionBackend.Delete(id)
+}
+
+// PurgeSessions removes expired sessions from the backend every interval
+func PurgeSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if SessionBackend == nil {
+			continue
+		}
+
+		if err := SessionBackend.Purge(); err != nil {
+			Logger.Logger.Errorf("Cannot purge expired sessions: %v", err)
+		}
+	}
+}
+
+// sessionTTL returns how long session data is kept on the backend
+func sessionTTL() time.Duration {
+	if d := Config.Get().Session.AbsoluteTimeout.Duration; d > 0 {
+		return d
+	}
+
+	if d := Config.Get().Session.IdleTimeout.Duration; d > 0 {
+		return d
+	}
+
+	if Config.Get().Cookies.MaxAge > 0 {
+		return time.Duration(Config.Get().Cookies.MaxAge) * time.Second
+	}
+
+	return time.Hour * 24 * 30
+}
+
+// databaseSessionStorage stores sessions on the castro_sessions table
+type databaseSessionStorage struct{}
+
+// Load retrieves the session data from the database
+func (d *databaseSessionStorage) Load(id string) ([]byte, bool, error) {
+	data := []byte{}
+
+	if err := database.DB.Get(&data, "SELECT data FROM castro_sessions WHERE id = ? AND expires_at > ?", id, time.Now().Unix()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+// Save stores the session data on the database
+func (d *databaseSessionStorage) Save(id string, data []byte, ttl time.Duration) error {
+	_, err := database.DB.Exec(
+		"INSERT INTO castro_sessions (id, data, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data), expires_at = VALUES(expires_at)",
+		id,
+		data,
+		time.Now().Add(ttl).Unix(),
+	)
+
+	return err
+}
+
+// Delete removes the session from the database
+func (d *databaseSessionStorage) Delete(id string) error {
+	_, err := database.DB.Exec("DELETE FROM castro_sessions WHERE id = ?", id)
+	return err
+}
+
+// Purge removes the expired sessions from the database
+func (d *databaseSessionStorage) Purge() error {
+	_, err := database.DB.Exec("DELETE FROM castro_sessions WHERE expires_at <= ?", time.Now().Unix())
+	return err
+}
+
+// redisSessionStorage stores sessions on redis using the key expiration
+type redisSessionStorage struct {
+	client *RedisClient
+}
+
+// Load retrieves the session data from redis
+func (r *redisSessionStorage) Load(id string) ([]byte, bool, error) {
+	reply, err := r.client.Do("GET", "castro_session:"+id)
+
+	if err == errRedisNil {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []byte(reply.(string)), true, nil
+}
+
+// Save stores the session data on redis
+func (r *redisSessionStorage) Save(id string, data []byte, ttl time.Duration) error {
+	_, err := r.client.Do("SET", "castro_session:"+id, string(data), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+// Delete removes the session from redis
+func (r *redisSessionStorage) Delete(id string) error {
+	_, err := r.client.Do("DEL", "castro_session:"+id)
+	return err
+}
+
+// Purge does nothing since redis expires the session keys
+func (r *redisSessionStorage) Purge() error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignURL adds the expires and signature query values to the given url. The signature covers
+// the url path and query so none of them can be changed. The cookie hash key is used as secret
+func SignURL(rawURL string, ttl time.Duration) (string, error) {
+	// Parse url
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Set expiration time
+	q := u.Query()
+	q.Del("signature")
+	q.Set("expires", strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+
+	// Sign path and query
+	q.Set("signature", urlSignature(u.EscapedPath(), q.Encode()))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// VerifySignedURL checks the signature and expiration time of the given url
+func VerifySignedURL(u *url.URL) bool {
+	// Get signature
+	q := u.Query()
+	signature := q.Get("signature")
+
+	if signature == "" {
+		return false
+	}
+
+	q.Del("signature")
+
+	// Compare signatures in constant time
+	if !hmac.Equal([]byte(signature), []byte(urlSignature(u.EscapedPath(), q.Encode()))) {
+		return false
+	}
+
+	// Check expiration time
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+
+	return err == nil && time.Now().Unix() <= exp
+}
+
+// urlSignature returns the HMAC-SHA256 signature of an url path and query
+func urlSignature(path, query string) string {
+	mac := hmac.New(sha256.New, []byte(Config.Get().Cookies.HashKey))
+	mac.Write([]byte("url|" + path + "|" + query))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SitemapMaxURLs maximum number of URLs a single sitemap file can hold
+	SitemapMaxURLs = 50000
+
+	// sitemapNamespace sitemap protocol XML namespace
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// sitemapChangeFrequencies valid changefreq values
+var sitemapChangeFrequencies = map[string]bool{
+	"always":  true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+	"never":   true,
+}
+
+// Sitemap list of URLs rendered using the sitemap protocol
+type Sitemap struct {
+	URLs []SitemapURL
+}
+
+// SitemapURL single sitemap entry
+type SitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+// sitemapURLSet root element of a sitemap file
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+// sitemapIndex root element of a sitemap index file
+type sitemapIndex struct {
+	XMLName  xml.Name          `xml:"sitemapindex"`
+	Xmlns    string            `xml:"xmlns,attr"`
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+}
+
+// sitemapLocation sitemap index entry
+type sitemapLocation struct {
+	Loc string `xml:"loc"`
+}
+
+// Add validates and appends the given entry to the sitemap
+func (s *Sitemap) Add(entry SitemapURL) error {
+	// Validate location
+	u, err := url.Parse(entry.Loc)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Invalid sitemap url. Expected an absolute http or https url")
+	}
+
+	if len(entry.Loc) > 2048 {
+		return errors.New("Invalid sitemap url. URLs cannot be longer than 2048 characters")
+	}
+
+	// Validate change frequency
+	if entry.ChangeFreq != "" && !sitemapChangeFrequencies[entry.ChangeFreq] {
+		return fmt.Errorf("Invalid sitemap changefreq %v", entry.ChangeFreq)
+	}
+
+	s.URLs = append(s.URLs, entry)
+
+	return nil
+}
+
+// Render renders the sitemap files. Each file holds up to SitemapMaxURLs entries
+func (s *Sitemap) Render() ([]string, error) {
+	files := []string{}
+
+	for start := 0; start < len(s.URLs) || start == 0; start += SitemapMaxURLs {
+		end := start + SitemapMaxURLs
+
+		if end > len(s.URLs) {
+			end = len(s.URLs)
+		}
+
+		// Encode chunk
+		buff, err := encodeSitemapXML(sitemapURLSet{
+			Xmlns: sitemapNamespace,
+			URLs:  s.URLs[start:end],
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, buff)
+	}
+
+	return files, nil
+}
+
+// RenderSitemapIndex renders a sitemap index pointing to the given number of sitemap files. The
+// chunk URL must contain a %d verb that is replaced by the file number starting at 1
+func RenderSitemapIndex(chunkURL string, n int) (string, error) {
+	if strings.Count(chunkURL, "%d") != 1 {
+		return "", errors.New("Invalid sitemap chunk url. Expected a single %d verb")
+	}
+
+	index := sitemapIndex{
+		Xmlns: sitemapNamespace,
+	}
+
+	for i := 1; i <= n; i++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapLocation{
+			Loc: strings.Replace(chunkURL, "%d", strconv.Itoa(i), 1),
+		})
+	}
+
+	return encodeSitemapXML(index)
+}
+
+// encodeSitemapXML encodes the given value including the XML header
+func encodeSitemapXML(v interface{}) (string, error) {
+	buff := &bytes.Buffer{}
+	buff.WriteString(xml.Header)
+
+	if err := xml.NewEncoder(buff).Encode(v); err != nil {
+		return "", err
+	}
+
+	return buff.String(), nil
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugReplacements letters that do not decompose into a base letter plus accent
+var slugReplacements = map[rune]string{
+	'ß': "ss",
+	'æ': "ae",
+	'Æ': "ae",
+	'œ': "oe",
+	'Œ': "oe",
+	'ø': "o",
+	'Ø': "o",
+	'đ': "d",
+	'Đ': "d",
+	'ł': "l",
+	'Ł': "l",
+	'þ': "th",
+	'Þ': "th",
+	'ð': "d",
+	'Ð': "d",
+}
+
+// Slugify converts the given text to a lowercase URL slug. Accented letters are
+// transliterated to their base letter, letters of other scripts are kept and any
+// other character becomes a hyphen
+func Slugify(text string) string {
+	buff := strings.Builder{}
+
+	// Whether the last written character was a hyphen
+	hyphen := true
+
+	// Decompose accented letters so the accent can be dropped
+	for _, r := range norm.NFKD.String(text) {
+
+		// Skip accents
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+
+		if s, ok := slugReplacements[r]; ok {
+			buff.WriteString(s)
+			hyphen = false
+			continue
+		}
+
+		r = unicode.ToLower(r)
+
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			buff.WriteRune(r)
+			hyphen = false
+			continue
+		}
+
+		// Collapse separators into a single hyphen
+		if !hyphen {
+			buff.WriteRune('-')
+			hyphen = true
+		}
+	}
+
+	return strings.TrimSuffix(buff.String(), "-")
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// ServerStatus struct used for the game server status protocol response
+type ServerStatus struct {
+	XMLName    xml.Name `xml:"tsqp"`
+	ServerInfo struct {
+		Uptime     int64  `xml:"uptime,attr"`
+		ServerName string `xml:"servername,attr"`
+		Location   string `xml:"location,attr"`
+		Version    string `xml:"version,attr"`
+		Client     string `xml:"client,attr"`
+	} `xml:"serverinfo"`
+	Players struct {
+		Online int `xml:"online,attr"`
+		Max    int `xml:"max,attr"`
+		Peak   int `xml:"peak,attr"`
+	} `xml:"players"`
+	MOTD string `xml:"motd"`
+}
+
+// GetServerStatus queries the game server using the status protocol
+func GetServerStatus(address string, timeout time.Duration) (*ServerStatus, error) {
+	// Connect to the status port
+	conn, err := net.DialTimeout("tcp", address, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Close connection
+	defer conn.Close()
+
+	// Set connection deadline
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	// Send info request packet
+	if _, err := conn.Write([]byte{0x06, 0x00, 0xFF, 0xFF, 'i', 'n', 'f', 'o'}); err != nil {
+		return nil, err
+	}
+
+	// Read response until the server closes the connection
+	data, err := ioutil.ReadAll(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode response
+	status := &ServerStatus{}
+
+	if err := xml.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -149,7 +149,7 @@ func (t *Tmpl) FuncMap(f template.FuncMap) {
 // RenderWidget renders the given widget template
 func (t Tmpl) RenderWidget(req *http.Request, name string, args map[string]interface{}) (*bytes.Buffer, error) {
 	// Check if app is running on dev mode
-	if Config.Configuration.IsDev() {
+	if Config.Get().IsDev() {
 
 		// Lock mutex
 		t.rw.Lock()
@@ -198,8 +198,9 @@ func (t Tmpl) RenderWidget(req *http.Request, name string, args map[string]inter
 	// Set nonce value
 	args["nonce"] = nonce
 
-	// Set token value
+	// Set token values
 	args["csrfToken"] = tkn.Token
+	args["csrfField"] = csrfField(tkn.Token)
 
 	// Data holder
 	buff := &bytes.Buffer{}
@@ -212,10 +213,81 @@ func (t Tmpl) RenderWidget(req *http.Request, name string, args map[string]inter
 	return buff, nil
 }
 
+// errMissingRequestValue returned when a value set by the request middlewares is missing
+type errMissingRequestValue string
+
+func (e errMissingRequestValue) Error() string {
+	return "Cannot read " + string(e)
+}
+
+// IsMissingRequestValue checks if the given render error was caused by a missing request value
+func IsMissingRequestValue(err error) bool {
+	_, ok := err.(errMissingRequestValue)
+	return ok
+}
+
 // RenderTemplate render the given template passing some values and loading all templates if in development mode
 func (t Tmpl) RenderTemplate(w http.ResponseWriter, req *http.Request, name string, args map[string]interface{}) {
+	// Render template and log error
+	if err := t.executePageTemplate(w, req, name, args); err != nil {
+		Logger.Logger.Error(err.Error())
+
+		// Requests without the middleware values cannot be rendered
+		if IsMissingRequestValue(err) {
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+		}
+	}
+}
+
+// RenderTemplateString renders the given template the same way RenderTemplate does and returns the result
+func (t Tmpl) RenderTemplateString(req *http.Request, name string, args map[string]interface{}) (string, error) {
+	// Data holder
+	buff := &bytes.Buffer{}
+
+	// Render template to buffer
+	if err := t.executePageTemplate(buff, req, name, args); err != nil {
+		return "", err
+	}
+
+	return buff.String(), nil
+}
+
+// RenderTemplateLayout renders the given template wrapped by the given layout template instead of the
+// default header and footer. Layouts are looked up as layout_<name>.html and receive the rendered page
+// as .content. An empty layout renders the bare template
+func (t Tmpl) RenderTemplateLayout(wr io.Writer, req *http.Request, name, layout string, args map[string]interface{}) error {
+	// Check if args is a valid map
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+
+	// Skip the default header and footer templates
+	args["customLayout"] = true
+
+	if layout == "" {
+		return t.executePageTemplate(wr, req, name, args)
+	}
+
+	// Render page to buffer
+	buff := &bytes.Buffer{}
+
+	if err := t.executePageTemplate(buff, req, name, args); err != nil {
+		return err
+	}
+
+	// Set page content
+	args["content"] = template.HTML(buff.String())
+
+	// Render layout
+	return t.executePageTemplate(wr, req, "layout_"+layout+".html", args)
+}
+
+// executePageTemplate executes the given template with the request values. If the app is running
+// on dev mode all the templates will be reloaded
+func (t Tmpl) executePageTemplate(wr io.Writer, req *http.Request, name string, args map[string]interface{}) error {
 	// Check if app is running on dev mode
-	if Config.Configuration.IsDev() {
+	if Config.Get().IsDev() {
 
 		// Lock mutex
 		t.rw.Lock()
@@ -229,20 +301,17 @@ func (t Tmpl) RenderTemplate(w http.ResponseWriter, req *http.Request, name stri
 
 		// Reload all templates
 		if err := t.LoadTemplates("views/"); err != nil {
-			Logger.Logger.Error(err.Error())
-			return
+			return err
 		}
 
 		// Reload all templates
 		if err := t.LoadTemplates("pages/"); err != nil {
-			Logger.Logger.Error(err.Error())
-			return
+			return err
 		}
 
 		// Reload all extension templates
 		if err := t.LoadExtensionTemplates("pages"); err != nil {
-			Logger.Logger.Errorf("Cannot load extension subtopic template: %v", err.Error())
-			return
+			return fmt.Errorf("Cannot load extension subtopic template: %v", err)
 		}
 
 		// Reload all template hooks
@@ -257,35 +326,27 @@ func (t Tmpl) RenderTemplate(w http.ResponseWriter, req *http.Request, name stri
 	// Load microtime from the microtimeHandler
 	microtime, ok := req.Context().Value("microtime").(time.Time)
 	if !ok {
-		w.WriteHeader(500)
-		w.Write([]byte("Cannot read microtime value"))
-		return
+		return errMissingRequestValue("microtime value")
 	}
 
 	// Get csrf token
 	tkn, ok := req.Context().Value("csrf-token").(*models.CsrfToken)
 	if !ok {
-		w.WriteHeader(500)
-		w.Write([]byte("Cannot read csrf token value"))
-		return
+		return errMissingRequestValue("csrf token value")
 	}
 
 	// Get nonce value
 	nonce, ok := req.Context().Value("nonce").(string)
 
 	if !ok {
-		w.WriteHeader(500)
-		w.Write([]byte("Cannot read nonce value"))
-		return
+		return errMissingRequestValue("nonce value")
 	}
 
 	// Get session map
 	session, ok := req.Context().Value("session").(map[string]interface{})
 
 	if !ok {
-		w.WriteHeader(500)
-		w.Write([]byte("Cannot read session map"))
-		return
+		return errMissingRequestValue("session map")
 	}
 
 	// Set session map
@@ -294,22 +355,21 @@ func (t Tmpl) RenderTemplate(w http.ResponseWriter, req *http.Request, name stri
 	// Set nonce value
 	args["nonce"] = nonce
 
-	// Set token value
+	// Set token values
 	args["csrfToken"] = tkn.Token
+	args["csrfField"] = csrfField(tkn.Token)
 
 	// Set microtime value
 	args["microtime"] = fmt.Sprintf("%9.4f seconds", time.Since(microtime).Seconds())
 
-	// Render template and log error
-	if err := t.Tmpl.ExecuteTemplate(w, name, args); err != nil {
-		Logger.Logger.Error(err.Error())
-	}
+	// Render template
+	return t.Tmpl.ExecuteTemplate(wr, name, args)
 }
 
 // Render executes the given template. if the app is running on dev mode all the templates will be reloaded
 func (t Tmpl) Render(wr io.Writer, name string, args interface{}) error {
 	// Check if app is running on dev mode
-	if Config.Configuration.IsDev() {
+	if Config.Get().IsDev() {
 
 		// Lock mutex
 		t.rw.Lock()
@@ -387,3 +447,8 @@ func (t Tmpl) LoadTemplateHooks() {
 	}
 	return
 }
+
+// csrfField returns the hidden form input of the given csrf token
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="_csrf" value="` + template.HTMLEscapeString(token) + `">`)
+}
@@ -41,3 +41,44 @@ func LoadVocations(file string, list ServerVocations) error {
 	// Unmarshal vocations file
 	return xml.Unmarshal(f, &list.List)
 }
+
+// VocationByID returns the vocation with the given id
+func (s ServerVocations) VocationByID(id int) *Vocation {
+	for _, voc := range s.List.Vocations {
+		if voc.ID == id {
+			return voc
+		}
+	}
+
+	return nil
+}
+
+// BaseVocation resolves the given vocation id back to its base vocation id. Vocations without
+// promotion return the same id
+func (s ServerVocations) BaseVocation(id int) int {
+	// Follow the promotion chain. The number of steps is limited to avoid loops
+	for i := 0; i < len(s.List.Vocations); i++ {
+
+		// Get current vocation
+		voc := s.VocationByID(id)
+
+		if voc == nil || voc.FromVoc == voc.ID {
+			break
+		}
+
+		id = voc.FromVoc
+	}
+
+	return id
+}
+
+// PromotedVocation returns the promotion of the given vocation id
+func (s ServerVocations) PromotedVocation(id int) (*Vocation, bool) {
+	for _, voc := range s.List.Vocations {
+		if voc.FromVoc == id && voc.ID != id {
+			return voc, true
+		}
+	}
+
+	return nil, false
+}
@@ -179,7 +179,7 @@ func (w *Widget) Execute(luaState *glua.LState) error {
 	if err := luaState.CallByParam(glua.P{
 		Fn:      luaState.GetGlobal("widget"),
 		NRet:    0,
-		Protect: !Config.Configuration.IsDev(),
+		Protect: !Config.Get().IsDev(),
 	}); err != nil {
 		return err
 	}
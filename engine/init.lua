@@ -6,6 +6,14 @@ if app.Mode == "dev" then
     print(">> Running on development mode. Never have development mode open to the public")
 end
 
+-- Render the error page of failed page handlers
+http:onError(function(err)
+    http:render("500.html", {
+        requestId = err.requestId,
+        stackTrace = err.stackTrace
+    })
+end)
+
 if app.Custom.OnlineChart.Enabled then
 	events:new(
        function()
@@ -0,0 +1,11 @@
+-- This file is executed at start-up before the templates are loaded
+-- Functions registered here can be used inside any template, for example:
+--
+-- template:registerFunc("money", function(value)
+--     return string.format("%.2f", value)
+-- end)
+--
+-- {{ money .price }}
+--
+-- Template functions run on their own state so they cannot use local variables
+-- defined outside the function
@@ -29,7 +29,6 @@ require (
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/errors v0.8.1
 	github.com/pmezard/go-difflib v1.0.0
-	github.com/raggaer/goimage v0.0.0-20181219215616-1230da9cbcec
 	github.com/raggaer/gopaypal v0.0.0-20170320150438-35c6d0cb52d5
 	github.com/raggaer/otmap v0.0.0-20170404205416-106b5485ec0f
 	github.com/sirupsen/logrus v1.4.2
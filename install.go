@@ -259,6 +259,10 @@ var (
 			HashKey:  uniuri.NewLen(32),
 			BlockKey: uniuri.NewLen(32),
 		},
+		Session: util.SessionConfig{
+			IdleTimeout:     util.NewStringDuration("1h"),
+			AbsoluteTimeout: util.NewStringDuration("24h"),
+		},
 		Cache: util.CacheConfig{
 			Default: util.NewStringDuration("5m"),
 			Purge:   util.NewStringDuration("1m"),
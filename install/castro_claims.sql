@@ -0,0 +1,5 @@
+CREATE TABLE `castro_claims` (
+  `claim_key` VARCHAR(255) COLLATE utf8_bin NOT NULL,
+  `created_at` INT NOT NULL,
+  PRIMARY KEY (`claim_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
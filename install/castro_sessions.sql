@@ -0,0 +1,7 @@
+CREATE TABLE `castro_sessions` (
+  `id` CHAR(32) NOT NULL,
+  `data` MEDIUMBLOB NOT NULL,
+  `expires_at` INT NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
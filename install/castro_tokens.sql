@@ -0,0 +1,9 @@
+CREATE TABLE `castro_tokens` (
+  `token` CHAR(64) NOT NULL,
+  `purpose` VARCHAR(64) NOT NULL,
+  `data` TEXT NOT NULL,
+  `expires_at` INT NOT NULL,
+  `created_at` INT NOT NULL,
+  PRIMARY KEY (`token`),
+  KEY `expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"crypto/tls"
 	"encoding/gob"
@@ -58,8 +62,8 @@ Compiled at: %v
 
 	// Create rate-limiter instance
 	rate := limiter.Rate{
-		Period: util.Config.Configuration.RateLimit.Time.Duration,
-		Limit:  util.Config.Configuration.RateLimit.Number,
+		Period: util.Config.Get().RateLimit.Time.Duration,
+		Limit:  util.Config.Get().RateLimit.Number,
 	}
 
 	// Create rate-limiter storage
@@ -78,36 +82,68 @@ Compiled at: %v
 	router.GET("/subtopic/*filepath", controllers.LuaPage)
 	router.GET("/extensions/:id/static/*filepath", controllers.ExtensionStatic)
 	router.POST("/nocsrf/*filepath", controllers.LuaPage)
+	router.OPTIONS("/", controllers.LuaPage)
+	router.OPTIONS("/subtopic/*filepath", controllers.LuaPage)
+	router.OPTIONS("/nocsrf/*filepath", controllers.LuaPage)
 	router.NotFound = http.HandlerFunc(PageNotFound)
 
+	// Register metrics endpoint
+	if util.Config.Get().Metrics.Enabled {
+		path := util.Config.Get().Metrics.Path
+
+		if path == "" {
+			path = "/metrics"
+		}
+
+		router.GET(path, wrapHandler(util.MetricsHandler()))
+	}
+
 	// Register pprof router only on development mode
-	if util.Config.Configuration.IsDev() {
+	if util.Config.Get().IsDev() {
 		router.GET("/pprof/heap", wrapHandler(pprof.Handler("heap")))
 	}
 
 	// Create the session storage
 	util.SessionStore = securecookie.New(
-		[]byte(util.Config.Configuration.Cookies.HashKey),
-		[]byte(util.Config.Configuration.Cookies.BlockKey),
+		[]byte(util.Config.Get().Cookies.HashKey),
+		[]byte(util.Config.Get().Cookies.BlockKey),
 	)
 
+	// Create the server side session backend
+	sessionBackend, err := util.NewSessionStorage(util.Config.Get().Session)
+
+	if err != nil {
+		util.Logger.Logger.Fatalf("Cannot create session backend: %v", err)
+	}
+
+	util.SessionBackend = sessionBackend
+
+	// Remove expired sessions from the backend
+	if sessionBackend != nil {
+		go util.PurgeSessions(time.Hour)
+	}
+
 	// Create the middleware negroni instance with some application middleware
 	n := negroni.New(
+		newRequestIDHandler(),
+		newHostHandler(),
 		newRateLimitHandler(limiter),
 		newSecurityHandler(),
 		newSessionHandler(),
 		newMicrotimeHandler(),
+		newBodyLimitHandler(),
 		newCsrfHandler(),
 		newI18nHandler(),
+		newMaintenanceHandler(),
 	)
 
 	// Use static handler if enabled
-	if util.Config.Configuration.Static.Enabled {
-		n.Use(negroni.NewStatic(http.Dir(util.Config.Configuration.Static.Directory)))
+	if util.Config.Get().Static.Enabled {
+		n.Use(negroni.NewStatic(http.Dir(util.Config.Get().Static.Directory)))
 	}
 
 	// Use negroni logger only in development mode
-	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
+	if util.Config.Get().IsDev() || util.Config.Get().IsLog() {
 		n.Use(negroni.NewLogger())
 	}
 
@@ -119,17 +155,22 @@ Compiled at: %v
 
 	// Create castro server
 	server := http.Server{
-		Addr:         fmt.Sprintf(":%v", util.Config.Configuration.Port),
+		Addr:         fmt.Sprintf(":%v", util.Config.Get().Port),
 		Handler:      n,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  util.Config.Get().HTTP.ReadTimeoutDuration(),
+		WriteTimeout: util.Config.Get().HTTP.WriteTimeoutDuration(),
+		ConnContext:  util.ConnContext,
 	}
 
+	// Stop the server gracefully on shutdown signals
+	shutdown := make(chan struct{})
+	go shutdownServer(&server, shutdown)
+
 	// Check if Castro should run on SSL mode
-	if util.Config.Configuration.SSL.Enabled {
+	if util.Config.Get().SSL.Enabled {
 
 		// Check if user is using auto-certificate
-		if util.Config.Configuration.SSL.Auto {
+		if util.Config.Get().SSL.Auto {
 
 			// Create auto-certificate manager
 			m := autocert.Manager{
@@ -138,10 +179,10 @@ Compiled at: %v
 			}
 
 			// Set auto-certificate hosts
-			if strings.HasPrefix(util.Config.Configuration.URL, "www") {
-				m.HostPolicy = autocert.HostWhitelist(util.Config.Configuration.URL, strings.Replace(util.Config.Configuration.URL, "www.", "", 1))
+			if strings.HasPrefix(util.Config.Get().URL, "www") {
+				m.HostPolicy = autocert.HostWhitelist(util.Config.Get().URL, strings.Replace(util.Config.Get().URL, "www.", "", 1))
 			} else {
-				m.HostPolicy = autocert.HostWhitelist(util.Config.Configuration.URL, "www."+util.Config.Configuration.URL)
+				m.HostPolicy = autocert.HostWhitelist(util.Config.Get().URL, "www."+util.Config.Get().URL)
 			}
 
 			// Set server TLS option
@@ -153,7 +194,7 @@ Compiled at: %v
 			go http.ListenAndServe(":http", m.HTTPHandler(nil))
 
 			// Listen to https connections using autocert
-			if err := server.ListenAndServeTLS("", ""); err != nil {
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 				util.Logger.Logger.Fatalf("Cannot start Castro autocert HTTPS server: %v", err)
 			}
 		}
@@ -163,19 +204,46 @@ Compiled at: %v
 
 		// If SSL is enabled listen with cert and key
 		if err := server.ListenAndServeTLS(
-			util.Config.Configuration.SSL.Cert,
-			util.Config.Configuration.SSL.Key,
-		); err != nil {
+			util.Config.Get().SSL.Cert,
+			util.Config.Get().SSL.Key,
+		); err != nil && err != http.ErrServerClosed {
 			util.Logger.Logger.Fatalf("Cannot start Castro HTTPS server: %v", err)
 		}
 
 	} else {
 
 		// Listen without using ssl
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			util.Logger.Logger.Fatalf("Cannot start Castro HTTP server: %v", err)
 		}
 	}
+
+	// Wait for the shutdown to complete
+	<-shutdown
+}
+
+// shutdownServer waits for a shutdown signal, stops the server and waits for the background events
+func shutdownServer(server *http.Server, done chan struct{}) {
+	// Listen for shutdown signals
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	<-sig
+
+	util.Logger.Logger.Info("Shutting down Castro")
+
+	// Stop accepting new connections
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		util.Logger.Logger.Errorf("Cannot shutdown Castro server: %v", err)
+	}
+
+	// Wait for background events
+	app.Shutdown()
+
+	close(done)
 }
 
 // wrapHandler converts a normal http handler to a httprouter handler
@@ -1,23 +1,44 @@
 package main
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/dchest/uniuri"
+	"github.com/julienschmidt/httprouter"
+	"github.com/raggaer/castro/app/controllers"
 	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/ulule/limiter"
 	"golang.org/x/net/context"
 )
 
+// maintenanceHandler used to show the maintenance page while maintenance mode is enabled
+type maintenanceHandler struct{}
+
+// maintenanceResponseWriter used to answer the maintenance page with a 503 status
+type maintenanceResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// requestIDHandler used to tag all requests with an identifier
+type requestIDHandler struct{}
+
+// hostHandler used to reject requests with a host header that is not allowed
+type hostHandler struct{}
+
 // microtimeHandler used to record all requests time spent
 type microtimeHandler struct{}
 
 // csrfHandler used to add a token to all requests
 type csrfHandler struct{}
 
+// bodyLimitHandler used to limit the size of request bodies
+type bodyLimitHandler struct{}
+
 // sessionHandler used for application session
 type sessionHandler struct{}
 
@@ -32,6 +53,150 @@ type rateLimitHandler struct {
 // i18nHandler used to detect user language
 type i18nHandler struct{}
 
+// newMaintenanceHandler creates and returns a new maintenanceHandler instance
+func newMaintenanceHandler() *maintenanceHandler {
+	return &maintenanceHandler{}
+}
+
+// ServeHTTP makes maintenanceHandler compatible with negroni. Page requests of non admin users
+// render the maintenance subtopic. Login pages stay available so admins can sign in and payment
+// callbacks under /nocsrf keep working
+func (m *maintenanceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	// Check if maintenance mode is enabled
+	if !util.Config.Get().Maintenance || !isMaintenancePage(req.URL.Path) {
+		next(w, req)
+		return
+	}
+
+	// Get session
+	session, ok := req.Context().Value("session").(map[string]interface{})
+
+	if ok && isAdminSession(session) {
+		next(w, req)
+		return
+	}
+
+	// Render maintenance page
+	w.Header().Set("Retry-After", "300")
+
+	controllers.LuaPage(&maintenanceResponseWriter{ResponseWriter: w}, req, httprouter.Params{
+		{
+			Key:   "filepath",
+			Value: "maintenance",
+		},
+	})
+}
+
+// WriteHeader sends the maintenance status instead of successful status codes
+func (m *maintenanceResponseWriter) WriteHeader(code int) {
+	if m.wroteHeader {
+		return
+	}
+
+	m.wroteHeader = true
+
+	if code >= 200 && code < 300 {
+		code = http.StatusServiceUnavailable
+	}
+
+	m.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends the maintenance status before the first write
+func (m *maintenanceResponseWriter) Write(b []byte) (int, error) {
+	if !m.wroteHeader {
+		m.WriteHeader(http.StatusOK)
+	}
+
+	return m.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered data to the client
+func (m *maintenanceResponseWriter) Flush() {
+	if !m.wroteHeader {
+		m.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// isMaintenancePage checks if the given path is a page affected by maintenance mode
+func isMaintenancePage(path string) bool {
+	// Check for login pages
+	if isPathUnder(path, "/subtopic/login") || isPathUnder(path, "/subtopic/logout") {
+		return false
+	}
+
+	return path == "/" || strings.HasPrefix(path, "/subtopic/")
+}
+
+// isPathUnder checks if the given path is the given page or any path below it
+func isPathUnder(path, page string) bool {
+	return path == page || strings.HasPrefix(path, page+"/")
+}
+
+// isAdminSession checks if the session belongs to a logged admin account. The admin flag is
+// stored on the session when the account logs in
+func isAdminSession(session map[string]interface{}) bool {
+	// Check if user is logged
+	if logged, ok := session["logged"].(bool); !ok || !logged {
+		return false
+	}
+
+	admin, _ := session["admin"].(bool)
+
+	return admin
+}
+
+// newRequestIDHandler creates and returns a new requestIDHandler instance
+func newRequestIDHandler() *requestIDHandler {
+	return &requestIDHandler{}
+}
+
+// ServeHTTP makes requestIDHandler compatible with negroni
+func (r *requestIDHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	// Generate request identifier
+	id := util.NewRequestID()
+
+	// Propagate identifier on the response
+	w.Header().Set(util.RequestIDHeader, id)
+
+	// Create new context with the request identifier
+	ctx := context.WithValue(req.Context(), "request-id", id)
+
+	next(w, req.WithContext(ctx))
+}
+
+// newHostHandler creates and returns a new hostHandler instance
+func newHostHandler() *hostHandler {
+	return &hostHandler{}
+}
+
+// ServeHTTP makes hostHandler compatible with negroni. Requests are rejected when the host
+// header is not on Security.AllowedHosts. Without allowed hosts the configured URL host is used
+func (h *hostHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	host := util.ConfiguredHost()
+
+	if len(util.Config.Get().Security.AllowedHosts) > 0 {
+
+		// Check host header
+		if !util.AllowedHost(req.Host) {
+			util.Logger.ForRequest(req).Warnf("Rejected request with invalid host %v", req.Host)
+			http.Error(w, "Invalid host", http.StatusBadRequest)
+			return
+		}
+
+		host = req.Host
+	}
+
+	// Create new context with the validated host
+	ctx := context.WithValue(req.Context(), "host", host)
+
+	next(w, req.WithContext(ctx))
+}
+
 // newI18nHandler creates and returns a new i18nHandler instance
 func newI18nHandler() *i18nHandler {
 	return &i18nHandler{}
@@ -54,25 +219,13 @@ func newRateLimitHandler(limiter *limiter.Limiter) *rateLimitHandler {
 
 func (r *rateLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	// Check if rate-limit is not enabled
-	if !util.Config.Configuration.RateLimit.Enabled {
+	if !util.Config.Get().RateLimit.Enabled {
 		next(w, req)
 		return
 	}
 
-	// IP data holder
-	ip := ""
-
-	// Check if behind proxy
-	if util.Config.Configuration.SSL.Proxy {
-
-		// Get address from X-Forwarded-For
-		ip = req.Header.Get("X-Forwarded-For")
-
-	} else {
-
-		// Get address from RemoteAddress
-		ip = req.RemoteAddr
-	}
+	// Get client address
+	ip := util.ClientIP(req)
 
 	// Get rate-limit context
 	ctx, err := r.Limiter.Get(req.Context(), ip)
@@ -110,42 +263,44 @@ func (s *securityHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, ne
 		util.Cache.Set("nonce", nonce, time.Minute*20)
 	}
 
-	// Set nonce header value
-	util.Config.Configuration.Security.CSP.Script.Default = append(util.Config.Configuration.Security.CSP.Script.Default, "nonce-"+nonce.(string))
+	// Get configuration with the nonce added to the script sources
+	config := *util.Config.Get()
+	scripts := config.Security.CSP.Script.Default
+	config.Security.CSP.Script.Default = append(scripts[:len(scripts):len(scripts)], "nonce-"+nonce.(string))
 
 	// Create new context with cookie value
 	ctx := context.WithValue(req.Context(), "nonce", nonce)
 
 	// Set Strict-Transport-Security header if SSL
-	if util.Config.Configuration.IsSSL() {
+	if util.Config.Get().IsSSL() {
 
 		// Set header
-		w.Header().Set("Strict-Transport-Security", util.Config.Configuration.Security.STS)
+		w.Header().Set("Strict-Transport-Security", util.Config.Get().Security.STS)
 	}
 
 	// Set Engine header
 	w.Header().Set("Engine", "Castro")
 
 	// Set X-XSS-Protection header
-	w.Header().Set("X-XSS-Protection", util.Config.Configuration.Security.XSS)
+	w.Header().Set("X-XSS-Protection", util.Config.Get().Security.XSS)
 
 	// Set X-Frame-Options header
-	w.Header().Set("X-Frame-Options", util.Config.Configuration.Security.Frame)
+	w.Header().Set("X-Frame-Options", util.Config.Get().Security.Frame)
 
 	// Set X-Content-Type-Options header
-	w.Header().Set("X-Content-Type-Options", util.Config.Configuration.Security.ContentType)
+	w.Header().Set("X-Content-Type-Options", util.Config.Get().Security.ContentType)
 
 	// Set Referrer-Policy header
-	w.Header().Set("Referrer-Policy", util.Config.Configuration.Security.ReferrerPolicy)
+	w.Header().Set("Referrer-Policy", util.Config.Get().Security.ReferrerPolicy)
 
 	// Set X-Permitted-Cross-Domain-Policies header
-	w.Header().Set("X-Permitted-Cross-Domain-Policies", util.Config.Configuration.Security.CrossDomainPolicy)
+	w.Header().Set("X-Permitted-Cross-Domain-Policies", util.Config.Get().Security.CrossDomainPolicy)
 
-	if util.Config.Configuration.Security.CSP.Enabled {
+	if util.Config.Get().Security.CSP.Enabled {
 		// Set Content-Security-Policy header
 		w.Header().Set(
 			"Content-Security-Policy",
-			util.Config.Configuration.CSP(),
+			config.CSP(),
 		)
 	}
 
@@ -160,7 +315,7 @@ func newSessionHandler() *sessionHandler {
 
 func (s *sessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	// Get application cookie
-	cookie, err := req.Cookie(util.Config.Configuration.Cookies.Name)
+	cookie, err := req.Cookie(util.Config.Get().Cookies.Name)
 
 	if err != nil {
 
@@ -170,21 +325,27 @@ func (s *sessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, nex
 		// Set issuer field
 		v["issuer"] = "Castro"
 
+		// Set session identifier
+		v["id"] = uniuri.NewLen(32)
+
+		// Set session timestamps
+		util.TouchSession(v)
+
 		// Encode cookie value
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, v)
+		encoded, err := util.EncodeSession(v)
 
 		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode cookie value: %v", err)
+			util.Logger.ForRequest(req).Errorf("Cannot encode cookie value: %v", err)
 			return
 		}
 
 		// Create cookie
 		c := &http.Cookie{
-			Name:     util.Config.Configuration.Cookies.Name,
+			Name:     util.Config.Get().Cookies.Name,
 			Value:    encoded,
 			Path:     "/",
-			MaxAge:   util.Config.Configuration.Cookies.MaxAge,
-			Secure:   util.Config.Configuration.IsSSL(),
+			MaxAge:   util.Config.Get().Cookies.MaxAge,
+			Secure:   util.Config.Get().IsSSL(),
 			HttpOnly: true,
 		}
 
@@ -200,16 +361,11 @@ func (s *sessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, nex
 		return
 	}
 
-	// Cookie data holder
-	v := make(map[string]interface{})
-
 	// Decode cookie
-	if err := util.SessionStore.Decode(
-		util.Config.Configuration.Cookies.Name,
-		cookie.Value,
-		&v,
-	); err != nil {
-		util.Logger.Logger.Errorf("Cannot decode cookie value: %v", err)
+	v, err := util.DecodeSession(cookie.Value)
+
+	if err != nil {
+		util.Logger.ForRequest(req).Errorf("Cannot decode cookie value: %v", err)
 		return
 	}
 
@@ -225,6 +381,34 @@ func (s *sessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, nex
 		return
 	}
 
+	// Check session expiration
+	expired := util.SessionExpired(v)
+
+	// Purge expired sessions
+	if expired {
+		util.PurgeSession(v)
+	}
+
+	// Check if session timestamps need to be saved
+	_, created := v["created-at"].(int64)
+
+	if expired || !created || util.Config.Get().Session.IdleTimeout.Duration > 0 {
+
+		// Set session timestamps
+		util.TouchSession(v)
+
+		// Encode cookie value
+		encoded, err := util.EncodeSession(v)
+
+		if err != nil {
+			util.Logger.ForRequest(req).Errorf("Cannot encode cookie value: %v", err)
+			return
+		}
+
+		// Set cookie
+		http.SetCookie(w, util.SessionCookie(encoded))
+	}
+
 	// Create new context with cookie value
 	ctx := context.WithValue(req.Context(), "session", v)
 
@@ -237,9 +421,71 @@ func newCsrfHandler() *csrfHandler {
 	return &csrfHandler{}
 }
 
+// csrfUnsafeMethods request methods that need a csrf token when Security.CSRF.Enforce is enabled
+var csrfUnsafeMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// csrfExempt checks if the given path skips the csrf check. Paths under /nocsrf and the
+// Security.CSRF.Exempt paths (and everything below them) are exempt
+func csrfExempt(path string) bool {
+	if strings.HasPrefix(path, "/nocsrf") {
+		return true
+	}
+
+	for _, exempt := range util.Config.Get().Security.CSRF.Exempt {
+		exempt = strings.TrimSuffix(exempt, "/")
+
+		if exempt != "" && (path == exempt || strings.HasPrefix(path, exempt+"/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// csrfProtected checks if the given request needs a valid csrf token
+func csrfProtected(req *http.Request) bool {
+	if util.Config.Get().Security.CSRF.Enforce {
+		return csrfUnsafeMethods[req.Method]
+	}
+
+	return req.Method == http.MethodPost
+}
+
+// csrfValid checks if the request carries the given token on the X-CSRF-Token header or
+// on the _csrf query or form value. The body is only parsed when the header and the query
+// do not carry the token
+func csrfValid(req *http.Request, token string) bool {
+	if csrfEqual(req.Header.Get("X-CSRF-Token"), token) || csrfEqual(req.URL.Query().Get("_csrf"), token) {
+		return true
+	}
+
+	return csrfEqual(req.PostFormValue("_csrf"), token)
+}
+
+// csrfEqual compares the given value with the token in constant time
+func csrfEqual(v, token string) bool {
+	return v != "" && subtle.ConstantTimeCompare([]byte(v), []byte(token)) == 1
+}
+
+// csrfReject answers a request without a valid csrf token. Requests are only answered with
+// a 403 status when Security.CSRF.Enforce is enabled
+func csrfReject(w http.ResponseWriter, req *http.Request) {
+	if !util.Config.Get().Security.CSRF.Enforce {
+		return
+	}
+
+	util.Logger.ForRequest(req).Warnf("Rejected %v %v request without a valid csrf token", req.Method, req.URL.Path)
+	http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+}
+
 func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-	// Skip nocsrf routes
-	if strings.HasPrefix(req.URL.Path, "/nocsrf") {
+	// Skip exempt routes
+	if csrfExempt(req.URL.Path) {
 
 		// Run next handler
 		next(w, req)
@@ -259,7 +505,8 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 	if !ok {
 
 		// Check if request is valid
-		if req.Method == http.MethodPost {
+		if csrfProtected(req) {
+			csrfReject(w, req)
 			return
 		}
 
@@ -273,19 +520,19 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 		session["csrf-token"] = &tkn
 
 		// Encode session
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
+		encoded, err := util.EncodeSession(session)
 
 		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode session: %v", err)
+			util.Logger.ForRequest(req).Errorf("Cannot encode session: %v", err)
 		}
 
 		// Create cookie
 		c := &http.Cookie{
-			Name:     util.Config.Configuration.Cookies.Name,
+			Name:     util.Config.Get().Cookies.Name,
 			Value:    encoded,
 			Path:     "/",
-			MaxAge:   util.Config.Configuration.Cookies.MaxAge,
-			Secure:   util.Config.Configuration.IsSSL(),
+			MaxAge:   util.Config.Get().Cookies.MaxAge,
+			Secure:   util.Config.Get().IsSSL(),
 			HttpOnly: true,
 		}
 
@@ -302,7 +549,15 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 	}
 
 	// Check if valid token
-	if req.Method == http.MethodPost && (req.FormValue("_csrf") != token.Token && req.URL.Query().Get("_csrf") != token.Token) {
+	if csrfProtected(req) && !csrfValid(req, token.Token) {
+
+		// Bodies over the size limit cannot carry a valid token
+		if body, ok := req.Body.(*util.LimitedBody); ok && body.Exceeded() {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		csrfReject(w, req)
 		return
 	}
 
@@ -314,10 +569,10 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 		token.At = time.Now()
 
 		// Encode session
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
+		encoded, err := util.EncodeSession(session)
 
 		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode session: %v", err)
+			util.Logger.ForRequest(req).Errorf("Cannot encode session: %v", err)
 		}
 
 		// Create cookie
@@ -334,6 +589,20 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 	next(w, req.WithContext(ctx))
 }
 
+// newBodyLimitHandler creates and returns a new bodyLimitHandler instance
+func newBodyLimitHandler() *bodyLimitHandler {
+	return &bodyLimitHandler{}
+}
+
+// ServeHTTP makes bodyLimitHandler compatible with negroni. Request bodies are limited before
+// any handler reads them. Pages can change the limit later using http:limit
+func (b *bodyLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	req.Body = util.NewLimitedBody(w, req.Body, util.Config.Get().HTTP.BodyLimit())
+
+	// Run next handler
+	next(w, req)
+}
+
 // newMicrotimeHandler creates and returns a new microtimeHandler instance
 func newMicrotimeHandler() *microtimeHandler {
 	return &microtimeHandler{}
@@ -342,8 +611,13 @@ func newMicrotimeHandler() *microtimeHandler {
 // ServeHTTP makes microtimeHandler compatible with negroni
 func (m *microtimeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 	// Set timestamp on the request context
-	ctx := context.WithValue(req.Context(), "microtime", time.Now())
+	start := time.Now()
+	ctx := context.WithValue(req.Context(), "microtime", start)
 
 	// Execute next handler
 	next(w, req.WithContext(ctx))
+
+	// Record request metrics
+	util.Metrics.Increment("castro_http_requests_total", 1)
+	util.Metrics.Observe("castro_http_request_duration_seconds", time.Since(start).Seconds())
 }
@@ -0,0 +1,10 @@
+-- Creates the claim table used by storage:claimOnce
+function migration()
+    db:execute([[
+        CREATE TABLE IF NOT EXISTS `castro_claims` (
+          `claim_key` VARCHAR(255) COLLATE utf8_bin NOT NULL,
+          `created_at` INT NOT NULL,
+          PRIMARY KEY (`claim_key`)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8
+    ]])
+end
@@ -0,0 +1,12 @@
+-- Creates the session table used by the database session backend
+function migration()
+    db:execute([[
+        CREATE TABLE IF NOT EXISTS `castro_sessions` (
+          `id` CHAR(32) NOT NULL,
+          `data` MEDIUMBLOB NOT NULL,
+          `expires_at` INT NOT NULL,
+          PRIMARY KEY (`id`),
+          KEY `expires_at` (`expires_at`)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8
+    ]])
+end
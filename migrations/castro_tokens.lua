@@ -0,0 +1,14 @@
+-- Creates the one-time token table on installations made before it was added
+function migration()
+    db:execute([[
+        CREATE TABLE IF NOT EXISTS `castro_tokens` (
+          `token` CHAR(64) NOT NULL,
+          `purpose` VARCHAR(64) NOT NULL,
+          `data` TEXT NOT NULL,
+          `expires_at` INT NOT NULL,
+          `created_at` INT NOT NULL,
+          PRIMARY KEY (`token`),
+          KEY `expires_at` (`expires_at`)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8
+    ]])
+end
@@ -4,7 +4,7 @@ function post()
         return
     end
 
-    if app.Captcha.Enabled then
+    if captcha:isEnabled("recoverPassword") then
         if not captcha:verify(http.postValues["g-recaptcha-response"]) then
             session:setFlash("validationError", "Invalid captcha answer")
             http:redirect("/subtopic/account/recover/password")
@@ -4,9 +4,9 @@ function post()
         return
     end
 
-    local account = session:loggedAccount()
+    local loggedAccount = session:loggedAccount()
 
-    if account.Secret ~= nil then
+    if loggedAccount.Secret ~= nil then
         http:redirect("/")
         return
     end
@@ -18,14 +18,13 @@ function post()
         return
     end
 
-    if not validator:validQRToken(http.postValues.token, secret) then
+    if not account:enable2FA(loggedAccount.ID, secret, http.postValues.token) then
         session:setFlash("validationError", "Invalid token. Please try again")
         http:redirect()
         return
     end
 
     session:destroy()
-    db:execute("UPDATE accounts SET secret = ? WHERE id = ?", secret, account.ID)
     session:setFlash("success", "Two-factor authentication enabled. Please log-in")
     http:redirect("/subtopic/login")
 end
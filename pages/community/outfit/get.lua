@@ -37,18 +37,19 @@ function get()
         return
     end
 
-    local out = outfit:generate(
-        looktype,
-        lookfeet,
-        looklegs,
-        lookbody,
-        lookhead,
-        lookaddons
-    )
-
-    local outfile = io.open(outfitpath, "w")
-
-    outfile:write(out)
-    outfile:close()
+    local ok = outfit:render({
+        lookType = looktype,
+        feet = lookfeet,
+        legs = looklegs,
+        body = lookbody,
+        head = lookhead,
+        addons = lookaddons,
+        path = outfitpath
+    })
+
+    if not ok then
+        return
+    end
+
     http:serveFile(outfitpath)
 end
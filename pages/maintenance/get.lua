@@ -0,0 +1,3 @@
+function get()
+    http:render("maintenance.html", nil)
+end
\ No newline at end of file
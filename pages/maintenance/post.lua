@@ -0,0 +1,3 @@
+function post()
+    http:render("maintenance.html", nil)
+end
\ No newline at end of file
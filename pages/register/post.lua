@@ -4,7 +4,7 @@ function post()
         return
     end
 
-    if app.Captcha.Enabled then
+    if captcha:isEnabled("register") then
         if not captcha:verify(http.postValues["g-recaptcha-response"]) then
             session:setFlash("validationError", "Invalid captcha answer")
             http:redirect("/subtopic/register")
@@ -48,16 +48,19 @@ function post()
         return
     end
 
-    local id = db:execute(
-        "INSERT INTO accounts (name, password, premdays, email, creation) VALUES (?, ?, ?, ?, ?)",
-        http.postValues["account-name"],
-        crypto:sha1(http.postValues["password"]),
-        10,
-        http.postValues["email"],
-        os.time()
-    )
+    local _, err = account:create({
+        name = http.postValues["account-name"],
+        password = http.postValues["password"],
+        email = http.postValues["email"],
+        premdays = 10
+    })
+
+    if err ~= nil then
+        session:setFlash("validationError", err)
+        http:redirect("/subtopic/register")
+        return
+    end
 
-    db:execute("INSERT INTO castro_accounts (account_id) VALUES (?)", id)
     session:setFlash("success", "Account created. You can now sign in")
     http:redirect("/subtopic/login")
 end
\ No newline at end of file